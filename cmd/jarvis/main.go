@@ -1,32 +1,158 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"jarvis_mcp/pkg/confirm"
 	"jarvis_mcp/pkg/files"
+	"jarvis_mcp/pkg/policy"
+	"jarvis_mcp/pkg/sandbox"
 	"jarvis_mcp/pkg/shell"
+	"os"
+	"strconv"
 
+	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
 func main() {
+	// Parse command line flags
+	shellConfig := shell.DefaultConfig()
+	flag.DurationVar(&shellConfig.DefaultTimeout, "command-timeout", shellConfig.DefaultTimeout,
+		"default timeout for execute_command when the call does not specify one")
+	flag.DurationVar(&shellConfig.MaxTimeout, "max-command-timeout", shellConfig.MaxTimeout,
+		"maximum timeout a call to execute_command may request (0 for unlimited)")
+	flag.IntVar(&shellConfig.MaxOutputBytes, "max-output-bytes", shellConfig.MaxOutputBytes,
+		"maximum bytes kept per output stream in structured command results (0 for unlimited)")
+	flag.IntVar(&shellConfig.JobBufferBytes, "job-buffer-bytes", shellConfig.JobBufferBytes,
+		"bytes of recent output kept for each background job and terminal (0 for unlimited)")
+	flag.DurationVar(&shellConfig.JobTTL, "job-ttl", shellConfig.JobTTL,
+		"how long finished background jobs are kept before cleanup")
+	flag.IntVar(&shellConfig.MaxJobs, "max-jobs", shellConfig.MaxJobs,
+		"maximum number of background jobs running at once (0 for unlimited)")
+	flag.IntVar(&shellConfig.MaxSessions, "max-sessions", shellConfig.MaxSessions,
+		"maximum number of persistent shell sessions open at once (0 for unlimited)")
+	flag.IntVar(&shellConfig.MaxTerminals, "max-terminals", shellConfig.MaxTerminals,
+		"maximum number of interactive terminals open at once (0 for unlimited)")
+	policyFile := flag.String("command-policy", "",
+		"JSON file with rules that allow, deny or require confirmation for commands")
+	flag.BoolVar(&shellConfig.ConfirmDangerous, "confirm-dangerous", shellConfig.ConfirmDangerous,
+		"require confirmation for commands that destroy data irrecoverably, such as rm -rf, git push --force, dd or mkfs")
+	confirmConfig := confirm.DefaultConfig()
+	flag.BoolVar(&confirmConfig.Enabled, "confirm", confirmConfig.Enabled,
+		"ask the user before overwriting files and running commands that require confirmation")
+	confirmFallback := flag.String("confirm-fallback", string(confirmConfig.Fallback),
+		"what to do when confirmation is needed but the client does not support elicitation: allow or deny")
+	sandboxConfig := sandbox.DefaultConfig()
+	flag.Func("allowed-root", "directory file tools and commands may access (repeat for several; default unrestricted)",
+		func(root string) error {
+			sandboxConfig.Roots = append(sandboxConfig.Roots, root)
+			return nil
+		})
+	flag.BoolVar(&sandboxConfig.ClientRoots, "client-roots", sandboxConfig.ClientRoots,
+		"restrict file tools and commands to the roots reported by clients that support roots/list")
+	filesConfig := files.DefaultConfig()
+	// Read-only mode can also be set in the client's server configuration, which may not pass flags
+	if value, ok := os.LookupEnv("JARVIS_READ_ONLY"); ok {
+		readOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: JARVIS_READ_ONLY must be true or false, got %q\n", value)
+			os.Exit(1)
+		}
+		filesConfig.ReadOnly = readOnly
+	}
+	flag.BoolVar(&filesConfig.ReadOnly, "read-only", filesConfig.ReadOnly,
+		"only register tools that do not change anything and restrict execute_command to read-only programs (default from JARVIS_READ_ONLY)")
+	flag.IntVar(&filesConfig.MaxReadBytes, "max-read-bytes", filesConfig.MaxReadBytes,
+		"maximum bytes of file content returned by one read_file call (0 for unlimited)")
+	flag.IntVar(&filesConfig.MaxBinaryBytes, "max-binary-bytes", filesConfig.MaxBinaryBytes,
+		"largest image or other binary file returned whole by read_file (0 for unlimited)")
+	flag.StringVar(&filesConfig.BackupDir, "backup-dir", files.DefaultBackupDir(),
+		"directory for the journal of file changes and the content they replaced, for undoing them (empty to disable)")
+	flag.Int64Var(&filesConfig.MaxBackupBytes, "max-backup-bytes", filesConfig.MaxBackupBytes,
+		"content kept in the backup directory before the oldest changes are forgotten (0 for unlimited)")
+	readOnlyCommands := flag.Bool("read-only-commands", true,
+		"keep execute_command in read-only mode, limited to the built-in allowlist of read-only programs")
+	flag.Parse()
+
+	confirmConfig.Fallback = confirm.Fallback(*confirmFallback)
+	if confirmConfig.Fallback != confirm.FallbackAllow && confirmConfig.Fallback != confirm.FallbackDeny {
+		fmt.Fprintf(os.Stderr, "Error: --confirm-fallback must be allow or deny, got %q\n", *confirmFallback)
+		os.Exit(1)
+	}
+	confirm.Configure(confirmConfig)
+
+	if err := sandbox.Configure(sandboxConfig); err != nil {
+		fmt.Fprintf(os.Stderr, "Error configuring allowed roots: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *policyFile != "" {
+		commandPolicy, err := policy.Load(*policyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading command policy: %v\n", err)
+			os.Exit(1)
+		}
+		shellConfig.Policy = commandPolicy
+	}
+
+	shellConfig.ReadOnly = filesConfig.ReadOnly
+	shell.Configure(shellConfig)
+	files.Configure(filesConfig)
+	readOnly := filesConfig.ReadOnly
+
 	// Create MCP server
 	mcpServer := server.NewMCPServer(
 		"jarvis-mcp",
 		"1.0.0",
+		server.WithElicitation(),
+		server.WithRoots(),
 	)
+	mcpServer.AddNotificationHandler(mcp.MethodNotificationRootsListChanged, sandbox.HandleRootsChanged)
 
 	// shell tools
-	mcpServer.AddTool(shell.GetExecuteCommand())
+	if !readOnly || *readOnlyCommands {
+		mcpServer.AddTool(shell.GetExecuteCommand())
+	}
+	if !readOnly {
+		mcpServer.AddTool(shell.GetRunProgram())
+		mcpServer.AddTool(shell.GetStartJob())
+		mcpServer.AddTool(shell.GetJobStatus())
+		mcpServer.AddTool(shell.GetReadJobOutput())
+		mcpServer.AddTool(shell.GetSendJobInput())
+		mcpServer.AddTool(shell.GetKillJob())
+		mcpServer.AddTool(shell.GetListJobs())
+		mcpServer.AddTool(shell.GetOpenSession())
+		mcpServer.AddTool(shell.GetCloseSession())
+		mcpServer.AddTool(shell.GetOpenTerminal())
+		mcpServer.AddTool(shell.GetWriteTerminal())
+		mcpServer.AddTool(shell.GetReadTerminal())
+		mcpServer.AddTool(shell.GetResizeTerminal())
+		mcpServer.AddTool(shell.GetCloseTerminal())
+	}
 
 	// file system tools
 	mcpServer.AddTool(files.GetReadFile())
-	mcpServer.AddTool(files.GetWriteFile())
-	mcpServer.AddTool(files.GetCreateDirectory())
+	mcpServer.AddTool(files.GetReadMultipleFiles())
 	mcpServer.AddTool(files.GetListDirectory())
-	mcpServer.AddTool(files.GetMoveFile())
 	mcpServer.AddTool(files.GetSearchFiles())
+	mcpServer.AddTool(files.GetGrepFiles())
 	mcpServer.AddTool(files.GetFileInfo())
 	mcpServer.AddTool(files.GetDirectoryTree())
+	if !readOnly {
+		mcpServer.AddTool(files.GetWriteFile())
+		mcpServer.AddTool(files.GetEditFile())
+		mcpServer.AddTool(files.GetApplyPatch())
+		mcpServer.AddTool(files.GetCreateDirectory())
+		mcpServer.AddTool(files.GetMoveFile())
+		mcpServer.AddTool(files.GetListChanges())
+		mcpServer.AddTool(files.GetUndoLastChange())
+		mcpServer.AddTool(files.GetRestoreFile())
+		mcpServer.AddTool(files.GetCreateCheckpoint())
+		mcpServer.AddTool(files.GetListCheckpoints())
+		mcpServer.AddTool(files.GetDiffCheckpoint())
+		mcpServer.AddTool(files.GetRestoreCheckpoint())
+	}
 
 	// Start the stdio server
 	if err := server.ServeStdio(mcpServer); err != nil {
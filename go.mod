@@ -1,11 +1,18 @@
 module jarvis_mcp
 
-go 1.24.1
+go 1.25.5
 
 require (
-	github.com/mark3labs/mcp-go v0.17.0
-	github.com/google/uuid v1.6.0 // indirect
+	github.com/creack/pty v1.1.24
+	github.com/mark3labs/mcp-go v0.58.0
 	github.com/samber/lo v1.49.1
+	golang.org/x/text v0.21.0
+)
+
+require (
+	github.com/google/jsonschema-go v0.4.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.2 // indirect
+	github.com/spf13/cast v1.7.1 // indirect
 	github.com/yosida95/uritemplate/v3 v3.0.2 // indirect
-	golang.org/x/text v0.21.0 // indirect
 )
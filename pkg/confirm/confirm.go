@@ -0,0 +1,113 @@
+// Package confirm asks the human behind the MCP client to approve destructive
+// operations before a tool carries them out, using MCP elicitation.
+package confirm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// Fallback decides what happens when confirmation is needed but the client cannot ask the user.
+type Fallback string
+
+const (
+	FallbackAllow Fallback = "allow"
+	FallbackDeny  Fallback = "deny"
+)
+
+// Config holds the server-wide confirmation settings.
+type Config struct {
+	// Enabled turns confirmation prompts on. When disabled, every operation proceeds.
+	Enabled bool
+	// Fallback applies when the client does not support elicitation.
+	Fallback Fallback
+}
+
+// DefaultConfig returns the configuration used when Configure has not been called.
+func DefaultConfig() Config {
+	return Config{
+		Enabled:  true,
+		Fallback: FallbackAllow,
+	}
+}
+
+// config is the active configuration shared by all tools.
+var config = DefaultConfig()
+
+// Configure replaces the server-wide confirmation settings.
+// It should be called once at startup, before any tool is registered.
+func Configure(c Config) {
+	config = c
+}
+
+var (
+	// ErrDeclined is returned when the user declines or dismisses a confirmation request.
+	ErrDeclined = utils.NewCodedError(utils.ErrCodePolicyDenied, errors.New("operation declined by the user"))
+	// ErrUnsupported is returned when confirmation is needed, the client cannot ask
+	// the user and the fallback is to deny.
+	ErrUnsupported = utils.NewCodedError(utils.ErrCodePolicyDenied,
+		errors.New("operation requires confirmation, but the client does not support elicitation"))
+)
+
+// approvalSchema is the form shown to the user: a single checkbox that defaults to approval,
+// so clients that only render accept and decline buttons work as expected.
+var approvalSchema = map[string]any{
+	"type": "object",
+	"properties": map[string]any{
+		"approve": map[string]any{
+			"type":        "boolean",
+			"title":       "Approve",
+			"description": "Carry out the operation described above",
+			"default":     true,
+		},
+	},
+	"required": []string{"approve"},
+}
+
+// Ask describes an operation to the user and waits for approval. It returns nil if the
+// operation may proceed, ErrDeclined if the user refused, and ErrUnsupported if the
+// client cannot ask and the configured fallback is to deny. The message should say
+// exactly what will happen, e.g. include the diff of a file that is about to be overwritten.
+func Ask(ctx context.Context, message string) error {
+	if !config.Enabled {
+		return nil
+	}
+
+	session, ok := server.ClientSessionFromContext(ctx).(server.SessionWithElicitation)
+	if ok {
+		if withInfo, hasInfo := session.(server.SessionWithClientInfo); hasInfo {
+			ok = withInfo.GetClientCapabilities().Elicitation != nil
+		}
+	}
+	if !ok {
+		if config.Fallback == FallbackAllow {
+			return nil
+		}
+		return ErrUnsupported
+	}
+
+	result, err := session.RequestElicitation(ctx, mcp.ElicitationRequest{
+		Params: mcp.ElicitationParams{
+			Message:         message,
+			RequestedSchema: approvalSchema,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("error requesting confirmation: %w", err)
+	}
+
+	if result.Action != mcp.ElicitationResponseActionAccept {
+		return fmt.Errorf("%w (%s)", ErrDeclined, result.Action)
+	}
+	if content, ok := result.Content.(map[string]any); ok {
+		if approve, ok := content["approve"].(bool); ok && !approve {
+			return ErrDeclined
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package confirm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// fakeClient answers elicitation requests with a fixed response and records the message
+type fakeClient struct {
+	response mcp.ElicitationResponse
+	message  string
+}
+
+func (c *fakeClient) Elicit(ctx context.Context, request mcp.ElicitationRequest) (*mcp.ElicitationResult, error) {
+	c.message = request.Params.Message
+	return &mcp.ElicitationResult{ElicitationResponse: c.response}, nil
+}
+
+// contextWithClient returns a context carrying a client session that supports elicitation
+func contextWithClient(client *fakeClient) context.Context {
+	session := server.NewInProcessSessionWithHandlers("test", nil, client, nil)
+	session.SetClientCapabilities(mcp.ClientCapabilities{Elicitation: &mcp.ElicitationCapability{}})
+	return server.NewMCPServer("test", "1.0.0").WithContext(context.Background(), session)
+}
+
+// TestAsk tests the user's answers to a confirmation request
+func TestAsk(t *testing.T) {
+	defer Configure(DefaultConfig())
+	Configure(Config{Enabled: true, Fallback: FallbackDeny})
+
+	tests := []struct {
+		name     string
+		response mcp.ElicitationResponse
+		wantErr  error
+	}{
+		{"accepted", mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionAccept, Content: map[string]any{"approve": true}}, nil},
+		{"accepted without content", mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionAccept}, nil},
+		{"unchecked", mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionAccept, Content: map[string]any{"approve": false}}, ErrDeclined},
+		{"declined", mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionDecline}, ErrDeclined},
+		{"cancelled", mcp.ElicitationResponse{Action: mcp.ElicitationResponseActionCancel}, ErrDeclined},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{response: tt.response}
+			err := Ask(contextWithClient(client), "Delete everything?")
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("Ask() error = %v, want %v", err, tt.wantErr)
+			}
+			if client.message != "Delete everything?" {
+				t.Errorf("client received message %q", client.message)
+			}
+		})
+	}
+}
+
+// TestAskFallback tests clients without elicitation support
+func TestAskFallback(t *testing.T) {
+	defer Configure(DefaultConfig())
+
+	noCapability := server.NewInProcessSessionWithHandlers("test", nil, &fakeClient{}, nil)
+	contexts := map[string]context.Context{
+		"no session":    context.Background(),
+		"no capability": server.NewMCPServer("test", "1.0.0").WithContext(context.Background(), noCapability),
+	}
+
+	for name, ctx := range contexts {
+		Configure(Config{Enabled: true, Fallback: FallbackAllow})
+		if err := Ask(ctx, "overwrite?"); err != nil {
+			t.Errorf("%s: Ask() with allow fallback error = %v", name, err)
+		}
+
+		Configure(Config{Enabled: true, Fallback: FallbackDeny})
+		err := Ask(ctx, "overwrite?")
+		if !errors.Is(err, ErrUnsupported) || !strings.Contains(err.Error(), "elicitation") {
+			t.Errorf("%s: Ask() with deny fallback error = %v, want ErrUnsupported", name, err)
+		}
+
+		Configure(Config{Enabled: false, Fallback: FallbackDeny})
+		if err := Ask(ctx, "overwrite?"); err != nil {
+			t.Errorf("%s: Ask() with confirmations disabled error = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/sandbox"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetApplyPatch() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("apply_patch",
+		mcp.WithDescription("Applies a unified diff, such as the output of diff -u or git diff, to the files it names. Files can be changed, created, deleted and renamed. Hunks are found even if the lines moved or some context changed. Unless partial is set, nothing is written if any hunk does not apply; the result reports the outcome of every hunk"),
+		mcp.WithString("patch",
+			mcp.Required(),
+			mcp.Description("The unified diff to apply"),
+		),
+		mcp.WithString("directory",
+			mcp.Description("Directory the file names in the patch are relative to. Defaults to the first allowed root or the server's working directory"),
+		),
+		mcp.WithNumber("strip",
+			mcp.Description("Number of leading path components to remove from file names, like patch -p. By default the a/ and b/ prefixes of git diffs are removed"),
+			mcp.Min(0),
+		),
+		mcp.WithBoolean("partial",
+			mcp.Description("Write the files and hunks that apply even if others do not"),
+		),
+		mcp.WithBoolean("dry_run",
+			mcp.Description("Only check whether the patch applies, without writing anything"),
+		),
+	), applyPatchHandler
+}
+
+// patchedFile is the outcome of applying the changes to one file.
+type patchedFile struct {
+	Path string `json:"path"`
+	// OldPath is the file a renamed file was moved from.
+	OldPath string `json:"old_path,omitempty"`
+	// Operation is "modify", "create", "delete" or "rename".
+	Operation string       `json:"operation"`
+	Applied   bool         `json:"applied"`
+	Error     string       `json:"error,omitempty"`
+	Hunks     []hunkResult `json:"hunks"`
+
+	content    string // the patched content, in the file's encoding and line endings
+	changed    bool   // whether any hunk applied
+	sourcePath string // the file the changes were applied to
+}
+
+// patchResult is the structured content of an apply_patch result.
+type patchResult struct {
+	Files   []*patchedFile `json:"files"`
+	DryRun  bool           `json:"dry_run"`
+	Written bool           `json:"written"`
+}
+
+func applyPatchHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	patchText, ok := request.GetArguments()["patch"].(string)
+	if !ok || strings.TrimSpace(patchText) == "" {
+		return nil, errors.New("patch is required")
+	}
+	strip := request.GetInt("strip", -1)
+	partial := request.GetBool("partial", false)
+	dryRun := request.GetBool("dry_run", false)
+
+	patches, err := parsePatch(patchText, strip)
+	if err != nil {
+		return nil, fmt.Errorf("invalid patch: %w", err)
+	}
+
+	dir, err := patchDirectory(ctx, request.GetString("directory", ""))
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if !dryRun {
+		// Refuse before the user is asked to confirm an operation that cannot happen
+		if err := checkWritable(); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+	}
+
+	// Everything is applied in memory first, so a failure leaves all files untouched
+	result := patchResult{DryRun: dryRun}
+	failed := 0
+	for _, p := range patches {
+		file := planPatch(ctx, dir, p)
+		result.Files = append(result.Files, file)
+		if !file.Applied {
+			failed++
+		}
+	}
+	report := patchReport(result.Files)
+
+	switch {
+	case failed > 0 && !partial:
+		return utils.NewToolResultError(fmt.Errorf("%w: %d of %d files do not apply, nothing was written; fix the patch or set partial to write the changes that apply",
+			utils.ErrConflict, failed, len(result.Files)), report), nil
+	case dryRun:
+		return mcp.NewToolResultStructured(result, report+"\nDry run, nothing was written"), nil
+	}
+
+	if err := confirm.Ask(ctx, "Apply patch?\n\n"+report+"\n"+truncateDiff(patchText)); err != nil {
+		return utils.NewToolResultError(err, report), nil
+	}
+	var paths []string
+	for _, file := range result.Files {
+		paths = append(paths, file.Path)
+		if file.OldPath != "" {
+			paths = append(paths, file.OldPath)
+		}
+	}
+	pending := beginChange(ctx, "apply_patch", paths...)
+	for _, file := range result.Files {
+		if err := writePatchedFile(file, partial); err != nil {
+			if rollbackErr := pending.rollback(ctx); rollbackErr != nil {
+				err = fmt.Errorf("%w; changing the files back failed too, see list_changes: %v", err, rollbackErr)
+			} else {
+				err = fmt.Errorf("%w; the files written so far were changed back", err)
+			}
+			return utils.NewToolResultError(err, report), nil
+		}
+	}
+	pending.commit()
+	result.Written = true
+
+	summary := utils.IfElse(failed == 0, "Patch applied", fmt.Sprintf("Patch partly applied, %d of %d files have changes that do not apply", failed, len(result.Files)))
+	return mcp.NewToolResultStructured(result, report+"\n"+summary), nil
+}
+
+// patchDirectory returns the directory the file names of a patch are relative to.
+func patchDirectory(ctx context.Context, dir string) (string, error) {
+	if dir != "" {
+		return resolvePath(ctx, dir)
+	}
+	dir, err := sandbox.WorkDir(ctx, "")
+	if err != nil || dir != "" {
+		return dir, err
+	}
+	return os.Getwd()
+}
+
+// planPatch applies the changes of p to the content of the file they are for and returns the
+// outcome, without writing anything.
+func planPatch(ctx context.Context, dir string, p *filePatch) *patchedFile {
+	file := &patchedFile{}
+	resolve := func(name string) (string, error) {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, name)
+		}
+		return resolvePath(ctx, name)
+	}
+
+	var err error
+	switch {
+	case p.OldName == "":
+		file.Operation = "create"
+		file.Path, err = resolve(p.NewName)
+	case p.NewName == "":
+		file.Operation = "delete"
+		file.Path, err = resolve(p.OldName)
+		file.sourcePath = file.Path
+	default:
+		file.Path, err = resolve(p.NewName)
+		if err == nil {
+			file.sourcePath, err = resolve(p.OldName)
+		}
+		file.Operation = utils.IfElse(file.sourcePath == file.Path, "modify", "rename")
+		if file.Operation == "rename" {
+			file.OldPath = file.sourcePath
+		}
+	}
+	if err == nil {
+		err = file.apply(p.Hunks)
+	}
+	if err != nil {
+		file.Error = err.Error()
+		file.Applied = false
+	}
+	return file
+}
+
+// apply applies hunks to the current content of the file, checking first that the operation is possible.
+func (f *patchedFile) apply(hunks []*hunk) error {
+	if f.Operation == "create" || f.Operation == "rename" {
+		if _, err := os.Lstat(f.Path); err == nil {
+			return fmt.Errorf("%s: %w", f.Path, os.ErrExist)
+		}
+	}
+
+	original, encodingName, lineEnding := "", "utf-8", ""
+	if f.sourcePath != "" {
+		mediaType, _, err := detectContentType(f.sourcePath)
+		if err != nil {
+			return err
+		}
+		if !isText(mediaType) {
+			return fmt.Errorf("%s is a binary file (%s): %w", f.sourcePath, mediaType, errors.ErrUnsupported)
+		}
+		original, encodingName, err = decodeFile(f.sourcePath)
+		if err != nil {
+			return err
+		}
+		lineEnding = detectLineEnding(original)
+	}
+
+	// Hunks are matched against lines ending in "\n", whatever the file uses
+	before := utils.IfElse(lineEnding == "crlf", convertLineEndings(original, "lf"), original)
+	after, results := applyHunks(before, hunks)
+	f.Hunks = results
+	f.Applied = true
+	for _, result := range results {
+		f.Applied = f.Applied && result.Applied
+		f.changed = f.changed || result.Applied
+	}
+	if f.Operation == "delete" && f.Applied && after != "" {
+		return fmt.Errorf("%w: %s is not empty after the changes, so it is not deleted", utils.ErrConflict, f.Path)
+	}
+
+	content, err := encodeText(utils.IfElse(lineEnding == "crlf", convertLineEndings(after, "crlf"), after), encodingName)
+	if err != nil {
+		return err
+	}
+	f.content = content
+	return nil
+}
+
+// writePatchedFile carries out the operation of a planned file. Files with hunks that did not
+// apply are only written if partial is set and they keep their name, so the changes that did
+// apply are kept while no file is created, deleted or renamed on the basis of a failed hunk.
+func writePatchedFile(file *patchedFile, partial bool) error {
+	if !file.Applied && !(partial && file.Error == "" && file.Operation == "modify" && file.changed) {
+		return nil
+	}
+	switch file.Operation {
+	case "delete":
+		if err := checkWritable(); err != nil {
+			return err
+		}
+		return os.Remove(file.Path)
+	case "rename":
+		// The renamed file keeps the permission of the original
+		info, err := os.Stat(file.OldPath)
+		if err != nil {
+			return err
+		}
+		if err := writeFileWith(file.Path, []byte(file.content), writeOptions{Mode: info.Mode().Perm(), CreateOnly: true}); err != nil {
+			return err
+		}
+		return os.Remove(file.OldPath)
+	default:
+		return writeFile(file.Path, file.content)
+	}
+}
+
+// patchReport describes the outcome of every file and hunk of a patch, in the manner of patch.
+func patchReport(files []*patchedFile) string {
+	var b strings.Builder
+	for _, file := range files {
+		switch file.Operation {
+		case "create":
+			fmt.Fprintf(&b, "creating file %s\n", file.Path)
+		case "delete":
+			fmt.Fprintf(&b, "deleting file %s\n", file.Path)
+		case "rename":
+			fmt.Fprintf(&b, "renaming file %s to %s\n", file.OldPath, file.Path)
+		default:
+			fmt.Fprintf(&b, "patching file %s\n", file.Path)
+		}
+		if file.Error != "" {
+			fmt.Fprintf(&b, "  FAILED: %s\n", file.Error)
+		}
+		for _, h := range file.Hunks {
+			switch {
+			case !h.Applied:
+				fmt.Fprintf(&b, "  hunk %d FAILED: %s\n", h.Hunk, h.Error)
+			case h.Offset != 0 || h.Fuzz != 0:
+				fmt.Fprintf(&b, "  hunk %d applied at line %d (offset %d lines, fuzz %d)\n", h.Hunk, h.Line, h.Offset, h.Fuzz)
+			default:
+				fmt.Fprintf(&b, "  hunk %d applied at line %d\n", h.Hunk, h.Line)
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,181 @@
+package files
+
+import (
+	"errors"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// defaultFileMode is the permission of new files, before the umask is applied.
+const defaultFileMode fs.FileMode = 0644
+
+// writeOptions changes how writeFileWith writes a file.
+type writeOptions struct {
+	// Mode is the permission the file gets. Zero keeps the permission of the file being
+	// replaced and uses defaultFileMode for new files.
+	Mode fs.FileMode
+	// CreateOnly fails with fs.ErrExist instead of replacing an existing file.
+	CreateOnly bool
+}
+
+// writeFileWith writes data to the file at path, creating parent directories as needed. The data
+// is written to a temporary file in the same directory, synced to disk and renamed over path, so
+// readers and crashes see either the old or the new content and never a mix of both. A replaced
+// file keeps its permissions and, where the platform has them, its owner. Files that cannot be
+// replaced without losing something, such as files with other hard links or owned by a user the
+// server cannot give a file to, are overwritten in place instead. A symbolic link at path is kept
+// and the file it points to is written.
+func writeFileWith(path string, data []byte, opts writeOptions) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+
+	// Ensure parent directory exists
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && opts.CreateOnly:
+		return &fs.PathError{Op: "create", Path: path, Err: fs.ErrExist}
+	case err == nil && info.IsDir():
+		return &fs.PathError{Op: "write", Path: path, Err: utils.ErrIsADirectory}
+	case err == nil && !info.Mode().IsRegular():
+		// Devices, pipes and dangling links cannot be replaced by renaming
+		return writeInPlace(path, data, opts.Mode)
+	case err == nil && linkCount(info) > 1:
+		// Renaming would split the file from its other names
+		return writeInPlace(path, data, opts.Mode)
+	case err != nil && !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+
+	mode := opts.Mode
+	if mode == 0 && info != nil {
+		mode = info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	}
+	temp, err := createTemp(path, mode)
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			temp.Close()
+			os.Remove(temp.Name())
+		}
+	}()
+
+	if info != nil {
+		if uid, gid, ok := fileOwner(info); ok {
+			if err := temp.Chown(uid, gid); err != nil {
+				// The server may not give files away; overwriting keeps the owner
+				return writeInPlace(path, data, opts.Mode)
+			}
+		}
+	}
+	if _, err := temp.Write(data); err != nil {
+		return err
+	}
+	// The permission is set after changing the owner, which clears the setuid and setgid bits
+	if mode != 0 {
+		if err := temp.Chmod(mode); err != nil {
+			return err
+		}
+	}
+	if err := temp.Sync(); err != nil {
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if opts.CreateOnly {
+		err = createFrom(temp.Name(), path)
+	} else {
+		err = os.Rename(temp.Name(), path)
+	}
+	if err != nil {
+		return err
+	}
+	committed = true
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// createTemp creates a new file next to path for writeFileWith. Without an explicit mode it
+// gets defaultFileMode, to which the umask is applied like for any new file.
+func createTemp(path string, mode fs.FileMode) (*os.File, error) {
+	perm := utils.IfElse(mode == 0, defaultFileMode, mode.Perm())
+	dir, base := filepath.Split(path)
+	for {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(rand.Uint64(), 36)+".tmp")
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if !errors.Is(err, fs.ErrExist) {
+			return file, err
+		}
+	}
+}
+
+// createFrom moves the file at temp to path unless path exists. A hard link is made first, which
+// fails if path exists without a window in which another writer could create it.
+func createFrom(temp string, path string) error {
+	err := os.Link(temp, path)
+	switch {
+	case err == nil:
+		return os.Remove(temp)
+	case errors.Is(err, fs.ErrExist):
+		return err
+	}
+	// The file system has no hard links
+	if _, err := os.Lstat(path); err == nil {
+		return &fs.PathError{Op: "create", Path: path, Err: fs.ErrExist}
+	}
+	return os.Rename(temp, path)
+}
+
+// writeInPlace overwrites the file at path with data, for files writeFileWith cannot replace.
+// The file is truncated first, so a crash while writing can leave part of the data.
+func writeInPlace(path string, data []byte, mode fs.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		return err
+	}
+	if mode != 0 {
+		if err := file.Chmod(mode); err != nil {
+			return err
+		}
+	}
+	// Devices and pipes cannot be synced
+	if err := file.Sync(); err != nil && isRegularFile(file) {
+		return err
+	}
+	return file.Close()
+}
+
+// isRegularFile reports whether file is a regular file.
+func isRegularFile(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode().IsRegular()
+}
+
+// syncDir flushes the entries of dir to disk, so a rename in it survives a crash. It is best
+// effort: not every platform can sync a directory.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,372 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// maxCheckpoints is the most checkpoints kept; creating another one removes the oldest.
+const maxCheckpoints = 20
+
+// checkpoint is a snapshot of the files under a directory, whose contents are in the backup store.
+type checkpoint struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name,omitempty"`
+	Root    string    `json:"root"`
+	Time    time.Time `json:"time"`
+	Session string    `json:"session"`
+	// Files and Bytes count the regular files in the snapshot and their total size.
+	Files   int               `json:"files"`
+	Bytes   int64             `json:"bytes"`
+	Entries []checkpointEntry `json:"entries,omitempty"`
+}
+
+// checkpointEntry is the state of an entry of a checkpoint.
+type checkpointEntry struct {
+	// Path is relative to the root of the checkpoint, with forward slashes.
+	Path string `json:"path"`
+	pathState
+}
+
+// checkpointsDir returns the directory the checkpoints are kept in, one file each.
+func checkpointsDir() string {
+	return filepath.Join(config.BackupDir, "checkpoints")
+}
+
+// loadCheckpoints returns the checkpoints, oldest first. With entries unset, only the summaries
+// are returned.
+func loadCheckpoints(entries bool) ([]*checkpoint, error) {
+	dirEntries, err := os.ReadDir(checkpointsDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var checkpoints []*checkpoint
+	for _, entry := range dirEntries {
+		id, found := strings.CutSuffix(entry.Name(), ".json")
+		if _, err := strconv.Atoi(id); !found || err != nil {
+			continue
+		}
+		cp, err := readCheckpoint(filepath.Join(checkpointsDir(), entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if !entries {
+			cp.Entries = nil
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	slices.SortFunc(checkpoints, func(a, b *checkpoint) int { return a.ID - b.ID })
+	return checkpoints, nil
+}
+
+// readCheckpoint reads the checkpoint in the file at path.
+func readCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("reading the checkpoint %s: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// findCheckpoint returns the checkpoint with the given ID, or with ID 0 the newest one of root.
+func findCheckpoint(id int, root string) (*checkpoint, error) {
+	if id != 0 {
+		cp, err := readCheckpoint(filepath.Join(checkpointsDir(), strconv.Itoa(id)+".json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("checkpoint %d does not exist, it may have been removed to make room for newer ones: %w", id, fs.ErrNotExist)
+		}
+		return cp, err
+	}
+
+	checkpoints, err := loadCheckpoints(false)
+	if err != nil {
+		return nil, err
+	}
+	for _, cp := range slices.Backward(checkpoints) {
+		if root == "" || cp.Root == root {
+			return readCheckpoint(filepath.Join(checkpointsDir(), strconv.Itoa(cp.ID)+".json"))
+		}
+	}
+	return nil, fmt.Errorf("no checkpoint of %s exists: %w", utils.IfElse(root == "", "any directory", root), fs.ErrNotExist)
+}
+
+// checkpointBlobs returns the hashes of the contents the checkpoints hold.
+func checkpointBlobs() map[string]bool {
+	blobs := make(map[string]bool)
+	checkpoints, _ := loadCheckpoints(true)
+	for _, cp := range checkpoints {
+		for _, entry := range cp.Entries {
+			if entry.Type == "file" {
+				blobs[entry.SHA256] = true
+			}
+		}
+	}
+	return blobs
+}
+
+// createCheckpoint saves the files under root that are not ignored in a new checkpoint.
+func createCheckpoint(ctx context.Context, root string, name string) (*checkpoint, error) {
+	cp := &checkpoint{Name: name, Root: root, Time: time.Now(), Session: sessionID(ctx)}
+	store := backups()
+	snapshot, err := snapshotTree(ctx, root, func(rel string, path string, size int64) (string, error) {
+		cp.Files++
+		cp.Bytes += size
+		if config.MaxBackupBytes > 0 && cp.Bytes > config.MaxBackupBytes {
+			return "", fmt.Errorf("%w: the files under %s hold more than the backup limit of %d bytes; ignore large files in .jarvisignore or raise --max-backup-bytes",
+				utils.ErrTooLarge, root, config.MaxBackupBytes)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return store.put(data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, rel := range slices.Sorted(maps.Keys(snapshot)) {
+		cp.Entries = append(cp.Entries, checkpointEntry{Path: rel, pathState: snapshot[rel]})
+	}
+
+	if err := os.MkdirAll(checkpointsDir(), 0700); err != nil {
+		return nil, err
+	}
+
+	// The ID is taken by creating the file, so servers sharing the directory cannot take the same
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	checkpoints, err := loadCheckpoints(false)
+	if err != nil {
+		return nil, err
+	}
+	cp.ID = 1
+	if len(checkpoints) > 0 {
+		cp.ID = checkpoints[len(checkpoints)-1].ID + 1
+	}
+	for {
+		data, err := json.Marshal(cp)
+		if err != nil {
+			return nil, err
+		}
+		err = writeFileWith(filepath.Join(checkpointsDir(), strconv.Itoa(cp.ID)+".json"), data, writeOptions{Mode: 0600, CreateOnly: true})
+		if !errors.Is(err, fs.ErrExist) {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		cp.ID++
+	}
+
+	if len(checkpoints)+1 > maxCheckpoints {
+		for _, old := range checkpoints[:len(checkpoints)+1-maxCheckpoints] {
+			os.Remove(filepath.Join(checkpointsDir(), strconv.Itoa(old.ID)+".json"))
+		}
+		keep := checkpointBlobs()
+		if changes, err := loadJournal(); err == nil {
+			maps.Copy(keep, journalBlobs(changes))
+			store.prune(keep)
+		}
+	}
+	return cp, nil
+}
+
+// snapshotTree returns the state of every entry under root that is not ignored, keyed by its path
+// relative to root with forward slashes. The hash of a regular file is taken from hash, which is
+// given its relative and full path and its size. The .git directory, the backup directory and
+// files that are not regular files, directories or symbolic links are left out.
+func snapshotTree(ctx context.Context, root string, hash func(rel string, path string, size int64) (string, error)) (map[string]pathState, error) {
+	snapshot := make(map[string]pathState)
+	ignore, err := newIgnorer(root)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if entry.IsDir() && config.BackupDir != "" && path == config.BackupDir || ignore.match(rel, entry.IsDir()) {
+			return utils.IfElse(entry.IsDir(), fs.SkipDir, nil)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		state := pathState{Mode: info.Mode().Perm()}
+		switch {
+		case info.Mode().IsRegular():
+			state.Type = "file"
+			state.Size = info.Size()
+			state.SHA256, err = hash(rel, path, info.Size())
+		case info.IsDir():
+			state.Type = "directory"
+			err = ignore.enter(path, rel)
+		case info.Mode()&fs.ModeSymlink != 0:
+			state.Type = "symlink"
+			state.Target, err = os.Readlink(path)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		snapshot[rel] = state
+		return nil
+	})
+	return snapshot, err
+}
+
+// checkpointDiff lists the paths, relative to the root of a checkpoint, that differ from it.
+type checkpointDiff struct {
+	// Created are the paths that were not in the checkpoint.
+	Created []string `json:"created"`
+	// Deleted are the paths of the checkpoint that no longer exist.
+	Deleted []string `json:"deleted"`
+	// Modified are the paths whose content, permission or type changed.
+	Modified []string `json:"modified"`
+}
+
+// empty reports whether nothing changed.
+func (d checkpointDiff) empty() bool {
+	return len(d.Created)+len(d.Deleted)+len(d.Modified) == 0
+}
+
+// diffCheckpoint compares the files under the root of cp with it. Only files of the same size as
+// in the checkpoint are hashed. It returns the differences and the current state of the entries.
+func diffCheckpoint(ctx context.Context, cp *checkpoint) (checkpointDiff, map[string]pathState, error) {
+	saved := make(map[string]pathState, len(cp.Entries))
+	for _, entry := range cp.Entries {
+		saved[entry.Path] = entry.pathState
+	}
+	current, err := snapshotTree(ctx, cp.Root, func(rel string, path string, size int64) (string, error) {
+		if state, ok := saved[rel]; !ok || state.Type != "file" || state.Size != size {
+			return "", nil
+		}
+		return fileSHA256(path)
+	})
+	if err != nil {
+		return checkpointDiff{}, nil, err
+	}
+
+	diff := checkpointDiff{Created: []string{}, Deleted: []string{}, Modified: []string{}}
+	for _, rel := range slices.Sorted(maps.Keys(current)) {
+		state, ok := saved[rel]
+		switch {
+		case !ok:
+			diff.Created = append(diff.Created, rel)
+		case state.Type == "directory" && current[rel].Type == "directory":
+			// The content of directories is compared entry by entry
+			if state.Mode != current[rel].Mode {
+				diff.Modified = append(diff.Modified, rel)
+			}
+		case !state.equal(current[rel]):
+			diff.Modified = append(diff.Modified, rel)
+		}
+	}
+	for _, entry := range cp.Entries {
+		if _, ok := current[entry.Path]; !ok {
+			diff.Deleted = append(diff.Deleted, entry.Path)
+		}
+	}
+	return diff, current, nil
+}
+
+// textContent decodes data to UTF-8 if it is text, reporting whether it is.
+func textContent(data []byte) (string, bool) {
+	if !isText(http.DetectContentType(data[:min(len(data), sniffLen)])) {
+		return "", false
+	}
+	encodingName, err := detectEncoding(data[:min(len(data), sniffLen)], bytes.NewReader(data))
+	if err != nil {
+		return "", false
+	}
+	text, err := decodeText(data, encodingName)
+	return text, err == nil
+}
+
+// checkpointArgs returns the checkpoint a call of a checkpoint tool is about: the one with the
+// checkpoint_id argument, or else the newest one of the path argument, or the newest one overall.
+func checkpointArgs(ctx context.Context, request mcp.CallToolRequest) (*checkpoint, error) {
+	id := request.GetInt("checkpoint_id", 0)
+	dirPath := request.GetString("path", "")
+	if dirPath != "" {
+		var err error
+		if dirPath, err = resolvePath(ctx, dirPath); err != nil {
+			return nil, err
+		}
+		dirPath = canonicalPath(dirPath)
+	}
+	cp, err := findCheckpoint(id, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	if dirPath != "" && cp.Root != dirPath {
+		return nil, fmt.Errorf("checkpoint %d is of %s, not %s: %w", cp.ID, cp.Root, dirPath, fs.ErrNotExist)
+	}
+	// The sandbox of the current session applies to checkpoints made by any session
+	if _, err := resolvePath(ctx, cp.Root); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// describe names the checkpoint in messages.
+func (cp *checkpoint) describe() string {
+	name := fmt.Sprintf("checkpoint %d of %s, %s", cp.ID, cp.Root, cp.Time.Format(time.DateTime))
+	if cp.Name != "" {
+		name += fmt.Sprintf(" (%q)", cp.Name)
+	}
+	return name
+}
+
+// summary lists the differences one per line, marked like git status: A for created, D for
+// deleted and M for modified paths.
+func (d checkpointDiff) summary() string {
+	var lines []string
+	for _, rel := range d.Modified {
+		lines = append(lines, "M "+rel)
+	}
+	for _, rel := range d.Created {
+		lines = append(lines, "A "+rel)
+	}
+	for _, rel := range d.Deleted {
+		lines = append(lines, "D "+rel)
+	}
+	slices.SortFunc(lines, func(a, b string) int { return strings.Compare(a[2:], b[2:]) })
+	return strings.Join(lines, "\n") + "\n"
+}
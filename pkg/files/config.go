@@ -0,0 +1,66 @@
+package files
+
+import (
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path/filepath"
+)
+
+// Config holds the server-wide settings for file operations.
+type Config struct {
+	// ReadOnly refuses every operation that would create, change or move files.
+	ReadOnly bool
+	// MaxReadBytes caps the content returned by a single read_file call. Larger ranges are
+	// cut short and report where to continue. Zero means unlimited.
+	MaxReadBytes int
+	// MaxBinaryBytes caps the images and other binary files returned by read_file. Larger files
+	// are refused unless a byte range is requested. Zero means unlimited.
+	MaxBinaryBytes int
+	// BackupDir is where the journal of the changes made by file tools is kept, with the content
+	// they replaced, so the changes can be undone. Empty disables the journal.
+	BackupDir string
+	// MaxBackupBytes bounds the content kept in BackupDir. The oldest changes are forgotten
+	// when newer ones need the space.
+	MaxBackupBytes int64
+}
+
+// DefaultConfig returns the configuration used when Configure has not been called.
+func DefaultConfig() Config {
+	return Config{
+		MaxReadBytes:   256 * 1024,
+		MaxBinaryBytes: 5 * 1024 * 1024,
+		MaxBackupBytes: 256 * 1024 * 1024,
+	}
+}
+
+// DefaultBackupDir returns the directory for BackupDir in the user's cache directory, or "" if
+// the platform has none.
+func DefaultBackupDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "jarvis-mcp", "backups")
+}
+
+// config is the active configuration shared by all file tools.
+var config = DefaultConfig()
+
+// Configure replaces the server-wide file operation settings.
+// It should be called once at startup, before any tool is registered.
+func Configure(c Config) {
+	config = c
+}
+
+// ErrReadOnly is returned for operations that would change files while the server is read-only.
+var ErrReadOnly = utils.NewCodedError(utils.ErrCodePolicyDenied,
+	fmt.Errorf("%w: the server is in read-only mode", utils.ErrPolicyDenied))
+
+// checkWritable returns ErrReadOnly if the server must not change files.
+func checkWritable() error {
+	if config.ReadOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
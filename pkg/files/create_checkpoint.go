@@ -0,0 +1,52 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetCreateCheckpoint() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("create_checkpoint",
+		mcp.WithDescription("Saves a snapshot of every file under a directory, such as a project before a risky change, that restore_checkpoint can return the directory to. Files ignored by .gitignore, .ignore or .jarvisignore files and the .git directory are left out"),
+		mcp.WithString("path",
+			mcp.Required(),
+			mcp.Description("Directory to save"),
+		),
+		mcp.WithString("name",
+			mcp.Description("Name describing the checkpoint, such as the task about to begin"),
+		),
+	), createCheckpointHandler
+}
+
+func createCheckpointHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	dirPath, ok := request.GetArguments()["path"].(string)
+	if !ok {
+		return nil, errors.New("directory path is required")
+	}
+	name := request.GetString("name", "")
+
+	if config.BackupDir == "" {
+		return utils.NewToolResultError(errCheckpointsDisabled, ""), nil
+	}
+	dirPath, err := resolvePath(ctx, dirPath)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	dirPath = canonicalPath(dirPath)
+
+	cp, err := createCheckpoint(ctx, dirPath, name)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	cp.Entries = nil
+
+	return mcp.NewToolResultStructured(cp, fmt.Sprintf("Created checkpoint %d of %s with %d files (%d bytes)", cp.ID, cp.Root, cp.Files, cp.Bytes)), nil
+}
+
+// errCheckpointsDisabled is the error of the checkpoint tools when the server keeps no backups.
+var errCheckpointsDisabled = fmt.Errorf("checkpoints are not available, the server was started with an empty --backup-dir: %w", errors.ErrUnsupported)
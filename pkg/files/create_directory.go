@@ -3,6 +3,7 @@ package files
 import (
 	"context"
 	"errors"
+	"jarvis_mcp/pkg/utils"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -19,15 +20,22 @@ func GetCreateDirectory() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 }
 
 func createDirectoryHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	dirPath, ok := request.Params.Arguments["path"].(string)
+	dirPath, ok := request.GetArguments()["path"].(string)
 	if !ok {
 		return nil, errors.New("directory path is required")
 	}
 
-	err := createDirectory(dirPath)
+	dirPath, err := resolvePath(ctx, dirPath)
 	if err != nil {
-		return nil, err
+		return utils.NewToolResultError(err, ""), nil
 	}
 
+	pending := beginChange(ctx, "create_directory", dirPath)
+	err = createDirectory(dirPath)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	pending.commit()
+
 	return mcp.NewToolResultText("Successfully created directory " + dirPath), nil
 }
@@ -0,0 +1,226 @@
+package files
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffEdits bounds the work spent on finding a minimal diff. Texts that differ in
+// more lines are shown as a replacement of everything between their common prefix and suffix.
+const maxDiffEdits = 4000
+
+// diffOp is one line of a line-based diff: ' ' for unchanged, '-' for removed and '+' for added.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits text into lines, keeping the line terminators.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the edit script turning a into b.
+func diffLines(a, b []string) []diffOp {
+	// Common prefix and suffix are unchanged and need no search
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	middle, ok := myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	if !ok {
+		middle = middle[:0]
+		for _, line := range a[prefix : len(a)-suffix] {
+			middle = append(middle, diffOp{'-', line})
+		}
+		for _, line := range b[prefix : len(b)-suffix] {
+			middle = append(middle, diffOp{'+', line})
+		}
+	}
+	ops = append(ops, middle...)
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// myers computes a shortest edit script with Myers' O(ND) algorithm. It gives up and
+// returns false if more than maxDiffEdits edits are needed.
+func myers(a, b []string) ([]diffOp, bool) {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= n+m; d++ {
+		if d > maxDiffEdits {
+			return nil, false
+		}
+		// Keep the furthest reaching paths of the previous round for backtracking
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				return backtrack(a, b, trace), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// backtrack walks the recorded paths from the end to the start and returns the edit script.
+func backtrack(a, b []string, trace [][]int) []diffOp {
+	var ops []diffOp
+	x, y := len(a), len(b)
+
+	for d := len(trace) - 1; d >= 0; d-- {
+		vd := trace[d]
+		get := func(k int) int { return vd[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+		if d == 0 {
+			prevX, prevY = 0, 0
+		}
+
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// unifiedDiff returns the changes from oldText to newText in unified diff format,
+// or an empty string if the texts are equal.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	// oldLine and newLine are the 1-based line numbers of ops[i] in each text
+	oldLine, newLine := 1, 1
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			oldLine++
+			newLine++
+			i++
+			continue
+		}
+
+		// Extend the hunk while the next change is close enough to share context
+		start := max(i-diffContext, 0)
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].kind == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, len(ops))
+				break
+			}
+			end = run
+		}
+
+		hunkOld := oldLine - (i - start)
+		hunkNew := newLine - (i - start)
+		oldCount, newCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(hunkOld, oldCount), hunkRange(hunkNew, newCount))
+		for _, op := range ops[start:end] {
+			b.WriteByte(op.kind)
+			b.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		for _, op := range ops[i:end] {
+			if op.kind != '+' {
+				oldLine++
+			}
+			if op.kind != '-' {
+				newLine++
+			}
+		}
+		i = end
+	}
+	return b.String()
+}
+
+// hunkRange formats the start and length of a hunk side as used in "@@" headers.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		// An empty range refers to the line before the insertion point
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,108 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetDiffCheckpoint() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("diff_checkpoint",
+		mcp.WithDescription("Lists the files created, deleted and modified under a directory since a checkpoint, optionally with unified diffs of text files"),
+		mcp.WithNumber("checkpoint_id",
+			mcp.Description("ID of the checkpoint, as listed by list_checkpoints. Defaults to the newest checkpoint of path"),
+			mcp.Min(1),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory whose newest checkpoint to compare with, if checkpoint_id is not given"),
+		),
+		mcp.WithBoolean("show_diff",
+			mcp.Description("Include unified diffs of the text files that changed"),
+		),
+	), diffCheckpointHandler
+}
+
+// checkpointDiffResult is the structured content of a diff_checkpoint result.
+type checkpointDiffResult struct {
+	Checkpoint *checkpoint `json:"checkpoint"`
+	checkpointDiff
+}
+
+func diffCheckpointHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	showDiff := request.GetBool("show_diff", false)
+	if config.BackupDir == "" {
+		return utils.NewToolResultError(errCheckpointsDisabled, ""), nil
+	}
+	cp, err := checkpointArgs(ctx, request)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	diff, _, err := diffCheckpoint(ctx, cp)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	saved := make(map[string]checkpointEntry, len(cp.Entries))
+	for _, entry := range cp.Entries {
+		saved[entry.Path] = entry
+	}
+	cp.Entries = nil
+	result := checkpointDiffResult{Checkpoint: cp, checkpointDiff: diff}
+
+	if diff.empty() {
+		return mcp.NewToolResultStructured(result, "Nothing changed since "+cp.describe()), nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Changes since %s:\n%s", cp.describe(), diff.summary())
+	if showDiff {
+		for _, rel := range append(append(diff.Modified, diff.Created...), diff.Deleted...) {
+			if b.Len() > config.MaxReadBytes && config.MaxReadBytes > 0 {
+				b.WriteString("\n[More diffs left out; the output limit was reached]\n")
+				break
+			}
+			b.WriteString(fileDiff(cp.Root, rel, saved[rel]))
+		}
+	}
+	return mcp.NewToolResultStructured(result, b.String()), nil
+}
+
+// fileDiff returns a unified diff from the saved state of the file at rel under root to its
+// current content, or "" if either is not text. Files that were created or deleted are compared
+// with /dev/null, so the diffs can be applied with apply_patch.
+func fileDiff(root string, rel string, saved checkpointEntry) string {
+	before, after := "", ""
+	oldName, newName := "/dev/null", "/dev/null"
+	if saved.Type == "file" {
+		oldName = "a/" + rel
+		data, err := backups().get(saved.SHA256)
+		if err != nil {
+			return ""
+		}
+		text, ok := textContent(data)
+		if !ok {
+			return ""
+		}
+		before = text
+	}
+	path := filepath.Join(root, filepath.FromSlash(rel))
+	if info, err := os.Lstat(path); err == nil && info.Mode().IsRegular() {
+		newName = "b/" + rel
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return ""
+		}
+		text, ok := textContent(data)
+		if !ok {
+			return ""
+		}
+		after = text
+	}
+	diff := unifiedDiff(oldName, newName, before, after)
+	return utils.IfElse(diff == "", "", "\n"+diff)
+}
@@ -3,31 +3,148 @@ package files
 import (
 	"context"
 	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// defaultTreeEntries is the number of entries directory_tree lists when no limit is given.
+const defaultTreeEntries = 1000
+
 func GetDirectoryTree() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("directory_tree",
-		mcp.WithDescription("Retrieve a detailed, recursive tree structure of files and directories in JSON format"),
+		mcp.WithDescription("Retrieve a detailed, recursive tree structure of files and directories in JSON format, or as compact text like the tree command. Directories cut short by max_depth or max_entries are marked with the number of entries left out"),
 		mcp.WithString("path",
 			mcp.Required(),
 			mcp.Description("The file system path of the directory for which to generate the tree structure"),
 		),
+		mcp.WithBoolean("respect_ignore",
+			mcp.Description("Leave out the entries ignored by .gitignore, .ignore and .jarvisignore files, and the .git directory. Defaults to true"),
+		),
+		mcp.WithBoolean("show_hidden",
+			mcp.Description("Include the entries whose name starts with a dot. Defaults to true"),
+		),
+		mcp.WithNumber("max_depth",
+			mcp.Description("Only list this many levels below the directory; 1 lists its own entries"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("max_entries",
+			mcp.Description(fmt.Sprintf("Maximum number of entries to list in the whole tree. Defaults to %d", defaultTreeEntries)),
+			mcp.Min(1),
+		),
+		mcp.WithBoolean("include_size",
+			mcp.Description("Include the size of each file in bytes"),
+		),
+		mcp.WithBoolean("include_mtime",
+			mcp.Description("Include the modification time of each entry"),
+		),
+		mcp.WithBoolean("include_totals",
+			mcp.Description("Include the total size and the number of files and directories under each directory, counting the entries left out by the limits too"),
+		),
+		mcp.WithString("format",
+			mcp.Description("json (the default) returns nested objects; text returns an indented tree that takes far fewer tokens"),
+			mcp.Enum("json", "text"),
+		),
 	), directoryTreeHandler
 }
 
 func directoryTreeHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	dirPath, ok := request.Params.Arguments["path"].(string)
+	dirPath, ok := request.GetArguments()["path"].(string)
 	if !ok {
 		return nil, errors.New("directory path is required")
 	}
 
-	treeJSON, err := directoryTree(dirPath)
+	opts := treeOptions{
+		RespectIgnore: request.GetBool("respect_ignore", true),
+		SkipHidden:    !request.GetBool("show_hidden", true),
+		MaxDepth:      request.GetInt("max_depth", 0),
+		MaxEntries:    request.GetInt("max_entries", defaultTreeEntries),
+		Size:          request.GetBool("include_size", false),
+		ModTime:       request.GetBool("include_mtime", false),
+		Totals:        request.GetBool("include_totals", false),
+		Format:        request.GetString("format", "json"),
+	}
+	if opts.MaxDepth < 0 || opts.MaxEntries < 1 {
+		return nil, errors.New("max_depth and max_entries must be at least 1")
+	}
+	if opts.Format != "json" && opts.Format != "text" {
+		return nil, fmt.Errorf("unknown format %q", opts.Format)
+	}
+
+	dirPath, err := resolvePath(ctx, dirPath)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	treeJSON, err := directoryTree(dirPath, opts)
 	if err != nil {
-		return nil, err
+		return utils.NewToolResultError(err, ""), nil
 	}
 
 	return mcp.NewToolResultText(treeJSON), nil
 }
+
+// renderTree formats a directory tree like the tree command, with a line per entry drawn under
+// its directory, the annotations of each entry in brackets, and a marker where entries were
+// left out.
+func renderTree(root *treeNode) string {
+	var b strings.Builder
+	b.WriteString(root.label() + "\n")
+
+	var dirs, files int
+	truncated := false
+	var render func(node *treeNode, indent string)
+	render = func(node *treeNode, indent string) {
+		for i, child := range node.Children {
+			last := i == len(node.Children)-1 && node.Truncated == 0
+			b.WriteString(indent + utils.IfElse(last, "└── ", "├── ") + child.label() + "\n")
+			if child.Type == "directory" {
+				dirs++
+				render(child, indent+utils.IfElse(last, "    ", "│   "))
+			} else {
+				files++
+			}
+		}
+		if node.Truncated > 0 {
+			truncated = true
+			fmt.Fprintf(&b, "%s└── [... %s not shown]\n", indent, countNoun(node.Truncated, "more entry", "more entries"))
+		}
+	}
+	render(root, "")
+
+	fmt.Fprintf(&b, "\n%s, %s\n", countNoun(dirs, "directory", "directories"), countNoun(files, "file", "files"))
+	if truncated {
+		b.WriteString("[The tree was cut short; raise max_depth or max_entries, or ask for a subdirectory]\n")
+	}
+	return b.String()
+}
+
+// label returns the line of the node in a rendered tree: its name, with a slash for a directory,
+// and its annotations.
+func (node *treeNode) label() string {
+	var notes []string
+	if node.Files != nil {
+		notes = append(notes, countNoun(*node.Files, "file", "files")+", "+countNoun(*node.Dirs, "dir", "dirs"))
+	}
+	if node.Size != nil {
+		notes = append(notes, humanSize(*node.Size))
+	}
+	if node.ModTime != nil {
+		notes = append(notes, node.ModTime.Local().Format(time.DateTime))
+	}
+
+	label := node.Name + utils.IfElse(node.Type == "directory", "/", "")
+	if len(notes) > 0 {
+		label += " [" + strings.Join(notes, ", ") + "]"
+	}
+	return label
+}
+
+// countNoun returns n followed by the singular or plural noun, as in "1 file" and "2 files".
+func countNoun(n int, singular string, plural string) string {
+	return fmt.Sprintf("%d %s", n, utils.IfElse(n == 1, singular, plural))
+}
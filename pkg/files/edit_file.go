@@ -0,0 +1,290 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetEditFile() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("edit_file",
+		mcp.WithDescription("Changes parts of a text file by replacing old_text with new_text, without rewriting the whole file. Each old_text must occur exactly once; if it is not found as is, lines matching it apart from whitespace are replaced. Edits are applied in order and all or none are written. Returns a unified diff of the changes"),
+		mcp.WithString("path",
+			mcp.Required(),
+			mcp.Description("Path of the file to edit"),
+		),
+		mcp.WithArray("edits",
+			mcp.Required(),
+			mcp.Description("Replacements to make, in order"),
+			mcp.MinItems(1),
+			mcp.Items(map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"old_text": map[string]any{"type": "string", "description": "Text to replace; include enough surrounding lines to make it unique"},
+					"new_text": map[string]any{"type": "string", "description": "Text to put in its place"},
+				},
+				"required": []string{"old_text", "new_text"},
+			}),
+		),
+		mcp.WithBoolean("dry_run",
+			mcp.Description("Only return the diff of the changes without writing the file"),
+		),
+	), editFileHandler
+}
+
+// textEdit replaces OldText with NewText.
+type textEdit struct {
+	OldText string
+	NewText string
+}
+
+// editMatch tells where an edit was applied.
+type editMatch struct {
+	Edit int `json:"edit"`
+	Line int `json:"line"`
+	// Fuzzy reports that old_text only matched when whitespace was ignored.
+	Fuzzy bool `json:"fuzzy,omitempty"`
+}
+
+// editResult is the structured content of an edit_file result.
+type editResult struct {
+	Path    string      `json:"path"`
+	DryRun  bool        `json:"dry_run"`
+	Changed bool        `json:"changed"`
+	Edits   []editMatch `json:"edits"`
+	Diff    string      `json:"diff"`
+}
+
+func editFileHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	fileName, ok := request.GetArguments()["path"].(string)
+	if !ok {
+		return nil, errors.New("file path is required")
+	}
+
+	edits, err := editsFromRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	dryRun := request.GetBool("dry_run", false)
+
+	fileName, err = resolvePath(ctx, fileName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if !dryRun {
+		// Refuse before the user is asked to confirm an operation that cannot happen
+		if err := checkWritable(); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+	}
+
+	mediaType, _, err := detectContentType(fileName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	if !isText(mediaType) {
+		return utils.NewToolResultError(fmt.Errorf("%s is a binary file (%s): %w", fileName, mediaType, errors.ErrUnsupported), ""), nil
+	}
+	original, encodingName, err := decodeFile(fileName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	// Edits are matched against lines ending in "\n", whatever the file uses
+	lineEnding := detectLineEnding(original)
+	before := utils.IfElse(lineEnding == "crlf", convertLineEndings(original, "lf"), original)
+	after, matches, err := applyEdits(before, edits)
+	if err != nil {
+		return utils.NewToolResultError(fmt.Errorf("%s: %w", fileName, err), ""), nil
+	}
+
+	result := editResult{
+		Path:    fileName,
+		DryRun:  dryRun,
+		Changed: after != before,
+		Edits:   matches,
+		Diff:    unifiedDiff(fileName, fileName, before, after),
+	}
+	if !result.Changed {
+		return mcp.NewToolResultStructured(result, "The edits do not change "+fileName), nil
+	}
+	if dryRun {
+		return mcp.NewToolResultStructured(result, "Dry run, nothing was written. The edits would change "+fileName+":\n\n"+result.Diff), nil
+	}
+
+	content := utils.IfElse(lineEnding == "crlf", convertLineEndings(after, "crlf"), after)
+	data, err := encodeText(content, encodingName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	if err := confirmOverwrite(ctx, fileName, content); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	pending := beginChange(ctx, "edit_file", fileName)
+	if err := writeFile(fileName, data); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	pending.commit()
+
+	return mcp.NewToolResultStructured(result, fmt.Sprintf("Applied %d edits to %s:\n\n%s", len(edits), fileName, result.Diff)), nil
+}
+
+// editsFromRequest validates the edits argument of an edit_file call.
+func editsFromRequest(request mcp.CallToolRequest) ([]textEdit, error) {
+	items, ok := request.GetArguments()["edits"].([]any)
+	if !ok || len(items) == 0 {
+		return nil, errors.New("edits must be a non-empty list of objects with old_text and new_text")
+	}
+
+	edits := make([]textEdit, 0, len(items))
+	for i, item := range items {
+		fields, _ := item.(map[string]any)
+		oldText, hasOld := fields["old_text"].(string)
+		newText, hasNew := fields["new_text"].(string)
+		switch {
+		case !hasOld || !hasNew:
+			return nil, fmt.Errorf("edit %d must have old_text and new_text", i+1)
+		case oldText == "":
+			return nil, fmt.Errorf("old_text of edit %d is empty", i+1)
+		}
+		edits = append(edits, textEdit{
+			OldText: convertLineEndings(oldText, "lf"),
+			NewText: convertLineEndings(newText, "lf"),
+		})
+	}
+	return edits, nil
+}
+
+// applyEdits applies edits to text one after another, each to the result of the ones before.
+// It fails with ErrConflict if the old text of an edit is not found or found more than once.
+func applyEdits(text string, edits []textEdit) (string, []editMatch, error) {
+	matches := make([]editMatch, 0, len(edits))
+	for i, edit := range edits {
+		match := editMatch{Edit: i + 1}
+
+		starts := findAll(text, edit.OldText)
+		switch len(starts) {
+		case 1:
+			match.Line = strings.Count(text[:starts[0]], "\n") + 1
+			text = text[:starts[0]] + edit.NewText + text[starts[0]+len(edit.OldText):]
+		case 0:
+			lines := splitLines(text)
+			oldLines := splitLines(edit.OldText)
+			found := findLinesIgnoringSpace(lines, oldLines)
+			if len(found) != 1 {
+				return "", nil, matchError(i+1, found)
+			}
+			start, end := found[0], found[0]+len(oldLines)
+			match.Line = start + 1
+			match.Fuzzy = true
+
+			// The first line with text shows how the indentation of old_text differs from the file
+			first := 0
+			for strings.TrimSpace(oldLines[first]) == "" {
+				first++
+			}
+			replaced := reindent(edit.NewText, oldLines[first], lines[start+first])
+			// The replaced lines end the way the matched ones did
+			if strings.HasSuffix(lines[end-1], "\n") {
+				replaced += utils.IfElse(replaced != "" && !strings.HasSuffix(replaced, "\n"), "\n", "")
+			} else {
+				replaced = strings.TrimSuffix(replaced, "\n")
+			}
+			text = strings.Join(lines[:start], "") + replaced + strings.Join(lines[end:], "")
+		default:
+			lineNumbers := make([]int, len(starts))
+			for j, start := range starts {
+				lineNumbers[j] = strings.Count(text[:start], "\n")
+			}
+			return "", nil, matchError(i+1, lineNumbers)
+		}
+		matches = append(matches, match)
+	}
+	return text, matches, nil
+}
+
+// matchError describes why an edit could not be applied, given the lines, counting from 0,
+// where its old text was found.
+func matchError(edit int, found []int) error {
+	if len(found) == 0 {
+		return fmt.Errorf("%w: old_text of edit %d was not found, not even ignoring whitespace; read the file to check its current content", utils.ErrConflict, edit)
+	}
+	lines := make([]string, len(found))
+	for i, line := range found {
+		lines[i] = fmt.Sprint(line + 1)
+	}
+	return fmt.Errorf("%w: old_text of edit %d matches %d times, at lines %s; include more surrounding lines to make it unique",
+		utils.ErrConflict, edit, len(found), strings.Join(lines, ", "))
+}
+
+// findAll returns the offsets of the non-overlapping occurrences of sub in text.
+func findAll(text string, sub string) []int {
+	var starts []int
+	for offset := 0; ; {
+		i := strings.Index(text[offset:], sub)
+		if i < 0 {
+			return starts
+		}
+		starts = append(starts, offset+i)
+		offset += i + len(sub)
+	}
+}
+
+// findLinesIgnoringSpace returns the indexes of the lines where a run of lines starts that
+// equals want when differences in whitespace are ignored.
+func findLinesIgnoringSpace(lines []string, want []string) []int {
+	normalized := make([]string, len(want))
+	blank := true
+	for i, line := range want {
+		normalized[i] = strings.Join(strings.Fields(line), " ")
+		blank = blank && normalized[i] == ""
+	}
+	if blank {
+		return nil
+	}
+
+	var starts []int
+	for start := 0; start+len(want) <= len(lines); start++ {
+		equal := true
+		for i := range want {
+			if strings.Join(strings.Fields(lines[start+i]), " ") != normalized[i] {
+				equal = false
+				break
+			}
+		}
+		if equal {
+			starts = append(starts, start)
+		}
+	}
+	return starts
+}
+
+// reindent moves newText to the indentation of the file where old_text matched, so a
+// replacement written with the wrong indentation still fits. oldLine is a line of old_text
+// and fileLine the line of the file it matched.
+func reindent(newText string, oldLine string, fileLine string) string {
+	oldIndent := leadingSpace(oldLine)
+	fileIndent := leadingSpace(fileLine)
+	if oldIndent == fileIndent {
+		return newText
+	}
+
+	lines := splitLines(newText)
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" && strings.HasPrefix(line, oldIndent) {
+			lines[i] = fileIndent + line[len(oldIndent):]
+		}
+	}
+	return strings.Join(lines, "")
+}
+
+// leadingSpace returns the spaces and tabs at the start of text.
+func leadingSpace(text string) string {
+	return text[:len(text)-len(strings.TrimLeft(text, " \t"))]
+}
@@ -0,0 +1,194 @@
+package files
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// encodings are the text encodings read_file decodes and write_file can write, by the names
+// reported to clients. The -bom variants start the file with a byte order mark.
+var encodings = map[string]encoding.Encoding{
+	"utf-8":        unicode.UTF8,
+	"utf-8-bom":    unicode.UTF8BOM,
+	"utf-16le":     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	"utf-16le-bom": unicode.UTF16(unicode.LittleEndian, unicode.UseBOM),
+	"utf-16be":     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	"utf-16be-bom": unicode.UTF16(unicode.BigEndian, unicode.UseBOM),
+	"latin1":       charmap.ISO8859_1,
+	"windows-1252": charmap.Windows1252,
+}
+
+// encodingNames lists the keys of encodings for tool descriptions, in a stable order.
+var encodingNames = []string{"utf-8", "utf-8-bom", "utf-16le", "utf-16le-bom", "utf-16be", "utf-16be-bom", "latin1", "windows-1252"}
+
+// maxDecodeBytes limits the files in encodings other than UTF-8 that are decoded for reading,
+// which unlike UTF-8 files are held in memory as a whole.
+const maxDecodeBytes = 64 * 1024 * 1024
+
+// detectEncoding returns the name of the encoding of the text read from r, whose first bytes
+// are head. A byte order mark decides; without one, UTF-16 is recognised by the zero bytes of
+// ASCII characters, and text that is not valid UTF-8 is taken to be Latin-1, or Windows-1252 if
+// it uses the characters that encoding adds.
+func detectEncoding(head []byte, r io.Reader) (string, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xef, 0xbb, 0xbf}):
+		return "utf-8-bom", nil
+	case bytes.HasPrefix(head, []byte{0xff, 0xfe}):
+		return "utf-16le-bom", nil
+	case bytes.HasPrefix(head, []byte{0xfe, 0xff}):
+		return "utf-16be-bom", nil
+	}
+	if name := utf16ByteOrder(head); name != "" {
+		return name, nil
+	}
+
+	// The whole text is checked, as legacy files are often plain ASCII for a long stretch
+	buf := make([]byte, 64*1024)
+	carry := 0
+	valid := true
+	for {
+		n, err := r.Read(buf[carry:])
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		data := buf[:carry+n]
+
+		complete := data
+		if err == nil {
+			complete = trimPartialRune(data)
+		}
+		if valid && !utf8.Valid(complete) {
+			valid = false
+		}
+		if !valid && hasWindows1252(data) {
+			return "windows-1252", nil
+		}
+		if err == io.EOF {
+			return utils.IfElse(valid, "utf-8", "latin1"), nil
+		}
+		carry = utils.IfElse(valid, copy(buf, data[len(complete):]), 0)
+	}
+}
+
+// hasWindows1252 reports whether data contains bytes that are control characters in Latin-1
+// but printable characters, such as curly quotes, in Windows-1252.
+func hasWindows1252(data []byte) bool {
+	for _, b := range data {
+		if b >= 0x80 && b <= 0x9f {
+			return true
+		}
+	}
+	return false
+}
+
+// utf16ByteOrder recognises UTF-16 text without a byte order mark, returning its encoding
+// name or "" if head does not look like it. Most text is made of ASCII characters, which in
+// UTF-16 are a printable byte and a zero byte, always in the same order.
+func utf16ByteOrder(head []byte) string {
+	pairs := len(head) / 2
+	if pairs < 2 {
+		return ""
+	}
+	var little, big int
+	for i := 0; i+1 < len(head); i += 2 {
+		switch {
+		case head[i] == 0 && head[i+1] == 0:
+			return "" // NUL characters do not occur in text
+		case head[i+1] == 0 && isASCIIText(head[i]):
+			little++
+		case head[i] == 0 && isASCIIText(head[i+1]):
+			big++
+		}
+	}
+	switch {
+	case little >= pairs/2 && big*10 <= little:
+		return "utf-16le"
+	case big >= pairs/2 && little*10 <= big:
+		return "utf-16be"
+	}
+	return ""
+}
+
+// isASCIIText reports whether b is a printable ASCII character or white space.
+func isASCIIText(b byte) bool {
+	return b >= 0x20 && b < 0x7f || b == '\t' || b == '\n' || b == '\r'
+}
+
+// decodeText converts data in the named encoding to UTF-8.
+func decodeText(data []byte, name string) (string, error) {
+	if name == "utf-8" {
+		return string(data), nil
+	}
+	decoded, err := encodings[name].NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("cannot decode %s: %w", name, err)
+	}
+	return string(decoded), nil
+}
+
+// encodeText converts text to the named encoding. It fails if the encoding cannot represent
+// every character of text.
+func encodeText(text string, name string) (string, error) {
+	if name == "utf-8" {
+		return text, nil
+	}
+	encoded, err := encodings[name].NewEncoder().String(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: the content cannot be written in %s: %v", errors.ErrUnsupported, name, err)
+	}
+	return encoded, nil
+}
+
+// decodeFile reads the file at path and returns its text converted to UTF-8, with the name
+// of the encoding it was found in.
+func decodeFile(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	name, err := detectEncoding(data[:min(len(data), sniffLen)], bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+	text, err := decodeText(data, name)
+	return text, name, err
+}
+
+// detectLineEnding returns "lf" or "crlf" if every line break in text is of that kind,
+// "mixed" if both occur, and "" if there is no line break.
+func detectLineEnding(text string) string {
+	lines := strings.Count(text, "\n")
+	crlf := strings.Count(text, "\r\n")
+	switch {
+	case lines == 0:
+		return ""
+	case crlf == lines:
+		return "crlf"
+	case crlf == 0:
+		return "lf"
+	default:
+		return "mixed"
+	}
+}
+
+// convertLineEndings makes every line break in text an "lf" or "crlf" line ending.
+// Any other lineEnding leaves text unchanged.
+func convertLineEndings(text string, lineEnding string) string {
+	switch lineEnding {
+	case "lf":
+		return strings.ReplaceAll(text, "\r\n", "\n")
+	case "crlf":
+		return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+	}
+	return text
+}
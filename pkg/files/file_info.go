@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"jarvis_mcp/pkg/utils"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -21,14 +22,19 @@ func GetFileInfo() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 }
 
 func getFileInfoHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	filePath, ok := request.Params.Arguments["path"].(string)
+	filePath, ok := request.GetArguments()["path"].(string)
 	if !ok {
 		return nil, errors.New("file path is required")
 	}
 
+	filePath, err := resolvePath(ctx, filePath)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
 	info, err := getFileInfo(filePath)
 	if err != nil {
-		return nil, err
+		return utils.NewToolResultError(err, ""), nil
 	}
 
 	// Format the output as JSON for better readability
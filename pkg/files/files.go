@@ -1,12 +1,21 @@
 package files
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"jarvis_mcp/pkg/sandbox"
 	"jarvis_mcp/pkg/utils"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/samber/lo"
 )
@@ -21,12 +30,9 @@ func normalizePath(path string) (string, error) {
 	}
 
 	// Expand home directory reference
-	if strings.HasPrefix(path, "~") {
-		userDir, err := os.UserHomeDir()
-		if err != nil {
-			return "", err // Return empty string consistently on error
-		}
-		path = filepath.Join(userDir, path[1:]) // More reliable than simple string replacement
+	path, err := expandHome(path)
+	if err != nil {
+		return "", err // Return empty string consistently on error
 	}
 
 	// Convert to absolute path
@@ -44,63 +50,81 @@ func normalizePath(path string) (string, error) {
 	return absPath, nil
 }
 
-// readFile reads the content of a file at the given path and returns it as a string.
-func readFile(path string) (string, error) {
-	// Validate and normalize the file path
-	path, err := normalizePath(path)
+// expandHome replaces a leading home directory reference (~) in path with the user's home directory.
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~") {
+		return path, nil
+	}
+	userDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
 	}
+	return filepath.Join(userDir, path[1:]), nil // More reliable than simple string replacement
+}
 
-	// Read the file directly into a string to avoid unnecessary conversions
-	data, err := os.ReadFile(path)
+// resolvePath is the single entry point for paths received from clients. It expands
+// home directory references (~) and checks the path against the sandbox, returning the
+// absolute path the operation must use. Unlike normalizePath, the path does not need to exist,
+// so targets that are about to be created are checked the same way.
+func resolvePath(ctx context.Context, path string) (string, error) {
+	return resolveWith(ctx, path, sandbox.Resolve)
+}
+
+// resolveEntryPath is like resolvePath, but a symbolic link at path is kept instead of resolved,
+// for operations that act on the directory entry itself.
+func resolveEntryPath(ctx context.Context, path string) (string, error) {
+	return resolveWith(ctx, path, sandbox.ResolveNoFollow)
+}
+
+// resolveWith expands path and passes it to the given sandbox check.
+func resolveWith(ctx context.Context, path string, check func(context.Context, string) (string, error)) (string, error) {
+	if path == "" {
+		return "", os.ErrNotExist
+	}
+
+	path, err := expandHome(path)
 	if err != nil {
 		return "", err
 	}
 
-	// Pre-allocate the string with the exact size of the byte slice
-	// This is more efficient than the implicit conversion
-	return string(data), nil
+	return check(ctx, path)
 }
 
-// writeFile writes the given content to a file at the specified path.
-func writeFile(path string, content string) error {
-
-	// Ensure parent directory exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+// readFile reads the content of a file at the given path and returns it as a string,
+// decoded to UTF-8 if the file is in another encoding.
+func readFile(path string) (string, error) {
+	// Validate and normalize the file path
+	path, err := normalizePath(path)
+	if err != nil {
+		return "", err
 	}
 
-	// Write the file with appropriate permissions
-	// 0644 = -rw-r--r-- (owner can read/write, group/others can read)
-	return os.WriteFile(path, []byte(content), 0644)
+	text, _, err := decodeFile(path)
+	return text, err
 }
 
-// readFiles reads the content of multiple files at the given paths and returns them as a slice of strings.
-func readFiles(paths []string) ([]string, error) {
-	var contents []string
-	for _, path := range paths {
-		content, err := readFile(path)
-		if err != nil {
-			return nil, err
-		}
-		contents = append(contents, content)
-	}
-	return contents, nil
+// writeFile writes the given content to a file at the specified path, replacing it atomically
+// and keeping its permissions. See writeFileWith.
+func writeFile(path string, content string) error {
+	return writeFileWith(path, []byte(content), writeOptions{})
 }
 
 // createDirectory creates a directory at the specified path with appropriate permissions.
 // Returns an error if the directory cannot be created.
 func createDirectory(path string) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+
 	// Create the directory with appropriate permissions
 	// 0755 = drwxr-xr-x (owner can read/write/execute, group/others can read/execute)
 	return os.Mkdir(path, 0755)
 }
 
-// listDirectory lists the contents of the directory at the given path.
-// Returns a slice of strings with directory entries prefixed with [DIR] or [FILE].
-func listDirectory(path string) ([]string, error) {
+// listDirectory lists the contents of the directory at the given path that pass the filters of
+// opts, in the order it asks for, one page at a time if it sets a limit.
+// Returns the listing with the details of each entry that opts.Columns ask for.
+func listDirectory(path string, opts listOptions) (*directoryListing, error) {
 	// Validate and normalize the directory path
 	path, err := normalizePath(path)
 	if err != nil {
@@ -112,19 +136,68 @@ func listDirectory(path string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	// Extract the names of the files and directories
-	names := lo.Map(files, func(file os.DirEntry, index int) string {
-		prefix := utils.IfElse(file.IsDir(), "[DIR]", "[FILE]")
-		return fmt.Sprintf("%s %s", prefix, file.Name())
+	var ignore *ignorer
+	if opts.RespectIgnore {
+		if ignore, err = newIgnorer(path); err != nil {
+			return nil, err
+		}
+	}
+	files = slices.DeleteFunc(files, func(file os.DirEntry) bool {
+		return opts.SkipHidden && strings.HasPrefix(file.Name(), ".") ||
+			opts.Pattern != "" && !matchGlob(opts.Pattern, file.Name()) ||
+			ignore.match(file.Name(), file.IsDir())
 	})
 
-	return names, nil
+	// Sort by size or modification time needs the details of every entry, by name only those of the page
+	entries := make([]listEntry, 0, len(files))
+	for _, file := range files {
+		entry := listEntry{DirEntry: file}
+		if opts.Sort == "size" || opts.Sort == "mtime" {
+			if err := entry.stat(); err != nil {
+				continue
+			}
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a listEntry, b listEntry) int { return opts.compare(a.key(), b.key()) })
+
+	// The page starts after the entry of the cursor, or where it would be if it was removed
+	start := 0
+	if opts.Cursor != nil {
+		var found bool
+		start, found = slices.BinarySearchFunc(entries, *opts.Cursor, func(entry listEntry, cursor listKey) int {
+			return opts.compare(entry.key(), cursor)
+		})
+		if found {
+			start++
+		}
+	}
+	end := len(entries)
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, len(entries))
+	}
+
+	listing := &directoryListing{Path: path, Total: len(entries), Offset: start, Entries: []dirEntry{}}
+	for _, entry := range entries[start:end] {
+		if err := entry.stat(); err != nil {
+			// The entry was removed while listing
+			continue
+		}
+		listing.Entries = append(listing.Entries, entry.details(path, opts.Columns))
+	}
+	if end < len(entries) {
+		listing.NextCursor = opts.cursor(entries[end-1].key())
+	}
+	return listing, nil
 }
 
 // moveFile moves a file from the source path to the destination path.
 // Returns an error if the operation fails.
 func moveFile(src, dst string) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+
 	// Validate and normalize the source and destination paths
 	src, err := normalizePath(src)
 	if err != nil {
@@ -135,33 +208,9 @@ func moveFile(src, dst string) error {
 	return os.Rename(src, dst)
 }
 
-// searchFiles searches for files in the specified directory that match the given pattern.
-// Returns a slice of file names that contain the pattern and any error encountered.
-func searchFiles(path string, pattern string) ([]string, error) {
-	// Validate and normalize the directory path
-	path, err := normalizePath(path)
-	if err != nil {
-		return nil, err
-	}
-
-	// List the contents of the directory
-	files, err := os.ReadDir(path)
-	if err != nil {
-		return nil, err
-	}
-
-	names := lo.FilterMap(files, func(file os.DirEntry, index int) (string, bool) {
-		if strings.Contains(file.Name(), pattern) {
-			return file.Name(), true
-		}
-		return "", false
-	})
-
-	return names, nil
-}
-
 // getFileInfo returns file information for the given path as a map.
-// The map contains the file's name, size, mode, modification time, and whether it's a directory.
+// The map contains the file's name, size, mode, modification time, whether it's a directory,
+// and for regular files the SHA-256 of the content.
 func getFileInfo(path string) (map[string]any, error) {
 	// Validate and normalize the file path
 	path, err := normalizePath(path)
@@ -176,25 +225,76 @@ func getFileInfo(path string) (map[string]any, error) {
 	}
 
 	// Return the file information as a map
-	return map[string]any{
+	fileInfo := map[string]any{
 		"Name":    info.Name(),
 		"Size":    info.Size(),
 		"Mode":    info.Mode(),
 		"ModTime": info.ModTime(),
 		"IsDir":   info.IsDir(),
-	}, nil
+	}
+
+	// The hash lets clients tell whether the file changed, see expected_sha256 of write_file
+	if info.Mode().IsRegular() {
+		sum, err := fileSHA256(path)
+		if err != nil {
+			return nil, err
+		}
+		fileInfo["SHA256"] = sum
+	}
+	return fileInfo, nil
+}
+
+// fileSHA256 returns the SHA-256 of the content of the file at path, in hex.
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
 // treeNode represents a node in the directory tree.
 type treeNode struct {
-	Name     string      `json:"name"`
-	Type     string      `json:"type"` // "file" or "directory"
+	Name string `json:"name"`
+	Type string `json:"type"` // "file" or "directory"
+	// Size is the size of a file, or with totals the size of all files under a directory.
+	Size    *int64     `json:"size,omitempty"`
+	ModTime *time.Time `json:"mtime,omitempty"`
+	// Files and Dirs count the files and directories under a directory at any depth, with totals.
+	Files    *int        `json:"files,omitempty"`
+	Dirs     *int        `json:"dirs,omitempty"`
 	Children []*treeNode `json:"children,omitempty"`
+	// Truncated counts the entries of a directory that were left out by the depth or entry limit.
+	Truncated int `json:"truncated,omitempty"`
+}
+
+// treeOptions select what directoryTree includes and how it is returned.
+type treeOptions struct {
+	RespectIgnore bool
+	// SkipHidden leaves out the entries whose name starts with a dot.
+	SkipHidden bool
+	// MaxDepth is the number of levels below the root to list, zero for all.
+	MaxDepth int
+	// MaxEntries is the number of entries to list in the whole tree, zero for all.
+	MaxEntries int
+	Size       bool
+	ModTime    bool
+	// Totals adds the size and number of files and directories under each directory, including
+	// the entries left out by the limits.
+	Totals bool
+	// Format is "json" or "text".
+	Format string
 }
 
 // directoryTree generates a recursive tree structure of files and directories starting from the given path.
-// Returns a JSON string representation of the directory tree and any error encountered.
-func directoryTree(path string) (string, error) {
+// Returns the directory tree as indented JSON, or as text like the tree command, and any error encountered.
+func directoryTree(path string, opts treeOptions) (string, error) {
 	// Validate and normalize the directory path
 	path, err := normalizePath(path)
 	if err != nil {
@@ -208,24 +308,23 @@ func directoryTree(path string) (string, error) {
 	}
 
 	// Create the root node
-	root := &treeNode{
-		Name: info.Name(),
-		Type: utils.IfElse(info.IsDir(), "directory", "file"),
-	}
+	b := &treeBuilder{treeOptions: opts}
+	root := b.node(info)
 
-	// If it's a file, just return the node
-	if !info.IsDir() {
-		jsonData, err := json.MarshalIndent(root, "", "  ")
-		if err != nil {
-			return "", fmt.Errorf("error marshaling tree to JSON: %v", err)
+	// Build the tree recursively for directories
+	if info.IsDir() {
+		if opts.RespectIgnore {
+			if b.ignore, err = newIgnorer(path); err != nil {
+				return "", err
+			}
+		}
+		if err := b.build(path, "", root, 1); err != nil {
+			return "", err
 		}
-		return string(jsonData), nil
 	}
 
-	// Build the tree recursively for directories
-	err = buildDirectoryTree(path, root)
-	if err != nil {
-		return "", err
+	if opts.Format == "text" {
+		return renderTree(root), nil
 	}
 
 	// Marshal the tree to JSON
@@ -237,36 +336,84 @@ func directoryTree(path string) (string, error) {
 	return string(jsonData), nil
 }
 
-// buildDirectoryTree recursively builds the directory tree structure.
-func buildDirectoryTree(path string, node *treeNode) error {
-	// Read directory contents
+// treeBuilder builds a directory tree within the limits of its options.
+type treeBuilder struct {
+	treeOptions
+	ignore *ignorer
+	// entries counts the nodes added below the root.
+	entries int
+}
+
+// node returns the node of the entry with the given info, annotated as the options ask.
+func (b *treeBuilder) node(info fs.FileInfo) *treeNode {
+	node := &treeNode{
+		Name: info.Name(),
+		Type: utils.IfElse(info.IsDir(), "directory", "file"),
+	}
+	if b.Size && !info.IsDir() {
+		node.Size = lo.ToPtr(info.Size())
+	}
+	if b.ModTime {
+		node.ModTime = lo.ToPtr(info.ModTime().UTC())
+	}
+	if b.Totals && info.IsDir() {
+		node.Size, node.Files, node.Dirs = new(int64), new(int), new(int)
+	}
+	return node
+}
+
+// readDir returns the entries of the directory at path, whose path relative to the root is rel,
+// that are neither hidden nor ignored.
+func (b *treeBuilder) readDir(path string, rel string) ([]os.DirEntry, error) {
 	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(entries, func(entry os.DirEntry) bool {
+		return b.SkipHidden && strings.HasPrefix(entry.Name(), ".") || b.ignore.match(joinRel(rel, entry.Name()), entry.IsDir())
+	}), nil
+}
+
+// build recursively builds the directory tree structure. rel is the path of the directory
+// relative to the root of the tree, with forward slashes, and depth the level of its entries.
+func (b *treeBuilder) build(path string, rel string, node *treeNode, depth int) error {
+	// Read directory contents
+	entries, err := b.readDir(path, rel)
 	if err != nil {
 		return err
 	}
 
 	// Initialize the children slice if it's a directory
-	if node.Type == "directory" && node.Children == nil {
-		node.Children = make([]*treeNode, 0)
-	}
+	node.Children = make([]*treeNode, 0)
 
 	// Process each entry
-	for _, entry := range entries {
-		// Skip hidden files/directories if needed
-		// if strings.HasPrefix(entry.Name(), ".") {
-		//     continue
-		// }
+	for i, entry := range entries {
+		if b.MaxDepth > 0 && depth > b.MaxDepth || b.MaxEntries > 0 && b.entries == b.MaxEntries {
+			node.Truncated = len(entries) - i
+			return b.measure(path, rel, node, entries[i:])
+		}
 
-		// Create a new node for this entry
-		childNode := &treeNode{
-			Name: entry.Name(),
-			Type: utils.IfElse(entry.IsDir(), "directory", "file"),
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// The entry was removed while the tree was built
+			continue
 		}
+		if err != nil {
+			return err
+		}
+
+		// Create a new node for this entry
+		b.entries++
+		childNode := b.node(info)
 
 		// If it's a directory, process it recursively
 		if entry.IsDir() {
 			childPath := filepath.Join(path, entry.Name())
-			err := buildDirectoryTree(childPath, childNode)
+			childRel := joinRel(rel, entry.Name())
+			if err := b.ignore.enter(childPath, childRel); err != nil {
+				return err
+			}
+			err := b.build(childPath, childRel, childNode, depth+1)
 			if err != nil {
 				return err
 			}
@@ -274,7 +421,77 @@ func buildDirectoryTree(path string, node *treeNode) error {
 
 		// Add the node to the parent's children
 		node.Children = append(node.Children, childNode)
+		b.addTotals(node, childNode, info)
 	}
 
 	return nil
 }
+
+// measure adds the given entries of the directory at path, which are not listed, to the totals
+// of its node.
+func (b *treeBuilder) measure(path string, rel string, node *treeNode, entries []os.DirEntry) error {
+	if !b.Totals {
+		return nil
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		childNode := b.node(info)
+		if entry.IsDir() {
+			childPath := filepath.Join(path, entry.Name())
+			childRel := joinRel(rel, entry.Name())
+			if err := b.ignore.enter(childPath, childRel); err != nil {
+				return err
+			}
+			children, err := b.readDir(childPath, childRel)
+			if err != nil {
+				return err
+			}
+			if err := b.measure(childPath, childRel, childNode, children); err != nil {
+				return err
+			}
+		}
+		b.addTotals(node, childNode, info)
+	}
+	return nil
+}
+
+// addTotals adds a child with the given info to the totals of node, if they are kept.
+func (b *treeBuilder) addTotals(node *treeNode, child *treeNode, info fs.FileInfo) {
+	if !b.Totals {
+		return
+	}
+	if child.Type == "directory" {
+		*node.Size += *child.Size
+		*node.Files += *child.Files
+		*node.Dirs += *child.Dirs + 1
+	} else {
+		*node.Size += info.Size()
+		*node.Files++
+	}
+}
+
+// joinRel returns the path of the entry name in the directory at rel, relative to the root of a
+// walk with forward slashes.
+func joinRel(rel string, name string) string {
+	return utils.IfElse(rel == "", name, rel+"/"+name)
+}
+
+// humanSize formats a number of bytes with a binary unit, such as 1.5 KiB.
+func humanSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < 6 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTPE"[unit-1])
+}
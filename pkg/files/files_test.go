@@ -1,13 +1,28 @@
 package files
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/sandbox"
+	"jarvis_mcp/pkg/utils"
 	"os"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
 	"github.com/samber/lo"
 )
 
@@ -164,10 +179,11 @@ func TestListDir(t *testing.T) {
 	os.WriteFile(filepath.Join(tmpDir, "file2.txt"), []byte("content2"), 0644)
 
 	// List the directory using listDirectory function
-	list, err := listDirectory(tmpDir)
+	listing, err := listDirectory(tmpDir, listOptions{})
 	if err != nil {
-		t.Errorf("failed to list directory: %v", err)
+		t.Fatalf("failed to list directory: %v", err)
 	}
+	list := strings.Split(listing.text(nil), "\n")
 
 	// Verify the directory contents
 	expected := []string{
@@ -232,29 +248,69 @@ func TestMoveFile(t *testing.T) {
 }
 
 func TestSearchFiles(t *testing.T) {
-	// Create a temporary directory
-	tmpDir, err := os.MkdirTemp("", "testdir")
-	if err != nil {
-		t.Fatalf("failed to create temp directory: %v", err)
+	tmpDir := t.TempDir()
+	for name, content := range map[string]string{
+		"file1.txt":                 "content1",
+		"file2.txt":                 "content2",
+		"another.TXT":               "content3, longer",
+		"src/main.go":               "package main",
+		"src/pkg/util.go":           "package pkg",
+		"src/pkg/util_test.go":      "package pkg_test",
+		"node_modules/lib/index.js": "module.exports = {}",
+	} {
+		path := filepath.Join(tmpDir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
 	}
-	defer os.RemoveAll(tmpDir)
-
-	// Create some files in the temporary directory
-	os.WriteFile(filepath.Join(tmpDir, "file1.txt"), []byte("content1"), 0644)
-	os.WriteFile(filepath.Join(tmpDir, "file2.txt"), []byte("content2"), 0644)
-	os.WriteFile(filepath.Join(tmpDir, "another.txt"), []byte("content3"), 0644)
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(filepath.Join(tmpDir, "file1.txt"), old, old)
 
-	// Search for files using searchFiles function
-	pattern := "file"
-	matchedFiles, err := searchFiles(tmpDir, pattern)
-	if err != nil {
-		t.Errorf("failed to search files: %v", err)
+	tests := []struct {
+		name string
+		opts searchOptions
+		want []string
+	}{
+		{name: "substring", opts: searchOptions{Pattern: "file"}, want: []string{"file1.txt", "file2.txt"}},
+		{name: "substring in subdirectories", opts: searchOptions{Pattern: "util"}, want: []string{"src/pkg/util.go", "src/pkg/util_test.go"}},
+		{name: "case insensitive", opts: searchOptions{Pattern: "txt"}, want: []string{"another.TXT", "file1.txt", "file2.txt"}},
+		{name: "case sensitive", opts: searchOptions{Pattern: "txt", CaseSensitive: true}, want: []string{"file1.txt", "file2.txt"}},
+		{name: "glob name", opts: searchOptions{Pattern: "*.go", Mode: "glob"}, want: []string{"src/main.go", "src/pkg/util.go", "src/pkg/util_test.go"}},
+		{name: "glob path", opts: searchOptions{Pattern: "src/**/*_test.go", Mode: "glob"}, want: []string{"src/pkg/util_test.go"}},
+		{name: "glob everything under", opts: searchOptions{Pattern: "src/**", Mode: "glob"}, want: []string{"src/main.go", "src/pkg", "src/pkg/util.go", "src/pkg/util_test.go"}},
+		{name: "regex", opts: searchOptions{Pattern: `^file\d`, Mode: "regex"}, want: []string{"file1.txt", "file2.txt"}},
+		{name: "regex on path", opts: searchOptions{Pattern: `^src/[^/]+$`, Mode: "regex", MatchPath: true}, want: []string{"src/main.go", "src/pkg"}},
+		{name: "directories", opts: searchOptions{Type: "directory"}, want: []string{"node_modules", "node_modules/lib", "src", "src/pkg"}},
+		{name: "extensions", opts: searchOptions{Extensions: []string{".js", "txt"}}, want: []string{"another.TXT", "file1.txt", "file2.txt", "node_modules/lib/index.js"}},
+		{name: "size", opts: searchOptions{MinSize: 10, MaxSize: 12}, want: []string{"src/main.go", "src/pkg/util.go"}},
+		{name: "modified after", opts: searchOptions{Pattern: "file", ModifiedAfter: time.Now().Add(-time.Hour)}, want: []string{"file2.txt"}},
+		{name: "modified before", opts: searchOptions{Pattern: "file", ModifiedBefore: time.Now().Add(-time.Hour)}, want: []string{"file1.txt"}},
+		{name: "max depth", opts: searchOptions{Pattern: ".go", MaxDepth: 2}, want: []string{"src/main.go"}},
+		{name: "exclude", opts: searchOptions{Pattern: "i", Exclude: []string{"node_modules", "**/*_test.go"}}, want: []string{"file1.txt", "file2.txt", "src/main.go", "src/pkg/util.go"}},
+		{name: "limit", opts: searchOptions{Pattern: "file", Limit: 1}, want: []string{"file1.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Mode = cmp.Or(opts.Mode, "substring")
+			opts.MinSize = cmp.Or(opts.MinSize, -1)
+			opts.MaxSize = cmp.Or(opts.MaxSize, -1)
+			opts.Limit = cmp.Or(opts.Limit, defaultSearchLimit)
+			result, err := searchFiles(context.Background(), tmpDir, opts)
+			if err != nil {
+				t.Fatalf("searchFiles() error = %v", err)
+			}
+			matched := lo.Map(result.Matches, func(match searchMatch, _ int) string { return match.Path })
+			if !reflect.DeepEqual(matched, tt.want) {
+				t.Errorf("searchFiles() = %v, want %v", matched, tt.want)
+			}
+			if result.Truncated != (opts.Limit == 1) {
+				t.Errorf("searchFiles() truncated = %v", result.Truncated)
+			}
+		})
 	}
 
-	// Verify the matched files
-	expected := []string{"file1.txt", "file2.txt"}
-	if !reflect.DeepEqual(matchedFiles, expected) {
-		t.Errorf("expected %v, got %v", expected, matchedFiles)
+	if _, err := searchFiles(context.Background(), tmpDir, searchOptions{Pattern: "[", Mode: "glob"}); err == nil {
+		t.Errorf("searchFiles() accepted a malformed glob")
 	}
 }
 
@@ -292,6 +348,7 @@ func TestGetFileInfo(t *testing.T) {
 		"Mode":    actualInfo.Mode(),
 		"ModTime": actualInfo.ModTime(),
 		"IsDir":   actualInfo.IsDir(),
+		"SHA256":  "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f",
 	}
 
 	if !reflect.DeepEqual(info, expected) {
@@ -324,7 +381,7 @@ func TestDirectoryTree(t *testing.T) {
 	os.WriteFile(filepath.Join(subDir2, "file4.txt"), []byte("content4"), 0644)
 
 	// Get directory tree using directoryTree function
-	treeJSON, err := directoryTree(tmpDir)
+	treeJSON, err := directoryTree(tmpDir, treeOptions{})
 	if err != nil {
 		t.Errorf("failed to get directory tree: %v", err)
 	}
@@ -432,6 +489,377 @@ func TestDirectoryTree(t *testing.T) {
 	}
 }
 
+func TestHandlerErrors(t *testing.T) {
+	// Create a temporary directory
+	tmpDir, err := os.MkdirTemp("", "testdir")
+	if err != nil {
+		t.Fatalf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	filePath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(filePath, []byte("content"), 0644)
+	missingPath := filepath.Join(tmpDir, "missing")
+
+	tests := []struct {
+		name     string
+		handler  func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args     map[string]any
+		wantCode string
+	}{
+		{name: "read missing file", handler: readFileHandler, args: map[string]any{"path": missingPath}, wantCode: "not_found"},
+		{name: "list file as directory", handler: listDirectoryHandler, args: map[string]any{"path": filePath}, wantCode: "not_a_directory"},
+		{name: "create existing directory", handler: createDirectoryHandler, args: map[string]any{"path": tmpDir}, wantCode: "already_exists"},
+		{name: "move missing file", handler: moveFileHandler, args: map[string]any{"source": missingPath, "destination": filePath}, wantCode: "not_found"},
+		{name: "info on missing file", handler: getFileInfoHandler, args: map[string]any{"path": missingPath}, wantCode: "not_found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := tt.handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("expected a tool error result, got protocol error: %v", err)
+			}
+			if !result.IsError {
+				t.Errorf("expected result to be flagged as error")
+			}
+			text := result.Content[0].(mcp.TextContent).Text
+			if !strings.Contains(text, "["+tt.wantCode+"]") {
+				t.Errorf("expected error code %s in %q", tt.wantCode, text)
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{name: "equal", old: "a\nb\n", new: "a\nb\n", want: ""},
+		{
+			name: "changed line",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "new file",
+			old:  "",
+			new:  "a\nb\n",
+			want: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "missing newline",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "--- old\n+++ new\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "0\n1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n",
+			want: "--- old\n+++ new\n@@ -1,3 +1,4 @@\n+0\n 1\n 2\n 3\n@@ -9,4 +10,3 @@\n 9\n 10\n 11\n-12\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", tt.old, tt.new); got != tt.want {
+				t.Errorf("unifiedDiff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfirmOverwrite(t *testing.T) {
+	// Without an MCP session the user cannot be asked, so the deny fallback refuses
+	confirm.Configure(confirm.Config{Enabled: true, Fallback: confirm.FallbackDeny})
+	defer confirm.Configure(confirm.DefaultConfig())
+
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(filePath, []byte("old"), 0644)
+	otherPath := filepath.Join(tmpDir, "other.txt")
+	os.WriteFile(otherPath, []byte("other"), 0644)
+	newPath := filepath.Join(tmpDir, "new.txt")
+
+	tests := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]any
+		denied  bool
+	}{
+		{name: "overwrite file", handler: writeFileHandler, args: map[string]any{"path": filePath, "content": "new"}, denied: true},
+		{name: "unchanged content", handler: writeFileHandler, args: map[string]any{"path": filePath, "content": "old"}, denied: false},
+		{name: "new file", handler: writeFileHandler, args: map[string]any{"path": newPath, "content": "new"}, denied: false},
+		{name: "move onto existing file", handler: moveFileHandler, args: map[string]any{"source": otherPath, "destination": filePath}, denied: true},
+		{name: "move to new path", handler: moveFileHandler, args: map[string]any{"source": newPath, "destination": newPath + ".moved"}, denied: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := tt.handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("expected a tool result, got protocol error: %v", err)
+			}
+			text := result.Content[0].(mcp.TextContent).Text
+			if denied := strings.Contains(text, "[policy_denied]"); denied != tt.denied {
+				t.Errorf("denied = %v, want %v: %q", denied, tt.denied, text)
+			}
+		})
+	}
+
+	if data, _ := os.ReadFile(filePath); string(data) != "old" {
+		t.Errorf("denied overwrite changed the file to %q", data)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	base, _ := filepath.EvalSymlinks(t.TempDir())
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	os.MkdirAll(root, 0755)
+	os.MkdirAll(outside, 0755)
+	insideFile := filepath.Join(root, "file.txt")
+	os.WriteFile(insideFile, []byte("inside"), 0644)
+	secretFile := filepath.Join(outside, "secret.txt")
+	os.WriteFile(secretFile, []byte("secret"), 0644)
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skipf("symbolic links not supported: %v", err)
+	}
+
+	if err := sandbox.Configure(sandbox.Config{Roots: []string{root}}); err != nil {
+		t.Fatalf("sandbox.Configure() error = %v", err)
+	}
+	defer sandbox.Configure(sandbox.DefaultConfig())
+
+	tests := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]any
+		denied  bool
+	}{
+		{name: "read inside", handler: readFileHandler, args: map[string]any{"path": insideFile}, denied: false},
+		{name: "read outside", handler: readFileHandler, args: map[string]any{"path": secretFile}, denied: true},
+		{name: "read through link", handler: readFileHandler, args: map[string]any{"path": filepath.Join(link, "secret.txt")}, denied: true},
+		{name: "read with dot dot", handler: readFileHandler, args: map[string]any{"path": filepath.Join(root, "..", "outside", "secret.txt")}, denied: true},
+		{name: "write outside", handler: writeFileHandler, args: map[string]any{"path": filepath.Join(outside, "new.txt"), "content": "x"}, denied: true},
+		{name: "write through link", handler: writeFileHandler, args: map[string]any{"path": filepath.Join(link, "new.txt"), "content": "x"}, denied: true},
+		{name: "create directory outside", handler: createDirectoryHandler, args: map[string]any{"path": filepath.Join(outside, "dir")}, denied: true},
+		{name: "list outside", handler: listDirectoryHandler, args: map[string]any{"path": outside}, denied: true},
+		{name: "search outside", handler: searchFilesHandler, args: map[string]any{"path": outside, "pattern": "secret"}, denied: true},
+		{name: "info outside", handler: getFileInfoHandler, args: map[string]any{"path": secretFile}, denied: true},
+		{name: "tree outside", handler: directoryTreeHandler, args: map[string]any{"path": outside}, denied: true},
+		{name: "move out of root", handler: moveFileHandler, args: map[string]any{"source": insideFile, "destination": filepath.Join(outside, "moved.txt")}, denied: true},
+		{name: "move into root", handler: moveFileHandler, args: map[string]any{"source": secretFile, "destination": filepath.Join(root, "stolen.txt")}, denied: true},
+		{name: "rename link", handler: moveFileHandler, args: map[string]any{"source": link, "destination": filepath.Join(root, "renamed")}, denied: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := tt.handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("expected a tool result, got protocol error: %v", err)
+			}
+			text := result.Content[0].(mcp.TextContent).Text
+			if denied := strings.Contains(text, "[policy_denied]"); denied != tt.denied {
+				t.Errorf("denied = %v, want %v: %q", denied, tt.denied, text)
+			}
+		})
+	}
+
+	// Renaming the link must move the link, not the directory it points to
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("link target was moved: %v", err)
+	}
+
+	// Globs outside the roots are refused before they are expanded, so no file names leak
+	for _, pattern := range []string{filepath.Join(outside, "*.txt"), filepath.Join(outside, "*", "x"), root + "/../outside/*"} {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]any{"paths": []any{pattern}}
+		result, err := readMultipleFilesHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("readMultipleFilesHandler() error = %v", err)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if !strings.Contains(text, "[policy_denied]") || strings.Contains(text, "secret") {
+			t.Errorf("read_multiple_files(%q) = %q, want policy_denied without file names", pattern, text)
+		}
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	Configure(Config{ReadOnly: true})
+	defer Configure(DefaultConfig())
+
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "file.txt")
+	os.WriteFile(filePath, []byte("content"), 0644)
+
+	tests := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]any
+		denied  bool
+	}{
+		{name: "read", handler: readFileHandler, args: map[string]any{"path": filePath}, denied: false},
+		{name: "list", handler: listDirectoryHandler, args: map[string]any{"path": tmpDir}, denied: false},
+		{name: "write", handler: writeFileHandler, args: map[string]any{"path": filePath, "content": "changed"}, denied: true},
+		{name: "create file", handler: writeFileHandler, args: map[string]any{"path": filepath.Join(tmpDir, "new.txt"), "content": "new"}, denied: true},
+		{name: "create directory", handler: createDirectoryHandler, args: map[string]any{"path": filepath.Join(tmpDir, "dir")}, denied: true},
+		{name: "move", handler: moveFileHandler, args: map[string]any{"source": filePath, "destination": filepath.Join(tmpDir, "moved.txt")}, denied: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := tt.handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("expected a tool result, got protocol error: %v", err)
+			}
+			text := result.Content[0].(mcp.TextContent).Text
+			if denied := strings.Contains(text, "[policy_denied]"); denied != tt.denied {
+				t.Errorf("denied = %v, want %v: %q", denied, tt.denied, text)
+			}
+		})
+	}
+
+	// The core functions refuse as well, whichever way they are reached
+	if err := writeFile(filePath, "changed"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("writeFile() error = %v, want ErrReadOnly", err)
+	}
+	entries, _ := os.ReadDir(tmpDir)
+	data, _ := os.ReadFile(filePath)
+	if len(entries) != 1 || string(data) != "content" {
+		t.Errorf("read-only mode changed the directory: %d entries, content %q", len(entries), data)
+	}
+}
+
+func TestReadFileWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lines.txt")
+	os.WriteFile(path, []byte("one\ntwo\nthree\nfour\nfive"), 0644)
+
+	tests := []struct {
+		name        string
+		opts        readOptions
+		wantContent string
+		wantStart   int
+		wantEnd     int
+		wantNext    int
+		wantNextB   int64
+		wantTrunc   bool
+	}{
+		{name: "whole file", opts: readOptions{}, wantContent: "one\ntwo\nthree\nfour\nfive", wantStart: 1, wantEnd: 5},
+		{name: "line range", opts: readOptions{Offset: 2, Limit: 2}, wantContent: "two\nthree\n", wantStart: 2, wantEnd: 3, wantNext: 4},
+		{name: "last line", opts: readOptions{Offset: 5}, wantContent: "five", wantStart: 5, wantEnd: 5},
+		{name: "past end", opts: readOptions{Offset: 9}, wantContent: ""},
+		{name: "line numbers", opts: readOptions{Offset: 4, LineNumbers: true}, wantContent: "     4\tfour\n     5\tfive", wantStart: 4, wantEnd: 5},
+		{name: "size limit keeps whole lines", opts: readOptions{MaxBytes: 10}, wantContent: "one\ntwo\n", wantStart: 1, wantEnd: 2, wantNext: 3, wantTrunc: true},
+		{name: "long first line", opts: readOptions{Offset: 3, MaxBytes: 4}, wantContent: "thre", wantStart: 3, wantEnd: 3, wantNextB: 12, wantTrunc: true},
+		{name: "byte range", opts: readOptions{Bytes: true, ByteOffset: 4, ByteLimit: 5}, wantContent: "two\nt", wantNextB: 9},
+		{name: "byte range to end", opts: readOptions{Bytes: true, ByteOffset: 19}, wantContent: "five"},
+		{name: "byte size limit", opts: readOptions{Bytes: true, MaxBytes: 3}, wantContent: "one", wantNextB: 3, wantTrunc: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := readFileWindow(path, tt.opts)
+			if err != nil {
+				t.Fatalf("readFileWindow() error = %v", err)
+			}
+			if w.Content != tt.wantContent {
+				t.Errorf("content = %q, want %q", w.Content, tt.wantContent)
+			}
+			if w.StartLine != tt.wantStart || w.EndLine != tt.wantEnd || w.NextOffset != tt.wantNext || w.NextByteOffset != tt.wantNextB {
+				t.Errorf("window = %+v", w)
+			}
+			if w.Truncated != tt.wantTrunc || w.More != (tt.wantNext > 0 || tt.wantNextB > 0) {
+				t.Errorf("truncated = %v, more = %v", w.Truncated, w.More)
+			}
+			if w.TotalLines != 5 || w.TotalBytes != 23 {
+				t.Errorf("totals = %d lines, %d bytes", w.TotalLines, w.TotalBytes)
+			}
+		})
+	}
+}
+
+func TestReadFileWindowUTF8(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utf8.txt")
+	os.WriteFile(path, []byte("héhé"), 0644)
+
+	// A window must not end in the middle of a character
+	w, err := readFileWindow(path, readOptions{Bytes: true, ByteLimit: 2})
+	if err != nil || w.Content != "h" || w.NextByteOffset != 1 {
+		t.Errorf("readFileWindow() = %+v, %v", w, err)
+	}
+	w, err = readFileWindow(path, readOptions{MaxBytes: 5})
+	if err != nil || w.Content != "héh" || w.NextByteOffset != 4 {
+		t.Errorf("readFileWindow() = %+v, %v", w, err)
+	}
+	w, err = readFileWindow(path, readOptions{Offset: 1, MaxBytes: 2, LineNumbers: true})
+	if err != nil || w.Content != "     1\th" || w.NextByteOffset != 1 {
+		t.Errorf("readFileWindow() = %+v, %v", w, err)
+	}
+}
+
+func TestReadFileHandlerRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.txt")
+	var content strings.Builder
+	for i := 1; i <= 100; i++ {
+		content.WriteString("log line\n")
+	}
+	os.WriteFile(path, []byte(content.String()), 0644)
+
+	read := func(args map[string]any) (*mcp.CallToolResult, error) {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		return readFileHandler(context.Background(), request)
+	}
+
+	result, err := read(map[string]any{"path": path, "offset": 10.0, "limit": 5.0, "line_numbers": true})
+	if err != nil {
+		t.Fatalf("readFileHandler() error = %v", err)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.HasPrefix(text, "    10\tlog line\n") || !strings.Contains(text, "[Lines 10-14 of 100; more available, continue with offset=15]") {
+		t.Errorf("unexpected result text %q", text)
+	}
+	if window, ok := result.StructuredContent.(*fileWindow); !ok || window.TotalLines != 100 || !window.More {
+		t.Errorf("unexpected structured content %+v", result.StructuredContent)
+	}
+
+	// A plain read of a file within the limit returns just the content
+	result, _ = read(map[string]any{"path": path})
+	if text := result.Content[0].(mcp.TextContent).Text; text != content.String() {
+		t.Errorf("plain read returned %q", text)
+	}
+
+	for _, args := range []map[string]any{
+		{"path": path, "offset": 1.0, "byte_limit": 10.0},
+		{"path": path, "offset": 0.0},
+		{"path": path, "byte_offset": -1.0},
+		{"path": path, "byte_offset": 0.0, "line_numbers": true},
+	} {
+		if _, err := read(args); err == nil {
+			t.Errorf("readFileHandler(%v) expected protocol error", args)
+		}
+	}
+}
+
 // getHomeDir returns the home directory of the current user.
 func getHomeDir() string {
 	homeDir, _ := os.UserHomeDir()
@@ -458,3 +886,1195 @@ func getFirstUserHomeDir(absolute bool) string {
 	}
 	return strings.Replace(dir, homeDir, "~/", 1)
 }
+
+// TestReadFileBinary tests that images and other binary files are returned as such
+func TestReadFileBinary(t *testing.T) {
+	dir := t.TempDir()
+	png := append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)
+	files := map[string][]byte{
+		"shot.png":  png,
+		"doc.pdf":   []byte("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n"),
+		"data.bin":  {0x00, 0x01, 0x02, 0xff, 0x00},
+		"notes.txt": []byte("plain text\n"),
+	}
+	for name, data := range files {
+		os.WriteFile(filepath.Join(dir, name), data, 0644)
+	}
+
+	read := func(args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := readFileHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("readFileHandler(%v) error = %v", args, err)
+		}
+		return result
+	}
+
+	result := read(map[string]any{"path": filepath.Join(dir, "shot.png")})
+	image, ok := result.Content[1].(mcp.ImageContent)
+	if !ok || image.MIMEType != "image/png" || image.Data != base64.StdEncoding.EncodeToString(png) {
+		t.Errorf("png returned %+v", result.Content)
+	}
+
+	for name, mimeType := range map[string]string{"doc.pdf": "application/pdf", "data.bin": "application/octet-stream"} {
+		result := read(map[string]any{"path": filepath.Join(dir, name)})
+		resource, ok := result.Content[1].(mcp.EmbeddedResource)
+		if !ok {
+			t.Errorf("%s returned %+v", name, result.Content)
+			continue
+		}
+		blob, ok := resource.Resource.(mcp.BlobResourceContents)
+		if !ok || blob.MIMEType != mimeType || blob.Blob != base64.StdEncoding.EncodeToString(files[name]) {
+			t.Errorf("%s returned resource %+v", name, resource.Resource)
+		}
+	}
+
+	if text := read(map[string]any{"path": filepath.Join(dir, "notes.txt")}).Content[0].(mcp.TextContent).Text; text != "plain text\n" {
+		t.Errorf("text file returned %q", text)
+	}
+
+	// A byte range of an image is a blob, not an image
+	result = read(map[string]any{"path": filepath.Join(dir, "shot.png"), "byte_offset": 0.0, "byte_limit": 8.0})
+	if resource, ok := result.Content[1].(mcp.EmbeddedResource); !ok || resource.Resource.(mcp.BlobResourceContents).Blob != base64.StdEncoding.EncodeToString(png[:8]) {
+		t.Errorf("byte range returned %+v", result.Content)
+	}
+	if window := result.StructuredContent.(*fileWindow); !window.More || window.NextByteOffset != 8 || window.MimeType != "image/png" {
+		t.Errorf("byte range window %+v", window)
+	}
+
+	if result := read(map[string]any{"path": filepath.Join(dir, "doc.pdf"), "offset": 2.0}); !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "[unsupported]") {
+		t.Errorf("line range of a binary file returned %+v", result.Content)
+	}
+
+	// Files over the limit are refused with their type and size, but ranges still work
+	defer Configure(DefaultConfig())
+	Configure(Config{MaxBinaryBytes: 16})
+	result = read(map[string]any{"path": filepath.Join(dir, "shot.png")})
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !strings.Contains(text, "[too_large]") || !strings.Contains(text, "image/png") {
+		t.Errorf("large binary file returned %q", text)
+	}
+	result = read(map[string]any{"path": filepath.Join(dir, "shot.png"), "byte_offset": 0.0})
+	if window := result.StructuredContent.(*fileWindow); result.IsError || window.ByteCount != 16 || !window.Truncated {
+		t.Errorf("ranged read of a large binary file returned %+v", result.StructuredContent)
+	}
+}
+
+// TestDetectEncoding tests recognising the encoding of text files
+func TestDetectEncoding(t *testing.T) {
+	utf16le := func(s string, bom bool) []byte {
+		data := lo.Ternary(bom, []byte{0xff, 0xfe}, []byte{})
+		for _, r := range s {
+			data = append(data, byte(r), byte(r>>8))
+		}
+		return data
+	}
+	ascii := strings.Repeat("a", 100*1024)
+	// A multi-byte character split across the 64 KiB read buffer is still valid
+	split := strings.Repeat("a", 64*1024-1) + "é"
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{name: "empty", data: nil, want: "utf-8"},
+		{name: "utf-8", data: []byte("naïve café\n"), want: "utf-8"},
+		{name: "utf-8 across buffers", data: []byte(split), want: "utf-8"},
+		{name: "utf-8 bom", data: []byte("\xef\xbb\xbfhello"), want: "utf-8-bom"},
+		{name: "utf-16le bom", data: utf16le("hello\r\n", true), want: "utf-16le-bom"},
+		{name: "utf-16be bom", data: []byte{0xfe, 0xff, 0, 'h', 0, 'i'}, want: "utf-16be-bom"},
+		{name: "utf-16le", data: utf16le("hello world\r\n", false), want: "utf-16le"},
+		{name: "utf-16be", data: []byte{0, 'h', 0, 'e', 0, 'l', 0, 'l', 0, 'o'}, want: "utf-16be"},
+		{name: "latin1", data: []byte("caf\xe9\n"), want: "latin1"},
+		{name: "latin1 after ascii", data: []byte(ascii + "caf\xe9\n"), want: "latin1"},
+		{name: "windows-1252", data: []byte("\x93quoted\x94 caf\xe9\n"), want: "windows-1252"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := detectEncoding(tt.data[:min(len(tt.data), sniffLen)], bytes.NewReader(tt.data))
+			if err != nil || got != tt.want {
+				t.Errorf("detectEncoding() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestFileEncodings tests that files in other encodings are read as UTF-8 and written back unchanged
+func TestFileEncodings(t *testing.T) {
+	dir := t.TempDir()
+	utf16Path := filepath.Join(dir, "windows.txt")
+	utf16Data := []byte{0xff, 0xfe, 'h', 0, 'i', 0, '\r', 0, '\n', 0, 0xe9, 0, '\r', 0, '\n', 0}
+	os.WriteFile(utf16Path, utf16Data, 0644)
+	latin1Path := filepath.Join(dir, "legacy.c")
+	os.WriteFile(latin1Path, []byte("/* caf\xe9 */\n"), 0644)
+
+	call := func(handler server.ToolHandlerFunc, args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := handler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("handler(%v) error = %v", args, err)
+		}
+		return result
+	}
+
+	result := call(readFileHandler, map[string]any{"path": utf16Path})
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.HasPrefix(text, "hi\r\né\r\n") || !strings.Contains(text, "encoding=utf-16le-bom") {
+		t.Errorf("read_file returned %q", text)
+	}
+	if window := result.StructuredContent.(*fileWindow); window.Encoding != "utf-16le-bom" || window.LineEnding != "crlf" {
+		t.Errorf("read_file reported %+v", window)
+	}
+	if text := call(readFileHandler, map[string]any{"path": latin1Path}).Content[0].(mcp.TextContent).Text; !strings.HasPrefix(text, "/* café */\n") {
+		t.Errorf("read_file of latin1 returned %q", text)
+	}
+
+	// Replacing a file keeps its encoding and line endings
+	call(writeFileHandler, map[string]any{"path": utf16Path, "content": "hi\né\n"})
+	if data, _ := os.ReadFile(utf16Path); !bytes.Equal(data, utf16Data) {
+		t.Errorf("rewritten file = %x, want %x", data, utf16Data)
+	}
+
+	// Both can be changed explicitly
+	call(writeFileHandler, map[string]any{"path": utf16Path, "content": "hi\r\né\r\n", "encoding": "latin1", "line_ending": "lf"})
+	if data, _ := os.ReadFile(utf16Path); string(data) != "hi\n\xe9\n" {
+		t.Errorf("converted file = %q", data)
+	}
+
+	result = call(writeFileHandler, map[string]any{"path": latin1Path, "content": "/* 咖啡 */\n"})
+	if !result.IsError || !strings.Contains(result.Content[0].(mcp.TextContent).Text, "[unsupported]") {
+		t.Errorf("unencodable content returned %+v", result.Content)
+	}
+	if _, err := writeFileHandler(context.Background(), mcp.CallToolRequest{Params: mcp.CallToolParams{
+		Arguments: map[string]any{"path": latin1Path, "content": "x", "encoding": "ebcdic"}}}); err == nil {
+		t.Error("unknown encoding accepted")
+	}
+}
+
+// TestReadMultipleFiles tests reading files and globs with per-file errors and a shared output limit
+func TestReadMultipleFiles(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("alpha\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "src", "util.go"), []byte("package util\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "image.png"), append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 16)...), 0644)
+
+	read := func(paths ...any) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]any{"paths": paths}
+		result, err := readMultipleFilesHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("readMultipleFilesHandler() error = %v", err)
+		}
+		return result
+	}
+
+	result := read(filepath.Join(dir, "a.txt"), filepath.Join(dir, "missing.txt"), filepath.Join(dir, "src", "*.go"),
+		filepath.Join(dir, "image.png"), filepath.Join(dir, "a.txt"))
+	files := result.StructuredContent.(batchResult).Files
+	got := lo.Map(files, func(f batchFile, _ int) string {
+		if f.fileWindow == nil {
+			return filepath.Base(f.Path) + ":" + string(f.ErrorCode)
+		}
+		return filepath.Base(f.Path) + ":" + strings.TrimSpace(f.Content)
+	})
+	want := []string{"a.txt:alpha", "missing.txt:not_found", "main.go:package main", "util.go:package util", "image.png:unsupported"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read_multiple_files returned %v, want %v", got, want)
+	}
+	text := result.Content[0].(mcp.TextContent).Text
+	if !strings.Contains(text, "==> "+filepath.Join(dir, "src", "main.go")+" <==\npackage main\n") || !strings.Contains(text, "Error [not_found]") {
+		t.Errorf("unexpected text %q", text)
+	}
+
+	// The output limit cuts the file that reaches it and skips the rest
+	defer Configure(DefaultConfig())
+	Configure(Config{MaxReadBytes: 10})
+	files = read(filepath.Join(dir, "a.txt"), filepath.Join(dir, "src", "main.go"), filepath.Join(dir, "src", "util.go")).StructuredContent.(batchResult).Files
+	if files[0].Content != "alpha\n" || !files[1].Truncated || files[1].Content != "pack" || !files[2].Skipped {
+		t.Errorf("limited read returned %+v", files)
+	}
+
+	if _, err := readMultipleFilesHandler(context.Background(), mcp.CallToolRequest{}); err == nil {
+		t.Error("missing paths accepted")
+	}
+}
+
+// TestApplyEdits tests exact and whitespace-tolerant replacements
+func TestApplyEdits(t *testing.T) {
+	text := "func main() {\n\tif ok {\n\t\treturn\n\t}\n\tlog(1)\n\tlog(1)\n}\n"
+
+	tests := []struct {
+		name  string
+		edits []textEdit
+		want  string // empty if the edits conflict
+		fuzzy bool
+	}{
+		{
+			name:  "exact",
+			edits: []textEdit{{OldText: "return", NewText: "return nil"}},
+			want:  "func main() {\n\tif ok {\n\t\treturn nil\n\t}\n\tlog(1)\n\tlog(1)\n}\n",
+		},
+		{
+			name:  "in order",
+			edits: []textEdit{{OldText: "main", NewText: "run"}, {OldText: "func run()", NewText: "func run() error"}},
+			want:  "func run() error {\n\tif ok {\n\t\treturn\n\t}\n\tlog(1)\n\tlog(1)\n}\n",
+		},
+		{
+			name:  "whitespace and indentation",
+			edits: []textEdit{{OldText: "if  ok {\n    return\n}", NewText: "if !ok {\n    panic(1)\n}"}},
+			want:  "func main() {\n\tif !ok {\n\t    panic(1)\n\t}\n\tlog(1)\n\tlog(1)\n}\n",
+			fuzzy: true,
+		},
+		{name: "ambiguous", edits: []textEdit{{OldText: "log(1)", NewText: "log(2)"}}},
+		{name: "ambiguous ignoring whitespace", edits: []textEdit{{OldText: "log( 1 )", NewText: "log(2)"}}},
+		{name: "not found", edits: []textEdit{{OldText: "missing", NewText: "x"}}},
+		{name: "later edit fails", edits: []textEdit{{OldText: "main", NewText: "run"}, {OldText: "main", NewText: "x"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, matches, err := applyEdits(text, tt.edits)
+			if tt.want == "" {
+				if !errors.Is(err, utils.ErrConflict) {
+					t.Errorf("applyEdits() = %q, %v, want conflict", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("applyEdits() = %q, %v, want %q", got, err, tt.want)
+			}
+			if matches[0].Fuzzy != tt.fuzzy {
+				t.Errorf("applyEdits() matches = %+v", matches)
+			}
+		})
+	}
+
+	if _, _, err := applyEdits(text, []textEdit{{OldText: "log(1)", NewText: "x"}}); err == nil || !strings.Contains(err.Error(), "at lines 5, 6") {
+		t.Errorf("ambiguous match error = %v", err)
+	}
+}
+
+// TestEditFile tests the edit_file tool, keeping the format of the file
+func TestEditFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.c")
+	original := "int main() {\r\n\treturn 0;\r\n}\r\n"
+	os.WriteFile(path, []byte(original), 0644)
+
+	edit := func(args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := editFileHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("editFileHandler() error = %v", err)
+		}
+		return result
+	}
+	edits := []any{map[string]any{"old_text": "return 0;\n", "new_text": "puts(\"hi\");\n\treturn 0;\n"}}
+
+	result := edit(map[string]any{"path": path, "edits": edits, "dry_run": true})
+	if diff := result.StructuredContent.(editResult).Diff; !strings.Contains(diff, "+\tputs(\"hi\");\n") {
+		t.Errorf("dry run diff %q", diff)
+	}
+	if data, _ := os.ReadFile(path); string(data) != original {
+		t.Errorf("dry run changed the file to %q", data)
+	}
+
+	result = edit(map[string]any{"path": path, "edits": edits})
+	if result.IsError {
+		t.Fatalf("edit_file failed: %v", result.Content)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "int main() {\r\n\tputs(\"hi\");\r\n\treturn 0;\r\n}\r\n" {
+		t.Errorf("edited file = %q", data)
+	}
+
+	// A conflicting edit leaves the file alone
+	result = edit(map[string]any{"path": path, "edits": []any{
+		map[string]any{"old_text": "int main", "new_text": "int run"},
+		map[string]any{"old_text": "return 1;", "new_text": "return 2;"},
+	}})
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !strings.Contains(text, "[conflict]") || !strings.Contains(text, "edit 2") {
+		t.Errorf("conflicting edit returned %q", text)
+	}
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "run") {
+		t.Error("conflicting edits were partly written")
+	}
+
+	for _, args := range []map[string]any{
+		{"path": path},
+		{"path": path, "edits": []any{}},
+		{"path": path, "edits": []any{map[string]any{"old_text": "", "new_text": "x"}}},
+		{"path": path, "edits": []any{map[string]any{"old_text": "x"}}},
+	} {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		if _, err := editFileHandler(context.Background(), request); err == nil {
+			t.Errorf("editFileHandler(%v) expected protocol error", args)
+		}
+	}
+}
+
+// TestParsePatch tests reading the files and hunks of unified diffs
+func TestParsePatch(t *testing.T) {
+	patch := `Some commentary
+diff --git a/src/main.go b/src/main.go
+index 1234567..89abcde 100644
+--- a/src/main.go
++++ b/src/main.go
+@@ -1,3 +1,3 @@ package main
+ package main
+
+-var x = 1
++var x = 2
+diff --git a/old.txt b/new.txt
+similarity index 100%
+rename from old.txt
+rename to new.txt
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+\ No newline at end of file
+--- /dev/null	2024-01-01 00:00:00
++++ created.txt	2024-01-01 00:00:00
+@@ -0,0 +1,2 @@
++hello
++world`
+
+	patches, err := parsePatch(patch, -1)
+	if err != nil {
+		t.Fatalf("parsePatch() error = %v", err)
+	}
+	got := lo.Map(patches, func(p *filePatch, _ int) string {
+		return fmt.Sprintf("%s>%s:%d", p.OldName, p.NewName, len(p.Hunks))
+	})
+	want := []string{"src/main.go>src/main.go:1", "old.txt>new.txt:0", "gone.txt>:1", ">created.txt:1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsePatch() files = %v, want %v", got, want)
+	}
+	// The context line that lost its space and the missing newline are understood
+	if ops := patches[0].Hunks[0].Lines; len(ops) != 4 || ops[1] != (diffOp{' ', "\n"}) {
+		t.Errorf("hunk lines = %q", ops)
+	}
+	if ops := patches[2].Hunks[0].Lines; ops[0] != (diffOp{'-', "bye"}) {
+		t.Errorf("hunk lines = %q", ops)
+	}
+	if ops := patches[3].Hunks[0].Lines; ops[1] != (diffOp{'+', "world\n"}) {
+		t.Errorf("hunk lines = %q", ops)
+	}
+
+	for _, invalid := range []string{"no patch here\n", "@@ -1 +1 @@\n-a\n+b\n", "--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n@@ bad @@\n",
+		"--- a/x\n+++ b/x\n@@ -1 +1 @@\n-a\n+b\n--- a/y\n+++ b/x\n@@ -3 +3 @@\n-c\n+d\n"} {
+		if _, err := parsePatch(invalid, -1); err == nil {
+			t.Errorf("parsePatch(%q) succeeded", invalid)
+		}
+	}
+}
+
+// TestApplyHunks tests applying hunks at an offset and with fuzz
+func TestApplyHunks(t *testing.T) {
+	patch := "--- a/f\n+++ b/f\n@@ -2,3 +2,3 @@\n two\n-three\n+THREE\n four\n@@ -8,3 +8,3 @@\n eight\n-nine\n+NINE\n ten\n"
+	patches, _ := parsePatch(patch, -1)
+	hunks := patches[0].Hunks
+	text := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+
+	got, results := applyHunks(text, hunks)
+	if got != strings.Replace(strings.Replace(text, "three", "THREE", 1), "nine", "NINE", 1) || results[1].Offset != 0 {
+		t.Errorf("applyHunks() = %q, %+v", got, results)
+	}
+
+	// Lines added at the top move both hunks
+	got, results = applyHunks("zero\nzero\n"+text, hunks)
+	if !strings.Contains(got, "THREE") || !strings.Contains(got, "NINE") || results[0].Offset != 2 || results[1].Line != 10 {
+		t.Errorf("applyHunks() with offset = %q, %+v", got, results)
+	}
+
+	// A changed context line is ignored with fuzz
+	got, results = applyHunks(strings.Replace(text, "eight", "EIGHT", 1), hunks)
+	if !strings.Contains(got, "EIGHT\nNINE\nten") || results[1].Fuzz != 1 {
+		t.Errorf("applyHunks() with fuzz = %q, %+v", got, results)
+	}
+
+	// A hunk whose removed line is gone fails, the others still apply
+	got, results = applyHunks(strings.Replace(text, "nine", "nein", 1), hunks)
+	if results[0].Applied != true || results[1].Applied || !strings.Contains(got, "THREE") {
+		t.Errorf("applyHunks() with conflict = %q, %+v", got, results)
+	}
+}
+
+// TestApplyPatch tests the apply_patch tool on several files at once
+func TestApplyPatch(t *testing.T) {
+	defer Configure(DefaultConfig())
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\r\n\r\nvar x = 1\r\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "old.txt"), []byte("keep\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "gone.txt"), []byte("bye\n"), 0644)
+
+	patch := `--- a/main.go
++++ b/main.go
+@@ -1,3 +1,3 @@
+ package main
+ 
+-var x = 1
++var x = 2
+--- a/old.txt
++++ b/new.txt
+@@ -1 +1 @@
+-keep
++kept
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-bye
+--- /dev/null
++++ b/sub/created.txt
+@@ -0,0 +1 @@
++hello
+`
+	apply := func(args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		args["directory"] = dir
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := applyPatchHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("applyPatchHandler() error = %v", err)
+		}
+		return result
+	}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(dir, name))
+		return err == nil
+	}
+
+	// A conflict in one file keeps all of them unchanged
+	conflicting := strings.Replace(patch, "-bye", "-hello", 1)
+	result := apply(map[string]any{"patch": conflicting})
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !strings.Contains(text, "[conflict]") || !strings.Contains(text, "hunk 1 FAILED") {
+		t.Errorf("conflicting patch returned %q", text)
+	}
+	if exists("new.txt") || exists("sub/created.txt") || !exists("gone.txt") {
+		t.Error("conflicting patch changed files")
+	}
+
+	// Dry runs report without writing
+	result = apply(map[string]any{"patch": patch, "dry_run": true})
+	if result.IsError || exists("new.txt") {
+		t.Errorf("dry run returned %v", result.Content)
+	}
+
+	result = apply(map[string]any{"patch": patch})
+	if result.IsError {
+		t.Fatalf("apply_patch failed: %v", result.Content)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "main.go")); string(data) != "package main\r\n\r\nvar x = 2\r\n" {
+		t.Errorf("main.go = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "new.txt")); string(data) != "kept\n" || exists("old.txt") {
+		t.Errorf("rename left new.txt = %q, old.txt exists = %v", data, exists("old.txt"))
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "sub", "created.txt")); string(data) != "hello\n" || exists("gone.txt") {
+		t.Errorf("created.txt = %q, gone.txt exists = %v", data, exists("gone.txt"))
+	}
+
+	// With partial, the hunks that apply are written
+	os.WriteFile(filepath.Join(dir, "two.txt"), []byte("a\nb\nc\nd\ne\nf\ng\nh\n"), 0644)
+	result = apply(map[string]any{"partial": true, "patch": "--- two.txt\n+++ two.txt\n@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -7,2 +7,2 @@\n g\n-x\n+X\n"})
+	if data, _ := os.ReadFile(filepath.Join(dir, "two.txt")); result.IsError || !strings.HasPrefix(string(data), "A\nb\n") {
+		t.Errorf("partial patch returned %v, two.txt = %q", result.Content, data)
+	}
+
+	// A file that cannot be written changes back the files written before it
+	Configure(Config{BackupDir: t.TempDir()})
+	os.WriteFile(filepath.Join(dir, "blocked"), []byte("a file\n"), 0644)
+	result = apply(map[string]any{"patch": "--- a/main.go\n+++ b/main.go\n@@ -3 +3 @@\n-var x = 2\n+var x = 3\n--- /dev/null\n+++ b/blocked/new.txt\n@@ -0,0 +1 @@\n+new\n"})
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !strings.Contains(text, "changed back") {
+		t.Errorf("failed write returned %q", text)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "main.go")); string(data) != "package main\r\n\r\nvar x = 2\r\n" {
+		t.Errorf("main.go after a failed write = %q", data)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{"patch": "not a patch"}
+	if _, err := applyPatchHandler(context.Background(), request); err == nil {
+		t.Error("invalid patch accepted")
+	}
+}
+
+// TestWriteFileOptions tests atomic writes that keep permissions, appending, create_only and expected_sha256
+func TestWriteFileOptions(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "run.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\n"), 0750)
+
+	call := func(args map[string]any) *mcp.CallToolResult {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := writeFileHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("writeFileHandler(%v) error = %v", args, err)
+		}
+		return result
+	}
+	errorText := func(result *mcp.CallToolResult) string {
+		if !result.IsError {
+			return ""
+		}
+		return result.Content[0].(mcp.TextContent).Text
+	}
+
+	// Replacing a file keeps its permission and leaves no temporary file behind
+	result := call(map[string]any{"path": script, "content": "#!/bin/sh\necho hi\n"})
+	if info, err := os.Stat(script); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("replaced file has mode %v, err %v", info.Mode(), err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("directory holds %d entries, want 1", len(entries))
+	}
+	written := result.StructuredContent.(writeResult)
+	if written.Created || written.SHA256 != fmt.Sprintf("%x", sha256.Sum256([]byte("#!/bin/sh\necho hi\n"))) {
+		t.Errorf("write_file reported %+v", written)
+	}
+
+	// Appending adds to the end, and the hash of the last write lets the next one through
+	call(map[string]any{"path": script, "content": "exit 0\n", "append": true, "expected_sha256": written.SHA256})
+	if data, _ := os.ReadFile(script); string(data) != "#!/bin/sh\necho hi\nexit 0\n" {
+		t.Errorf("appended file = %q", data)
+	}
+	if text := errorText(call(map[string]any{"path": script, "content": "stale", "expected_sha256": written.SHA256})); !strings.Contains(text, "[conflict]") {
+		t.Errorf("stale expected_sha256 returned %q", text)
+	}
+
+	// A link to the file is kept and the file it points to is written
+	link := filepath.Join(dir, "link.sh")
+	os.Symlink(script, link)
+	call(map[string]any{"path": link, "content": "#!/bin/sh\n", "mode": "0700"})
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link was replaced: %v %v", info, err)
+	}
+	if info, _ := os.Stat(script); info.Mode().Perm() != 0700 {
+		t.Errorf("mode was not changed: %v", info.Mode())
+	}
+
+	if text := errorText(call(map[string]any{"path": script, "content": "x", "create_only": true})); !strings.Contains(text, "[already_exists]") {
+		t.Errorf("create_only on an existing file returned %q", text)
+	}
+	if text := errorText(call(map[string]any{"path": filepath.Join(dir, "new", "file.txt"), "content": "x", "create_only": true})); text != "" {
+		t.Errorf("create_only on a new file returned %q", text)
+	}
+	if text := errorText(call(map[string]any{"path": dir, "content": "x"})); !strings.Contains(text, "[is_a_directory]") {
+		t.Errorf("writing a directory returned %q", text)
+	}
+
+	// Files with other hard links are written in place, so the links keep sharing the content
+	other := filepath.Join(dir, "other.sh")
+	os.Link(script, other)
+	call(map[string]any{"path": script, "content": "shared\n"})
+	if data, _ := os.ReadFile(other); string(data) != "shared\n" {
+		t.Errorf("hard link holds %q", data)
+	}
+
+	for _, args := range []map[string]any{
+		{"path": script, "content": "x", "mode": "rwx"},
+		{"path": script, "content": "x", "mode": "0999"},
+		{"path": script, "content": "x", "expected_sha256": "abc"},
+		{"path": script, "content": "x", "append": true, "create_only": true},
+	} {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		if _, err := writeFileHandler(context.Background(), request); err == nil {
+			t.Errorf("writeFileHandler(%v) accepted invalid arguments", args)
+		}
+	}
+}
+
+// TestWriteFileAppendEncoding tests that appending to a file with a byte order mark does not repeat it
+func TestWriteFileAppendEncoding(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bom.txt")
+	os.WriteFile(path, []byte{0xff, 0xfe, 'a', 0, '\r', 0, '\n', 0}, 0644)
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{"path": path, "content": "b\n", "append": true}
+	if result, err := writeFileHandler(context.Background(), request); err != nil || result.IsError {
+		t.Fatalf("writeFileHandler() = %v, %v", result, err)
+	}
+	want := []byte{0xff, 0xfe, 'a', 0, '\r', 0, '\n', 0, 'b', 0, '\r', 0, '\n', 0}
+	if data, _ := os.ReadFile(path); !bytes.Equal(data, want) {
+		t.Errorf("appended file = %x, want %x", data, want)
+	}
+}
+
+// TestJournal tests that changes are recorded and can be listed, undone and restored
+func TestJournal(t *testing.T) {
+	defer Configure(DefaultConfig())
+	Configure(Config{BackupDir: t.TempDir(), MaxBackupBytes: 1024})
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	moved := filepath.Join(dir, "b.txt")
+	sub := filepath.Join(dir, "sub")
+
+	call := func(handler server.ToolHandlerFunc, args map[string]any) (string, *mcp.CallToolResult) {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := handler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("handler(%v) error = %v", args, err)
+		}
+		return result.Content[0].(mcp.TextContent).Text, result
+	}
+	content := func(path string) string {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+
+	call(writeFileHandler, map[string]any{"path": file, "content": "one\n"})
+	call(writeFileHandler, map[string]any{"path": file, "content": "two\n"})
+	call(editFileHandler, map[string]any{"path": file, "edits": []any{map[string]any{"old_text": "two", "new_text": "three"}}})
+	call(moveFileHandler, map[string]any{"source": file, "destination": moved})
+	call(createDirectoryHandler, map[string]any{"path": sub})
+	// Writing the same content again changes nothing and is not recorded
+	call(writeFileHandler, map[string]any{"path": moved, "content": "three\n"})
+
+	text, result := call(listChangesHandler, map[string]any{})
+	tools := lo.Map(result.StructuredContent.(changeList).Changes, func(c *change, _ int) string { return c.Tool })
+	if want := []string{"create_directory", "move_file", "edit_file", "write_file", "write_file"}; !reflect.DeepEqual(tools, want) {
+		t.Errorf("list_changes tools = %v, want %v", tools, want)
+	}
+	if !strings.Contains(text, "moved "+file+" to "+moved) || !strings.Contains(text, "created "+file) {
+		t.Errorf("list_changes returned %q", text)
+	}
+	if _, result := call(listChangesHandler, map[string]any{"path": sub, "limit": 1}); len(result.StructuredContent.(changeList).Changes) != 1 {
+		t.Errorf("list_changes for %s returned %+v", sub, result.StructuredContent)
+	}
+
+	// The last two changes are undone in turn
+	call(undoLastChangeHandler, map[string]any{})
+	if _, err := os.Stat(sub); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("created directory still exists: %v", err)
+	}
+	call(undoLastChangeHandler, map[string]any{})
+	if content(file) != "three\n" || content(moved) != "<missing>" {
+		t.Errorf("after undoing the move, a.txt = %q and b.txt = %q", content(file), content(moved))
+	}
+
+	// A file can be restored to its content before any change, which is recorded too
+	text, _ = call(restoreFileHandler, map[string]any{"path": file, "change_id": 2})
+	if content(file) != "one\n" || !strings.Contains(text, "change 6") {
+		t.Errorf("restore_file returned %q, a.txt = %q", text, content(file))
+	}
+
+	// Changes made outside the tools are not discarded without force
+	os.WriteFile(file, []byte("outside\n"), 0644)
+	if text, result := call(undoLastChangeHandler, map[string]any{}); !result.IsError || !strings.Contains(text, "[conflict]") {
+		t.Errorf("undo of a file changed since returned %q", text)
+	}
+	call(undoLastChangeHandler, map[string]any{"force": true})
+	if content(file) != "three\n" {
+		t.Errorf("after the forced undo a.txt = %q", content(file))
+	}
+
+	// Undoing the rest of the session removes the file it created
+	text, result = call(undoLastChangeHandler, map[string]any{"all_session": true})
+	if result.IsError || content(file) != "<missing>" || len(result.StructuredContent.(undoResult).Undone) != 3 {
+		t.Errorf("undo of the session returned %q, a.txt = %q", text, content(file))
+	}
+	if text, result := call(undoLastChangeHandler, map[string]any{}); !result.IsError || !strings.Contains(text, "[not_found]") {
+		t.Errorf("undo without changes left returned %q", text)
+	}
+	if text, result := call(undoLastChangeHandler, map[string]any{"change_id": 1}); !result.IsError || !strings.Contains(text, "already undone") {
+		t.Errorf("second undo of a change returned %q", text)
+	}
+}
+
+// TestPruneJournal tests that the oldest changes are forgotten when their backups exceed the limit
+func TestPruneJournal(t *testing.T) {
+	defer Configure(DefaultConfig())
+	Configure(Config{BackupDir: t.TempDir(), MaxBackupBytes: 20})
+	file := filepath.Join(t.TempDir(), "a.txt")
+
+	for i := range 5 {
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = map[string]any{"path": file, "content": fmt.Sprintf("content %d\n", i)}
+		writeFileHandler(context.Background(), request)
+	}
+	changes, err := readJournal()
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	// Each backup holds 10 bytes, and the first change saved none
+	ids := lo.Map(changes, func(c *change, _ int) int { return c.ID })
+	if want := []int{4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("journal holds changes %v, want %v", ids, want)
+	}
+}
+
+// TestCheckpoints tests creating a checkpoint of a directory, comparing it and restoring it
+func TestCheckpoints(t *testing.T) {
+	defer Configure(DefaultConfig())
+	Configure(Config{BackupDir: t.TempDir()})
+	dir := t.TempDir()
+	write := func(rel string, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+	content := func(rel string) string {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			return "<missing>"
+		}
+		return string(data)
+	}
+	call := func(handler server.ToolHandlerFunc, args map[string]any) (string, *mcp.CallToolResult) {
+		t.Helper()
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := handler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("handler(%v) error = %v", args, err)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if result.IsError {
+			t.Fatalf("handler(%v) failed: %s", args, text)
+		}
+		return text, result
+	}
+
+	write("a.txt", "alpha\n")
+	write("sub/b.txt", "beta\n")
+	write(".gitignore", "*.log\nbuild/\n")
+	write("debug.log", "old log\n")
+	write("build/out.bin", "binary")
+	write(".git/HEAD", "ref: refs/heads/main\n")
+
+	_, result := call(createCheckpointHandler, map[string]any{"path": dir, "name": "before refactoring"})
+	if cp := result.StructuredContent.(*checkpoint); cp.Files != 3 || cp.ID != 1 {
+		t.Errorf("create_checkpoint saved %+v, want the 3 files that are not ignored", cp)
+	}
+
+	write("a.txt", "ALPHA\n")
+	os.Remove(filepath.Join(dir, "sub", "b.txt"))
+	write("new.txt", "new\n")
+	write("newdir/c.txt", "gamma\n")
+	write("debug.log", "new log\n")
+
+	text, result := call(diffCheckpointHandler, map[string]any{"path": dir, "show_diff": true})
+	diff := result.StructuredContent.(checkpointDiffResult).checkpointDiff
+	want := checkpointDiff{Created: []string{"new.txt", "newdir", "newdir/c.txt"}, Deleted: []string{"sub/b.txt"}, Modified: []string{"a.txt"}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff_checkpoint = %+v, want %+v", diff, want)
+	}
+	if !strings.Contains(text, "M a.txt\n") || !strings.Contains(text, "-alpha\n+ALPHA\n") || !strings.Contains(text, "--- /dev/null\n+++ b/new.txt") {
+		t.Errorf("diff_checkpoint returned %q", text)
+	}
+
+	call(restoreCheckpointHandler, map[string]any{"checkpoint_id": 1})
+	for rel, want := range map[string]string{"a.txt": "alpha\n", "sub/b.txt": "beta\n", "new.txt": "<missing>", "newdir/c.txt": "<missing>", "debug.log": "new log\n"} {
+		if got := content(rel); got != want {
+			t.Errorf("after restore_checkpoint %s = %q, want %q", rel, got, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "newdir")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("created directory was kept: %v", err)
+	}
+	if text, _ := call(diffCheckpointHandler, map[string]any{"checkpoint_id": 1}); !strings.HasPrefix(text, "Nothing changed") {
+		t.Errorf("diff_checkpoint after restoring returned %q", text)
+	}
+
+	// The restore is undone like any other change
+	call(undoLastChangeHandler, map[string]any{})
+	if content("a.txt") != "ALPHA\n" || content("newdir/c.txt") != "gamma\n" || content("sub/b.txt") != "<missing>" {
+		t.Errorf("after undo a.txt = %q, newdir/c.txt = %q, sub/b.txt = %q", content("a.txt"), content("newdir/c.txt"), content("sub/b.txt"))
+	}
+
+	// Created files can be kept
+	call(restoreCheckpointHandler, map[string]any{"path": dir, "keep_created": true})
+	if content("a.txt") != "alpha\n" || content("new.txt") != "new\n" {
+		t.Errorf("after restore with keep_created a.txt = %q, new.txt = %q", content("a.txt"), content("new.txt"))
+	}
+
+	text, _ = call(listCheckpointsHandler, map[string]any{})
+	if !strings.HasPrefix(text, "#1 ") || !strings.Contains(text, `"before refactoring"`) {
+		t.Errorf("list_checkpoints returned %q", text)
+	}
+}
+
+// TestGrepFiles tests searching the content of files with limits and context lines
+func TestGrepFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"a.go":          "package a\n\nfunc Hello() {\n\tprintln(\"hello\")\n}\n",
+		"b.txt":         "one\nHello world\nthree\nfour\nfive\nhello again\n",
+		"vendor/c.go":   "package c // Hello\n",
+		"sub/d.go":      "// héllo Hello\n",
+		"image.bin":     "Hello\x00\x01\x02binary",
+		"utf16.txt":     "\xff\xfeH\x00e\x00l\x00l\x00o\x00\n\x00",
+		"special.txt":   "a.b\naxb\n",
+		"many.txt":      strings.Repeat("match\n", 10),
+		"long/line.txt": strings.Repeat("x", 2*maxGrepLineLen) + "Hello\n",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	grep := func(opts grepOptions) *grepResult {
+		t.Helper()
+		opts.FileLimit = cmp.Or(opts.FileLimit, defaultGrepFileMatches)
+		opts.Limit = cmp.Or(opts.Limit, defaultGrepMatches)
+		result, err := grepFiles(context.Background(), dir, opts)
+		if err != nil {
+			t.Fatalf("grepFiles(%+v) error = %v", opts, err)
+		}
+		return result
+	}
+	matches := func(result *grepResult) []string {
+		var found []string
+		for _, file := range result.Files {
+			for _, match := range file.Matches {
+				found = append(found, fmt.Sprintf("%s:%d:%d", file.Path, match.Line, match.Column))
+			}
+		}
+		return found
+	}
+
+	result := grep(grepOptions{Pattern: "Hello"})
+	want := []string{"a.go:3:6", "b.txt:2:1", "long/line.txt:1:1001", "sub/d.go:1:10", "utf16.txt:1:1", "vendor/c.go:1:14"}
+	if got := matches(result); !reflect.DeepEqual(got, want) {
+		t.Errorf("grepFiles() = %v, want %v", got, want)
+	}
+	if result.Binary != 1 || result.Searched != 9 {
+		t.Errorf("grepFiles() searched %d files and skipped %d binary ones, want 9 and 1", result.Searched, result.Binary)
+	}
+	if text := result.Files[2].Matches[0].Text; len(text) > maxGrepLineLen+10 || !strings.HasSuffix(text, " [...]") {
+		t.Errorf("long line was returned as %q", text)
+	}
+
+	tests := []struct {
+		name string
+		opts grepOptions
+		want []string
+	}{
+		{name: "ignore case", opts: grepOptions{Pattern: "h.llo", IgnoreCase: true, Include: []string{"*.txt"}}, want: []string{"b.txt:2:1", "b.txt:6:1", "long/line.txt:1:1001", "utf16.txt:1:1"}},
+		{name: "literal", opts: grepOptions{Pattern: "a.b", Literal: true}, want: []string{"special.txt:1:1"}},
+		{name: "regex", opts: grepOptions{Pattern: "a.b"}, want: []string{"special.txt:1:1", "special.txt:2:1"}},
+		{name: "include path", opts: grepOptions{Pattern: "Hello", Include: []string{"**/*.go"}}, want: []string{"a.go:3:6", "sub/d.go:1:10", "vendor/c.go:1:14"}},
+		{name: "exclude", opts: grepOptions{Pattern: "Hello", Include: []string{"*.go"}, Exclude: []string{"vendor"}}, want: []string{"a.go:3:6", "sub/d.go:1:10"}},
+		{name: "file limit", opts: grepOptions{Pattern: "match", FileLimit: 3}, want: []string{"many.txt:1:1", "many.txt:2:1", "many.txt:3:1"}},
+		{name: "total limit", opts: grepOptions{Pattern: "Hello", Limit: 2}, want: []string{"a.go:3:6", "b.txt:2:1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := grep(tt.opts)
+			if got := matches(result); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("grepFiles() = %v, want %v", got, tt.want)
+			}
+			if result.Truncated != (tt.opts.Limit != 0) {
+				t.Errorf("grepFiles() truncated = %v", result.Truncated)
+			}
+		})
+	}
+
+	// Context lines of nearby matches are merged in the text
+	opts := grepOptions{Pattern: "hello", IgnoreCase: true, Before: 1, After: 1, Include: []string{"b.txt"}, FileLimit: 50, Limit: 50}
+	result = grep(opts)
+	match := result.Files[0].Matches[0]
+	if !reflect.DeepEqual(match.Before, []string{"one"}) || !reflect.DeepEqual(match.After, []string{"three"}) {
+		t.Errorf("context of the first match = %q, %q", match.Before, match.After)
+	}
+	wantText := "==> b.txt (2 matches) <==\n1-one\n2:1:Hello world\n3-three\n--\n5-five\n6:1:hello again\n\n2 matching lines in 1 of 1 files\n"
+	if text := result.text(opts); text != wantText {
+		t.Errorf("text() = %q, want %q", text, wantText)
+	}
+
+	// A single file can be searched
+	result, err := grepFiles(context.Background(), filepath.Join(dir, "b.txt"), grepOptions{Pattern: "four", FileLimit: 1, Limit: 1})
+	if err != nil || len(result.Files) != 1 || result.Files[0].Path != "b.txt" || result.Files[0].Matches[0].Line != 4 {
+		t.Errorf("grepFiles() on a file = %+v, %v", result, err)
+	}
+}
+
+// TestIgnorer tests gitignore semantics across nested ignore files
+func TestIgnorer(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".git/HEAD":              "ref: refs/heads/main\n",
+		".gitignore":             "# build output\n*.log\n!keep.log\n/dist\nbuild/\ndocs/**/*.tmp\n\\#notes\ntrailing.txt   \n",
+		"app/.gitignore":         "generated/\n!important.log\n",
+		"app/.ignore":            "*.snap\n",
+		"app/.jarvisignore":      "secrets.env\n!*.snap\n",
+		"app/main.go":            "",
+		"app/debug.log":          "",
+		"app/important.log":      "",
+		"app/keep.log":           "",
+		"app/test.snap":          "",
+		"app/secrets.env":        "",
+		"app/generated/x.go":     "",
+		"app/dist/bundle.js":     "",
+		"app/build":              "not a directory",
+		"dist/bundle.js":         "",
+		"docs/a/b/c.tmp":         "",
+		"docs/c.tmp":             "",
+		"#notes":                 "",
+		"trailing.txt":           "",
+		"lib/build/out.o":        "",
+		"lib/[weird]/.gitignore": "*.txt\n",
+		"lib/[weird]/a.txt":      "",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(content), 0644)
+	}
+
+	walk := func(root string) []string {
+		t.Helper()
+		ignore, err := newIgnorer(root)
+		if err != nil {
+			t.Fatalf("newIgnorer() error = %v", err)
+		}
+		var kept []string
+		filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if path == root {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, path)
+			rel = filepath.ToSlash(rel)
+			if ignore.match(rel, entry.IsDir()) {
+				return utils.IfElse(entry.IsDir(), fs.SkipDir, nil)
+			}
+			if entry.IsDir() {
+				ignore.enter(path, rel)
+			} else if !slices.Contains(ignoreFiles, entry.Name()) {
+				kept = append(kept, rel)
+			}
+			return nil
+		})
+		return kept
+	}
+
+	want := []string{"app/build", "app/dist/bundle.js", "app/important.log", "app/keep.log", "app/main.go", "app/test.snap"}
+	if got := walk(dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("walking the repository kept %v, want %v", got, want)
+	}
+	// The ignore files of the parent directories in the repository apply too
+	want = []string{"build", "dist/bundle.js", "important.log", "keep.log", "main.go", "test.snap"}
+	if got := walk(filepath.Join(dir, "app")); !reflect.DeepEqual(got, want) {
+		t.Errorf("walking a subdirectory kept %v, want %v", got, want)
+	}
+
+	// The tools leave ignored entries out unless asked not to
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{"path": dir, "pattern": "bundle"}
+	result, _ := searchFilesHandler(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; strings.Contains(text, "[FILE] dist/bundle.js") || !strings.Contains(text, "[FILE] app/dist/bundle.js") {
+		t.Errorf("search_files returned %q", text)
+	}
+	request.Params.Arguments = map[string]any{"path": dir, "pattern": "bundle", "respect_ignore": false}
+	result, _ = searchFilesHandler(context.Background(), request)
+	if text := result.Content[0].(mcp.TextContent).Text; !strings.Contains(text, "[FILE] dist/bundle.js") {
+		t.Errorf("search_files without respect_ignore returned %q", text)
+	}
+	tree, err := directoryTree(dir, treeOptions{RespectIgnore: true})
+	if err != nil || strings.Contains(tree, `"generated"`) || strings.Contains(tree, `"HEAD"`) || !strings.Contains(tree, `"main.go"`) {
+		t.Errorf("directoryTree() = %s, %v", tree, err)
+	}
+	listing, err := listDirectory(filepath.Join(dir, "app"), listOptions{RespectIgnore: true})
+	if err != nil {
+		t.Fatalf("listDirectory() error = %v", err)
+	}
+	if list := strings.Split(listing.text(nil), "\n"); slices.Contains(list, "[FILE] debug.log") || !slices.Contains(list, "[FILE] keep.log") {
+		t.Errorf("listDirectory() = %v", list)
+	}
+	grep, err := grepFiles(context.Background(), dir, grepOptions{Pattern: "refs", RespectIgnore: true, FileLimit: 1, Limit: 1})
+	if err != nil || grep.Matches != 0 {
+		t.Errorf("grepFiles() searched the .git directory: %+v, %v", grep, err)
+	}
+}
+
+// TestDirectoryTreeOptions tests the limits, annotations and text rendering of directory_tree
+func TestDirectoryTreeOptions(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{".hidden": 1, "a.txt": 5, "big/f1": 10, "big/f2": 20, "big/sub/f3": 2000, "z.txt": 1} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, bytes.Repeat([]byte("x"), size), 0644)
+	}
+	name := filepath.Base(dir)
+
+	tests := []struct {
+		name string
+		opts treeOptions
+		want string
+	}{
+		{
+			name: "text",
+			opts: treeOptions{Format: "text"},
+			want: name + "/\n├── .hidden\n├── a.txt\n├── big/\n│   ├── f1\n│   ├── f2\n│   └── sub/\n│       └── f3\n└── z.txt\n\n2 directories, 6 files\n",
+		},
+		{
+			name: "hidden and sizes",
+			opts: treeOptions{Format: "text", SkipHidden: true, Size: true, MaxDepth: 1},
+			want: name + "/\n├── a.txt [5 B]\n├── big/\n│   └── [... 3 more entries not shown]\n└── z.txt [1 B]\n\n1 directory, 2 files\n[The tree was cut short; raise max_depth or max_entries, or ask for a subdirectory]\n",
+		},
+		{
+			name: "totals beyond the limits",
+			opts: treeOptions{Format: "text", SkipHidden: true, Totals: true, MaxEntries: 3},
+			want: name + "/ [5 files, 2 dirs, 2.0 KiB]\n├── a.txt\n├── big/ [3 files, 1 dir, 2.0 KiB]\n│   ├── f1\n│   └── [... 2 more entries not shown]\n└── [... 1 more entry not shown]\n\n1 directory, 2 files\n[The tree was cut short; raise max_depth or max_entries, or ask for a subdirectory]\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := directoryTree(dir, tt.opts)
+			if err != nil {
+				t.Fatalf("directoryTree() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("directoryTree() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// The JSON tree carries the same information
+	treeJSON, err := directoryTree(dir, treeOptions{Totals: true, Size: true, MaxDepth: 1})
+	if err != nil {
+		t.Fatalf("directoryTree() error = %v", err)
+	}
+	var tree treeNode
+	if err := json.Unmarshal([]byte(treeJSON), &tree); err != nil {
+		t.Fatalf("failed to parse directory tree JSON: %v", err)
+	}
+	big := tree.Children[2]
+	if big.Name != "big" || big.Truncated != 3 || len(big.Children) != 0 || *big.Size != 2030 || *big.Files != 3 || *big.Dirs != 1 {
+		t.Errorf("directoryTree() returned %+v for big", big)
+	}
+	if *tree.Children[1].Size != 5 || tree.Children[1].Files != nil {
+		t.Errorf("directoryTree() returned %+v for a.txt", tree.Children[1])
+	}
+}
+
+// TestListDirectoryOptions tests the columns, sorting, filters and pages of list_directory
+func TestListDirectoryOptions(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now().Truncate(time.Second)
+	for i, file := range []struct {
+		name string
+		size int
+	}{{"b.txt", 300}, {"a.go", 2000}, {"c.txt", 10}, {".env", 1}} {
+		path := filepath.Join(dir, file.name)
+		os.WriteFile(path, bytes.Repeat([]byte("x"), file.size), 0640)
+		modTime := now.Add(time.Duration(i-10) * time.Hour)
+		os.Chtimes(path, modTime, modTime)
+	}
+	os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	os.Symlink("a.go", filepath.Join(dir, "link"))
+
+	list := func(args map[string]any) (string, *directoryListing) {
+		t.Helper()
+		args["path"] = dir
+		request := mcp.CallToolRequest{}
+		request.Params.Arguments = args
+		result, err := listDirectoryHandler(context.Background(), request)
+		if err != nil {
+			t.Fatalf("listDirectoryHandler(%v) error = %v", args, err)
+		}
+		text := result.Content[0].(mcp.TextContent).Text
+		if result.IsError {
+			t.Fatalf("listDirectoryHandler(%v) failed: %s", args, text)
+		}
+		return text, result.StructuredContent.(*directoryListing)
+	}
+	names := func(listing *directoryListing) []string {
+		return lo.Map(listing.Entries, func(entry dirEntry, _ int) string { return entry.Name })
+	}
+
+	if text, _ := list(map[string]any{}); text != "[FILE] .env\n[FILE] a.go\n[FILE] b.txt\n[FILE] c.txt\n[FILE] link\n[DIR] sub" {
+		t.Errorf("list_directory returned %q", text)
+	}
+
+	tests := []struct {
+		name string
+		args map[string]any
+		want []string
+	}{
+		{name: "hidden", args: map[string]any{"show_hidden": false}, want: []string{"a.go", "b.txt", "c.txt", "link", "sub"}},
+		{name: "pattern", args: map[string]any{"pattern": "*.txt"}, want: []string{"b.txt", "c.txt"}},
+		{name: "size", args: map[string]any{"sort": "size", "pattern": "*.*"}, want: []string{".env", "c.txt", "b.txt", "a.go"}},
+		{name: "largest first", args: map[string]any{"sort": "size", "reverse": true, "pattern": "*.*"}, want: []string{"a.go", "b.txt", "c.txt", ".env"}},
+		{name: "oldest first", args: map[string]any{"sort": "mtime", "pattern": "*.*"}, want: []string{"b.txt", "a.go", "c.txt", ".env"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, listing := list(tt.args); !reflect.DeepEqual(names(listing), tt.want) {
+				t.Errorf("list_directory(%v) = %v, want %v", tt.args, names(listing), tt.want)
+			}
+		})
+	}
+
+	// Columns are returned as a table or as JSON
+	text, listing := list(map[string]any{"columns": []any{"type", "size", "human_size", "permissions", "target"}, "pattern": "[al]*"})
+	want := "NAME  TYPE     SIZE  HUMAN_SIZE  PERMISSIONS  TARGET\na.go  file     2000  2.0 KiB     -rw-r-----   -\nlink  symlink  -     -           lrwxrwxrwx   a.go"
+	if text != want {
+		t.Errorf("list_directory table = %q, want %q", text, want)
+	}
+	if entry := listing.Entries[1]; entry.Type != "symlink" || entry.Target != "a.go" || entry.Size != nil {
+		t.Errorf("list_directory returned %+v for the link", entry)
+	}
+	text, _ = list(map[string]any{"columns": []any{"mtime"}, "pattern": "sub", "format": "json"})
+	var decoded directoryListing
+	if err := json.Unmarshal([]byte(text), &decoded); err != nil || len(decoded.Entries) != 1 || decoded.Entries[0].ModTime == nil {
+		t.Errorf("list_directory JSON = %s, %v", text, err)
+	}
+	// Pages follow each other through the cursor, even if the entry it ends with is removed
+	var all []string
+	args := map[string]any{"sort": "size", "reverse": true, "limit": 2}
+	for page := 0; ; page++ {
+		text, listing := list(args)
+		all = append(all, names(listing)...)
+		if want := utils.IfElse(page == 0, 6, 5); listing.Total != want || listing.Offset != len(all)-len(listing.Entries)-utils.IfElse(page == 0, 0, 1) {
+			t.Errorf("page %d has total %d and offset %d", page, listing.Total, listing.Offset)
+		}
+		if listing.NextCursor == "" {
+			break
+		}
+		if !strings.Contains(text, fmt.Sprintf("pass cursor %q", listing.NextCursor)) {
+			t.Errorf("page %d does not tell how to continue: %q", page, text)
+		}
+		if page == 0 {
+			os.Remove(filepath.Join(dir, listing.Entries[1].Name))
+		}
+		args["cursor"] = listing.NextCursor
+	}
+	if want := []string{"sub", "a.go", "b.txt", "c.txt", "link", ".env"}; !reflect.DeepEqual(all, want) {
+		t.Errorf("the pages listed %v, want %v", all, want)
+	}
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{"path": dir, "cursor": args["cursor"]}
+	if _, err := listDirectoryHandler(context.Background(), request); err == nil {
+		t.Errorf("list_directory accepted a cursor of another sort order")
+	}
+
+	// Ignored entries are left out unless respect_ignore is false
+	os.WriteFile(filepath.Join(dir, ".ignore"), []byte("*.txt\n"), 0644)
+	if _, listing := list(map[string]any{"pattern": "*.txt"}); len(listing.Entries) != 0 {
+		t.Errorf("list_directory returned ignored entries %v", names(listing))
+	}
+	if _, listing := list(map[string]any{"pattern": "*.txt", "respect_ignore": false}); len(listing.Entries) != 2 {
+		t.Errorf("list_directory without respect_ignore returned %v", names(listing))
+	}
+}
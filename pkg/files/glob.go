@@ -0,0 +1,61 @@
+package files
+
+import (
+	"path"
+	"strings"
+)
+
+// matchGlob reports whether the slash-separated path name matches pattern. As in path.Match,
+// * and ? do not match slashes; in addition a ** segment matches any number of directories, so
+// **/*.go matches Go files at any depth and build/** everything under build.
+func matchGlob(pattern string, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			pattern = pattern[1:]
+			if len(pattern) == 0 {
+				// A trailing ** matches what is inside, not the directory itself
+				return len(name) > 0
+			}
+			for i := range len(name) + 1 {
+				if matchSegments(pattern, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// validGlob returns path.ErrBadPattern if pattern is malformed.
+func validGlob(pattern string) error {
+	for segment := range strings.SplitSeq(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// escapeGlob returns a pattern that matches s literally.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
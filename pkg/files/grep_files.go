@@ -0,0 +1,437 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"golang.org/x/text/transform"
+)
+
+const (
+	// defaultGrepMatches is the number of matching lines grep_files returns when no limit is given.
+	defaultGrepMatches = 500
+	// defaultGrepFileMatches is the number of matching lines returned per file when no limit is given.
+	defaultGrepFileMatches = 50
+	// maxGrepLineLen is the length at which lines are cut in grep_files results, so that a match
+	// in minified code does not return the whole file.
+	maxGrepLineLen = 500
+)
+
+func GetGrepFiles() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("grep_files",
+		mcp.WithDescription("Searches the content of the files under a directory for a regular expression or literal text, returning the matching lines grouped by file with their line and column numbers. Binary files are skipped"),
+		mcp.WithString("pattern",
+			mcp.Required(),
+			mcp.Description("Regular expression, in RE2 syntax, or literal text to search for. Patterns match within a single line"),
+		),
+		mcp.WithString("path",
+			mcp.Required(),
+			mcp.Description("Directory to search, or a single file"),
+		),
+		mcp.WithBoolean("literal",
+			mcp.Description("Search for the pattern as literal text instead of a regular expression"),
+		),
+		mcp.WithBoolean("ignore_case",
+			mcp.Description("Match letters regardless of case"),
+		),
+		mcp.WithArray("include",
+			mcp.Description("Glob patterns of the files to search, such as *.go or src/**/*.ts. Patterns without a slash match names. Defaults to all files"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithArray("exclude",
+			mcp.Description("Glob patterns of files and directories to skip, such as vendor or *.min.js; excluded directories are not searched"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithBoolean("respect_ignore",
+			mcp.Description("Skip the files ignored by .gitignore, .ignore and .jarvisignore files, and the .git directory. Defaults to true"),
+		),
+		mcp.WithNumber("before_context",
+			mcp.Description("Number of lines to return before each match"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("after_context",
+			mcp.Description("Number of lines to return after each match"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("context",
+			mcp.Description("Number of lines to return before and after each match, unless before_context or after_context is given"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("max_matches_per_file",
+			mcp.Description(fmt.Sprintf("Maximum number of matching lines to return per file. Defaults to %d", defaultGrepFileMatches)),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("max_matches",
+			mcp.Description(fmt.Sprintf("Maximum number of matching lines to return in total. Defaults to %d", defaultGrepMatches)),
+			mcp.Min(1),
+		),
+	), grepFilesHandler
+}
+
+// grepOptions are the criteria of a grep_files call.
+type grepOptions struct {
+	Pattern    string
+	Literal    bool
+	IgnoreCase bool
+	Include    []string
+	Exclude    []string
+	// RespectIgnore skips the files ignored by ignore files.
+	RespectIgnore bool
+	Before        int
+	After         int
+	// FileLimit and Limit are the maximum numbers of matching lines per file and in total.
+	FileLimit int
+	Limit     int
+}
+
+// grepMatch is a line matching the pattern of grep_files.
+type grepMatch struct {
+	Line int `json:"line"`
+	// Column is the position of the first match in the line, in characters from 1.
+	Column int    `json:"column"`
+	Text   string `json:"text"`
+	// Before and After are the context lines around the match.
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// grepFile is a file with lines matching the pattern of grep_files, or one that could not be searched.
+type grepFile struct {
+	// Path is relative to the search root, with forward slashes.
+	Path    string      `json:"path"`
+	Matches []grepMatch `json:"matches,omitempty"`
+	// Truncated reports that more lines of the file match than the per-file limit.
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+
+	path   string
+	binary bool
+}
+
+// grepResult is the structured content of a grep_files result.
+type grepResult struct {
+	Root  string      `json:"root"`
+	Files []*grepFile `json:"files"`
+	// Matches counts the matching lines returned.
+	Matches  int `json:"matches"`
+	Searched int `json:"searched"`
+	// Binary counts the files skipped as binary.
+	Binary int `json:"binary,omitempty"`
+	// Truncated reports that the total limit was reached, so files may have matches that are not returned.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+func grepFilesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	pattern, ok := request.GetArguments()["pattern"].(string)
+	if !ok || pattern == "" {
+		return nil, errors.New("search pattern is required")
+	}
+	dirPath, ok := request.GetArguments()["path"].(string)
+	if !ok {
+		return nil, errors.New("search path is required")
+	}
+
+	contextLines := request.GetInt("context", 0)
+	opts := grepOptions{
+		Pattern:       pattern,
+		Literal:       request.GetBool("literal", false),
+		IgnoreCase:    request.GetBool("ignore_case", false),
+		Include:       request.GetStringSlice("include", nil),
+		Exclude:       request.GetStringSlice("exclude", nil),
+		RespectIgnore: request.GetBool("respect_ignore", true),
+		Before:        request.GetInt("before_context", contextLines),
+		After:         request.GetInt("after_context", contextLines),
+		FileLimit:     request.GetInt("max_matches_per_file", defaultGrepFileMatches),
+		Limit:         request.GetInt("max_matches", defaultGrepMatches),
+	}
+	if opts.Before < 0 || opts.After < 0 {
+		return nil, errors.New("context lines cannot be negative")
+	}
+	if opts.FileLimit < 1 || opts.Limit < 1 {
+		return nil, errors.New("match limits must be at least 1")
+	}
+
+	dirPath, err := resolvePath(ctx, dirPath)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	result, err := grepFiles(ctx, dirPath, opts)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	return mcp.NewToolResultStructured(result, result.text(opts)), nil
+}
+
+// grepFiles searches the files under root, or root itself if it is a file, for lines matching
+// opts. The files are searched concurrently, but they are handed out in lexical order and the
+// limit is applied in that order, so the result is the same as a search one file after another.
+func grepFiles(ctx context.Context, root string, opts grepOptions) (*grepResult, error) {
+	re, err := regexp.Compile(utils.IfElse(opts.IgnoreCase, "(?i)", "") + utils.IfElse(opts.Literal, regexp.QuoteMeta(opts.Pattern), opts.Pattern))
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+	for _, pattern := range append(slices.Clone(opts.Include), opts.Exclude...) {
+		if err := validGlob(pattern); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+	globMatch := func(patterns []string, name string, rel string) bool {
+		for _, pattern := range patterns {
+			if matchGlob(strings.TrimPrefix(pattern, "/"), utils.IfElse(strings.Contains(pattern, "/"), rel, name)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	var ignore *ignorer
+	if opts.RespectIgnore && info.IsDir() {
+		if ignore, err = newIgnorer(root); err != nil {
+			return nil, err
+		}
+	}
+
+	var files []*grepFile
+	var total atomic.Int64
+	// stopped reports that files were left unsearched because the limit was reached
+	stopped := false
+	jobs := make(chan *grepFile)
+	var wg sync.WaitGroup
+	for range batchWorkers {
+		wg.Go(func() {
+			for file := range jobs {
+				file.search(re, opts)
+				total.Add(int64(len(file.Matches)))
+			}
+		})
+	}
+
+	if !info.IsDir() {
+		file := &grepFile{Path: filepath.Base(root), path: root}
+		files = append(files, file)
+		root = filepath.Dir(root)
+		jobs <- file
+	} else {
+		err = filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if filePath == root {
+					return err
+				}
+				return utils.IfElse(entry != nil && entry.IsDir(), fs.SkipDir, nil)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if total.Load() >= int64(opts.Limit) {
+				stopped = true
+				return fs.SkipAll
+			}
+			if filePath == root {
+				return nil
+			}
+			rel, err := filepath.Rel(root, filePath)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if ignore.match(rel, entry.IsDir()) || globMatch(opts.Exclude, entry.Name(), rel) {
+				return utils.IfElse(entry.IsDir(), fs.SkipDir, nil)
+			}
+			if entry.IsDir() {
+				return ignore.enter(filePath, rel)
+			}
+			if !entry.Type().IsRegular() || len(opts.Include) > 0 && !globMatch(opts.Include, entry.Name(), rel) {
+				return nil
+			}
+			file := &grepFile{Path: rel, path: filePath}
+			files = append(files, file)
+			jobs <- file
+			return nil
+		})
+	}
+	close(jobs)
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	// Apply the limit in the order of the files, so it does not depend on which finished first
+	result := &grepResult{Root: root, Files: []*grepFile{}}
+	for _, file := range files {
+		result.Searched++
+		if file.binary {
+			result.Binary++
+		}
+		if remaining := opts.Limit - result.Matches; len(file.Matches) > remaining {
+			file.Matches = file.Matches[:remaining]
+			file.Truncated = false
+			result.Truncated = true
+		}
+		result.Matches += len(file.Matches)
+		if len(file.Matches) > 0 || file.Error != "" {
+			result.Files = append(result.Files, file)
+		}
+		if result.Truncated {
+			break
+		}
+	}
+	result.Truncated = result.Truncated || stopped
+	return result, nil
+}
+
+// search fills file with the lines of the file that match re, up to the per-file limit
+// of opts, and their context. Binary files are skipped and UTF-16 files decoded; other files
+// are searched as UTF-8, so their characters outside ASCII may not match.
+func (file *grepFile) search(re *regexp.Regexp, opts grepOptions) {
+	f, err := os.Open(file.path)
+	if err != nil {
+		file.Error = err.Error()
+		return
+	}
+	defer f.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		file.Error = err.Error()
+		return
+	}
+	head = head[:n]
+	var reader io.Reader = io.MultiReader(bytes.NewReader(head), f)
+	switch name, _ := detectEncoding(head, bytes.NewReader(head)); {
+	case strings.HasPrefix(name, "utf-16"), name == "utf-8-bom":
+		reader = transform.NewReader(reader, encodings[name].NewDecoder())
+	case !isText(http.DetectContentType(head)):
+		file.binary = true
+		return
+	}
+
+	var before []string
+	// open are the indexes of the matches still collecting lines after them
+	var open []int
+	lines := bufio.NewReaderSize(reader, 64*1024)
+	for number := 1; ; number++ {
+		line, err := lines.ReadString('\n')
+		if line == "" && err != nil {
+			if err != io.EOF {
+				file.Error = err.Error()
+			}
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		open = slices.DeleteFunc(open, func(i int) bool {
+			match := &file.Matches[i]
+			match.After = append(match.After, shortLine(line))
+			return len(match.After) == opts.After
+		})
+		if loc := re.FindStringIndex(line); loc != nil {
+			if len(file.Matches) == opts.FileLimit {
+				file.Truncated = true
+			} else {
+				file.Matches = append(file.Matches, grepMatch{
+					Line:   number,
+					Column: utf8.RuneCountInString(line[:loc[0]]) + 1,
+					Text:   shortLine(line),
+					Before: slices.Clone(before),
+				})
+				if opts.After > 0 {
+					open = append(open, len(file.Matches)-1)
+				}
+			}
+		}
+		if file.Truncated && len(open) == 0 {
+			return
+		}
+		if opts.Before > 0 {
+			before = append(before, shortLine(line))
+			if len(before) > opts.Before {
+				before = before[1:]
+			}
+		}
+	}
+}
+
+// shortLine returns line as valid UTF-8, cut to maxGrepLineLen bytes.
+func shortLine(line string) string {
+	line = strings.ToValidUTF8(line, "�")
+	if len(line) <= maxGrepLineLen {
+		return line
+	}
+	return string(trimPartialRune([]byte(line[:maxGrepLineLen]))) + " [...]"
+}
+
+// text formats the result like grep: each file under a header, matching lines as
+// line:column:text and context lines as line-text, with -- between groups that are not adjacent.
+func (r *grepResult) text(opts grepOptions) string {
+	var b strings.Builder
+	for _, file := range r.Files {
+		if file.Error != "" {
+			fmt.Fprintf(&b, "==> %s <==\nError: %s\n\n", file.Path, file.Error)
+			continue
+		}
+		fmt.Fprintf(&b, "==> %s (%d %s) <==\n", file.Path, len(file.Matches), utils.IfElse(len(file.Matches) == 1, "match", "matches"))
+		printed := 0
+		for _, match := range file.Matches {
+			first := match.Line - len(match.Before)
+			if printed > 0 && first > printed+1 && (opts.Before > 0 || opts.After > 0) {
+				b.WriteString("--\n")
+			}
+			for i, line := range match.Before {
+				if first+i > printed {
+					fmt.Fprintf(&b, "%d-%s\n", first+i, line)
+				}
+			}
+			if match.Line > printed {
+				fmt.Fprintf(&b, "%d:%d:%s\n", match.Line, match.Column, match.Text)
+			}
+			printed = max(printed, match.Line)
+			for i, line := range match.After {
+				if match.Line+1+i > printed {
+					fmt.Fprintf(&b, "%d-%s\n", match.Line+1+i, line)
+					printed = match.Line + 1 + i
+				}
+			}
+		}
+		if file.Truncated {
+			fmt.Fprintf(&b, "[More lines match; raise max_matches_per_file to see more than %d]\n", opts.FileLimit)
+		}
+		b.WriteString("\n")
+	}
+
+	if r.Matches == 0 {
+		fmt.Fprintf(&b, "No matches for '%s' in %d files", opts.Pattern, r.Searched)
+	} else {
+		fmt.Fprintf(&b, "%d matching lines in %d of %d files", r.Matches, len(r.Files), r.Searched)
+	}
+	if r.Binary > 0 {
+		fmt.Fprintf(&b, ", %d binary files skipped", r.Binary)
+	}
+	b.WriteString("\n")
+	if r.Truncated {
+		fmt.Fprintf(&b, "[The search stopped at %d matching lines; raise max_matches or narrow the search to see more]\n", opts.Limit)
+	}
+	return b.String()
+}
@@ -0,0 +1,171 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFiles are the files read in every directory for patterns of entries to leave out, in
+// increasing precedence: .ignore files are shared with tools such as ripgrep, and .jarvisignore
+// files hide what only this server should not see.
+var ignoreFiles = []string{".gitignore", ".ignore", ".jarvisignore"}
+
+// ignorer decides which entries of a directory tree are ignored, with the semantics of
+// .gitignore files: the patterns of a file apply to the directory it is in and below, patterns of
+// deeper files and later lines take precedence, ! re-includes what an earlier pattern ignored,
+// a slash at the start or in the middle anchors a pattern to its directory, a trailing slash
+// matches directories only, and ** matches any number of directories. The .git directory is
+// always ignored. A nil *ignorer ignores nothing.
+type ignorer struct {
+	// prefix is the path of the walked root relative to the directory pattern paths are
+	// relative to, the top of the repository the root is in, or "" if they are the same.
+	prefix   string
+	patterns []ignorePattern
+}
+
+// ignorePattern is a line of an ignore file.
+type ignorePattern struct {
+	// glob matches the paths it applies to relative to the top, including the directory of its file.
+	glob    string
+	negate  bool
+	dirOnly bool
+}
+
+// newIgnorer returns an ignorer for the tree at root with the patterns of root and, if root is in
+// a Git repository, of its parent directories up to the top of the repository. The patterns of
+// the directories below root are added with enter as they are walked.
+func newIgnorer(root string) (*ignorer, error) {
+	dirs := []string{root}
+	for dir := root; !exists(filepath.Join(dir, ".git")); {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Not in a repository: the patterns above root do not apply
+			dirs = dirs[:1]
+			break
+		}
+		dir = parent
+		dirs = append(dirs, dir)
+	}
+
+	ig := &ignorer{}
+	top := dirs[len(dirs)-1]
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rel, err := filepath.Rel(top, dirs[i])
+		if err != nil {
+			return nil, err
+		}
+		rel = utils.IfElse(rel == ".", "", filepath.ToSlash(rel))
+		if err := ig.load(dirs[i], rel); err != nil {
+			return nil, err
+		}
+		ig.prefix = rel
+	}
+	return ig, nil
+}
+
+// exists reports whether there is an entry at path.
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// enter adds the patterns of the directory at path, whose path relative to the walked root is
+// rel. It is called for each directory walked into after the root.
+func (ig *ignorer) enter(path string, rel string) error {
+	if ig == nil {
+		return nil
+	}
+	return ig.load(path, ig.full(rel))
+}
+
+// load adds the patterns of the ignore files in the directory at path, whose path relative to the
+// top is dir. Missing files add nothing.
+func (ig *ignorer) load(path string, dir string) error {
+	for _, name := range ignoreFiles {
+		data, err := os.ReadFile(filepath.Join(path, name))
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ig.patterns = append(ig.patterns, parseIgnore(data, dir)...)
+	}
+	return nil
+}
+
+// parseIgnore returns the patterns of an ignore file in the directory dir, relative to the top.
+func parseIgnore(data []byte, dir string) []ignorePattern {
+	var patterns []ignorePattern
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := trimUnescapedSpace(strings.TrimSuffix(scanner.Text(), "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if p.negate = strings.HasPrefix(line, "!"); p.negate {
+			line = line[1:]
+		}
+		if p.dirOnly = strings.HasSuffix(line, "/"); p.dirOnly {
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" || validGlob(line) != nil {
+			continue
+		}
+		// A pattern without a slash but at its end matches at any depth
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		p.glob = path.Join(escapeGlob(dir), strings.TrimPrefix(line, "/"))
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// trimUnescapedSpace removes the trailing spaces of line that are not escaped with a backslash.
+func trimUnescapedSpace(line string) string {
+	trimmed := strings.TrimRight(line, " ")
+	if strings.HasSuffix(trimmed, `\`) && len(trimmed) < len(line) {
+		return trimmed + " "
+	}
+	return trimmed
+}
+
+// full returns the path relative to the top of the entry at rel relative to the walked root.
+func (ig *ignorer) full(rel string) string {
+	if ig.prefix == "" {
+		return rel
+	}
+	return ig.prefix + "/" + rel
+}
+
+// match reports whether the entry at rel, relative to the walked root with forward slashes, is
+// ignored. Entries inside an ignored directory are not matched by the patterns of the directory;
+// walks skip the directory instead.
+func (ig *ignorer) match(rel string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	if path.Base(rel) == ".git" {
+		return true
+	}
+	rel = ig.full(rel)
+	for i := len(ig.patterns) - 1; i >= 0; i-- {
+		p := ig.patterns[i]
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if matchGlob(p.glob, rel) {
+			return !p.negate
+		}
+	}
+	return false
+}
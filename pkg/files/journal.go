@@ -0,0 +1,477 @@
+package files
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// maxJournalChanges is the most changes the journal remembers, however little content they saved.
+const maxJournalChanges = 1000
+
+// change is an entry of the journal: one call of a file tool and what it did to each path.
+type change struct {
+	ID   int       `json:"id"`
+	Time time.Time `json:"time"`
+	// Session identifies the server process and client the change was made for.
+	Session string         `json:"session"`
+	Tool    string         `json:"tool"`
+	Paths   []*changedPath `json:"paths"`
+	// Move is set for move_file, which is undone by moving the entry back rather than from backups.
+	Move   *movedPath `json:"move,omitempty"`
+	Undone bool       `json:"undone,omitempty"`
+}
+
+// changedPath is the state of a path before and after a change.
+type changedPath struct {
+	Path   string    `json:"path"`
+	Before pathState `json:"before"`
+	After  pathState `json:"after"`
+	// Saved reports that the content of the file before the change is in the backup store.
+	Saved bool `json:"saved,omitempty"`
+	// Error tells why the content before the change could not be saved.
+	Error string `json:"error,omitempty"`
+}
+
+// movedPath is the source and destination of a move.
+type movedPath struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// pathState describes what was at a path.
+type pathState struct {
+	// Type is "file", "directory", "symlink", "other" or "" if nothing was at the path.
+	Type   string      `json:"type,omitempty"`
+	Mode   fs.FileMode `json:"mode,omitempty"`
+	Size   int64       `json:"size,omitempty"`
+	SHA256 string      `json:"sha256,omitempty"`
+	Target string      `json:"target,omitempty"`
+}
+
+// equal reports whether a and b describe the same content.
+func (a pathState) equal(b pathState) bool {
+	return a.Type == b.Type && a.Mode == b.Mode && a.SHA256 == b.SHA256 && a.Target == b.Target
+}
+
+// readState returns the state of the entry at path, which is not followed if it is a symbolic link.
+func readState(path string) (pathState, error) {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return pathState{}, nil
+	}
+	if err != nil {
+		return pathState{}, err
+	}
+
+	state := pathState{Mode: info.Mode().Perm()}
+	switch {
+	case info.Mode().IsRegular():
+		state.Type = "file"
+		state.Size = info.Size()
+		state.SHA256, err = fileSHA256(path)
+	case info.IsDir():
+		state.Type = "directory"
+	case info.Mode()&fs.ModeSymlink != 0:
+		state.Type = "symlink"
+		state.Target, err = os.Readlink(path)
+	default:
+		state.Type = "other"
+	}
+	return state, err
+}
+
+// runID tells apart the server processes that share a backup directory.
+var runID = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// sessionID returns the session changes made for the client of ctx are recorded under.
+func sessionID(ctx context.Context) string {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		return runID + "/" + session.SessionID()
+	}
+	return runID
+}
+
+// journalMu serializes the reads and writes of the journal within the server.
+var journalMu sync.Mutex
+
+// journalPath returns the file the journal is kept in.
+func journalPath() string {
+	return filepath.Join(config.BackupDir, "journal.json")
+}
+
+// backups returns the store of the content changes replaced.
+func backups() blobStore {
+	return blobStore{dir: filepath.Join(config.BackupDir, "blobs")}
+}
+
+// loadJournal returns the changes in the journal, oldest first. The caller must hold journalMu.
+func loadJournal() ([]*change, error) {
+	data, err := os.ReadFile(journalPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var changes []*change
+	if err := json.Unmarshal(data, &changes); err != nil {
+		return nil, fmt.Errorf("reading the journal %s: %w", journalPath(), err)
+	}
+	return changes, nil
+}
+
+// saveJournal replaces the journal with changes. The caller must hold journalMu.
+func saveJournal(changes []*change) error {
+	data, err := json.MarshalIndent(changes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.BackupDir, 0700); err != nil {
+		return err
+	}
+	return writeFileWith(journalPath(), data, writeOptions{Mode: 0600})
+}
+
+// updateJournal loads the journal, passes it to update and saves the result. Journals of other
+// servers sharing the directory are loaded each time, but a change they record at the same
+// moment can be lost.
+func updateJournal(update func([]*change) ([]*change, error)) error {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+
+	changes, err := loadJournal()
+	if err != nil {
+		return err
+	}
+	changes, err = update(changes)
+	if err != nil {
+		return err
+	}
+	return saveJournal(changes)
+}
+
+// readJournal returns the changes in the journal, oldest first.
+func readJournal() ([]*change, error) {
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	return loadJournal()
+}
+
+// pendingChange is a change about to be made, with the state of its paths saved before it.
+// Its methods do nothing on a nil pendingChange, which is what beginChange returns when the
+// journal is disabled.
+type pendingChange struct {
+	change
+}
+
+// beginChange saves the state of paths before tool changes them. Symbolic links are followed, as
+// the files they point to are what write operations change. Contents that cannot be saved are
+// noted in the change, which does not stop it from being made.
+func beginChange(ctx context.Context, tool string, paths ...string) *pendingChange {
+	if config.BackupDir == "" {
+		return nil
+	}
+	p := &pendingChange{change{Session: sessionID(ctx), Tool: tool}}
+	for _, path := range paths {
+		p.save(canonicalPath(path))
+	}
+	return p
+}
+
+// beginEntryChange is like beginChange, but does not follow symbolic links, for operations that
+// replace or remove the entries themselves.
+func beginEntryChange(ctx context.Context, tool string, paths ...string) *pendingChange {
+	if config.BackupDir == "" {
+		return nil
+	}
+	p := &pendingChange{change{Session: sessionID(ctx), Tool: tool}}
+	for _, path := range paths {
+		p.save(canonicalEntryPath(path))
+	}
+	return p
+}
+
+// canonicalPath returns path with symbolic links resolved, as the journal records it, or path
+// itself if it does not exist.
+func canonicalPath(path string) string {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		return target
+	}
+	return path
+}
+
+// canonicalEntryPath is like canonicalPath, but keeps a symbolic link in the final element of path.
+func canonicalEntryPath(path string) string {
+	return filepath.Join(canonicalPath(filepath.Dir(path)), filepath.Base(path))
+}
+
+// beginMove saves the state of the destination of a move, which the move may replace. The
+// source is restored by moving it back.
+func beginMove(ctx context.Context, from string, to string) *pendingChange {
+	if config.BackupDir == "" {
+		return nil
+	}
+	from, to = canonicalEntryPath(from), canonicalEntryPath(to)
+	p := &pendingChange{change{Session: sessionID(ctx), Tool: "move_file", Move: &movedPath{From: from, To: to}}}
+	if state, err := readState(from); err == nil {
+		p.Paths = append(p.Paths, &changedPath{Path: from, Before: state})
+	}
+	p.save(to)
+	return p
+}
+
+// save adds path to the change with its current state, saving the content of a file.
+func (p *pendingChange) save(path string) {
+	if slices.ContainsFunc(p.Paths, func(c *changedPath) bool { return c.Path == path }) {
+		return
+	}
+	changed := &changedPath{Path: path}
+	p.Paths = append(p.Paths, changed)
+
+	state, err := readState(path)
+	changed.Before = state
+	switch {
+	case err != nil:
+		changed.Error = err.Error()
+	case state.Type != "file":
+	case config.MaxBackupBytes > 0 && state.Size > config.MaxBackupBytes:
+		changed.Error = fmt.Sprintf("the file of %d bytes is larger than the backup limit of %d bytes", state.Size, config.MaxBackupBytes)
+	default:
+		data, err := os.ReadFile(path)
+		if err == nil {
+			_, err = backups().put(data)
+		}
+		if err != nil {
+			changed.Error = "saving a backup failed: " + err.Error()
+		} else {
+			changed.Saved = true
+		}
+	}
+}
+
+// commit records the change in the journal with the state its paths were left in. Paths the
+// change did not alter are left out, and nothing is recorded if it altered none. Failing to
+// record is not an error of the operation, which has already happened; it returns the ID of
+// the change, or 0 if none was recorded.
+func (p *pendingChange) commit() int {
+	if p == nil {
+		return 0
+	}
+	var changed []*changedPath
+	for _, path := range p.Paths {
+		after, err := readState(path.Path)
+		if err != nil || after.equal(path.Before) {
+			continue
+		}
+		path.After = after
+		changed = append(changed, path)
+	}
+	p.Paths = changed
+	if len(changed) == 0 {
+		return 0
+	}
+	p.Time = time.Now()
+
+	err := updateJournal(func(changes []*change) ([]*change, error) {
+		p.ID = 1
+		if len(changes) > 0 {
+			p.ID = changes[len(changes)-1].ID + 1
+		}
+		return pruneJournal(append(changes, &p.change)), nil
+	})
+	if err != nil {
+		return 0
+	}
+	return p.ID
+}
+
+// rollback returns the paths of an operation that failed part way to their state before it. The
+// partial change is recorded and marked as undone, so it stays in the journal if rolling back fails.
+func (p *pendingChange) rollback(ctx context.Context) error {
+	if p == nil {
+		return errJournalDisabled
+	}
+	if p.commit(); len(p.Paths) == 0 {
+		// Nothing was changed
+		return nil
+	}
+	if err := undoChange(ctx, &p.change, true); err != nil || p.ID == 0 {
+		return err
+	}
+	return updateJournal(func(changes []*change) ([]*change, error) {
+		for _, c := range changes {
+			c.Undone = c.Undone || c.ID == p.ID
+		}
+		return changes, nil
+	})
+}
+
+// pruneJournal drops the oldest changes until the rest fit in maxJournalChanges and the content
+// they saved fits in config.MaxBackupBytes, and removes content no longer needed from the store.
+func pruneJournal(changes []*change) []*change {
+	var total int64
+	keep := make(map[string]bool)
+	first := max(len(changes)-maxJournalChanges, 0)
+	for i := len(changes) - 1; i >= first; i-- {
+		var size int64
+		for _, path := range changes[i].Paths {
+			if path.Saved && !keep[path.Before.SHA256] {
+				size += path.Before.Size
+			}
+		}
+		// The newest change is kept even if it is over the limit on its own
+		if config.MaxBackupBytes > 0 && total+size > config.MaxBackupBytes && i < len(changes)-1 {
+			first = i + 1
+			break
+		}
+		total += size
+		for _, path := range changes[i].Paths {
+			if path.Saved {
+				keep[path.Before.SHA256] = true
+			}
+		}
+	}
+	if first == 0 {
+		return changes
+	}
+
+	changes = changes[first:]
+	keep = journalBlobs(changes)
+	maps.Copy(keep, checkpointBlobs())
+	backups().prune(keep)
+	return changes
+}
+
+// journalBlobs returns the hashes of the contents changes saved.
+func journalBlobs(changes []*change) map[string]bool {
+	blobs := make(map[string]bool)
+	for _, c := range changes {
+		for _, path := range c.Paths {
+			if path.Saved {
+				blobs[path.Before.SHA256] = true
+			}
+		}
+	}
+	return blobs
+}
+
+// findChange returns the change with the given ID.
+func findChange(changes []*change, id int) (*change, error) {
+	for _, c := range changes {
+		if c.ID == id {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("change %d is not in the journal, it may have been pruned: %w", id, fs.ErrNotExist)
+}
+
+// undoChange returns the paths of c to their state before it, after checking that they still
+// are as c left them. force skips the check, discarding what happened to them since. Nothing
+// is changed if a path fails the check or its content before c was not saved.
+func undoChange(ctx context.Context, c *change, force bool) error {
+	if c.Undone {
+		return fmt.Errorf("%w: change %d was already undone", utils.ErrConflict, c.ID)
+	}
+
+	for _, path := range c.Paths {
+		// The sandbox of the current session applies to paths recorded by any session
+		if _, err := resolveEntryPath(ctx, path.Path); err != nil {
+			return err
+		}
+		if !force {
+			current, err := readState(path.Path)
+			if err != nil {
+				return err
+			}
+			if !current.equal(path.After) {
+				return fmt.Errorf("%w: %s was changed after change %d; restore it with restore_file or undo with force to discard the later changes",
+					utils.ErrConflict, path.Path, c.ID)
+			}
+		}
+		if path.Before.Type == "file" && !path.Saved && !c.moves(path.Path) {
+			return fmt.Errorf("%s cannot be restored, its content before change %d was not saved: %s", path.Path, c.ID, path.Error)
+		}
+	}
+
+	if c.Move != nil {
+		if err := checkWritable(); err != nil {
+			return err
+		}
+		if err := os.Rename(c.Move.To, c.Move.From); err != nil {
+			return err
+		}
+	}
+	for _, path := range slices.Backward(c.Paths) {
+		if c.moves(path.Path) {
+			continue
+		}
+		if err := restoreState(path.Path, path.Before); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moves reports whether c moved the entry at path away, so it is restored by moving it back.
+func (c *change) moves(path string) bool {
+	return c.Move != nil && c.Move.From == path
+}
+
+// restoreState returns path to the given state, taking the content of a file from the backups.
+func restoreState(path string, state pathState) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	current, err := readState(path)
+	if err != nil {
+		return err
+	}
+
+	switch state.Type {
+	case "file":
+		data, err := backups().get(state.SHA256)
+		if err != nil {
+			return err
+		}
+		if current.Type == "symlink" {
+			// The link did not exist before the change, or it would have been followed
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		return writeFileWith(path, data, writeOptions{Mode: state.Mode})
+	case "directory":
+		if current.Type == "directory" {
+			return os.Chmod(path, state.Mode)
+		}
+		return os.MkdirAll(path, state.Mode)
+	case "symlink":
+		if current.Type != "" {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+		return os.Symlink(state.Target, path)
+	case "":
+		// A directory is only removed if it is empty
+		if current.Type != "" {
+			return os.Remove(path)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%s cannot be restored, it was not a file, directory or symbolic link: %w", path, errors.ErrUnsupported)
+	}
+}
@@ -0,0 +1,146 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetListChanges() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("list_changes",
+		mcp.WithDescription("Lists the recent changes made by the file tools, newest first, with the files each one changed. Changes can be reverted with undo_last_change, and files returned to their content before a change with restore_file"),
+		mcp.WithString("path",
+			mcp.Description("Only list the changes to this file or to files under this directory"),
+		),
+		mcp.WithBoolean("session_only",
+			mcp.Description("Only list the changes made in this session"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of changes to list. Defaults to 20"),
+			mcp.Min(1),
+		),
+	), listChangesHandler
+}
+
+// changeList is the structured content of a list_changes result.
+type changeList struct {
+	// Session is the session of the caller, to tell its changes from others.
+	Session string    `json:"session"`
+	Changes []*change `json:"changes"`
+	// More reports that older changes match too.
+	More bool `json:"more,omitempty"`
+}
+
+func listChangesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	limit := request.GetInt("limit", 20)
+	if limit < 1 {
+		return nil, errors.New("limit must be at least 1")
+	}
+	sessionOnly := request.GetBool("session_only", false)
+
+	if config.BackupDir == "" {
+		return utils.NewToolResultError(errJournalDisabled, ""), nil
+	}
+	path := request.GetString("path", "")
+	if path != "" {
+		var err error
+		if path, err = resolveEntryPath(ctx, path); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		path = canonicalPath(path)
+	}
+
+	changes, err := readJournal()
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	result := changeList{Session: sessionID(ctx), Changes: []*change{}}
+	for _, c := range slices.Backward(changes) {
+		if sessionOnly && c.Session != result.Session || path != "" && !c.touches(path) {
+			continue
+		}
+		if len(result.Changes) == limit {
+			result.More = true
+			break
+		}
+		result.Changes = append(result.Changes, c)
+	}
+
+	if len(result.Changes) == 0 {
+		return mcp.NewToolResultStructured(result, "No changes recorded"), nil
+	}
+	var b strings.Builder
+	for _, c := range result.Changes {
+		b.WriteString(c.describe(result.Session))
+	}
+	if result.More {
+		fmt.Fprintf(&b, "[Older changes are not shown; raise limit to see more than %d]\n", limit)
+	}
+	return mcp.NewToolResultStructured(result, b.String()), nil
+}
+
+// errJournalDisabled is the error of the journal tools when the server keeps no journal.
+var errJournalDisabled = fmt.Errorf("changes are not recorded, the server was started with an empty --backup-dir: %w", errors.ErrUnsupported)
+
+// touches reports whether c changed path or something under it.
+func (c *change) touches(path string) bool {
+	dir := strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+	return slices.ContainsFunc(c.Paths, func(changed *changedPath) bool {
+		return changed.Path == path || strings.HasPrefix(changed.Path, dir)
+	})
+}
+
+// describe summarizes c in a few lines, marking the changes made in session.
+func (c *change) describe(session string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "#%d %s %s", c.ID, c.Time.Format(time.DateTime), c.Tool)
+	if c.Session == session {
+		b.WriteString(" (this session)")
+	}
+	if c.Undone {
+		b.WriteString(" [undone]")
+	}
+	b.WriteString("\n")
+	if c.Move != nil {
+		fmt.Fprintf(&b, "  moved %s to %s\n", c.Move.From, c.Move.To)
+	}
+	for _, path := range c.Paths {
+		action := path.action()
+		if c.Move != nil {
+			// The move is already shown; only what it replaced is worth adding
+			if c.moves(path.Path) || path.Before.Type == "" {
+				continue
+			}
+			action = "replaced"
+		}
+		fmt.Fprintf(&b, "  %s %s", action, path.Path)
+		if path.Error != "" {
+			fmt.Fprintf(&b, " (not backed up: %s)", path.Error)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// action names what happened to the path.
+func (c *changedPath) action() string {
+	switch {
+	case c.Before.Type == "":
+		return "created"
+	case c.After.Type == "":
+		return "deleted"
+	case c.Before.Type != c.After.Type:
+		return "replaced"
+	default:
+		return "modified"
+	}
+}
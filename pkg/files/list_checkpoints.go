@@ -0,0 +1,64 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetListCheckpoints() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("list_checkpoints",
+		mcp.WithDescription("Lists the checkpoints saved by create_checkpoint, newest first"),
+		mcp.WithString("path",
+			mcp.Description("Only list the checkpoints of this directory"),
+		),
+	), listCheckpointsHandler
+}
+
+// checkpointList is the structured content of a list_checkpoints result.
+type checkpointList struct {
+	Checkpoints []*checkpoint `json:"checkpoints"`
+}
+
+func listCheckpointsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	if config.BackupDir == "" {
+		return utils.NewToolResultError(errCheckpointsDisabled, ""), nil
+	}
+	dirPath := request.GetString("path", "")
+	if dirPath != "" {
+		var err error
+		if dirPath, err = resolvePath(ctx, dirPath); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		dirPath = canonicalPath(dirPath)
+	}
+
+	checkpoints, err := loadCheckpoints(false)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	result := checkpointList{Checkpoints: []*checkpoint{}}
+	var b strings.Builder
+	for _, cp := range slices.Backward(checkpoints) {
+		if dirPath != "" && cp.Root != dirPath {
+			continue
+		}
+		result.Checkpoints = append(result.Checkpoints, cp)
+		fmt.Fprintf(&b, "#%d %s %s: %d files (%d bytes)", cp.ID, cp.Time.Format(time.DateTime), cp.Root, cp.Files, cp.Bytes)
+		if cp.Name != "" {
+			fmt.Fprintf(&b, " %q", cp.Name)
+		}
+		b.WriteString("\n")
+	}
+
+	if len(result.Checkpoints) == 0 {
+		return mcp.NewToolResultStructured(result, "No checkpoints saved"), nil
+	}
+	return mcp.NewToolResultStructured(result, b.String()), nil
+}
@@ -1,35 +1,352 @@
 package files
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
+	"github.com/samber/lo"
 )
 
+// listColumns are the details list_directory can return for each entry besides its name.
+var listColumns = []string{"type", "size", "human_size", "permissions", "mtime", "target"}
+
 func GetListDirectory() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("list_directory",
-		mcp.WithDescription("Retrieve a comprehensive listing of all files and subdirectories within a specified directory path"),
+		mcp.WithDescription("Retrieve a comprehensive listing of all files and subdirectories within a specified directory path. Large directories can be listed a page at a time"),
 		mcp.WithString("path",
 			mcp.Required(),
 			mcp.Description("The absolute or relative path of the directory to be listed"),
 		),
+		mcp.WithBoolean("respect_ignore",
+			mcp.Description("Leave out the entries ignored by .gitignore, .ignore and .jarvisignore files, and the .git directory. Defaults to true"),
+		),
+		mcp.WithBoolean("show_hidden",
+			mcp.Description("Include the entries whose name starts with a dot. Defaults to true"),
+		),
+		mcp.WithString("pattern",
+			mcp.Description("Only list the entries whose name matches this glob pattern, such as *.go"),
+		),
+		mcp.WithArray("columns",
+			mcp.Description("Details to return for each entry: type (file, directory, symlink, socket, pipe, device or char_device), size in bytes and human_size of files, permissions as in ls -l, mtime, and the target of symbolic links. Without columns, entries are listed as [DIR] name or [FILE] name"),
+			mcp.WithStringEnumItems(listColumns),
+		),
+		mcp.WithString("sort",
+			mcp.Description("Order of the entries: name (the default), size or mtime, smallest and oldest first"),
+			mcp.Enum("name", "size", "mtime"),
+		),
+		mcp.WithBoolean("reverse",
+			mcp.Description("Reverse the order, such as largest or newest first"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of entries to return; the result then includes a cursor for the next page if there are more"),
+			mcp.Min(1),
+		),
+		mcp.WithString("cursor",
+			mcp.Description("Cursor returned by a previous call, to list the entries after it with the same sort and reverse"),
+		),
+		mcp.WithString("format",
+			mcp.Description("text (the default) returns a line per entry, a table if columns are given; json returns the listing as JSON"),
+			mcp.Enum("text", "json"),
+		),
 	), listDirectoryHandler
 }
+
+// listOptions select the entries listDirectory returns and what it tells about them.
+type listOptions struct {
+	RespectIgnore bool
+	// SkipHidden leaves out the entries whose name starts with a dot.
+	SkipHidden bool
+	// Pattern is a glob the names of the entries must match, or "" for all.
+	Pattern string
+	// Sort is "name", "size" or "mtime".
+	Sort    string
+	Reverse bool
+	Columns []string
+	// Cursor is the key of the last entry of the previous page.
+	Cursor *listKey
+	// Limit is the number of entries of a page, zero for all.
+	Limit int
+}
+
+// dirEntry is an entry of a list_directory result, with the details that were asked for.
+type dirEntry struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type,omitempty"`
+	Size        *int64     `json:"size,omitempty"`
+	HumanSize   string     `json:"human_size,omitempty"`
+	Permissions string     `json:"permissions,omitempty"`
+	ModTime     *time.Time `json:"mtime,omitempty"`
+	Target      string     `json:"target,omitempty"`
+
+	isDir bool
+}
+
+// directoryListing is the structured content of a list_directory result.
+type directoryListing struct {
+	Path string `json:"path"`
+	// Total counts the entries that pass the filters, on all pages.
+	Total int `json:"total"`
+	// Offset is the position of the first entry of the page among them.
+	Offset  int        `json:"offset"`
+	Entries []dirEntry `json:"entries"`
+	// NextCursor continues the listing after this page, if there are more entries.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
 func listDirectoryHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	dirPath, ok := request.Params.Arguments["path"].(string)
+	dirPath, ok := request.GetArguments()["path"].(string)
 	if !ok {
 		return nil, errors.New("directory path is required")
 	}
 
-	entries, err := listDirectory(dirPath)
+	opts := listOptions{
+		RespectIgnore: request.GetBool("respect_ignore", true),
+		SkipHidden:    !request.GetBool("show_hidden", true),
+		Pattern:       request.GetString("pattern", ""),
+		Sort:          request.GetString("sort", "name"),
+		Reverse:       request.GetBool("reverse", false),
+		Columns:       request.GetStringSlice("columns", nil),
+		Limit:         request.GetInt("limit", 0),
+	}
+	format := request.GetString("format", "text")
+	if !slices.Contains([]string{"name", "size", "mtime"}, opts.Sort) {
+		return nil, fmt.Errorf("unknown sort order %q", opts.Sort)
+	}
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+	for _, column := range opts.Columns {
+		if !slices.Contains(listColumns, column) {
+			return nil, fmt.Errorf("unknown column %q, expected one of %s", column, strings.Join(listColumns, ", "))
+		}
+	}
+	if opts.Limit < 0 {
+		return nil, errors.New("limit must be at least 1")
+	}
+	if err := validGlob(opts.Pattern); err != nil || strings.Contains(opts.Pattern, "/") {
+		return nil, fmt.Errorf("invalid pattern %q: it must be a glob matching names", opts.Pattern)
+	}
+	if cursor := request.GetString("cursor", ""); cursor != "" {
+		var err error
+		if opts.Cursor, err = opts.parseCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	dirPath, err := resolvePath(ctx, dirPath)
 	if err != nil {
-		return nil, err
+		return utils.NewToolResultError(err, ""), nil
 	}
 
-	// Format the output for better readability
-	output := strings.Join(entries, "\n")
-	return mcp.NewToolResultText(output), nil
+	listing, err := listDirectory(dirPath, opts)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(listing, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling listing to JSON: %v", err)
+		}
+		return mcp.NewToolResultStructured(listing, string(jsonData)), nil
+	}
+	return mcp.NewToolResultStructured(listing, listing.text(opts.Columns)), nil
+}
+
+// listEntry is a directory entry being listed, with its details once they are needed.
+type listEntry struct {
+	fs.DirEntry
+	info fs.FileInfo
+}
+
+// stat reads the details of the entry if they were not read yet.
+func (e *listEntry) stat() error {
+	if e.info != nil {
+		return nil
+	}
+	info, err := e.Info()
+	if err != nil {
+		return err
+	}
+	e.info = info
+	return nil
+}
+
+// listKey is what the entries of a listing are sorted by, and where a cursor continues from.
+type listKey struct {
+	Name    string `json:"n"`
+	Size    int64  `json:"s,omitempty"`
+	ModTime int64  `json:"m,omitempty"`
+}
+
+// key returns the sort key of the entry. The size and time are only known once stat was called.
+func (e *listEntry) key() listKey {
+	key := listKey{Name: e.Name()}
+	if e.info != nil {
+		key.Size = e.info.Size()
+		key.ModTime = e.info.ModTime().UnixNano()
+	}
+	return key
+}
+
+// compare orders two entries as opts ask, by name among entries of the same size or time.
+func (opts listOptions) compare(a listKey, b listKey) int {
+	c := 0
+	switch opts.Sort {
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	case "mtime":
+		c = cmp.Compare(a.ModTime, b.ModTime)
+	}
+	c = cmp.Or(c, strings.Compare(a.Name, b.Name))
+	return utils.IfElse(opts.Reverse, -c, c)
+}
+
+// listCursor is the content of a list_directory cursor. The order is kept in it, as the key only
+// makes sense in the order it was taken from.
+type listCursor struct {
+	Sort    string `json:"o"`
+	Reverse bool   `json:"r,omitempty"`
+	listKey
+}
+
+// cursor returns the cursor of a page that ends with the entry of the given key.
+func (opts listOptions) cursor(key listKey) string {
+	data, _ := json.Marshal(listCursor{Sort: opts.Sort, Reverse: opts.Reverse, listKey: key})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// parseCursor returns the key a cursor continues from, checking it was returned for the order of opts.
+func (opts listOptions) parseCursor(cursor string) (*listKey, error) {
+	var c listCursor
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &c)
+	}
+	if err != nil {
+		return nil, errors.New("invalid cursor: pass the next_cursor of a previous call unchanged")
+	}
+	if c.Sort != opts.Sort || c.Reverse != opts.Reverse {
+		return nil, fmt.Errorf("the cursor continues a listing sorted by %s%s; pass the same sort and reverse", c.Sort, utils.IfElse(c.Reverse, " in reverse", ""))
+	}
+	return &c.listKey, nil
+}
+
+// details returns the entry with the given columns, in the directory at dir. stat must have been called.
+func (e *listEntry) details(dir string, columns []string) dirEntry {
+	entry := dirEntry{Name: e.Name(), isDir: e.IsDir()}
+	mode := e.info.Mode()
+	for _, column := range columns {
+		switch column {
+		case "type":
+			entry.Type = entryType(mode)
+		case "size":
+			if mode.IsRegular() {
+				entry.Size = lo.ToPtr(e.info.Size())
+			}
+		case "human_size":
+			if mode.IsRegular() {
+				entry.HumanSize = humanSize(e.info.Size())
+			}
+		case "permissions":
+			entry.Permissions = lsMode(mode)
+		case "mtime":
+			entry.ModTime = lo.ToPtr(e.info.ModTime().UTC())
+		case "target":
+			if mode&fs.ModeSymlink != 0 {
+				entry.Target, _ = os.Readlink(filepath.Join(dir, e.Name()))
+			}
+		}
+	}
+	return entry
+}
+
+// lsMode formats a file mode as ls -l does, such as drwxr-xr-x.
+func lsMode(mode fs.FileMode) string {
+	kind := "-"
+	switch {
+	case mode.IsDir():
+		kind = "d"
+	case mode&fs.ModeSymlink != 0:
+		kind = "l"
+	case mode&fs.ModeSocket != 0:
+		kind = "s"
+	case mode&fs.ModeNamedPipe != 0:
+		kind = "p"
+	case mode&fs.ModeCharDevice != 0:
+		kind = "c"
+	case mode&fs.ModeDevice != 0:
+		kind = "b"
+	}
+	return kind + mode.Perm().String()[1:]
+}
+
+// text formats the listing as lines of [DIR] name and [FILE] name, or as a table of the given
+// columns, followed by how to get the next page if there is one.
+func (l *directoryListing) text(columns []string) string {
+	var b strings.Builder
+	if len(columns) == 0 {
+		lines := make([]string, len(l.Entries))
+		for i, entry := range l.Entries {
+			lines[i] = fmt.Sprintf("%s %s", utils.IfElse(entry.isDir, "[DIR]", "[FILE]"), entry.Name)
+		}
+		b.WriteString(strings.Join(lines, "\n"))
+	} else {
+		var rows strings.Builder
+		table := tabwriter.NewWriter(&rows, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(table, "NAME\t%s\n", strings.ToUpper(strings.Join(columns, "\t")))
+		for _, entry := range l.Entries {
+			row := []string{entry.Name}
+			for _, column := range columns {
+				row = append(row, cmp.Or(entry.column(column), "-"))
+			}
+			fmt.Fprintln(table, strings.Join(row, "\t"))
+		}
+		table.Flush()
+		// The last line ends without a newline, like the lines format
+		b.WriteString(strings.TrimSuffix(rows.String(), "\n"))
+	}
+
+	if l.NextCursor != "" {
+		fmt.Fprintf(&b, "\n[Entries %d-%d of %d; pass cursor %q to list the next ones]", l.Offset+1, l.Offset+len(l.Entries), l.Total, l.NextCursor)
+	}
+	return b.String()
+}
+
+// column returns the value of a column of the entry as text, or "" if it has none.
+func (e dirEntry) column(column string) string {
+	switch column {
+	case "type":
+		return e.Type
+	case "size":
+		if e.Size != nil {
+			return strconv.FormatInt(*e.Size, 10)
+		}
+	case "human_size":
+		return e.HumanSize
+	case "permissions":
+		return e.Permissions
+	case "mtime":
+		if e.ModTime != nil {
+			return e.ModTime.Local().Format(time.DateTime)
+		}
+	case "target":
+		return e.Target
+	}
+	return ""
 }
@@ -0,0 +1,118 @@
+package files
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"jarvis_mcp/pkg/utils"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// sniffLen is the number of leading bytes http.DetectContentType looks at.
+const sniffLen = 512
+
+// imageTypes are the image formats returned as image content, the ones clients can show
+// to a model. Other images are returned as blobs like any other binary file.
+var imageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// detectContentType returns the media type of the file at path, sniffed from its first bytes,
+// and for text files the name of their encoding. The extension is only consulted for binary
+// content the sniffer does not recognise.
+func detectContentType(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", "", err
+	}
+	head = head[:n]
+
+	mediaType := http.DetectContentType(head)
+	switch {
+	case mediaType == "application/octet-stream" && utf16ByteOrder(head) != "":
+		// UTF-16 text without a byte order mark is full of zero bytes, which look binary
+		mediaType = "text/plain"
+	case mediaType == "application/octet-stream":
+		// Text types are not trusted: the content has already been found to be binary
+		if byExtension := mime.TypeByExtension(filepath.Ext(path)); byExtension != "" && !isText(byExtension) {
+			mediaType = byExtension
+		}
+	}
+	if !isText(mediaType) {
+		return mediaType, "", nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	encodingName, err := detectEncoding(head, file)
+	return mediaType, encodingName, err
+}
+
+// isText reports whether content of the given media type is returned as text.
+func isText(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "text/")
+}
+
+// readBinary returns a binary file as image content or as an embedded blob resource, with its
+// type and size as structured content. Whole files larger than the configured limit are refused,
+// but a byte range of them can still be read.
+func readBinary(path string, mediaType string, opts readOptions, ranged bool) (*mcp.CallToolResult, error) {
+	if ranged && !opts.Bytes || opts.LineNumbers {
+		return nil, fmt.Errorf("%s is a binary file (%s) and has no lines, read it with byte_offset and byte_limit: %w",
+			path, mediaType, errors.ErrUnsupported)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !ranged && config.MaxBinaryBytes > 0 && info.Size() > int64(config.MaxBinaryBytes) {
+		return nil, fmt.Errorf("%w: %s is a binary file (%s) of %d bytes, more than the %d bytes returned at once; read parts of it with byte_offset and byte_limit",
+			utils.ErrTooLarge, path, mediaType, info.Size(), config.MaxBinaryBytes)
+	}
+
+	opts.Bytes = true
+	opts.Binary = true
+	opts.MaxBytes = config.MaxBinaryBytes
+	window, err := readFileWindow(path, opts)
+	if err != nil {
+		return nil, err
+	}
+	window.MimeType = mediaType
+
+	data := base64.StdEncoding.EncodeToString([]byte(window.Content))
+	var content mcp.Content
+	if imageTypes[mediaType] && window.ByteOffset == 0 && !window.More {
+		content = mcp.NewImageContent(data, mediaType)
+	} else {
+		content = mcp.NewEmbeddedResource(mcp.BlobResourceContents{
+			URI:      (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(),
+			MIMEType: mediaType,
+			Blob:     data,
+		})
+	}
+
+	return &mcp.CallToolResult{
+		Content:           []mcp.Content{mcp.NewTextContent(window.summary(opts.MaxBytes)), content},
+		StructuredContent: window,
+	}, nil
+}
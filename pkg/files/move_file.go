@@ -3,6 +3,9 @@ package files
 import (
 	"context"
 	"errors"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/utils"
+	"os"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -23,20 +26,45 @@ func GetMoveFile() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 }
 
 func moveFileHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	sourcePath, ok := request.Params.Arguments["source"].(string)
+	sourcePath, ok := request.GetArguments()["source"].(string)
 	if !ok {
 		return nil, errors.New("source path is required")
 	}
 
-	destPath, ok := request.Params.Arguments["destination"].(string)
+	destPath, ok := request.GetArguments()["destination"].(string)
 	if !ok {
 		return nil, errors.New("destination path is required")
 	}
 
-	err := moveFile(sourcePath, destPath)
+	// Renaming acts on the entries themselves, not on the targets of symbolic links
+	sourcePath, err := resolveEntryPath(ctx, sourcePath)
 	if err != nil {
-		return nil, err
+		return utils.NewToolResultError(err, ""), nil
 	}
+	destPath, err = resolveEntryPath(ctx, destPath)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	// Refuse before the user is asked to confirm an operation that cannot happen
+	if err := checkWritable(); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	// Moving onto an existing path silently replaces it
+	if _, err := os.Lstat(destPath); err == nil {
+		message := "Move " + sourcePath + " to " + destPath + ", replacing the existing " + destPath + "?"
+		if err := confirm.Ask(ctx, message); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+	}
+
+	pending := beginMove(ctx, sourcePath, destPath)
+	err = moveFile(sourcePath, destPath)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	pending.commit()
 
 	return mcp.NewToolResultText("Successfully moved file from " + sourcePath + " to " + destPath), nil
 }
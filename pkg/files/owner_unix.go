@@ -0,0 +1,25 @@
+//go:build !windows
+
+package files
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// fileOwner returns the user and group that own the file described by info.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(stat.Uid), int(stat.Gid), true
+}
+
+// linkCount returns the number of hard links to the file described by info.
+func linkCount(info fs.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
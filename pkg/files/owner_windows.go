@@ -0,0 +1,16 @@
+//go:build windows
+
+package files
+
+import "io/fs"
+
+// fileOwner is not supported on Windows, where a replaced file gets the default owner and
+// access control list of its directory.
+func fileOwner(info fs.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// linkCount returns 1 on Windows, where FileInfo does not report hard links.
+func linkCount(info fs.FileInfo) uint64 {
+	return 1
+}
@@ -0,0 +1,361 @@
+package files
+
+import (
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxFuzz is the most context lines ignored at each end of a hunk that does not match as is.
+const maxFuzz = 2
+
+// filePatch is the part of a unified diff that changes one file.
+type filePatch struct {
+	// OldName and NewName are the file names with prefixes stripped, "" for /dev/null.
+	OldName string
+	NewName string
+	Hunks   []*hunk
+	// git is set for patches in the format of git diff, which may change files without hunks.
+	git bool
+	// named is set once file names were read from --- and +++ lines.
+	named bool
+}
+
+// hunk is a block of changes to one region of a file.
+type hunk struct {
+	OldStart, OldCount int
+	NewStart, NewCount int
+	Lines              []diffOp
+}
+
+// hunkHeader matches the first line of a hunk, such as "@@ -12,7 +12,8 @@".
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch splits a unified diff into the changes to each file. It understands the
+// headers of git diff for created, deleted and renamed files. strip is the number of leading
+// path components removed from the file names, like patch -p; a negative value removes the
+// a/ and b/ prefixes of git diffs and nothing from other file names.
+func parsePatch(text string, strip int) ([]*filePatch, error) {
+	text = convertLineEndings(text, "lf")
+	if !strings.HasSuffix(text, "\n") {
+		// A missing newline at the end is a cut off patch, not a change
+		text += "\n"
+	}
+	lines := splitLines(text)
+	var patches []*filePatch
+	var current *filePatch
+
+	for i := 0; i < len(lines); {
+		line := strings.TrimSuffix(lines[i], "\n")
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			current = &filePatch{git: true}
+			patches = append(patches, current)
+			names := strings.TrimPrefix(line, "diff --git ")
+			if sep := strings.Index(names, " b/"); strings.HasPrefix(names, "a/") && sep > 0 {
+				current.OldName, current.NewName = names[:sep], names[sep+1:]
+			}
+			i++
+
+		case current != nil && current.git && len(current.Hunks) == 0 && isGitHeader(line):
+			switch {
+			case strings.HasPrefix(line, "new file mode"):
+				current.OldName = ""
+			case strings.HasPrefix(line, "deleted file mode"):
+				current.NewName = ""
+			case strings.HasPrefix(line, "rename from "):
+				current.OldName = "a/" + unquoteName(strings.TrimPrefix(line, "rename from "))
+			case strings.HasPrefix(line, "rename to "):
+				current.NewName = "b/" + unquoteName(strings.TrimPrefix(line, "rename to "))
+			case strings.HasPrefix(line, "copy from "), strings.HasPrefix(line, "copy to "):
+				return nil, fmt.Errorf("line %d: copying files is not supported", i+1)
+			}
+			i++
+
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			if current == nil || !current.git || current.named {
+				current = &filePatch{}
+				patches = append(patches, current)
+			}
+			current.OldName = patchFileName(line[4:])
+			current.NewName = patchFileName(strings.TrimSuffix(lines[i+1], "\n")[4:])
+			current.named = true
+			i += 2
+
+		case strings.HasPrefix(line, "@@ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk without a file header", i+1)
+			}
+			h, next, err := parseHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			current.Hunks = append(current.Hunks, h)
+			i = next
+
+		case strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			return nil, fmt.Errorf("line %d: binary patches are not supported", i+1)
+
+		default:
+			// Commentary, index lines and the like
+			i++
+		}
+	}
+
+	if len(patches) == 0 {
+		return nil, errors.New("no file changes found in the patch")
+	}
+	for _, p := range patches {
+		if p.OldName == "" && p.NewName == "" {
+			return nil, errors.New("the patch has a file without a name")
+		}
+		prefixed := p.git || (p.OldName == "" || strings.HasPrefix(p.OldName, "a/")) && (p.NewName == "" || strings.HasPrefix(p.NewName, "b/"))
+		p.OldName = stripName(p.OldName, strip, prefixed)
+		p.NewName = stripName(p.NewName, strip, prefixed)
+	}
+
+	// Each file patch is applied to the file as it is on disk, so a file named twice would
+	// only get the changes of one of them
+	seen := make(map[string]bool)
+	for _, p := range patches {
+		for i, name := range []string{p.OldName, p.NewName} {
+			if name == "" || i == 1 && name == p.OldName {
+				continue
+			}
+			name = path.Clean(name)
+			if seen[name] {
+				return nil, fmt.Errorf("%s is changed more than once; put all its hunks under one file header", name)
+			}
+			seen[name] = true
+		}
+	}
+	return patches, nil
+}
+
+// isGitHeader reports whether line is one of the extended header lines of git diff.
+func isGitHeader(line string) bool {
+	for _, prefix := range []string{"old mode ", "new mode ", "new file mode ", "deleted file mode ", "index ",
+		"similarity index ", "dissimilarity index ", "rename from ", "rename to ", "copy from ", "copy to "} {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// patchFileName returns the file name of a --- or +++ line, without the timestamp some
+// tools add after a tab, or "" for /dev/null.
+func patchFileName(field string) string {
+	name, _, _ := strings.Cut(field, "\t")
+	name = unquoteName(strings.TrimSpace(name))
+	return utils.IfElse(name == "/dev/null", "", name)
+}
+
+// unquoteName decodes a file name that git quoted because of unusual characters.
+func unquoteName(name string) string {
+	if unquoted, err := strconv.Unquote(name); strings.HasPrefix(name, `"`) && err == nil {
+		return unquoted
+	}
+	return name
+}
+
+// stripName removes strip leading components from name. A negative strip removes the
+// git a/ or b/ prefix if the patch uses them.
+func stripName(name string, strip int, gitPrefix bool) string {
+	if name == "" {
+		return ""
+	}
+	if strip < 0 {
+		strip = utils.IfElse(gitPrefix && (strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/")), 1, 0)
+	}
+	for ; strip > 0; strip-- {
+		if _, rest, found := strings.Cut(name, "/"); found {
+			name = rest
+		}
+	}
+	return name
+}
+
+// parseHunk reads the hunk starting at lines[start] and returns it with the index of the line
+// after it. A hunk that ends before the line counts of its header are reached is accepted, as
+// the counts of hand-written patches are often wrong.
+func parseHunk(lines []string, start int) (*hunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[start])
+	if m == nil {
+		return nil, 0, fmt.Errorf("line %d: invalid hunk header %q", start+1, strings.TrimSuffix(lines[start], "\n"))
+	}
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	h := &hunk{}
+	h.OldStart, _ = strconv.Atoi(m[1])
+	h.OldCount = count(m[2])
+	h.NewStart, _ = strconv.Atoi(m[3])
+	h.NewCount = count(m[4])
+
+	oldSeen, newSeen := 0, 0
+	i := start + 1
+lines:
+	for i < len(lines) && (oldSeen < h.OldCount || newSeen < h.NewCount) && !isNextHeader(lines, i) {
+		if strings.HasPrefix(lines[i], `\`) {
+			trimLastNewline(h.Lines)
+			i++
+			continue
+		}
+		// Some tools strip the space of empty context lines
+		kind, line := byte(' '), "\n"
+		if lines[i] != "\n" {
+			kind, line = lines[i][0], lines[i][1:]
+		}
+		switch kind {
+		case ' ':
+			oldSeen++
+			newSeen++
+		case '-':
+			oldSeen++
+		case '+':
+			newSeen++
+		default:
+			break lines
+		}
+		h.Lines = append(h.Lines, diffOp{kind, line})
+		i++
+	}
+	// The marker for a missing newline follows the last line of the hunk
+	if i < len(lines) && strings.HasPrefix(lines[i], `\`) {
+		trimLastNewline(h.Lines)
+		i++
+	}
+	return h, i, nil
+}
+
+// isNextHeader reports whether lines[i] starts the next hunk or file of a patch.
+func isNextHeader(lines []string, i int) bool {
+	line := lines[i]
+	return strings.HasPrefix(line, "@@ ") || strings.HasPrefix(line, "diff ") ||
+		strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ ")
+}
+
+// trimLastNewline applies a "\ No newline at end of file" marker to the line before it.
+func trimLastNewline(ops []diffOp) {
+	if len(ops) > 0 {
+		ops[len(ops)-1].line = strings.TrimSuffix(ops[len(ops)-1].line, "\n")
+	}
+}
+
+// hunkResult reports how a hunk was applied.
+type hunkResult struct {
+	Hunk    int  `json:"hunk"`
+	Applied bool `json:"applied"`
+	// Line is where the hunk was applied in the original file, counting from 1.
+	Line int `json:"line,omitempty"`
+	// Offset is how many lines from the position in the hunk header it was applied at.
+	Offset int `json:"offset,omitempty"`
+	// Fuzz is the number of context lines at each end that had to be ignored.
+	Fuzz  int    `json:"fuzz,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// applyHunks applies hunks to text in order and returns the result with the outcome of each.
+// A hunk is searched for nearest to where its header places it, adjusted by the offset of the
+// hunk before, and if it does not match anywhere, up to maxFuzz context lines at its ends are
+// ignored. Hunks that do not match are left out.
+func applyHunks(text string, hunks []*hunk) (string, []hunkResult) {
+	lines := splitLines(text)
+	var out []string
+	results := make([]hunkResult, 0, len(hunks))
+	cursor, delta := 0, 0
+
+	for i, h := range hunks {
+		result := hunkResult{Hunk: i + 1}
+		var oldLines, newLines []string
+		for _, op := range h.Lines {
+			if op.kind != '+' {
+				oldLines = append(oldLines, op.line)
+			}
+			if op.kind != '-' {
+				newLines = append(newLines, op.line)
+			}
+		}
+
+		// A hunk that removes nothing and has no context inserts after line OldStart
+		base := utils.IfElse(len(oldLines) == 0, h.OldStart, h.OldStart-1)
+		pos, fuzz, lead, trail, ok := locateHunk(lines, cursor, base+delta, h.Lines, oldLines)
+		if !ok {
+			result.Error = fmt.Sprintf("does not match the file near line %d", max(base+delta, 0)+1)
+			results = append(results, result)
+			continue
+		}
+
+		out = append(out, lines[cursor:pos]...)
+		out = append(out, newLines[lead:len(newLines)-trail]...)
+		cursor = pos + len(oldLines) - lead - trail
+
+		result.Applied = true
+		result.Line = pos - lead + 1
+		result.Offset = pos - lead - base
+		result.Fuzz = fuzz
+		delta = result.Offset
+		results = append(results, result)
+	}
+	out = append(out, lines[cursor:]...)
+	return strings.Join(out, ""), results
+}
+
+// locateHunk finds where the old lines of a hunk occur in lines at or after cursor, nearest to
+// guess. It returns the position of the match and the number of leading and trailing context
+// lines that were ignored to find it.
+func locateHunk(lines []string, cursor int, guess int, ops []diffOp, old []string) (pos, fuzz, lead, trail int, ok bool) {
+	if len(old) == 0 {
+		return min(max(guess, cursor), len(lines)), 0, 0, 0, true
+	}
+
+	leading, trailing := 0, 0
+	for leading < len(ops) && ops[leading].kind == ' ' {
+		leading++
+	}
+	for trailing < len(ops) && ops[len(ops)-1-trailing].kind == ' ' {
+		trailing++
+	}
+
+	for fuzz = 0; fuzz <= maxFuzz; fuzz++ {
+		lead, trail = min(fuzz, leading), min(fuzz, trailing)
+		if fuzz > 0 && lead+trail == 0 || lead+trail >= len(old) {
+			break
+		}
+		want := old[lead : len(old)-trail]
+		last := len(lines) - len(want)
+		// Search outwards from the expected position
+		start := min(max(guess+lead, cursor), max(last, cursor))
+		for distance := 0; ; distance++ {
+			before, after := start-distance, start+distance
+			if before < cursor && after > last {
+				break
+			}
+			for _, p := range []int{before, after} {
+				if p >= cursor && p <= last && linesEqual(lines[p:p+len(want)], want) {
+					return p, fuzz, lead, trail, true
+				}
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// linesEqual reports whether a and b hold the same lines.
+func linesEqual(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,9 @@ package files
 import (
 	"context"
 	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"strings"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -11,25 +14,157 @@ import (
 func GetReadFile() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 
 	return mcp.NewTool("read_file",
-		mcp.WithDescription("Reads the contents of a file specified by its path"),
+		mcp.WithDescription("Reads the contents of a file specified by its path. Large files are returned in windows of lines or bytes; the result reports the total line count and where to continue. Text in UTF-16, Latin-1 and other encodings is decoded to UTF-8 and the encoding reported. Images are returned as image content and other binary files as base64 blobs"),
 		mcp.WithString("path",
 			mcp.Required(),
 			mcp.Description("The full path to the file that should be read"),
 		),
+		mcp.WithNumber("offset",
+			mcp.Description("Line number to start reading at, counting from 1"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description("Maximum number of lines to read"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("byte_offset",
+			mcp.Description("Byte offset to start reading at, counting from 0; cannot be combined with offset or limit"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("byte_limit",
+			mcp.Description("Maximum number of bytes to read; cannot be combined with offset or limit"),
+			mcp.Min(1),
+		),
+		mcp.WithBoolean("line_numbers",
+			mcp.Description("Prefix each line with its line number"),
+		),
 	), readFileHandler
 }
 
+// readFileHandler returns the requested window of a file as text followed by a note on its
+// position in the file, and the position as structured content. Reads are capped at the
+// configured maximum response size, so even a plain read of a huge file returns only its beginning.
+// Text in other encodings than UTF-8 is decoded, and binary files are handed to readBinary.
 func readFileHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
-	fileName, ok := request.Params.Arguments["path"].(string)
+	fileName, ok := request.GetArguments()["path"].(string)
 	if !ok {
 		return nil, errors.New("file path is required")
 	}
 
-	content, err := readFile(fileName)
+	opts, ranged, err := readOptionsFromRequest(request)
 	if err != nil {
 		return nil, err
 	}
 
-	return mcp.NewToolResultText("File read successfully. Content: " + content), nil
+	fileName, err = resolvePath(ctx, fileName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	mediaType, encodingName, err := detectContentType(fileName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	if !isText(mediaType) {
+		result, err := readBinary(fileName, mediaType, opts, ranged)
+		if err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		return result, nil
+	}
+
+	opts.Encoding = encodingName
+	window, err := readFileWindow(fileName, opts)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	return mcp.NewToolResultStructured(window, window.text(ranged, opts.MaxBytes)), nil
+}
+
+// text returns the content of the window followed by notes on its position in the file, if
+// it is not the whole file, and on the encoding the content was decoded from.
+func (w *fileWindow) text(ranged bool, maxBytes int) string {
+	var notes []string
+	if ranged || w.More || w.Truncated {
+		notes = append(notes, w.summary(maxBytes))
+	}
+	if w.Encoding != "utf-8" {
+		// Writing the content back unchanged needs the encoding, which plain text cannot show
+		notes = append(notes, fmt.Sprintf("[Decoded from %s; pass encoding=%s to write_file to keep it]", w.Encoding, w.Encoding))
+	}
+
+	text := w.Content
+	if len(notes) > 0 {
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		text += "\n" + strings.Join(notes, "\n")
+	}
+	return text
+}
+
+// readOptionsFromRequest validates the range arguments of a read_file call. It also reports
+// whether a range was requested at all.
+func readOptionsFromRequest(request mcp.CallToolRequest) (readOptions, bool, error) {
+	args := request.GetArguments()
+	_, hasOffset := args["offset"]
+	_, hasLimit := args["limit"]
+	_, hasByteOffset := args["byte_offset"]
+	_, hasByteLimit := args["byte_limit"]
+
+	opts := readOptions{
+		Offset:      request.GetInt("offset", 1),
+		Limit:       request.GetInt("limit", 0),
+		Bytes:       hasByteOffset || hasByteLimit,
+		ByteOffset:  int64(request.GetInt("byte_offset", 0)),
+		ByteLimit:   int64(request.GetInt("byte_limit", 0)),
+		LineNumbers: request.GetBool("line_numbers", false),
+		MaxBytes:    config.MaxReadBytes,
+	}
+
+	switch {
+	case opts.Bytes && (hasOffset || hasLimit):
+		return opts, false, errors.New("use either offset and limit or byte_offset and byte_limit")
+	case opts.Bytes && opts.LineNumbers:
+		return opts, false, errors.New("line_numbers cannot be combined with byte ranges")
+	case opts.Offset < 1 || (hasLimit && opts.Limit < 1):
+		return opts, false, errors.New("offset and limit must be positive")
+	case opts.ByteOffset < 0 || (hasByteLimit && opts.ByteLimit < 1):
+		return opts, false, errors.New("byte_offset must not be negative and byte_limit must be positive")
+	}
+	return opts, hasOffset || hasLimit || opts.Bytes, nil
+}
+
+// summary describes the position of the window in the file and how to continue reading.
+func (w *fileWindow) summary(maxBytes int) string {
+	var b strings.Builder
+	switch {
+	case w.StartLine > 0:
+		fmt.Fprintf(&b, "[Lines %d-%d of %d", w.StartLine, w.EndLine, w.TotalLines)
+	case w.MimeType != "" && w.ByteCount == w.TotalBytes:
+		fmt.Fprintf(&b, "[Binary file (%s) of %d bytes", w.MimeType, w.TotalBytes)
+	case w.MimeType != "" && w.ByteCount > 0:
+		fmt.Fprintf(&b, "[Bytes %d-%d of %d of a binary file (%s)", w.ByteOffset, w.ByteOffset+w.ByteCount-1, w.TotalBytes, w.MimeType)
+	case w.MimeType != "":
+		fmt.Fprintf(&b, "[Nothing to read: the binary file (%s) has %d bytes", w.MimeType, w.TotalBytes)
+	case w.ByteCount > 0:
+		fmt.Fprintf(&b, "[Bytes %d-%d of %d, %d lines in total", w.ByteOffset, w.ByteOffset+w.ByteCount-1, w.TotalBytes, w.TotalLines)
+	default:
+		fmt.Fprintf(&b, "[Nothing to read: the file has %d lines and %d bytes", w.TotalLines, w.TotalBytes)
+	}
+
+	if w.Truncated {
+		fmt.Fprintf(&b, "; response limited to %d bytes", maxBytes)
+	}
+	switch {
+	case w.NextOffset > 0:
+		fmt.Fprintf(&b, "; more available, continue with offset=%d", w.NextOffset)
+	case w.More && w.StartLine > 0:
+		fmt.Fprintf(&b, "; line %d continues, read the rest with byte_offset=%d", w.EndLine, w.NextByteOffset)
+	case w.More:
+		fmt.Fprintf(&b, "; more available, continue with byte_offset=%d", w.NextByteOffset)
+	}
+	b.WriteString("]")
+	return b.String()
 }
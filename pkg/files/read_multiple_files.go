@@ -0,0 +1,223 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+const (
+	// maxBatchFiles is the most files read by one read_multiple_files call, after globs are expanded.
+	maxBatchFiles = 100
+	// batchWorkers is the number of files read at the same time.
+	batchWorkers = 8
+)
+
+func GetReadMultipleFiles() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("read_multiple_files",
+		mcp.WithDescription("Reads several files in one call. Each file's content, or the error that prevented reading it, is returned under a header with its path; a failure does not stop the other files from being read. The content of all files together is limited, so later files may be cut short or left out"),
+		mcp.WithArray("paths",
+			mcp.Required(),
+			mcp.Description("Paths of the files to read. Paths containing *, ? or [ are glob patterns, such as src/*.go"),
+			mcp.WithStringItems(),
+			mcp.MinItems(1),
+		),
+	), readMultipleFilesHandler
+}
+
+// batchFile is the outcome of reading one file of a read_multiple_files call.
+type batchFile struct {
+	Path string `json:"path"`
+	*fileWindow
+	ErrorCode utils.ErrorCode `json:"error_code,omitempty"`
+	Error     string          `json:"error,omitempty"`
+	// Skipped reports that the file was not read because the output limit was already reached.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// batchResult is the structured content of a read_multiple_files result.
+type batchResult struct {
+	Files []batchFile `json:"files"`
+	// Omitted counts the files globs matched beyond maxBatchFiles, which were not read.
+	Omitted int `json:"omitted,omitempty"`
+}
+
+func readMultipleFilesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	paths, err := request.RequireStringSlice("paths")
+	if err != nil || len(paths) == 0 {
+		return nil, errors.New("paths must be a non-empty list of file paths")
+	}
+
+	result := readFiles(ctx, paths, config.MaxReadBytes)
+
+	var b strings.Builder
+	for i, file := range result.Files {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "==> %s <==\n", file.Path)
+		switch {
+		case file.Skipped:
+			fmt.Fprintf(&b, "[Not read: the output limit of %d bytes was reached; read it with read_file]\n", config.MaxReadBytes)
+		case file.Error != "":
+			fmt.Fprintf(&b, "Error [%s]: %s\n", file.ErrorCode, file.Error)
+		default:
+			b.WriteString(file.text(false, config.MaxReadBytes))
+			if !strings.HasSuffix(b.String(), "\n") {
+				b.WriteString("\n")
+			}
+		}
+	}
+	if result.Omitted > 0 {
+		fmt.Fprintf(&b, "\n[%d more files matched but were not read; at most %d files are read per call]\n", result.Omitted, maxBatchFiles)
+	}
+
+	return mcp.NewToolResultStructured(result, b.String()), nil
+}
+
+// readFiles reads the files at the given paths, which may be glob patterns, and returns the
+// content of each or the error that prevented reading it, in the order given. The files are
+// read concurrently. Their content together is limited to maxBytes: the first file that does
+// not fit is cut short and the ones after it are skipped.
+func readFiles(ctx context.Context, paths []string, maxBytes int) batchResult {
+	var result batchResult
+	seen := make(map[string]bool)
+	for _, path := range paths {
+		matches, err := expandPattern(ctx, path)
+		if err != nil {
+			result.Files = append(result.Files, failedFile(path, err))
+			continue
+		}
+		for _, match := range matches {
+			resolved, err := resolvePath(ctx, match)
+			switch {
+			case err != nil:
+				result.Files = append(result.Files, failedFile(match, err))
+			case seen[resolved]:
+			case len(result.Files) == maxBatchFiles:
+				result.Omitted++
+			default:
+				seen[resolved] = true
+				result.Files = append(result.Files, batchFile{Path: resolved})
+			}
+		}
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(batchWorkers, len(result.Files)) {
+		wg.Go(func() {
+			for i := range jobs {
+				file := &result.Files[i]
+				if err := ctx.Err(); err != nil {
+					*file = failedFile(file.Path, err)
+					continue
+				}
+				window, err := readTextFile(file.Path, maxBytes)
+				if err != nil {
+					*file = failedFile(file.Path, err)
+					continue
+				}
+				file.fileWindow = window
+			}
+		})
+	}
+	for i := range result.Files {
+		if result.Files[i].Error == "" {
+			jobs <- i
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Apply the limit in the order of the files, so it does not depend on which finished first
+	remaining := maxBytes
+	for i := range result.Files {
+		file := &result.Files[i]
+		if file.fileWindow == nil || maxBytes <= 0 {
+			continue
+		}
+		switch {
+		case remaining == 0:
+			*file = batchFile{Path: file.Path, Skipped: true}
+		case len(file.Content) > remaining:
+			window, err := readTextFile(file.Path, remaining)
+			if err != nil {
+				*file = failedFile(file.Path, err)
+				continue
+			}
+			file.fileWindow = window
+			remaining = 0
+		default:
+			remaining -= len(file.Content)
+		}
+	}
+	return result
+}
+
+// expandPattern returns the regular files matching path if it is a glob pattern, and path itself otherwise.
+// The directory a pattern starts from must be inside the allowed roots, so that matching does
+// not reveal which files exist outside them.
+func expandPattern(ctx context.Context, path string) ([]string, error) {
+	if !strings.ContainsAny(path, "*?[") {
+		return []string{path}, nil
+	}
+	pattern, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+	pattern = filepath.Clean(pattern)
+	if _, err := resolvePath(ctx, globBase(pattern)); err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+			files = append(files, match)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s: %w", path, fs.ErrNotExist)
+	}
+	return files, nil
+}
+
+// globBase returns the leading directories of pattern that contain no glob characters.
+func globBase(pattern string) string {
+	for strings.ContainsAny(pattern, "*?[") {
+		pattern = filepath.Dir(pattern)
+	}
+	return pattern
+}
+
+// readTextFile reads the beginning of the text file at path, up to maxBytes. Binary files are
+// refused, as their content cannot be returned among text.
+func readTextFile(path string, maxBytes int) (*fileWindow, error) {
+	mediaType, encodingName, err := detectContentType(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isText(mediaType) {
+		return nil, fmt.Errorf("%s is a binary file (%s), read it with read_file: %w", path, mediaType, errors.ErrUnsupported)
+	}
+	return readFileWindow(path, readOptions{MaxBytes: maxBytes, Encoding: encodingName})
+}
+
+// failedFile returns the outcome of a file that could not be read because of err.
+func failedFile(path string, err error) batchFile {
+	return batchFile{Path: path, ErrorCode: utils.ClassifyError(err), Error: err.Error()}
+}
@@ -0,0 +1,141 @@
+package files
+
+import (
+	"context"
+	"fmt"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetRestoreCheckpoint() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("restore_checkpoint",
+		mcp.WithDescription("Returns a directory to a checkpoint: modified and deleted files get their saved content back and files created since are removed. Ignored files are left alone. If restoring fails part way, the files already restored are changed back; a completed restore can be reverted with undo_last_change"),
+		mcp.WithNumber("checkpoint_id",
+			mcp.Description("ID of the checkpoint, as listed by list_checkpoints. Defaults to the newest checkpoint of path"),
+			mcp.Min(1),
+		),
+		mcp.WithString("path",
+			mcp.Description("Directory to restore to its newest checkpoint, if checkpoint_id is not given"),
+		),
+		mcp.WithBoolean("keep_created",
+			mcp.Description("Keep the files created since the checkpoint instead of removing them"),
+		),
+	), restoreCheckpointHandler
+}
+
+// checkpointRestoreResult is the structured content of a restore_checkpoint result.
+type checkpointRestoreResult struct {
+	Checkpoint *checkpoint `json:"checkpoint"`
+	checkpointDiff
+	// ChangeID is the journal entry that undoes the restore.
+	ChangeID int `json:"change_id,omitempty"`
+}
+
+func restoreCheckpointHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	keepCreated := request.GetBool("keep_created", false)
+	if config.BackupDir == "" {
+		return utils.NewToolResultError(errCheckpointsDisabled, ""), nil
+	}
+	// Refuse before the user is asked to confirm an operation that cannot happen
+	if err := checkWritable(); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	cp, err := checkpointArgs(ctx, request)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	diff, current, err := diffCheckpoint(ctx, cp)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	if keepCreated {
+		diff.Created = []string{}
+	}
+	saved := make(map[string]pathState, len(cp.Entries))
+	for _, entry := range cp.Entries {
+		saved[entry.Path] = entry.pathState
+	}
+	cp.Entries = nil
+	result := checkpointRestoreResult{Checkpoint: cp, checkpointDiff: diff}
+	if diff.empty() {
+		return mcp.NewToolResultStructured(result, "Nothing changed since "+cp.describe()), nil
+	}
+
+	// Check that every content is still there before anything is changed
+	for _, rel := range append(slices.Clone(diff.Modified), diff.Deleted...) {
+		if state := saved[rel]; state.Type == "file" {
+			if _, err := os.Stat(backups().path(state.SHA256)); err != nil {
+				return utils.NewToolResultError(fmt.Errorf("the saved content of %s is missing from the backups: %w", rel, err), ""), nil
+			}
+		}
+	}
+
+	summary := diff.summary()
+	if err := confirm.Ask(ctx, "Restore "+cp.describe()+"?\n\n"+summary); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	var paths []string
+	for _, rel := range append(append(slices.Clone(diff.Modified), diff.Created...), diff.Deleted...) {
+		paths = append(paths, filepath.Join(cp.Root, filepath.FromSlash(rel)))
+	}
+	pending := beginEntryChange(ctx, "restore_checkpoint", paths...)
+	if err := applyCheckpoint(cp.Root, diff, saved, current); err != nil {
+		if rollbackErr := pending.rollback(ctx); rollbackErr != nil {
+			err = fmt.Errorf("%w; changing the files back failed too, see list_changes: %v", err, rollbackErr)
+		} else {
+			err = fmt.Errorf("%w; the files restored so far were changed back", err)
+		}
+		return utils.NewToolResultError(err, summary), nil
+	}
+	result.ChangeID = pending.commit()
+
+	message := fmt.Sprintf("Restored %s:\n%s", cp.describe(), summary)
+	if result.ChangeID != 0 {
+		message += fmt.Sprintf("Undo this with undo_last_change (change %d)\n", result.ChangeID)
+	}
+	return mcp.NewToolResultStructured(result, message), nil
+}
+
+// applyCheckpoint makes the entries under root match the saved states of a checkpoint, given
+// the differences and current states found by diffCheckpoint. Created entries are removed
+// first, children before their directories, and saved entries are then restored, directories
+// before their children. Directories that still hold ignored files are kept.
+func applyCheckpoint(root string, diff checkpointDiff, saved map[string]pathState, current map[string]pathState) error {
+	var remove []string
+	remove = append(remove, diff.Created...)
+	for _, rel := range diff.Modified {
+		if saved[rel].Type != current[rel].Type {
+			remove = append(remove, rel)
+		}
+	}
+	slices.SortFunc(remove, func(a, b string) int { return strings.Compare(b, a) })
+	for _, rel := range remove {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if current[rel].Type == "directory" {
+			if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 {
+				continue
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+
+	restore := append(slices.Clone(diff.Modified), diff.Deleted...)
+	slices.Sort(restore)
+	for _, rel := range restore {
+		if err := restoreState(filepath.Join(root, filepath.FromSlash(rel)), saved[rel]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
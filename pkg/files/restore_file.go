@@ -0,0 +1,121 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/utils"
+	"slices"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetRestoreFile() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("restore_file",
+		mcp.WithDescription("Returns a file to its content before a change recorded by the file tools, whatever happened to it since. The restore is itself a change that can be undone"),
+		mcp.WithString("path",
+			mcp.Required(),
+			mcp.Description("Path of the file to restore"),
+		),
+		mcp.WithNumber("change_id",
+			mcp.Description("ID of the change, as listed by list_changes, to restore the file to its state before. Defaults to the last change that saved a backup of the file"),
+			mcp.Min(1),
+		),
+	), restoreFileHandler
+}
+
+func restoreFileHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	fileName, ok := request.GetArguments()["path"].(string)
+	if !ok {
+		return nil, errors.New("file path is required")
+	}
+	changeID := request.GetInt("change_id", 0)
+
+	if config.BackupDir == "" {
+		return utils.NewToolResultError(errJournalDisabled, ""), nil
+	}
+	fileName, err := resolvePath(ctx, fileName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	// Refuse before the user is asked to confirm an operation that cannot happen
+	if err := checkWritable(); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	changes, err := readJournal()
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	fileName = canonicalPath(fileName)
+	c, path, err := findBackup(changes, fileName, changeID)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if err := confirmRestore(ctx, fileName, path.Before, c.ID); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	pending := beginChange(ctx, "restore_file", fileName)
+	if err := restoreState(fileName, path.Before); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	id := pending.commit()
+
+	message := fmt.Sprintf("Restored %s to its state before change %d", fileName, c.ID)
+	if path.Before.Type == "" {
+		message = fmt.Sprintf("Removed %s, which did not exist before change %d", fileName, c.ID)
+	}
+	if id != 0 {
+		message += fmt.Sprintf("; undo this as change %d", id)
+	}
+	return mcp.NewToolResultText(message), nil
+}
+
+// findBackup returns the change with the given ID and the state of path before it, or with no ID
+// the last change that saved the content path had.
+func findBackup(changes []*change, path string, id int) (*change, *changedPath, error) {
+	isPath := func(changed *changedPath) bool { return changed.Path == path }
+	if id != 0 {
+		c, err := findChange(changes, id)
+		if err != nil {
+			return nil, nil, err
+		}
+		i := slices.IndexFunc(c.Paths, isPath)
+		switch {
+		case i < 0:
+			return nil, nil, fmt.Errorf("change %d did not change %s: %w", id, path, fs.ErrNotExist)
+		case c.Paths[i].Before.Type == "file" && !c.Paths[i].Saved:
+			return nil, nil, fmt.Errorf("the content of %s before change %d was not saved: %s", path, id, c.Paths[i].Error)
+		case c.moves(path):
+			return nil, nil, fmt.Errorf("change %d moved %s away, undo it with undo_last_change instead: %w", id, path, errors.ErrUnsupported)
+		}
+		return c, c.Paths[i], nil
+	}
+
+	for _, c := range slices.Backward(changes) {
+		if i := slices.IndexFunc(c.Paths, isPath); i >= 0 && c.Paths[i].Saved {
+			return c, c.Paths[i], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("no backup of %s was saved: %w", path, fs.ErrNotExist)
+}
+
+// confirmRestore asks the user before path is returned to state, showing the changes as a diff
+// if both contents are text.
+func confirmRestore(ctx context.Context, path string, state pathState, id int) error {
+	if state.Type != "file" {
+		return confirm.Ask(ctx, fmt.Sprintf("Restore %s to its state before change %d, when it was %s?", path, id, utils.IfElse(state.Type == "", "absent", "a "+state.Type)))
+	}
+	data, err := backups().get(state.SHA256)
+	if err != nil {
+		return err
+	}
+	if text, ok := textContent(data); ok {
+		return confirmOverwrite(ctx, path, text)
+	}
+	return confirm.Ask(ctx, fmt.Sprintf("Restore %s to its content before change %d?", path, id))
+}
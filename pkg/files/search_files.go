@@ -3,47 +3,381 @@ package files
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+// defaultSearchLimit is the number of matches search_files returns when no limit is given.
+const defaultSearchLimit = 1000
+
 func GetSearchFiles() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("search_files",
-		mcp.WithDescription("Perform a recursive search to locate files and directories that match a specified pattern"),
+		mcp.WithDescription("Perform a recursive search to locate files and directories that match a specified pattern. Matches are returned with their path relative to the search root, type and size"),
 		mcp.WithString("path",
 			mcp.Required(),
 			mcp.Description("The root directory path where the search will begin"),
 		),
 		mcp.WithString("pattern",
 			mcp.Required(),
-			mcp.Description("The pattern used to identify matching file and directory names"),
+			mcp.Description("The pattern used to identify matching file and directory names. A glob pattern containing a slash, such as src/**/*_test.go, is matched against the path relative to the root"),
+		),
+		mcp.WithString("mode",
+			mcp.Description("How the pattern is interpreted: substring (the default) finds names containing it, glob matches names with *, ?, [...] and ** for any number of directories, regex finds names matching a regular expression"),
+			mcp.Enum("substring", "glob", "regex"),
+		),
+		mcp.WithBoolean("match_path",
+			mcp.Description("Match the pattern against the path relative to the root instead of the name"),
+		),
+		mcp.WithBoolean("case_sensitive",
+			mcp.Description("Match the pattern and extensions with case sensitivity. Defaults to false"),
+		),
+		mcp.WithString("type",
+			mcp.Description("Only return entries of this type"),
+			mcp.Enum("file", "directory", "symlink"),
+		),
+		mcp.WithArray("extensions",
+			mcp.Description("Only return files with one of these extensions, such as go or .md"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithNumber("min_size",
+			mcp.Description("Only return files of at least this many bytes"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("max_size",
+			mcp.Description("Only return files of at most this many bytes"),
+			mcp.Min(0),
+		),
+		mcp.WithString("modified_after",
+			mcp.Description("Only return entries modified after this time, given as an RFC 3339 time, a date such as 2024-01-31, or a duration before now such as 24h"),
+		),
+		mcp.WithString("modified_before",
+			mcp.Description("Only return entries modified before this time, in the same forms as modified_after"),
+		),
+		mcp.WithNumber("max_depth",
+			mcp.Description("Only search this many levels below the root; 1 searches the root's own entries"),
+			mcp.Min(1),
+		),
+		mcp.WithArray("exclude",
+			mcp.Description("Glob patterns of entries to skip, such as node_modules or **/*.min.js; excluded directories are not searched. Patterns without a slash match names"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithBoolean("respect_ignore",
+			mcp.Description("Skip the entries ignored by .gitignore, .ignore and .jarvisignore files, and the .git directory. Defaults to true"),
+		),
+		mcp.WithNumber("limit",
+			mcp.Description(fmt.Sprintf("Maximum number of matches to return. Defaults to %d", defaultSearchLimit)),
+			mcp.Min(1),
 		),
 	), searchFilesHandler
 }
 
+// searchOptions are the criteria of a search_files call.
+type searchOptions struct {
+	Pattern string
+	// Mode is "substring", "glob" or "regex".
+	Mode          string
+	MatchPath     bool
+	CaseSensitive bool
+	// Type is "file", "directory", "symlink", or empty for any.
+	Type       string
+	Extensions []string
+	// MinSize and MaxSize bound the size of files; a negative value leaves it unbounded.
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// MaxDepth is the number of levels below the root to search, zero for all.
+	MaxDepth int
+	Exclude  []string
+	// RespectIgnore skips the entries ignored by ignore files.
+	RespectIgnore bool
+	Limit         int
+}
+
+// searchMatch is an entry found by search_files.
+type searchMatch struct {
+	// Path is relative to the search root, with forward slashes.
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+}
+
+// searchResult is the structured content of a search_files result.
+type searchResult struct {
+	Root    string        `json:"root"`
+	Matches []searchMatch `json:"matches"`
+	// Truncated reports that more entries match than the limit.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
 func searchFilesHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	dirPath, ok := request.Params.Arguments["path"].(string)
+	dirPath, ok := request.GetArguments()["path"].(string)
 	if !ok {
 		return nil, errors.New("search path is required")
 	}
 
-	pattern, ok := request.Params.Arguments["pattern"].(string)
+	pattern, ok := request.GetArguments()["pattern"].(string)
 	if !ok {
 		return nil, errors.New("search pattern is required")
 	}
 
-	files, err := searchFiles(dirPath, pattern)
+	opts := searchOptions{
+		Pattern:       pattern,
+		Mode:          request.GetString("mode", "substring"),
+		MatchPath:     request.GetBool("match_path", false),
+		CaseSensitive: request.GetBool("case_sensitive", false),
+		Type:          request.GetString("type", ""),
+		Extensions:    request.GetStringSlice("extensions", nil),
+		MinSize:       int64(request.GetInt("min_size", -1)),
+		MaxSize:       int64(request.GetInt("max_size", -1)),
+		MaxDepth:      request.GetInt("max_depth", 0),
+		Exclude:       request.GetStringSlice("exclude", nil),
+		RespectIgnore: request.GetBool("respect_ignore", true),
+		Limit:         request.GetInt("limit", defaultSearchLimit),
+	}
+	if opts.Limit < 1 {
+		return nil, errors.New("limit must be at least 1")
+	}
+	if opts.MaxDepth < 0 {
+		return nil, errors.New("max_depth must be at least 1")
+	}
+	now := time.Now()
+	var err error
+	if opts.ModifiedAfter, err = parseTime(request.GetString("modified_after", ""), now); err != nil {
+		return nil, fmt.Errorf("invalid modified_after: %w", err)
+	}
+	if opts.ModifiedBefore, err = parseTime(request.GetString("modified_before", ""), now); err != nil {
+		return nil, fmt.Errorf("invalid modified_before: %w", err)
+	}
+
+	dirPath, err = resolvePath(ctx, dirPath)
 	if err != nil {
-		return nil, err
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	result, err := searchFiles(ctx, dirPath, opts)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
 	}
 
-	if len(files) == 0 {
-		return mcp.NewToolResultText("No files matching the pattern were found"), nil
+	if len(result.Matches) == 0 {
+		return mcp.NewToolResultStructured(result, "No files matching the pattern were found"), nil
 	}
 
 	// Format the output for better readability
-	output := "Files matching pattern '" + pattern + "':\n" + strings.Join(files, "\n")
-	return mcp.NewToolResultText(output), nil
+	var b strings.Builder
+	fmt.Fprintf(&b, "Files matching pattern '%s' in %s:\n", pattern, result.Root)
+	for _, match := range result.Matches {
+		switch match.Type {
+		case "file":
+			fmt.Fprintf(&b, "[FILE] %s (%d bytes)\n", match.Path, match.Size)
+		case "directory":
+			fmt.Fprintf(&b, "[DIR] %s\n", match.Path)
+		case "symlink":
+			fmt.Fprintf(&b, "[LINK] %s\n", match.Path)
+		default:
+			fmt.Fprintf(&b, "[OTHER] %s\n", match.Path)
+		}
+	}
+	if result.Truncated {
+		fmt.Fprintf(&b, "[More entries match; raise limit to see more than %d]\n", opts.Limit)
+	}
+	return mcp.NewToolResultStructured(result, b.String()), nil
+}
+
+// searchFiles walks the directory at root and returns the entries below it that meet opts,
+// in lexical order. Symbolic links are reported but not followed, and directories that cannot
+// be read are skipped.
+func searchFiles(ctx context.Context, root string, opts searchOptions) (*searchResult, error) {
+	match, err := opts.matcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range opts.Exclude {
+		if err := validGlob(pattern); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern %q: %w", pattern, err)
+		}
+	}
+	fold := func(s string) string { return utils.IfElse(opts.CaseSensitive, s, strings.ToLower(s)) }
+	var ignore *ignorer
+	if opts.RespectIgnore {
+		if ignore, err = newIgnorer(root); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &searchResult{Root: root, Matches: []searchMatch{}}
+	err = filepath.WalkDir(root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if filePath == root {
+				return err
+			}
+			return utils.IfElse(entry != nil && entry.IsDir(), fs.SkipDir, nil)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if filePath == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		depth := strings.Count(rel, "/") + 1
+
+		if ignore.match(rel, entry.IsDir()) || slices.ContainsFunc(opts.Exclude, func(pattern string) bool {
+			return matchGlob(fold(pattern), fold(utils.IfElse(strings.Contains(pattern, "/"), rel, entry.Name())))
+		}) {
+			return utils.IfElse(entry.IsDir(), fs.SkipDir, nil)
+		}
+		var skip error
+		if entry.IsDir() && opts.MaxDepth > 0 && depth >= opts.MaxDepth {
+			skip = fs.SkipDir
+		} else if entry.IsDir() {
+			if err := ignore.enter(filePath, rel); err != nil {
+				return err
+			}
+		}
+
+		if !match(entry.Name(), rel) {
+			return skip
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// The entry was removed while walking
+			return skip
+		}
+		found := searchMatch{Path: rel, Type: entryType(info.Mode())}
+		if found.Type == "file" {
+			found.Size = info.Size()
+		}
+		if !opts.accepts(found, info) {
+			return skip
+		}
+		if len(result.Matches) == opts.Limit {
+			result.Truncated = true
+			return fs.SkipAll
+		}
+		result.Matches = append(result.Matches, found)
+		return skip
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// matcher compiles the pattern of opts into a function reporting whether the entry with the
+// given name and path relative to the root matches it.
+func (opts searchOptions) matcher() (func(name string, rel string) bool, error) {
+	pattern := utils.IfElse(opts.CaseSensitive, opts.Pattern, strings.ToLower(opts.Pattern))
+	fold := func(name string, rel string) string {
+		s := utils.IfElse(opts.MatchPath, rel, name)
+		return utils.IfElse(opts.CaseSensitive, s, strings.ToLower(s))
+	}
+
+	switch opts.Mode {
+	case "substring":
+		return func(name string, rel string) bool { return strings.Contains(fold(name, rel), pattern) }, nil
+	case "glob":
+		if err := validGlob(pattern); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", opts.Pattern, err)
+		}
+		// As in .gitignore, a pattern with a slash is anchored to the root
+		if strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+			opts.MatchPath = true
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+		return func(name string, rel string) bool { return matchGlob(pattern, fold(name, rel)) }, nil
+	case "regex":
+		re, err := regexp.Compile(utils.IfElse(opts.CaseSensitive, "", "(?i)") + opts.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %w", err)
+		}
+		return func(name string, rel string) bool { return re.MatchString(utils.IfElse(opts.MatchPath, rel, name)) }, nil
+	default:
+		return nil, fmt.Errorf("unknown search mode %q", opts.Mode)
+	}
+}
+
+// accepts reports whether the entry found meets the type, extension, size and modification time
+// criteria of opts.
+func (opts searchOptions) accepts(found searchMatch, info fs.FileInfo) bool {
+	if opts.Type != "" && found.Type != opts.Type {
+		return false
+	}
+	if len(opts.Extensions) > 0 {
+		ext := strings.TrimPrefix(path.Ext(found.Path), ".")
+		if found.Type != "file" || !slices.ContainsFunc(opts.Extensions, func(want string) bool {
+			want = strings.TrimPrefix(want, ".")
+			return utils.IfElse(opts.CaseSensitive, want == ext, strings.EqualFold(want, ext))
+		}) {
+			return false
+		}
+	}
+	if opts.MinSize >= 0 && (found.Type != "file" || found.Size < opts.MinSize) {
+		return false
+	}
+	if opts.MaxSize >= 0 && (found.Type != "file" || found.Size > opts.MaxSize) {
+		return false
+	}
+	if !opts.ModifiedAfter.IsZero() && !info.ModTime().After(opts.ModifiedAfter) {
+		return false
+	}
+	if !opts.ModifiedBefore.IsZero() && !info.ModTime().Before(opts.ModifiedBefore) {
+		return false
+	}
+	return true
+}
+
+// entryType names the type of a file with the given mode, as in pathState for the types it records.
+func entryType(mode fs.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "file"
+	case mode.IsDir():
+		return "directory"
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeNamedPipe != 0:
+		return "pipe"
+	case mode&fs.ModeCharDevice != 0:
+		return "char_device"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	default:
+		return "other"
+	}
+}
+
+// parseTime parses an RFC 3339 time, a date, or a duration before now. An empty string is the
+// zero time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not an RFC 3339 time, a date or a duration", s)
 }
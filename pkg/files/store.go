@@ -0,0 +1,78 @@
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// blobGracePeriod protects recently saved contents from pruning, as they may belong to a change
+// that is still being made, possibly by another server process sharing the directory.
+const blobGracePeriod = time.Hour
+
+// blobStore keeps file contents in a directory under the hex SHA-256 of the content, so content
+// saved several times is stored once.
+type blobStore struct {
+	dir string
+}
+
+// path returns where the content with the given hash is kept.
+func (s blobStore) path(sum string) string {
+	return filepath.Join(s.dir, sum[:2], sum[2:])
+}
+
+// put saves data and returns its hash.
+func (s blobStore) put(data []byte) (string, error) {
+	hash := sha256.Sum256(data)
+	sum := hex.EncodeToString(hash[:])
+	path := s.path(sum)
+
+	// Content that is already stored is marked as recently used instead
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err == nil {
+		return sum, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+	return sum, writeFileWith(path, data, writeOptions{Mode: 0600})
+}
+
+// get returns the content with the given hash.
+func (s blobStore) get(sum string) ([]byte, error) {
+	if len(sum) != 2*sha256.Size {
+		return nil, fmt.Errorf("invalid content hash %q: %w", sum, fs.ErrNotExist)
+	}
+	data, err := os.ReadFile(s.path(sum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("the saved content %s is gone from %s: %w", sum[:12], s.dir, fs.ErrNotExist)
+	}
+	return data, err
+}
+
+// prune removes the contents not in keep that were not saved or used within blobGracePeriod.
+func (s blobStore) prune(keep map[string]bool) error {
+	cutoff := time.Now().Add(-blobGracePeriod)
+	return filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		// Temporary files left by a crash are not kept either
+		sum := filepath.Base(filepath.Dir(path)) + entry.Name()
+		if info, err := entry.Info(); err == nil && !keep[sum] && info.ModTime().Before(cutoff) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,119 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/utils"
+	"slices"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+func GetUndoLastChange() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("undo_last_change",
+		mcp.WithDescription("Reverts the last change the file tools made in this session, restoring the files it wrote, removing the ones it created and moving back what it moved. A change to files that were changed again afterwards is not undone unless force is set"),
+		mcp.WithNumber("change_id",
+			mcp.Description("ID of the change to undo instead, as listed by list_changes; it may be from another session"),
+			mcp.Min(1),
+		),
+		mcp.WithBoolean("all_session",
+			mcp.Description("Undo every change of this session that was not undone yet, newest first"),
+		),
+		mcp.WithBoolean("force",
+			mcp.Description("Undo even if the files were changed after the change, discarding those later changes"),
+		),
+	), undoLastChangeHandler
+}
+
+// undoResult is the structured content of an undo_last_change result.
+type undoResult struct {
+	Undone []*change `json:"undone"`
+}
+
+func undoLastChangeHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	changeID := request.GetInt("change_id", 0)
+	allSession := request.GetBool("all_session", false)
+	force := request.GetBool("force", false)
+	if changeID != 0 && allSession {
+		return nil, errors.New("change_id and all_session cannot be used together")
+	}
+
+	if config.BackupDir == "" {
+		return utils.NewToolResultError(errJournalDisabled, ""), nil
+	}
+	// Refuse before the user is asked to confirm an operation that cannot happen
+	if err := checkWritable(); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	changes, err := readJournal()
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	session := sessionID(ctx)
+	var targets []*change
+	if changeID != 0 {
+		c, err := findChange(changes, changeID)
+		if err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		targets = append(targets, c)
+	} else {
+		for _, c := range slices.Backward(changes) {
+			if c.Session == session && !c.Undone {
+				targets = append(targets, c)
+				if !allSession {
+					break
+				}
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return utils.NewToolResultError(fmt.Errorf("this session made no changes that are not undone yet; list_changes shows the changes of earlier sessions, which are undone by change_id: %w", fs.ErrNotExist), ""), nil
+	}
+
+	var b strings.Builder
+	for _, c := range targets {
+		b.WriteString(c.describe(session))
+	}
+	if err := confirm.Ask(ctx, "Undo these changes?\n\n"+b.String()); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	result := undoResult{Undone: []*change{}}
+	var undoErr error
+	for _, c := range targets {
+		if undoErr = undoChange(ctx, c, force); undoErr != nil {
+			undoErr = fmt.Errorf("undoing change %d: %w", c.ID, undoErr)
+			break
+		}
+		c.Undone = true
+		result.Undone = append(result.Undone, c)
+	}
+
+	if len(result.Undone) > 0 {
+		err := updateJournal(func(changes []*change) ([]*change, error) {
+			for _, c := range changes {
+				if slices.ContainsFunc(result.Undone, func(undone *change) bool { return undone.ID == c.ID }) {
+					c.Undone = true
+				}
+			}
+			return changes, nil
+		})
+		undoErr = utils.IfElse(undoErr == nil, err, undoErr)
+	}
+
+	b.Reset()
+	for _, c := range result.Undone {
+		b.WriteString("Undid " + c.describe(session))
+	}
+	if undoErr != nil {
+		return utils.NewToolResultError(undoErr, b.String()), nil
+	}
+	return mcp.NewToolResultStructured(result, b.String()), nil
+}
@@ -0,0 +1,295 @@
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+// readOptions selects the part of a file returned by readFileWindow.
+type readOptions struct {
+	// Offset is the first line to return, counting from 1. Zero means 1.
+	Offset int
+	// Limit is the maximum number of lines to return. Zero means no limit.
+	Limit int
+	// Bytes selects a byte range given by ByteOffset and ByteLimit instead of a line range.
+	Bytes bool
+	// ByteOffset is the first byte to return, counting from 0.
+	ByteOffset int64
+	// ByteLimit is the maximum number of bytes to return. Zero means no limit.
+	ByteLimit int64
+	// LineNumbers prefixes each returned line with its number.
+	LineNumbers bool
+	// MaxBytes caps the size of the returned content, line number prefixes included. Zero means no limit.
+	MaxBytes int
+	// Binary returns a byte range as it is, without keeping UTF-8 characters whole or counting lines.
+	Binary bool
+	// Encoding is the name of the encoding the file is decoded from. Empty means UTF-8.
+	Encoding string
+}
+
+// fileWindow is the part of a file returned by readFileWindow and where it lies in the file.
+type fileWindow struct {
+	Content    string `json:"-"`
+	Path       string `json:"path"`
+	TotalBytes int64  `json:"total_bytes"`
+	TotalLines int    `json:"total_lines"`
+	// MimeType is set for binary files, whose content is returned as it is.
+	MimeType string `json:"mime_type,omitempty"`
+	// Encoding and LineEnding describe the format of text files, see detectEncoding and detectLineEnding.
+	Encoding   string `json:"encoding,omitempty"`
+	LineEnding string `json:"line_ending,omitempty"`
+	// StartLine and EndLine are the first and last line returned in line mode, 0 if none.
+	StartLine int `json:"start_line,omitempty"`
+	EndLine   int `json:"end_line,omitempty"`
+	// ByteOffset and ByteCount locate the returned part of the file in bytes.
+	ByteOffset int64 `json:"byte_offset"`
+	ByteCount  int64 `json:"byte_count"`
+	// More reports that the file continues after the returned part.
+	More bool `json:"more"`
+	// NextOffset or NextByteOffset is where the next read should start if More is set.
+	NextOffset     int   `json:"next_offset,omitempty"`
+	NextByteOffset int64 `json:"next_byte_offset,omitempty"`
+	// Truncated reports that the response size limit cut the requested range short.
+	Truncated bool `json:"truncated"`
+}
+
+// readSource is the content readFileWindow reads from: the file itself, or its text decoded to UTF-8.
+type readSource interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+}
+
+// readFileWindow reads the part of the file at path selected by opts. The file is streamed,
+// so only the returned part is held in memory, and lines are counted to the end of the file.
+// Files in other encodings than UTF-8 are decoded as a whole first, and byte offsets and
+// sizes refer to the decoded text.
+func readFileWindow(path string, opts readOptions) (*fileWindow, error) {
+	path, err := normalizePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+
+	window := &fileWindow{Path: path, TotalBytes: info.Size()}
+	var source readSource = file
+	if opts.Encoding != "" && opts.Encoding != "utf-8" {
+		if info.Size() > maxDecodeBytes {
+			return nil, fmt.Errorf("%w: %s is a %s file of %d bytes, only files up to %d bytes are decoded",
+				utils.ErrTooLarge, path, opts.Encoding, info.Size(), maxDecodeBytes)
+		}
+		text, err := io.ReadAll(transform.NewReader(file, encodings[opts.Encoding].NewDecoder()))
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode %s: %w", opts.Encoding, err)
+		}
+		source = bytes.NewReader(text)
+		window.TotalBytes = int64(len(text))
+	}
+
+	if opts.Bytes {
+		err = window.readBytes(source, opts)
+	} else {
+		err = window.readLines(source, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Binary {
+		window.Encoding = utils.IfElse(opts.Encoding == "", "utf-8", opts.Encoding)
+		window.LineEnding = detectLineEnding(window.Content)
+	}
+	return window, nil
+}
+
+// readBytes fills the window with a byte range. Unless opts.Binary is set, the range is
+// shortened so it does not end in the middle of a UTF-8 encoded character.
+func (w *fileWindow) readBytes(file readSource, opts readOptions) error {
+	start := min(opts.ByteOffset, w.TotalBytes)
+	count := w.TotalBytes - start
+	if opts.ByteLimit > 0 {
+		count = min(count, opts.ByteLimit)
+	}
+	if opts.MaxBytes > 0 && count > int64(opts.MaxBytes) {
+		count = int64(opts.MaxBytes)
+		w.Truncated = true
+	}
+
+	data := make([]byte, count)
+	if _, err := file.ReadAt(data, start); err != nil && err != io.EOF {
+		return err
+	}
+	if trimmed := trimPartialRune(data); !opts.Binary && start+count < w.TotalBytes && len(trimmed) > 0 {
+		data = trimmed
+	}
+
+	w.Content = string(data)
+	w.ByteOffset = start
+	w.ByteCount = int64(len(data))
+	w.More = start+w.ByteCount < w.TotalBytes
+	if w.More {
+		w.NextByteOffset = start + w.ByteCount
+	}
+	if opts.Binary {
+		return nil
+	}
+
+	lines, err := countLines(file)
+	w.TotalLines = lines
+	return err
+}
+
+// trimPartialRune removes an incomplete UTF-8 sequence from the end of data.
+func trimPartialRune(data []byte) []byte {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i]
+			}
+			break
+		}
+	}
+	return data
+}
+
+// countLines returns the number of lines in file, reading it from the start.
+// A final line without a trailing newline counts as a line.
+func countLines(file readSource) (int, error) {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	lines := 0
+	last := byte('\n')
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			lines += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+	return lines, nil
+}
+
+// readLines fills the window with a line range, keeping whole lines within the size limit.
+// Only a first line that is larger than the limit on its own is cut, at a byte offset the
+// rest can be read from.
+func (w *fileWindow) readLines(file readSource, opts readOptions) error {
+	first := max(opts.Offset, 1)
+	inRange := func(line int) bool {
+		return line >= first && (opts.Limit <= 0 || line < first+opts.Limit)
+	}
+
+	var out bytes.Buffer
+	reader := bufio.NewReaderSize(file, 64*1024)
+	line := 1
+	var pos int64        // file offset of the next byte read
+	var lineStart int64  // file offset of the current line
+	var end int64        // file offset just past the returned content
+	outLineStart := 0    // length of out when the current line started
+	outContentStart := 0 // length of out after the line number of the current line
+	collecting := true
+	last := byte('\n')
+
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		if len(chunk) > 0 {
+			last = chunk[len(chunk)-1]
+			if collecting && inRange(line) {
+				if pos == lineStart {
+					if w.StartLine == 0 {
+						w.StartLine = line
+						w.ByteOffset = pos
+					}
+					outLineStart = out.Len()
+					if opts.LineNumbers {
+						out.WriteString(lineNumber(line))
+					}
+					outContentStart = out.Len()
+				}
+				out.Write(chunk)
+
+				switch {
+				case opts.MaxBytes > 0 && out.Len() > opts.MaxBytes && line > w.StartLine:
+					// Drop the line that does not fit; the next read starts with it
+					out.Truncate(outLineStart)
+					collecting = false
+					w.Truncated = true
+				case opts.MaxBytes > 0 && out.Len() > opts.MaxBytes:
+					// A single line larger than the limit: return its start, at least one character of it
+					_, firstRune := utf8.DecodeRune(out.Bytes()[outContentStart:])
+					keep := max(opts.MaxBytes, outContentStart+firstRune)
+					kept := trimPartialRune(out.Bytes()[:keep])
+					end = pos + int64(len(chunk)) - int64(out.Len()-len(kept))
+					out.Truncate(len(kept))
+					collecting = false
+					w.Truncated = true
+					w.EndLine = line
+					w.NextByteOffset = end
+				case last == '\n' || err == io.EOF:
+					end = pos + int64(len(chunk))
+					w.EndLine = line
+				}
+			} else if collecting && line >= first {
+				collecting = false
+			}
+
+			pos += int64(len(chunk))
+			if last == '\n' {
+				line++
+				lineStart = pos
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != bufio.ErrBufferFull {
+			return err
+		}
+	}
+
+	w.TotalLines = utils.IfElse(last == '\n', line-1, line)
+	w.Content = out.String()
+	if w.StartLine > 0 {
+		w.ByteCount = end - w.ByteOffset
+	}
+	// Nothing is returned for an empty file or an offset past its end
+	w.More = w.StartLine > 0 && (w.EndLine < w.TotalLines || w.NextByteOffset > 0)
+	if w.More && w.NextByteOffset == 0 {
+		w.NextOffset = w.EndLine + 1
+	}
+	return nil
+}
+
+// lineNumber returns the prefix for a numbered line, in the style of "cat -n".
+func lineNumber(line int) string {
+	return fmt.Sprintf("%6d\t", line)
+}
@@ -2,7 +2,16 @@ package files
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -10,7 +19,7 @@ import (
 
 func GetWriteFile() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 	return mcp.NewTool("write_file",
-		mcp.WithDescription("Write file, given the path"),
+		mcp.WithDescription("Write file, given the path. The file is replaced atomically, so it is never left half written, and keeps its permissions"),
 		mcp.WithString("path",
 			mcp.Required(),
 			mcp.Description("Path for the file name to write"),
@@ -19,24 +28,236 @@ func GetWriteFile() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 			mcp.Required(),
 			mcp.Description("Content to write to the file"),
 		),
+		mcp.WithString("encoding",
+			mcp.Description("Encoding to write the file in, as reported by read_file. Defaults to the encoding of the file being replaced, or utf-8 for new files"),
+			mcp.Enum(encodingNames...),
+		),
+		mcp.WithString("line_ending",
+			mcp.Description("Line ending to write every line with. Defaults to the line ending of the file being replaced; new files are written as given"),
+			mcp.Enum("lf", "crlf"),
+		),
+		mcp.WithString("mode",
+			mcp.Description("Permission of the file as an octal number, such as 0644 or 0755. Defaults to the permission of the file being replaced, or 0644 for new files"),
+		),
+		mcp.WithBoolean("append",
+			mcp.Description("Add the content to the end of the file instead of replacing it. The file is created if it does not exist"),
+		),
+		mcp.WithBoolean("create_only",
+			mcp.Description("Fail instead of replacing the file if it already exists"),
+		),
+		mcp.WithString("expected_sha256",
+			mcp.Description("SHA-256 of the file when it was last read, in hex, as reported by get_file_info and write_file. The write fails with a conflict if the file has changed since"),
+		),
 	), writeFileHandler
 }
 
+// writeResult is the structured content of a write_file result.
+type writeResult struct {
+	Path string `json:"path"`
+	// Bytes is the size of the file after the write.
+	Bytes    int    `json:"bytes"`
+	SHA256   string `json:"sha256"`
+	Encoding string `json:"encoding"`
+	// LineEnding is "lf" or "crlf" if every line was converted to it, and "" otherwise.
+	LineEnding string `json:"line_ending,omitempty"`
+	Created    bool   `json:"created"`
+	Appended   bool   `json:"appended,omitempty"`
+}
+
 func writeFileHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
-	fileName, ok := request.Params.Arguments["path"].(string)
+	fileName, ok := request.GetArguments()["path"].(string)
 	if !ok {
 		return nil, errors.New("file path is required")
 	}
 
-	content, ok := request.Params.Arguments["content"].(string)
+	content, ok := request.GetArguments()["content"].(string)
 	if !ok {
 		return nil, errors.New("file content is required")
 	}
 
-	if err := writeFile(fileName, content); err != nil {
-		return nil, err
+	encodingName := request.GetString("encoding", "")
+	if _, ok := encodings[encodingName]; encodingName != "" && !ok {
+		return nil, fmt.Errorf("unknown encoding %q, use one of %s", encodingName, strings.Join(encodingNames, ", "))
+	}
+	lineEnding := request.GetString("line_ending", "")
+	if lineEnding != "" && lineEnding != "lf" && lineEnding != "crlf" {
+		return nil, fmt.Errorf("unknown line ending %q, use lf or crlf", lineEnding)
+	}
+
+	var opts writeOptions
+	if mode := request.GetString("mode", ""); mode != "" {
+		var err error
+		if opts.Mode, err = parseMode(mode); err != nil {
+			return nil, err
+		}
+	}
+	appending := request.GetBool("append", false)
+	opts.CreateOnly = request.GetBool("create_only", false)
+	if appending && opts.CreateOnly {
+		return nil, errors.New("append and create_only cannot be used together")
+	}
+	expectedSHA256 := request.GetString("expected_sha256", "")
+	if sum, err := hex.DecodeString(expectedSHA256); err != nil || expectedSHA256 != "" && len(sum) != sha256.Size {
+		return nil, fmt.Errorf("expected_sha256 must be %d hexadecimal digits", 2*sha256.Size)
+	}
+
+	fileName, err := resolvePath(ctx, fileName)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	// Refuse before the user is asked to confirm an operation that cannot happen
+	if err := checkWritable(); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+	_, statErr := os.Stat(fileName)
+	created := errors.Is(statErr, fs.ErrNotExist)
+	if opts.CreateOnly && !created {
+		return utils.NewToolResultError(&fs.PathError{Op: "create", Path: fileName, Err: fs.ErrExist}, ""), nil
+	}
+	if expectedSHA256 != "" {
+		if err := checkSHA256(fileName, expectedSHA256); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+	}
+
+	// A file that is replaced keeps its format unless another one is asked for
+	currentEncoding, currentLineEnding := existingFormat(fileName)
+	encodingName = utils.IfElse(encodingName == "", currentEncoding, encodingName)
+	lineEnding = utils.IfElse(lineEnding == "", currentLineEnding, lineEnding)
+	content = convertLineEndings(content, lineEnding)
+
+	var data []byte
+	if appending {
+		current, err := os.ReadFile(fileName)
+		if err != nil && !created {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		// The byte order mark is already at the start of the file
+		encoded, err := encodeText(content, utils.IfElse(len(current) > 0, strings.TrimSuffix(encodingName, "-bom"), encodingName))
+		if err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		data = append(current, encoded...)
+	} else {
+		encoded, err := encodeText(content, encodingName)
+		if err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		data = []byte(encoded)
+
+		// Appending loses nothing, so only replacing a file is confirmed
+		if err := confirmOverwrite(ctx, fileName, content); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+	}
+
+	if expectedSHA256 != "" {
+		// The file may have changed while the user was asked
+		if err := checkSHA256(fileName, expectedSHA256); err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+	}
+	pending := beginChange(ctx, "write_file", fileName)
+	if err := writeFileWith(fileName, data, opts); err != nil {
+		return utils.NewToolResultError(err, ""), nil
 	}
+	pending.commit()
 
-	return mcp.NewToolResultText("File written successfully"), nil
+	sum := sha256.Sum256(data)
+	result := writeResult{
+		Path:       fileName,
+		Bytes:      len(data),
+		SHA256:     hex.EncodeToString(sum[:]),
+		Encoding:   encodingName,
+		LineEnding: lineEnding,
+		Created:    created,
+		Appended:   appending,
+	}
+	message := utils.IfElse(appending, "Content appended successfully", "File written successfully")
+
+	// Mention the format only when it is not the usual one
+	var format []string
+	if encodingName != "utf-8" {
+		format = append(format, encodingName)
+	}
+	if lineEnding == "crlf" {
+		format = append(format, "CRLF line endings")
+	}
+	if len(format) > 0 {
+		message += fmt.Sprintf(" (%s)", strings.Join(format, ", "))
+	}
+	return mcp.NewToolResultStructured(result, message), nil
+}
+
+// parseMode parses a file permission given as an octal number, such as "0755".
+func parseMode(mode string) (fs.FileMode, error) {
+	perm, err := strconv.ParseUint(strings.TrimPrefix(mode, "0o"), 8, 32)
+	if err != nil || perm == 0 || perm > 0o777 {
+		return 0, fmt.Errorf("invalid mode %q, use an octal permission such as 0644 or 0755", mode)
+	}
+	return fs.FileMode(perm), nil
+}
+
+// checkSHA256 fails with ErrConflict unless the file at path has the given SHA-256, which tells
+// that it has not changed since the client read it.
+func checkSHA256(path string, expected string) error {
+	actual, err := fileSHA256(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%w: %s no longer exists", utils.ErrConflict, path)
+	case err != nil:
+		return err
+	case !strings.EqualFold(actual, expected):
+		return fmt.Errorf("%w: %s has changed since it was read, its SHA-256 is %s; read it again before writing",
+			utils.ErrConflict, path, actual)
+	}
+	return nil
+}
+
+// existingFormat returns the encoding and line ending of the text file at path, which a write
+// replacing it keeps by default. Mixed line endings are reported as "" and left as they are
+// written; missing and binary files report utf-8.
+func existingFormat(path string) (string, string) {
+	mediaType, _, err := detectContentType(path)
+	if err != nil || !isText(mediaType) {
+		return "utf-8", ""
+	}
+	text, encodingName, err := decodeFile(path)
+	if err != nil {
+		return "utf-8", ""
+	}
+	lineEnding := detectLineEnding(text)
+	return encodingName, utils.IfElse(lineEnding == "mixed", "", lineEnding)
+}
+
+// maxConfirmDiffBytes limits the diff shown in an overwrite confirmation request.
+const maxConfirmDiffBytes = 8 * 1024
+
+// confirmOverwrite asks the user before an existing file is replaced, showing the changes as a diff.
+// New files and files that already hold the same content are written without asking.
+func confirmOverwrite(ctx context.Context, path string, content string) error {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+	current, err := readFile(path)
+	if err != nil {
+		return err
+	}
+
+	diff := unifiedDiff(path, path, current, content)
+	if diff == "" {
+		return nil
+	}
+	return confirm.Ask(ctx, fmt.Sprintf("Overwrite %s?\n\n%s", path, truncateDiff(diff)))
+}
+
+// truncateDiff shortens a diff to the size shown in a confirmation request, cutting it at a line break.
+func truncateDiff(diff string) string {
+	if len(diff) <= maxConfirmDiffBytes {
+		return diff
+	}
+	return diff[:strings.LastIndexByte(diff[:maxConfirmDiffBytes], '\n')+1] + "... (diff truncated)\n"
 }
@@ -0,0 +1,40 @@
+package policy
+
+// dangerousPolicy requires confirmation for commands that destroy data or the system
+// in ways that cannot be undone: recursive deletes, forced pushes and history rewrites,
+// writing to devices and formatting or partitioning disks.
+const dangerousPolicy = `{
+	"default_action": "allow",
+	"rules": [
+		{"name": "recursive-delete", "action": "require-confirmation", "programs": ["rm"],
+			"regex": " (-[^- ]*[rR]|--recursive)"},
+		{"name": "windows-recursive-delete", "action": "require-confirmation", "programs": ["del", "erase", "rd", "rmdir"],
+			"args": ["/[sS]"]},
+		{"name": "find-delete", "action": "require-confirmation", "programs": ["find"],
+			"regex": " -(delete|exec rm)( |$)"},
+		{"name": "git-force-push", "action": "require-confirmation", "programs": ["git"],
+			"regex": " push( [^ ]+)* (-[^- ]*f|--force|--mirror|--delete|-d( |$)|:|\\+)"},
+		{"name": "git-discard", "action": "require-confirmation", "programs": ["git"],
+			"regex": " (reset( [^ ]+)* --hard|clean( [^ ]+)* (-[^- ]*f|--force)|checkout( [^ ]+)* (-f|--force|-- |\\.( |$))|branch( [^ ]+)* -D|stash (drop|clear))"},
+		{"name": "recursive-permissions", "action": "require-confirmation", "programs": ["chmod", "chown", "chgrp"],
+			"regex": " (-[^- ]*R|--recursive)"},
+		{"name": "device-write", "action": "require-confirmation", "programs": ["dd"],
+			"args": ["of=*"]},
+		{"name": "disk-format", "action": "require-confirmation", "programs": [
+			"mkfs", "mkfs.*", "mke2fs", "mkswap", "wipefs", "shred", "fdisk", "sfdisk", "cfdisk",
+			"gdisk", "sgdisk", "parted", "format", "diskpart"
+		]},
+		{"name": "shutdown", "action": "require-confirmation", "programs": ["shutdown", "reboot", "halt", "poweroff"]}
+	]
+}`
+
+// Dangerous returns the built-in policy that asks the user before running commands
+// that destroy data irrecoverably, such as "rm -rf", "git push --force", "dd of=" or "mkfs".
+// Every other command is allowed.
+func Dangerous() *Policy {
+	p, err := Decode([]byte(dangerousPolicy))
+	if err != nil {
+		panic("invalid dangerous command policy: " + err.Error())
+	}
+	return p
+}
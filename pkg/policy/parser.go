@@ -0,0 +1,585 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxNesting bounds how deeply subshells, command substitutions and "sh -c" scripts are followed.
+const maxNesting = 8
+
+// Segment is a single simple command found in a shell command line, such as one
+// stage of a pipeline or the body of a command substitution.
+type Segment struct {
+	// Args holds the program and its arguments with quotes removed. Redirections,
+	// leading variable assignments and shell keywords are not included.
+	Args []string
+	// Assignments lists the NAME=value words before the program, which set its environment.
+	Assignments []string
+	// Writes lists the files the command's output is redirected to, as in "> out.txt".
+	Writes []string
+}
+
+// Program returns the name of the program the segment runs, without its directory.
+func (s Segment) Program() string {
+	if len(s.Args) == 0 {
+		return ""
+	}
+	return filepath.Base(s.Args[0])
+}
+
+// String returns the segment as a space-separated command line.
+func (s Segment) String() string {
+	return strings.Join(s.Args, " ")
+}
+
+// ErrParse is returned for command lines that cannot be split reliably, such as ones with unterminated quotes.
+var ErrParse = errors.New("cannot parse command")
+
+// Parse splits a POSIX shell command line into the simple commands it would run.
+// Pipelines, lists (&&, ||, ;, &, newlines), subshells, brace groups, command and
+// process substitutions are all taken apart so every command can be checked on its
+// own. Scripts passed to "sh -c" or eval and programs started through wrappers such
+// as sudo, env or xargs are reported as additional segments.
+func Parse(command string) ([]Segment, error) {
+	return parse(command, 0)
+}
+
+// parse implements Parse, tracking the nesting depth.
+func parse(command string, depth int) ([]Segment, error) {
+	if depth > maxNesting {
+		return nil, fmt.Errorf("%w: nested too deeply", ErrParse)
+	}
+
+	l := &lexer{input: []rune(command)}
+	tokens, err := l.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	var words, writes []string
+	flush := func() error {
+		assignments, args := simpleCommand(words)
+		segmentWrites := writes
+		words, writes = nil, nil
+		if len(args) == 0 {
+			// A redirection without a command, as in "> file" or "{ ...; } > file", still
+			// writes, and an assignment on its own changes exported variables for later commands
+			if len(segmentWrites) > 0 || len(assignments) > 0 {
+				segments = append(segments, Segment{Assignments: assignments, Writes: segmentWrites})
+			}
+			return nil
+		}
+		expanded, err := expand(args, depth)
+		if err != nil {
+			return err
+		}
+		expanded[0].Assignments = assignments
+		expanded[0].Writes = segmentWrites
+		segments = append(segments, expanded...)
+		return nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		switch tok.kind {
+		case tokenWord:
+			words = append(words, tok.text)
+		case tokenRedirect:
+			// The redirection target is a file name, not an argument
+			if i+1 < len(tokens) && writesFile(tok.text, tokens[i+1].text) {
+				writes = append(writes, tokens[i+1].text)
+			}
+			i++
+		case tokenOperator:
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	// Commands inside $(...), `...`, <(...) and >(...) run as well
+	for _, sub := range l.substitutions {
+		nested, err := parse(sub, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, nested...)
+	}
+
+	return segments, nil
+}
+
+// writesFile reports whether the redirection operator op opens target for writing.
+func writesFile(op string, target string) bool {
+	switch op {
+	case ">", ">>", ">|", "&>", "&>>", "<>":
+		return true
+	case ">&":
+		// Duplicating or closing a descriptor, as in 2>&1 or >&-, writes no file
+		return target != "-" && strings.Trim(target, "0123456789") != ""
+	}
+	return false
+}
+
+// reservedWords are shell keywords that may precede a command.
+var reservedWords = map[string]bool{
+	"if": true, "then": true, "else": true, "elif": true, "do": true,
+	"while": true, "until": true, "!": true, "{": true, "time": true,
+}
+
+// closingWords are shell keywords that end a compound command.
+var closingWords = map[string]bool{"fi": true, "done": true, "esac": true, "}": true}
+
+// assignmentPattern matches a leading NAME=value word.
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// simpleCommand strips keywords and variable assignments from the words of one command
+// and returns the assignments and the remaining program and arguments.
+func simpleCommand(words []string) (assignments []string, args []string) {
+	for len(words) > 0 && (reservedWords[words[0]] || assignmentPattern.MatchString(words[0])) {
+		if !reservedWords[words[0]] {
+			assignments = append(assignments, words[0])
+		}
+		words = words[1:]
+	}
+	for len(words) > 0 && closingWords[words[len(words)-1]] {
+		words = words[:len(words)-1]
+	}
+	if len(words) == 0 || closingWords[words[0]] {
+		return assignments, nil
+	}
+	switch words[0] {
+	case "for", "case", "select", "function":
+		// The loop header or pattern list is not a command; the body is split off by ";"
+		return assignments, nil
+	}
+	return assignments, words
+}
+
+// wrappers are programs that run another program given as their arguments.
+var wrappers = map[string]bool{
+	"sudo": true, "doas": true, "env": true, "nohup": true, "nice": true, "ionice": true,
+	"time": true, "timeout": true, "exec": true, "command": true, "builtin": true,
+	"xargs": true, "stdbuf": true, "setsid": true, "chroot": true, "watch": true, "strace": true, "busybox": true,
+}
+
+// shells are programs whose -c argument is a script.
+var shells = map[string]bool{
+	"sh": true, "bash": true, "dash": true, "zsh": true, "ksh": true, "ash": true, "fish": true,
+}
+
+// expand returns the segment for args followed by the segments of any program it starts.
+func expand(args []string, depth int) ([]Segment, error) {
+	segments := []Segment{{Args: args}}
+	if depth > maxNesting {
+		return nil, fmt.Errorf("%w: nested too deeply", ErrParse)
+	}
+
+	program := filepath.Base(args[0])
+	switch {
+	case wrappers[program]:
+		for _, inner := range unwrap(program, args[1:]) {
+			nested, err := expand(inner, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, nested...)
+		}
+	case shells[program]:
+		if script, ok := shellScript(args[1:]); ok {
+			nested, err := parse(script, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, nested...)
+		}
+	case program == "eval":
+		nested, err := parse(strings.Join(args[1:], " "), depth+1)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, nested...)
+	}
+	return segments, nil
+}
+
+// unwrap skips the options of a wrapper program and returns the commands it may run.
+// Whether an option takes a value ("sudo -u root") cannot be known in general, so
+// after a short option both the next word and the word after it are candidates.
+func unwrap(wrapper string, args []string) [][]string {
+	for i, arg := range args {
+		switch {
+		case arg == "--":
+			if i+1 < len(args) {
+				return [][]string{args[i+1:]}
+			}
+			return nil
+		case strings.HasPrefix(arg, "-"):
+		case wrapper == "env" && assignmentPattern.MatchString(arg):
+		case wrapper == "timeout" && arg != "" && arg[0] >= '0' && arg[0] <= '9':
+		default:
+			candidates := [][]string{args[i:]}
+			if prev := args[max(i-1, 0)]; i > 0 && len(prev) == 2 && prev[0] == '-' && prev[1] != '-' {
+				candidates = append(candidates, unwrap(wrapper, args[i+1:])...)
+			}
+			return candidates
+		}
+	}
+	return nil
+}
+
+// shellScript returns the script passed to a shell with -c, if any.
+func shellScript(args []string) (string, bool) {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") || arg == "--" {
+			return "", false
+		}
+		// -c may be combined with other options, as in "bash -lc"
+		if !strings.HasPrefix(arg, "--") && strings.Contains(arg, "c") && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// Token kinds produced by the lexer.
+const (
+	tokenWord = iota
+	tokenOperator
+	tokenRedirect
+)
+
+// token is a word, a control operator or a redirection operator.
+type token struct {
+	kind int
+	text string
+}
+
+// lexer splits a command line into tokens following POSIX shell quoting rules.
+// The bodies of command and process substitutions are collected for separate parsing.
+type lexer struct {
+	input         []rune
+	pos           int
+	substitutions []string
+	heredocs      []heredoc // here-documents whose bodies start at the next newline
+}
+
+// heredoc is a here-document whose body is still to be read.
+type heredoc struct {
+	delim string
+	// expand is set if the delimiter is not quoted, so the body is subject to substitutions.
+	expand bool
+}
+
+// tokens returns all tokens of the input.
+func (l *lexer) tokens() ([]token, error) {
+	var tokens []token
+	for {
+		tok, ok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return tokens, nil
+		}
+		if tok.kind == tokenRedirect && (tok.text == "<<" || tok.text == "<<-") {
+			// Remember the delimiter so the body is skipped
+			l.skipBlanks()
+			start := l.pos
+			delim, ok, err := l.next()
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				quoted := strings.ContainsAny(string(l.input[start:l.pos]), `'"\`)
+				l.heredocs = append(l.heredocs, heredoc{delim: delim.text, expand: !quoted})
+			}
+			tokens = append(tokens, tok, delim)
+			continue
+		}
+		tokens = append(tokens, tok)
+	}
+}
+
+// skipBlanks advances past spaces and tabs.
+func (l *lexer) skipBlanks() {
+	for l.pos < len(l.input) && (l.input[l.pos] == ' ' || l.input[l.pos] == '\t') {
+		l.pos++
+	}
+}
+
+// peek returns the rune at offset from the current position, or 0 past the end.
+func (l *lexer) peek(offset int) rune {
+	if l.pos+offset < len(l.input) {
+		return l.input[l.pos+offset]
+	}
+	return 0
+}
+
+// next returns the next token, or false at the end of input.
+func (l *lexer) next() (token, bool, error) {
+	for {
+		l.skipBlanks()
+		if l.pos >= len(l.input) {
+			return token{}, false, nil
+		}
+		switch r := l.input[l.pos]; {
+		case r == '#':
+			for l.pos < len(l.input) && l.input[l.pos] != '\n' {
+				l.pos++
+			}
+		case r == '\\' && l.peek(1) == '\n':
+			l.pos += 2
+		case r == '\n':
+			l.pos++
+			if err := l.skipHeredocs(); err != nil {
+				return token{}, false, err
+			}
+			return token{kind: tokenOperator, text: "\n"}, true, nil
+		default:
+			return l.operatorOrWord()
+		}
+	}
+}
+
+// skipHeredocs skips the bodies of pending here-documents, which start after a newline. The
+// command substitutions in the bodies of here-documents with an unquoted delimiter run, so
+// they are recorded.
+func (l *lexer) skipHeredocs() error {
+	for _, doc := range l.heredocs {
+		start, bodyEnd := l.pos, len(l.input)
+		for l.pos < len(l.input) {
+			lineStart, end := l.pos, l.pos
+			for end < len(l.input) && l.input[end] != '\n' {
+				end++
+			}
+			line := strings.TrimLeft(string(l.input[l.pos:end]), "\t")
+			l.pos = min(end+1, len(l.input))
+			if line == doc.delim {
+				bodyEnd = lineStart
+				break
+			}
+		}
+		if doc.expand {
+			if err := l.expansions(l.input[start:bodyEnd]); err != nil {
+				return err
+			}
+		}
+	}
+	l.heredocs = nil
+	return nil
+}
+
+// expansions records the command substitutions in text that is not split into words, such
+// as the body of a here-document or an arithmetic expression.
+func (l *lexer) expansions(text []rune) error {
+	inner := &lexer{input: text}
+	for inner.pos < len(inner.input) {
+		switch r := inner.input[inner.pos]; {
+		case r == '\\':
+			inner.pos += 2
+		case r == '`':
+			end := inner.indexFrom(inner.pos+1, '`')
+			if end < 0 {
+				return fmt.Errorf("%w: unterminated backquote", ErrParse)
+			}
+			inner.substitutions = append(inner.substitutions, string(inner.input[inner.pos+1:end]))
+			inner.pos = end + 1
+		case r == '$' && inner.peek(1) == '(':
+			var discard strings.Builder
+			if err := inner.substitution(&discard); err != nil {
+				return err
+			}
+		default:
+			inner.pos++
+		}
+	}
+	l.substitutions = append(l.substitutions, inner.substitutions...)
+	return nil
+}
+
+// operators lists control and redirection operators, longest first.
+var operators = []struct {
+	text string
+	kind int
+}{
+	{"&&", tokenOperator}, {"||", tokenOperator}, {";;", tokenOperator}, {"|&", tokenOperator},
+	{"<<<", tokenRedirect}, {"<<-", tokenRedirect}, {"&>>", tokenRedirect},
+	{"<<", tokenRedirect}, {">>", tokenRedirect}, {"<&", tokenRedirect}, {">&", tokenRedirect},
+	{"<>", tokenRedirect}, {">|", tokenRedirect}, {"&>", tokenRedirect},
+	{"|", tokenOperator}, {"&", tokenOperator}, {";", tokenOperator},
+	{"(", tokenOperator}, {")", tokenOperator},
+	{"<", tokenRedirect}, {">", tokenRedirect},
+}
+
+// operatorOrWord reads an operator if one starts at the current position, otherwise a word.
+func (l *lexer) operatorOrWord() (token, bool, error) {
+	// Process substitution looks like a redirection but runs a command
+	if (l.peek(0) == '<' || l.peek(0) == '>') && l.peek(1) == '(' {
+		return l.word()
+	}
+
+	// A file descriptor number directly before a redirection, as in 2>&1
+	start := l.pos
+	for l.pos < len(l.input) && l.input[l.pos] >= '0' && l.input[l.pos] <= '9' {
+		l.pos++
+	}
+	if l.pos > start && l.peek(0) != '<' && l.peek(0) != '>' {
+		l.pos = start
+	}
+
+	rest := string(l.input[l.pos:min(l.pos+3, len(l.input))])
+	for _, op := range operators {
+		if strings.HasPrefix(rest, op.text) {
+			l.pos += len([]rune(op.text))
+			return token{kind: op.kind, text: op.text}, true, nil
+		}
+	}
+	l.pos = start
+	return l.word()
+}
+
+// word reads a single shell word, removing quotes and recording substitutions.
+func (l *lexer) word() (token, bool, error) {
+	var b strings.Builder
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			return token{kind: tokenWord, text: b.String()}, true, nil
+		case strings.ContainsRune("|&;()", r):
+			return token{kind: tokenWord, text: b.String()}, true, nil
+		case (r == '<' || r == '>') && l.peek(1) != '(':
+			return token{kind: tokenWord, text: b.String()}, true, nil
+		case r == '\\':
+			if l.pos+1 < len(l.input) {
+				b.WriteRune(l.input[l.pos+1])
+			}
+			l.pos += 2
+		case r == '\'':
+			end := l.indexFrom(l.pos+1, '\'')
+			if end < 0 {
+				return token{}, false, fmt.Errorf("%w: unterminated single quote", ErrParse)
+			}
+			b.WriteString(string(l.input[l.pos+1 : end]))
+			l.pos = end + 1
+		case r == '"':
+			if err := l.doubleQuoted(&b); err != nil {
+				return token{}, false, err
+			}
+		case r == '`':
+			end := l.indexFrom(l.pos+1, '`')
+			if end < 0 {
+				return token{}, false, fmt.Errorf("%w: unterminated backquote", ErrParse)
+			}
+			l.substitutions = append(l.substitutions, string(l.input[l.pos+1:end]))
+			b.WriteString(string(l.input[l.pos : end+1]))
+			l.pos = end + 1
+		case (r == '$' || r == '<' || r == '>') && l.peek(1) == '(':
+			if err := l.substitution(&b); err != nil {
+				return token{}, false, err
+			}
+		default:
+			b.WriteRune(r)
+			l.pos++
+		}
+	}
+	return token{kind: tokenWord, text: b.String()}, true, nil
+}
+
+// doubleQuoted reads a double-quoted string starting at the opening quote.
+func (l *lexer) doubleQuoted(b *strings.Builder) error {
+	l.pos++
+	for l.pos < len(l.input) {
+		r := l.input[l.pos]
+		switch {
+		case r == '"':
+			l.pos++
+			return nil
+		case r == '\\' && l.pos+1 < len(l.input) && strings.ContainsRune("$`\"\\\n", l.input[l.pos+1]):
+			b.WriteRune(l.input[l.pos+1])
+			l.pos += 2
+		case r == '`':
+			end := l.indexFrom(l.pos+1, '`')
+			if end < 0 {
+				return fmt.Errorf("%w: unterminated backquote", ErrParse)
+			}
+			l.substitutions = append(l.substitutions, string(l.input[l.pos+1:end]))
+			b.WriteString(string(l.input[l.pos : end+1]))
+			l.pos = end + 1
+		case r == '$' && l.peek(1) == '(':
+			if err := l.substitution(b); err != nil {
+				return err
+			}
+		default:
+			b.WriteRune(r)
+			l.pos++
+		}
+	}
+	return fmt.Errorf("%w: unterminated double quote", ErrParse)
+}
+
+// substitution reads $(...), <(...) or >(...) starting at the sigil and records the
+// command inside. Arithmetic expansion $((...)) is not a command, but the substitutions
+// inside it are recorded.
+func (l *lexer) substitution(b *strings.Builder) error {
+	start := l.pos
+	arithmetic := l.peek(0) == '$' && l.peek(2) == '('
+	l.pos += 2
+
+	depth := 1
+	for l.pos < len(l.input) && depth > 0 {
+		switch r := l.input[l.pos]; r {
+		case '\\':
+			l.pos++
+		case '\'':
+			end := l.indexFrom(l.pos+1, '\'')
+			if end < 0 {
+				return fmt.Errorf("%w: unterminated single quote", ErrParse)
+			}
+			l.pos = end
+		case '"':
+			var discard strings.Builder
+			if err := l.doubleQuoted(&discard); err != nil {
+				return err
+			}
+			continue
+		case '(':
+			depth++
+		case ')':
+			depth--
+		}
+		l.pos++
+	}
+	if depth > 0 {
+		return fmt.Errorf("%w: unterminated substitution", ErrParse)
+	}
+
+	if arithmetic {
+		if err := l.expansions(l.input[start+3 : max(l.pos-2, start+3)]); err != nil {
+			return err
+		}
+	} else {
+		l.substitutions = append(l.substitutions, string(l.input[start+2:l.pos-1]))
+	}
+	b.WriteString(string(l.input[start:l.pos]))
+	return nil
+}
+
+// indexFrom returns the index of the first r at or after from, or -1.
+func (l *lexer) indexFrom(from int, r rune) int {
+	for i := from; i < len(l.input); i++ {
+		if l.input[i] == r {
+			return i
+		}
+	}
+	return -1
+}
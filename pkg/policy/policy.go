@@ -0,0 +1,390 @@
+// Package policy decides whether a command may run, based on rules loaded from a
+// configuration file. Command lines are split into their individual commands first,
+// so a rule for "rm" also catches "make && rm -rf /" or "echo $(rm -rf /)".
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Action is what happens to a command matched by a rule.
+type Action string
+
+const (
+	Allow   Action = "allow"
+	Deny    Action = "deny"
+	Confirm Action = "require-confirmation"
+)
+
+// severity orders actions from least to most restrictive.
+func (a Action) severity() int {
+	switch a {
+	case Deny:
+		return 2
+	case Confirm:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Rule matches commands and assigns them an action. All conditions that are set must
+// match; a rule without conditions is rejected when the policy is loaded.
+type Rule struct {
+	// Name identifies the rule in decisions and error messages.
+	Name string `json:"name"`
+	// Action is applied to matching commands.
+	Action Action `json:"action"`
+	// Programs are glob patterns for the program name, e.g. "rm" or "python*".
+	// A pattern containing a slash is matched against the program path as written.
+	Programs []string `json:"programs,omitempty"`
+	// Args are glob patterns that must each match at least one argument, e.g. ["push", "--force*"].
+	Args []string `json:"args,omitempty"`
+	// Regex is matched against the command and its arguments joined by spaces.
+	Regex string `json:"regex,omitempty"`
+	// CommandRegex is matched against the complete command line as submitted, which
+	// catches combinations such as "curl ... | sh" that span several commands.
+	CommandRegex string `json:"command_regex,omitempty"`
+	// WorkingDirectories are path glob patterns for the working directory; "**" matches
+	// any number of directories and "~" is the user's home directory.
+	WorkingDirectories []string `json:"working_directories,omitempty"`
+	// Reason is reported to the client when the rule denies a command.
+	Reason string `json:"reason,omitempty"`
+
+	programs     []*regexp.Regexp
+	args         []*regexp.Regexp
+	regex        *regexp.Regexp
+	commandRegex *regexp.Regexp
+	workDirs     []*regexp.Regexp
+}
+
+// Policy is an ordered list of rules. For every command in a command line the first
+// matching rule decides; commands no rule matches get DefaultAction.
+type Policy struct {
+	// DefaultAction applies to commands no rule matches; it defaults to allow.
+	DefaultAction Action `json:"default_action,omitempty"`
+	// Rules are evaluated in order.
+	Rules []*Rule `json:"rules"`
+	// DenyRedirects denies commands that redirect output to a file other than /dev/null,
+	// whatever the rules say about the command itself.
+	DenyRedirects bool `json:"deny_redirects,omitempty"`
+	// DenyAssignments denies commands preceded by variable assignments, as in "PAGER=sh git log",
+	// since variables such as PAGER, GIT_EXTERNAL_DIFF or LD_PRELOAD make programs run others.
+	DenyAssignments bool `json:"deny_assignments,omitempty"`
+}
+
+// Decision is the outcome of checking a command line against a policy.
+type Decision struct {
+	// Action is the most restrictive action of all commands in the command line.
+	Action Action `json:"action"`
+	// Rule is the name of the rule that decided, empty for the default action.
+	Rule string `json:"rule,omitempty"`
+	// Reason explains the decision.
+	Reason string `json:"reason,omitempty"`
+	// Segment is the individual command that led to the decision.
+	Segment string `json:"segment,omitempty"`
+}
+
+// String describes the decision for error messages.
+func (d Decision) String() string {
+	var b strings.Builder
+	if d.Rule != "" {
+		fmt.Fprintf(&b, "rule %q", d.Rule)
+	} else {
+		b.WriteString("default action")
+	}
+	if d.Segment != "" {
+		fmt.Fprintf(&b, " matched %q", d.Segment)
+	}
+	if d.Reason != "" {
+		b.WriteString(": " + d.Reason)
+	}
+	return b.String()
+}
+
+// Load reads a policy from a JSON file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy file: %w", err)
+	}
+	p, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Decode parses and validates a policy in JSON form.
+func Decode(data []byte) (*Policy, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var p Policy
+	if err := decoder.Decode(&p); err != nil {
+		return nil, err
+	}
+	if err := p.compile(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// validAction reports whether a is one of the known actions.
+func validAction(a Action) bool {
+	return a == Allow || a == Deny || a == Confirm
+}
+
+// compile validates the policy and prepares its patterns.
+func (p *Policy) compile() error {
+	if p.DefaultAction == "" {
+		p.DefaultAction = Allow
+	}
+	if !validAction(p.DefaultAction) {
+		return fmt.Errorf("unknown default_action %q", p.DefaultAction)
+	}
+
+	for i, r := range p.Rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if !validAction(r.Action) {
+			return fmt.Errorf("%s: unknown action %q", r.Name, r.Action)
+		}
+		if len(r.Programs) == 0 && len(r.Args) == 0 && r.Regex == "" && r.CommandRegex == "" && len(r.WorkingDirectories) == 0 {
+			return fmt.Errorf("%s: rule has no conditions", r.Name)
+		}
+
+		var err error
+		for _, pattern := range r.Programs {
+			r.programs = append(r.programs, globPattern(pattern, strings.Contains(pattern, "/")))
+		}
+		for _, pattern := range r.Args {
+			r.args = append(r.args, globPattern(pattern, false))
+		}
+		for _, pattern := range r.WorkingDirectories {
+			r.workDirs = append(r.workDirs, globPattern(expandHome(filepath.ToSlash(pattern)), true))
+		}
+		if r.Regex != "" {
+			if r.regex, err = regexp.Compile(r.Regex); err != nil {
+				return fmt.Errorf("%s: invalid regex: %w", r.Name, err)
+			}
+		}
+		if r.CommandRegex != "" {
+			if r.commandRegex, err = regexp.Compile(r.CommandRegex); err != nil {
+				return fmt.Errorf("%s: invalid command_regex: %w", r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Check decides whether command, run through the shell in workDir, may be executed.
+// Every command in the line is checked and the most restrictive outcome wins. Command
+// lines that cannot be parsed are denied.
+func (p *Policy) Check(command string, workDir string) Decision {
+	segments, err := Parse(command)
+	if err != nil {
+		return Decision{Action: Deny, Reason: err.Error()}
+	}
+	return p.decide(segments, command, workDir)
+}
+
+// CheckArgs decides whether a program started directly with args, without a shell, may run.
+func (p *Policy) CheckArgs(args []string, workDir string) Decision {
+	if len(args) == 0 {
+		return p.decide(nil, "", workDir)
+	}
+	segments, err := expand(args, 0)
+	if err != nil {
+		return Decision{Action: Deny, Reason: err.Error()}
+	}
+	return p.decide(segments, Segment{Args: args}.String(), workDir)
+}
+
+// decide evaluates every segment and returns the most restrictive decision.
+func (p *Policy) decide(segments []Segment, command string, workDir string) Decision {
+	workDir = normalizeWorkDir(workDir)
+
+	if len(segments) == 0 {
+		// An empty command line still has to satisfy the default action
+		segments = []Segment{{}}
+	}
+
+	var result *Decision
+	for _, segment := range segments {
+		d := p.evaluate(segment, command, workDir)
+		if result == nil || d.Action.severity() > result.Action.severity() {
+			result = &d
+		}
+	}
+	return *result
+}
+
+// evaluate returns the decision of the first rule matching segment.
+func (p *Policy) evaluate(segment Segment, command string, workDir string) Decision {
+	if p.DenyRedirects {
+		for _, file := range segment.Writes {
+			if file != "/dev/null" {
+				return Decision{Action: Deny, Rule: "deny_redirects", Reason: "output is redirected to " + file, Segment: segment.String()}
+			}
+		}
+	}
+	if p.DenyAssignments && len(segment.Assignments) > 0 {
+		return Decision{Action: Deny, Rule: "deny_assignments", Reason: "variable assignments are not allowed: " + strings.Join(segment.Assignments, " "), Segment: segment.String()}
+	}
+	for _, r := range p.Rules {
+		if r.matches(segment, command, workDir) {
+			return Decision{Action: r.Action, Rule: r.Name, Reason: r.Reason, Segment: segment.String()}
+		}
+	}
+	return Decision{Action: p.DefaultAction, Segment: segment.String()}
+}
+
+// matches reports whether every condition of the rule holds for segment.
+func (r *Rule) matches(segment Segment, command string, workDir string) bool {
+	if len(r.programs) > 0 {
+		if len(segment.Args) == 0 {
+			return false
+		}
+		name, path := programName(segment.Args[0])
+		if !anyMatch(r.programs, name) && !anyMatch(r.programs, path) {
+			return false
+		}
+	}
+
+	var args []string
+	if len(segment.Args) > 1 {
+		args = segment.Args[1:]
+	}
+	for _, pattern := range r.args {
+		if !anyArgMatch(pattern, args) {
+			return false
+		}
+	}
+
+	if r.regex != nil && !r.regex.MatchString(segment.String()) {
+		return false
+	}
+	if r.commandRegex != nil && !r.commandRegex.MatchString(command) {
+		return false
+	}
+	if len(r.workDirs) > 0 && !anyMatch(r.workDirs, workDir) {
+		return false
+	}
+	return true
+}
+
+// anyMatch reports whether s matches one of patterns.
+func anyMatch(patterns []*regexp.Regexp, s string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// anyArgMatch reports whether pattern matches one of args.
+func anyArgMatch(pattern *regexp.Regexp, args []string) bool {
+	for _, arg := range args {
+		if pattern.MatchString(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// programName returns the base name of a program and its path with forward slashes.
+// On Windows names are compared in lower case and without an .exe suffix.
+func programName(program string) (name string, path string) {
+	path = filepath.ToSlash(program)
+	name = filepath.Base(program)
+	if runtime.GOOS == "windows" {
+		name = strings.TrimSuffix(strings.ToLower(name), ".exe")
+		path = strings.ToLower(path)
+	}
+	return name, path
+}
+
+// normalizeWorkDir returns the absolute working directory a command runs in, with forward slashes.
+func normalizeWorkDir(workDir string) string {
+	if strings.TrimSpace(workDir) == "" {
+		workDir, _ = os.Getwd()
+	}
+	if abs, err := filepath.Abs(workDir); err == nil {
+		workDir = abs
+	}
+	return filepath.ToSlash(filepath.Clean(workDir))
+}
+
+// expandHome replaces a leading "~" in pattern with the user's home directory.
+func expandHome(pattern string) string {
+	if pattern != "~" && !strings.HasPrefix(pattern, "~/") {
+		return pattern
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return pattern
+	}
+	return filepath.ToSlash(home) + pattern[1:]
+}
+
+// globPattern compiles a glob into an anchored regular expression. "*" and "?" match
+// any characters, unless path is set: then they stop at "/" and "**" matches across
+// directories, with a trailing "/**" also matching the directory itself.
+func globPattern(glob string, path bool) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	if path && runtime.GOOS == "windows" {
+		b.WriteString("(?i)")
+	}
+
+	runes := []rune(glob)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case path && strings.HasPrefix(string(runes[i:]), "/**") && i+3 == len(runes):
+			b.WriteString("(/.*)?")
+			i += 2
+		case r == '*' && path && i+1 < len(runes) && runes[i+1] == '*':
+			b.WriteString(".*")
+			i++
+		case r == '*':
+			b.WriteString(utils.IfElse(path, "[^/]*", ".*"))
+		case r == '?':
+			b.WriteString(utils.IfElse(path, "[^/]", "."))
+		case r == '[':
+			end := slices.Index(runes[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := string(runes[i+1 : i+1+end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		// Fall back to a literal match for malformed character classes
+		return regexp.MustCompile("^" + regexp.QuoteMeta(glob) + "$")
+	}
+	return re
+}
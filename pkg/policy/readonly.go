@@ -0,0 +1,52 @@
+package policy
+
+// readOnlyPolicy allows programs that only inspect files and the system. Options that
+// make them write files or run other programs are denied before the allow rules are
+// reached, and so are redirecting output to a file and setting environment variables.
+const readOnlyPolicy = `{
+	"default_action": "deny",
+	"deny_redirects": true,
+	"deny_assignments": true,
+	"rules": [
+		{"name": "find-actions", "action": "deny", "programs": ["find"],
+			"regex": " -(delete|exec|execdir|ok|okdir|fprint|fprint0|fprintf|fls)( |$)",
+			"reason": "find may only search"},
+		{"name": "git-writes", "action": "deny", "programs": ["git"],
+			"regex": " (--output(=| |$)|-O|--open-files-in-pager|--ext-diff)",
+			"reason": "git options that write files or run programs are not allowed"},
+		{"name": "output-files", "action": "deny", "programs": ["sort", "tree"],
+			"regex": " (-[^- ]*o|--output)",
+			"reason": "writing output files is not allowed"},
+		{"name": "sort-compress", "action": "deny", "programs": ["sort"],
+			"regex": " --co",
+			"reason": "sort compression programs run programs"},
+		{"name": "file-compile", "action": "deny", "programs": ["file"],
+			"regex": " (-[^- ]*C|--compile)",
+			"reason": "compiling magic files is not allowed"},
+		{"name": "date-set", "action": "deny", "programs": ["date"],
+			"regex": " (-[^- ]*s|--set)",
+			"reason": "setting the clock is not allowed"},
+		{"name": "ripgrep-preprocessor", "action": "deny", "programs": ["rg"],
+			"regex": " --pre(=| |$)",
+			"reason": "ripgrep preprocessors run programs"},
+		{"name": "git-read", "action": "allow", "programs": ["git"],
+			"regex": "^([^ ]*/)?git( -C [^ ]+| --no-pager)* (status|log|diff|show|blame|grep|ls-files|ls-tree|rev-parse|describe|shortlog|cat-file|branch --list|remote -v)( |$)"},
+		{"name": "inspect", "action": "allow", "programs": [
+			"ls", "cat", "head", "tail", "wc", "grep", "egrep", "fgrep", "rg", "find", "tree",
+			"stat", "file", "du", "df", "pwd", "echo", "printf", "true", "false", "test", "[",
+			"diff", "cmp", "md5sum", "sha1sum", "sha256sum", "sha512sum", "cksum",
+			"basename", "dirname", "realpath", "readlink", "nl", "cut", "sort", "tr", "column",
+			"which", "whoami", "id", "uname", "date", "ps", "jq"
+		]}
+	]
+}`
+
+// ReadOnly returns the built-in policy for read-only mode: an allowlist of programs that
+// inspect files and the system without changing them. Everything else is denied.
+func ReadOnly() *Policy {
+	p, err := Decode([]byte(readOnlyPolicy))
+	if err != nil {
+		panic("invalid read-only policy: " + err.Error())
+	}
+	return p
+}
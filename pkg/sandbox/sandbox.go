@@ -0,0 +1,307 @@
+// Package sandbox restricts the file system locations tools may access to a set of
+// allowed root directories, configured on the command line or provided by the MCP client.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"jarvis_mcp/pkg/utils"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// maxLinks bounds the number of dangling symbolic links followed while resolving a path.
+const maxLinks = 40
+
+// Config holds the server-wide sandbox settings.
+type Config struct {
+	// Roots are the directories tools may access, including everything below them.
+	// If empty, the file system is unrestricted unless the client provides roots.
+	Roots []string
+	// ClientRoots asks clients that support it for their roots with roots/list.
+	// Client roots narrow the configured roots, they never extend them.
+	ClientRoots bool
+}
+
+// DefaultConfig returns the configuration used when Configure has not been called.
+func DefaultConfig() Config {
+	return Config{
+		ClientRoots: true,
+	}
+}
+
+var (
+	// config is the active configuration shared by all tools.
+	config = DefaultConfig()
+	// roots are the configured roots with symbolic links resolved.
+	roots []string
+)
+
+// Configure replaces the server-wide sandbox settings. Every root must be an existing directory.
+// It should be called once at startup, before any tool is registered.
+func Configure(c Config) error {
+	resolved := make([]string, 0, len(c.Roots))
+	for _, root := range c.Roots {
+		dir, err := resolveRoot(root)
+		if err != nil {
+			return err
+		}
+		resolved = append(resolved, dir)
+	}
+
+	config = c
+	roots = resolved
+	clientRoots.clear()
+	return nil
+}
+
+// resolveRoot returns the absolute, symlink-free form of an existing directory.
+func resolveRoot(root string) (string, error) {
+	dir, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("invalid root %s: %w", root, err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("invalid root %s: not a directory", root)
+	}
+	return dir, nil
+}
+
+// Resolve returns the absolute form of path and checks that it lies inside an allowed root.
+// When the sandbox is active, symbolic links are resolved first, so neither links nor ".."
+// can escape the roots, and the returned path is the resolved one that should be used for
+// the operation. The path does not need to exist. Paths outside the roots fail with a
+// policy_denied error.
+func Resolve(ctx context.Context, path string) (string, error) {
+	return resolve(ctx, path, true)
+}
+
+// ResolveNoFollow is like Resolve, but keeps a symbolic link in the final element of path,
+// for operations such as renaming that act on the link rather than on its target.
+func ResolveNoFollow(ctx context.Context, path string) (string, error) {
+	return resolve(ctx, path, false)
+}
+
+// resolve implements Resolve and ResolveNoFollow.
+func resolve(ctx context.Context, path string, follow bool) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	allowed, active := allowedRoots(ctx)
+	if !active {
+		return abs, nil
+	}
+
+	var resolved string
+	if follow {
+		resolved, err = resolveLinks(abs, 0)
+	} else {
+		resolved, err = resolveLinks(filepath.Dir(abs), 0)
+		resolved = filepath.Join(resolved, filepath.Base(abs))
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, root := range allowed {
+		if within(resolved, root) {
+			return resolved, nil
+		}
+	}
+	return "", utils.NewCodedError(utils.ErrCodePolicyDenied,
+		fmt.Errorf("%w: %s is outside the allowed roots", utils.ErrPolicyDenied, path))
+}
+
+// WorkDir checks a command's working directory like Resolve. An empty dir, which would run
+// the command in the server's own working directory, becomes the first allowed root
+// while the sandbox is active.
+func WorkDir(ctx context.Context, dir string) (string, error) {
+	if dir != "" {
+		return Resolve(ctx, dir)
+	}
+	allowed, active := allowedRoots(ctx)
+	if !active {
+		return "", nil
+	}
+	if len(allowed) == 0 {
+		return "", utils.NewCodedError(utils.ErrCodePolicyDenied,
+			fmt.Errorf("%w: no allowed roots", utils.ErrPolicyDenied))
+	}
+	return allowed[0], nil
+}
+
+// resolveLinks resolves the symbolic links in an absolute path. Missing trailing elements are
+// kept as they are, so paths about to be created can be checked, but dangling links are followed
+// to where a write through them would end up.
+func resolveLinks(path string, links int) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return resolved, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	if info, err := os.Lstat(path); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if links >= maxLinks {
+			return "", fmt.Errorf("too many links resolving %s", path)
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		return resolveLinks(target, links+1)
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return path, nil
+	}
+	dir, err := resolveLinks(parent, links)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+// within reports whether path is root or lies below it. Both must be clean absolute paths.
+func within(path, root string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// allowedRoots returns the roots in effect for the client session in ctx and whether
+// the sandbox is active at all.
+func allowedRoots(ctx context.Context) ([]string, bool) {
+	client, ok := clientRootsFor(ctx)
+	if !ok {
+		return roots, len(roots) > 0
+	}
+	if len(roots) == 0 {
+		return client, true
+	}
+
+	// Client roots may only narrow the configured ones, so the sandbox is their intersection
+	var allowed []string
+	for _, dir := range client {
+		for _, root := range roots {
+			switch {
+			case within(dir, root):
+				allowed = append(allowed, dir)
+			case within(root, dir):
+				allowed = append(allowed, root)
+			}
+		}
+	}
+	return allowed, true
+}
+
+// rootsCache holds the roots reported by each client session, so roots/list
+// is only sent again after the client announced a change.
+type rootsCache struct {
+	mu    sync.Mutex
+	roots map[string][]string
+}
+
+// clientRoots is the cache shared by all tools.
+var clientRoots = &rootsCache{roots: make(map[string][]string)}
+
+// clear forgets the roots of all sessions.
+func (c *rootsCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	clear(c.roots)
+}
+
+// clientRootsFor returns the roots of the client session in ctx. It returns false if client
+// roots are disabled, the client does not support them or did not report any.
+func clientRootsFor(ctx context.Context) ([]string, bool) {
+	if !config.ClientRoots {
+		return nil, false
+	}
+	session, ok := server.ClientSessionFromContext(ctx).(server.SessionWithRoots)
+	if !ok {
+		return nil, false
+	}
+	if withInfo, hasInfo := session.(server.SessionWithClientInfo); hasInfo && withInfo.GetClientCapabilities().Roots == nil {
+		return nil, false
+	}
+
+	clientRoots.mu.Lock()
+	cached, found := clientRoots.roots[session.SessionID()]
+	clientRoots.mu.Unlock()
+	if found {
+		return cached, len(cached) > 0
+	}
+
+	result, err := session.ListRoots(ctx, mcp.ListRootsRequest{})
+	if err != nil {
+		// Not cached, so the next call asks again
+		return nil, false
+	}
+	var dirs []string
+	for _, root := range result.Roots {
+		if dir, err := rootPath(root.URI); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	clientRoots.mu.Lock()
+	clientRoots.roots[session.SessionID()] = dirs
+	clientRoots.mu.Unlock()
+	return dirs, len(dirs) > 0
+}
+
+// rootPath converts a file:// root URI to a resolved directory path.
+func rootPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "file" {
+		return "", fmt.Errorf("unsupported root URI %s", uri)
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		// file:///C:/dir has the path /C:/dir
+		path = strings.TrimPrefix(path, "/")
+	}
+	return resolveRoot(filepath.FromSlash(path))
+}
+
+// HandleRootsChanged is the handler for notifications/roots/list_changed. It drops the cached
+// roots of the notifying session so they are requested again before the next tool call.
+func HandleRootsChanged(ctx context.Context, notification mcp.JSONRPCNotification) {
+	if session := server.ClientSessionFromContext(ctx); session != nil {
+		clientRoots.mu.Lock()
+		delete(clientRoots.roots, session.SessionID())
+		clientRoots.mu.Unlock()
+	} else {
+		clientRoots.clear()
+	}
+}
@@ -0,0 +1,213 @@
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// setup creates a root directory and a directory outside it, with links between them
+func setup(t *testing.T) (root string, outside string) {
+	t.Helper()
+	base, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = filepath.Join(base, "root")
+	outside = filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "file.txt"), []byte("inside"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("outside"), 0644)
+
+	links := map[string]string{
+		"inside-link":  filepath.Join(root, "file.txt"),
+		"outside-link": filepath.Join(outside, "secret.txt"),
+		"outside-dir":  outside,
+		"dangling":     filepath.Join(outside, "new.txt"),
+		"relative":     "../outside",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("symbolic links not supported: %v", err)
+		}
+	}
+	return root, outside
+}
+
+// TestResolve tests that paths are confined to the allowed roots
+func TestResolve(t *testing.T) {
+	root, outside := setup(t)
+	defer Configure(DefaultConfig())
+	if err := Configure(Config{Roots: []string{root}}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string // empty if denied
+	}{
+		{path: root, want: root},
+		{path: filepath.Join(root, "file.txt"), want: filepath.Join(root, "file.txt")},
+		{path: filepath.Join(root, "sub", "..", "file.txt"), want: filepath.Join(root, "file.txt")},
+		{path: filepath.Join(root, "new", "dir", "file.txt"), want: filepath.Join(root, "new", "dir", "file.txt")},
+		{path: filepath.Join(root, "inside-link"), want: filepath.Join(root, "file.txt")},
+		{path: filepath.Join(root, "outside-link")},
+		{path: filepath.Join(root, "outside-dir", "secret.txt")},
+		{path: filepath.Join(root, "outside-dir", "new.txt")},
+		{path: filepath.Join(root, "dangling")},
+		{path: filepath.Join(root, "relative", "secret.txt")},
+		{path: filepath.Join(root, "..", "outside", "secret.txt")},
+		{path: filepath.Join(outside, "secret.txt")},
+		{path: root + "-sibling"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := Resolve(context.Background(), tt.path)
+			if tt.want == "" {
+				if !errors.Is(err, utils.ErrPolicyDenied) || utils.ClassifyError(err) != utils.ErrCodePolicyDenied {
+					t.Errorf("Resolve() = %q, %v, want policy_denied", got, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Resolve() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	// Renaming a link moves the link itself, which stays inside the root
+	if got, err := ResolveNoFollow(context.Background(), filepath.Join(root, "outside-link")); err != nil || got != filepath.Join(root, "outside-link") {
+		t.Errorf("ResolveNoFollow() = %q, %v", got, err)
+	}
+	if _, err := ResolveNoFollow(context.Background(), filepath.Join(root, "outside-dir", "secret.txt")); err == nil {
+		t.Error("ResolveNoFollow() through a link to outside succeeded")
+	}
+}
+
+// TestUnrestricted tests that paths are only made absolute without roots
+func TestUnrestricted(t *testing.T) {
+	_, outside := setup(t)
+	Configure(DefaultConfig())
+
+	got, err := Resolve(context.Background(), filepath.Join(outside, "secret.txt"))
+	if err != nil || got != filepath.Join(outside, "secret.txt") {
+		t.Errorf("Resolve() = %q, %v", got, err)
+	}
+	if dir, err := WorkDir(context.Background(), ""); err != nil || dir != "" {
+		t.Errorf("WorkDir() = %q, %v, want empty", dir, err)
+	}
+}
+
+// TestWorkDir tests the default working directory inside the sandbox
+func TestWorkDir(t *testing.T) {
+	root, outside := setup(t)
+	defer Configure(DefaultConfig())
+	Configure(Config{Roots: []string{root}})
+
+	if dir, err := WorkDir(context.Background(), ""); err != nil || dir != root {
+		t.Errorf("WorkDir() = %q, %v, want %q", dir, err, root)
+	}
+	if _, err := WorkDir(context.Background(), outside); utils.ClassifyError(err) != utils.ErrCodePolicyDenied {
+		t.Errorf("WorkDir(outside) error = %v, want policy_denied", err)
+	}
+}
+
+// TestConfigure tests validation of the configured roots
+func TestConfigure(t *testing.T) {
+	root, _ := setup(t)
+	defer Configure(DefaultConfig())
+
+	for _, invalid := range []string{filepath.Join(root, "missing"), filepath.Join(root, "file.txt")} {
+		if err := Configure(Config{Roots: []string{invalid}}); err == nil {
+			t.Errorf("Configure(%s) succeeded, want error", invalid)
+		}
+	}
+}
+
+// fakeClient answers roots/list requests and counts them
+type fakeClient struct {
+	roots []string
+	calls int
+}
+
+func (c *fakeClient) ListRoots(ctx context.Context, request mcp.ListRootsRequest) (*mcp.ListRootsResult, error) {
+	c.calls++
+	result := &mcp.ListRootsResult{}
+	for _, dir := range c.roots {
+		result.Roots = append(result.Roots, mcp.Root{URI: (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}).String()})
+	}
+	return result, nil
+}
+
+// contextWithClient returns a context carrying a client session that supports roots
+func contextWithClient(client *fakeClient) context.Context {
+	session := server.NewInProcessSessionWithHandlers("test", nil, nil, client)
+	session.SetClientCapabilities(mcp.ClientCapabilities{Roots: &struct {
+		ListChanged bool `json:"listChanged,omitempty"`
+	}{ListChanged: true}})
+	return server.NewMCPServer("test", "1.0.0").WithContext(context.Background(), session)
+}
+
+// TestClientRoots tests roots reported by the client with roots/list
+func TestClientRoots(t *testing.T) {
+	root, outside := setup(t)
+	defer Configure(DefaultConfig())
+	Configure(DefaultConfig())
+
+	client := &fakeClient{roots: []string{filepath.Join(root, "sub")}}
+	ctx := contextWithClient(client)
+
+	if _, err := Resolve(ctx, filepath.Join(root, "sub", "new.txt")); err != nil {
+		t.Errorf("Resolve() inside client root error = %v", err)
+	}
+	if _, err := Resolve(ctx, filepath.Join(root, "file.txt")); err == nil {
+		t.Error("Resolve() outside client root succeeded")
+	}
+	if client.calls != 1 {
+		t.Errorf("roots/list sent %d times, want 1", client.calls)
+	}
+
+	// After a change notification the roots are requested again
+	client.roots = []string{root}
+	HandleRootsChanged(ctx, mcp.JSONRPCNotification{})
+	if _, err := Resolve(ctx, filepath.Join(root, "file.txt")); err != nil {
+		t.Errorf("Resolve() after roots changed error = %v", err)
+	}
+	if client.calls != 2 {
+		t.Errorf("roots/list sent %d times, want 2", client.calls)
+	}
+
+	// Client roots cannot widen the configured ones
+	Configure(Config{Roots: []string{filepath.Join(root, "sub")}, ClientRoots: true})
+	client.roots = []string{filepath.Dir(root), outside}
+	if _, err := Resolve(ctx, filepath.Join(root, "sub", "new.txt")); err != nil {
+		t.Errorf("Resolve() inside configured root error = %v", err)
+	}
+	for _, path := range []string{filepath.Join(root, "file.txt"), filepath.Join(outside, "secret.txt")} {
+		if _, err := Resolve(ctx, path); err == nil {
+			t.Errorf("Resolve(%s) outside configured root succeeded", path)
+		}
+	}
+
+	// Disabled client roots are not requested
+	Configure(Config{ClientRoots: false})
+	calls := client.calls
+	if _, err := Resolve(ctx, filepath.Join(outside, "secret.txt")); err != nil {
+		t.Errorf("Resolve() without sandbox error = %v", err)
+	}
+	if client.calls != calls {
+		t.Error("roots/list sent with client roots disabled")
+	}
+}
@@ -0,0 +1,35 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetCloseSession returns the tool and handler for closing a persistent shell session.
+func GetCloseSession() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("close_session",
+		mcp.WithDescription("Close a persistent shell session and kill every process it started"),
+		mcp.WithString("session_id",
+			mcp.Required(),
+			mcp.Description("ID of the session returned by open_session"),
+		),
+	), closeSessionHandler
+}
+
+// closeSessionHandler terminates the session shell.
+func closeSessionHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["session_id"].(string)
+	if !ok {
+		return nil, errors.New("session_id is required")
+	}
+
+	if err := sessions.close(id); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultText("Session " + id + " closed"), nil
+}
@@ -0,0 +1,36 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetCloseTerminal returns the tool and handler for closing an interactive terminal.
+func GetCloseTerminal() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("close_terminal",
+		mcp.WithDescription("Close a terminal opened with open_terminal and kill every process running in it"),
+		mcp.WithString("terminal_id",
+			mcp.Required(),
+			mcp.Description("ID of the terminal returned by open_terminal"),
+		),
+	), closeTerminalHandler
+}
+
+// closeTerminalHandler kills the terminal's programs and returns their final state.
+func closeTerminalHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["terminal_id"].(string)
+	if !ok {
+		return nil, errors.New("terminal_id is required")
+	}
+
+	info, err := terminals.close(id)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(info)
+}
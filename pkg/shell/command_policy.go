@@ -0,0 +1,69 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/policy"
+	"jarvis_mcp/pkg/utils"
+)
+
+// readOnlyPolicy is the allowlist enforced in read-only mode.
+var readOnlyPolicy = policy.ReadOnly()
+
+// dangerousPolicy lists the commands that require confirmation when ConfirmDangerous is set.
+var dangerousPolicy = policy.Dangerous()
+
+// checkCommand consults the configured command policies before cmd is run through the
+// shell in workDir. It returns nil if there is no policy, the policies allow the command,
+// or a policy requires confirmation and the user approved it.
+func checkCommand(ctx context.Context, cmd string, workDir string) error {
+	return checkPolicies(ctx, cmd, workDir, func(p *policy.Policy) policy.Decision {
+		return p.Check(cmd, workDir)
+	})
+}
+
+// checkProgram consults the configured command policies before a program is started
+// directly with args, without a shell.
+func checkProgram(ctx context.Context, args []string, workDir string) error {
+	return checkPolicies(ctx, displayCommand(args[0], args[1:]), workDir, func(p *policy.Policy) policy.Decision {
+		return p.CheckArgs(args, workDir)
+	})
+}
+
+// checkPolicies applies check to the read-only allowlist, if enabled, to the configured policy
+// and to the built-in list of dangerous commands, if enabled. The user is asked at most once.
+func checkPolicies(ctx context.Context, cmd string, workDir string, check func(*policy.Policy) policy.Decision) error {
+	// The allowlist comes first, so commands outside it are never offered for confirmation
+	if config.ReadOnly {
+		if err := policyError(ctx, cmd, workDir, check(readOnlyPolicy)); err != nil {
+			return err
+		}
+	}
+	if config.Policy != nil {
+		if decision := check(config.Policy); decision.Action != policy.Allow || !config.ConfirmDangerous {
+			return policyError(ctx, cmd, workDir, decision)
+		}
+	}
+	if !config.ConfirmDangerous {
+		return nil
+	}
+	return policyError(ctx, cmd, workDir, check(dangerousPolicy))
+}
+
+// policyError turns a policy decision into the error reported to the client,
+// asking the user first if the decision requires confirmation.
+func policyError(ctx context.Context, cmd string, workDir string, decision policy.Decision) error {
+	switch decision.Action {
+	case policy.Deny:
+		return utils.NewCodedError(utils.ErrCodePolicyDenied,
+			fmt.Errorf("%w: %s", utils.ErrPolicyDenied, decision))
+	case policy.Confirm:
+		message := fmt.Sprintf("Run command?\n\n%s\n\nWorking directory: %s\nRequired by %s",
+			cmd, utils.IfElse(workDir == "", "(server default)", workDir), decision)
+		if err := confirm.Ask(ctx, message); err != nil {
+			return fmt.Errorf("%w: %s", err, decision)
+		}
+	}
+	return nil
+}
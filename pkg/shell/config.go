@@ -0,0 +1,70 @@
+package shell
+
+import (
+	"jarvis_mcp/pkg/policy"
+	"time"
+)
+
+// Config holds the server-wide settings for command execution.
+type Config struct {
+	// DefaultTimeout is applied when a tool call does not specify timeout_seconds.
+	DefaultTimeout time.Duration
+	// MaxTimeout is the upper bound for any requested timeout. Zero means unlimited.
+	MaxTimeout time.Duration
+	// MaxOutputBytes caps each output stream in structured results. Zero means unlimited.
+	MaxOutputBytes int
+	// JobBufferBytes is how much recent output each background job and terminal keeps. Zero means unlimited.
+	JobBufferBytes int
+	// JobTTL is how long a finished background job, or a terminal whose program exited, is kept before it is removed.
+	JobTTL time.Duration
+	// MaxJobs limits the number of background jobs running at once. Zero means unlimited.
+	MaxJobs int
+	// MaxSessions limits the number of persistent shell sessions open at once. Zero means unlimited.
+	MaxSessions int
+	// MaxTerminals limits the number of interactive terminals open at once. Zero means unlimited.
+	MaxTerminals int
+	// Policy decides which commands may run. Nil allows every command.
+	Policy *policy.Policy
+	// ReadOnly additionally restricts commands to the built-in allowlist of programs that do not change anything.
+	ReadOnly bool
+	// ConfirmDangerous asks the user before commands that destroy data irrecoverably, such as
+	// "rm -rf" or "git push --force", even without a policy.
+	ConfirmDangerous bool
+}
+
+// DefaultConfig returns the configuration used when Configure has not been called.
+func DefaultConfig() Config {
+	return Config{
+		DefaultTimeout:   60 * time.Second,
+		MaxTimeout:       10 * time.Minute,
+		MaxOutputBytes:   1 << 20,
+		JobBufferBytes:   1 << 20,
+		JobTTL:           30 * time.Minute,
+		MaxJobs:          16,
+		MaxSessions:      8,
+		MaxTerminals:     8,
+		ConfirmDangerous: true,
+	}
+}
+
+// config is the active configuration shared by all shell tools.
+var config = DefaultConfig()
+
+// Configure replaces the server-wide command execution settings.
+// It should be called once at startup, before any tool is registered.
+func Configure(c Config) {
+	config = c
+}
+
+// resolveTimeout turns the timeout requested by a tool call into the effective timeout,
+// falling back to the configured default and capping it at the configured maximum.
+func resolveTimeout(requested time.Duration) time.Duration {
+	timeout := requested
+	if timeout <= 0 {
+		timeout = config.DefaultTimeout
+	}
+	if config.MaxTimeout > 0 && (timeout <= 0 || timeout > config.MaxTimeout) {
+		timeout = config.MaxTimeout
+	}
+	return timeout
+}
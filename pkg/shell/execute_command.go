@@ -2,7 +2,12 @@ package shell
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/sandbox"
+	"jarvis_mcp/pkg/utils"
+	"time"
 
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
@@ -19,27 +24,139 @@ func GetExecuteCommand() (tool mcp.Tool, handler server.ToolHandlerFunc) {
 		mcp.WithString("working directory",
 			mcp.Description("Working directory for the command"),
 		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("Maximum time in seconds the command may run before it is killed; defaults to the server setting"),
+			mcp.Min(0),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("Result format: 'text' for a readable summary with merged output, 'json' for separate stdout, stderr, exit code and timing"),
+			mcp.Enum("text", "json"),
+			mcp.DefaultString("text"),
+		),
+		mcp.WithString("session_id",
+			mcp.Description("Run the command in a persistent shell session opened with open_session, keeping its working directory and environment"),
+		),
 	), executeCommandHandler
 }
 
-// executeCommandHandler executes OS commands specified by the user and returns the command output.
-// It takes the command string from the request parameters, runs it via the shell, and handles
-// optional working directory configuration. The function captures both stdout and stderr output.
+// executeCommandHandler runs the command of the request through the shell, optionally in a
+// working directory or a persistent session, and returns its stdout and stderr. Failed, timed
+// out or cancelled commands are reported as tool errors carrying the partial output and an
+// error code; only malformed requests produce protocol errors. With output_format "json" the
+// result is returned as both JSON text and structured content.
 func executeCommandHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	cmd, ok := request.Params.Arguments["command"].(string)
+	cmd, ok := request.GetArguments()["command"].(string)
 	if !ok {
 		return nil, errors.New("command must be a string")
 	}
 
 	workDir := ""
-	if workDirVal, ok := request.Params.Arguments["working directory"].(string); ok {
+	if workDirVal, ok := request.GetArguments()["working directory"].(string); ok {
 		workDir = workDirVal
 	}
 
-	result, err := executeCommand(cmd, workDir)
+	timeout := resolveTimeout(time.Duration(request.GetFloat("timeout_seconds", 0) * float64(time.Second)))
+
+	jsonOutput := request.GetString("output_format", "text") == "json"
+
+	// Session commands without a working directory run where the session currently is
+	sessionID := request.GetString("session_id", "")
+	if sessionID == "" || workDir != "" {
+		dir, err := sandbox.WorkDir(ctx, workDir)
+		if err != nil {
+			return utils.NewToolResultError(err, ""), nil
+		}
+		workDir = dir
+	}
+
+	if err := checkCommand(ctx, cmd, workDir); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if sessionID != "" {
+		return executeInSession(ctx, sessionID, cmd, workDir, timeout, jsonOutput)
+	}
+
+	if jsonOutput {
+		return executeCommandJSON(ctx, cmd, workDir, timeout)
+	}
+
+	result, err := executeCommand(ctx, cmd, workDir, timeout)
 	if err != nil {
-		return nil, err
+		return utils.NewToolResultError(err, result), nil
 	}
 
 	return mcp.NewToolResultText(result), nil
 }
+
+// executeCommandJSON runs the command with separate output streams and returns the
+// commandResult as structured content. Failed, timed out or cancelled commands carry
+// an error code and are flagged with IsError so the client does not have to inspect the exit code.
+func executeCommandJSON(ctx context.Context, cmd string, workDir string, timeout time.Duration) (*mcp.CallToolResult, error) {
+	result, err := runCommand(ctx, cmd, workDir, timeout, config.MaxOutputBytes)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return newCommandJSONResult(result)
+}
+
+// newCommandJSONResult wraps a commandResult as JSON text and structured content.
+func newCommandJSONResult(result *commandResult) (*mcp.CallToolResult, error) {
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return nil, fmt.Errorf("error formatting command result: %v", err)
+	}
+
+	toolResult := mcp.NewToolResultStructured(result, string(jsonData))
+	toolResult.IsError = result.ErrorCode != ""
+	return toolResult, nil
+}
+
+// executeInSession runs the command in a persistent session and reports it in the requested format.
+func executeInSession(ctx context.Context, sessionID string, cmd string, workDir string, timeout time.Duration, jsonOutput bool) (*mcp.CallToolResult, error) {
+	s, err := sessions.get(sessionID)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	result, err := s.run(ctx, cmd, workDir, timeout, config.MaxOutputBytes)
+	if result == nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if jsonOutput {
+		if err != nil {
+			result.Error = err.Error()
+		}
+		return newCommandJSONResult(result)
+	}
+
+	text, resultErr := formatCommandResult(cmd, timeout, result)
+	if err == nil {
+		err = resultErr
+	}
+	if err != nil {
+		return utils.NewToolResultError(err, text), nil
+	}
+	return mcp.NewToolResultText(text), nil
+}
+
+// formatCommandResult renders a commandResult in the same text form as executeCommand,
+// with stdout followed by stderr, and returns the matching error for failed commands.
+func formatCommandResult(cmd string, timeout time.Duration, result *commandResult) (string, error) {
+	output := result.Stdout + result.Stderr
+
+	switch {
+	case result.TimedOut:
+		return fmt.Sprintf("Command timed out after %s: %s\n\nPartial output:\n%s", timeout, cmd, output),
+			fmt.Errorf("%w after %s", ErrTimedOut, timeout)
+	case result.Cancelled:
+		return fmt.Sprintf("Command cancelled: %s\n\nPartial output:\n%s", cmd, output), ErrCancelled
+	case result.ExitCode != 0:
+		err := utils.NewCodedError(utils.ErrCodeCommandFailed, fmt.Errorf("exit status %d", result.ExitCode))
+		return fmt.Sprintf("Command failed: %s\n\nOutput:\n%s\n\nError: %v", cmd, output, err), err
+	}
+
+	return fmt.Sprintf("Command executed successfully: %s\n\nOutput:\n%s", cmd, output), nil
+}
@@ -0,0 +1,36 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetJobStatus returns the tool and handler for inspecting a background job.
+func GetJobStatus() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("job_status",
+		mcp.WithDescription("Get the state, exit code and output size of a background job"),
+		mcp.WithString("job_id",
+			mcp.Required(),
+			mcp.Description("ID of the job returned by start_job"),
+		),
+	), jobStatusHandler
+}
+
+// jobStatusHandler returns the current status of a background job.
+func jobStatusHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["job_id"].(string)
+	if !ok {
+		return nil, errors.New("job_id is required")
+	}
+
+	j, err := jobs.get(id)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(j.status())
+}
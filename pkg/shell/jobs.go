@@ -0,0 +1,429 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"jarvis_mcp/pkg/utils"
+	"os/exec"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrJobNotFound is returned when a job ID is unknown or the job has already been cleaned up.
+var ErrJobNotFound = utils.NewCodedError(utils.ErrCodeNotFound, errors.New("job not found"))
+
+// Job states reported by jobStatus.
+const (
+	jobRunning = "running"
+	jobExited  = "exited"
+	jobKilled  = "killed"
+)
+
+// ringBuffer keeps the most recent output of a job and addresses it by absolute byte offset,
+// so readers can resume where they left off even after older output has been discarded.
+type ringBuffer struct {
+	data  []byte // the bytes held; once limit bytes are held, a circle starting at head
+	head  int    // index in data of the oldest byte
+	limit int
+	total int64 // total bytes ever written; the offset just past the last byte
+}
+
+// write appends p, overwriting the oldest bytes once the buffer holds limit bytes.
+func (r *ringBuffer) write(p []byte) {
+	r.total += int64(len(p))
+	if r.limit <= 0 {
+		r.data = append(r.data, p...)
+		return
+	}
+	if len(p) >= r.limit {
+		r.data = append(r.data[:0], p[len(p)-r.limit:]...)
+		r.head = 0
+		return
+	}
+	if n := min(len(p), r.limit-len(r.data)); n > 0 {
+		r.data = append(r.data, p[:n]...)
+		p = p[n:]
+	}
+	for len(p) > 0 {
+		n := copy(r.data[r.head:], p)
+		p = p[n:]
+		r.head = (r.head + n) % len(r.data)
+	}
+}
+
+// start returns the absolute offset of the oldest byte still held.
+func (r *ringBuffer) start() int64 {
+	return r.total - int64(len(r.data))
+}
+
+// read returns up to maxBytes starting at offset, the offset to continue from,
+// and how many requested bytes were already discarded.
+func (r *ringBuffer) read(offset int64, maxBytes int) (data []byte, next int64, dropped int64) {
+	if offset < r.start() {
+		dropped = r.start() - offset
+		offset = r.start()
+	}
+	if offset > r.total {
+		offset = r.total
+	}
+	from := int(offset - r.start())
+	to := len(r.data)
+	if maxBytes > 0 && to-from > maxBytes {
+		to = from + maxBytes
+	}
+	data = make([]byte, 0, to-from)
+	for i := from; i < to; {
+		// The bytes from head to the end of data come first, then those before head
+		j := (r.head + i) % len(r.data)
+		end := min(len(r.data), j+to-i)
+		data = append(data, r.data[j:end]...)
+		i += end - j
+	}
+	return data, offset + int64(to-from), dropped
+}
+
+// job is a command running in the background, detached from the tool call that started it.
+type job struct {
+	id         string
+	command    string
+	workDir    string
+	cmd        *exec.Cmd
+	stdin      io.WriteCloser
+	cancel     context.CancelFunc
+	startedAt  time.Time
+	ttl        time.Duration // how long the job is kept after it finishes
+	done       chan struct{}
+	mu         sync.Mutex
+	output     ringBuffer
+	updated    chan struct{} // closed and replaced whenever output is written or the job ends
+	finishedAt time.Time
+	exitCode   int
+	signal     string
+	killed     bool
+}
+
+// Write implements io.Writer for the job's combined stdout and stderr.
+func (j *job) Write(p []byte) (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.output.write(p)
+	j.notifyLocked()
+	return len(p), nil
+}
+
+// notifyLocked wakes up readers waiting for new output. j.mu must be held.
+func (j *job) notifyLocked() {
+	close(j.updated)
+	j.updated = make(chan struct{})
+}
+
+// jobStatus is the snapshot of a job returned to clients.
+type jobStatus struct {
+	ID          string `json:"id"`
+	Command     string `json:"command"`
+	WorkDir     string `json:"working_directory,omitempty"`
+	State       string `json:"state"`
+	PID         int    `json:"pid"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	Signal      string `json:"signal,omitempty"`
+	StartedAt   string `json:"started_at"`
+	FinishedAt  string `json:"finished_at,omitempty"`
+	DurationMs  int64  `json:"duration_ms"`
+	OutputBytes int64  `json:"output_bytes"`
+}
+
+// status returns a snapshot of the job's current state.
+func (j *job) status() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := jobStatus{
+		ID:          j.id,
+		Command:     j.command,
+		WorkDir:     j.workDir,
+		State:       jobRunning,
+		PID:         j.cmd.Process.Pid,
+		StartedAt:   j.startedAt.Format(time.RFC3339),
+		DurationMs:  time.Since(j.startedAt).Milliseconds(),
+		OutputBytes: j.output.total,
+	}
+	if !j.finishedAt.IsZero() {
+		status.State = utils.IfElse(j.killed, jobKilled, jobExited)
+		exitCode := j.exitCode
+		status.ExitCode = &exitCode
+		status.Signal = j.signal
+		status.FinishedAt = j.finishedAt.Format(time.RFC3339)
+		status.DurationMs = j.finishedAt.Sub(j.startedAt).Milliseconds()
+	}
+	return status
+}
+
+// jobOutput is a window of a job's output returned by readJobOutput.
+type jobOutput struct {
+	ID           string `json:"id"`
+	Output       string `json:"output"`
+	Offset       int64  `json:"offset"`
+	NextOffset   int64  `json:"next_offset"`
+	DroppedBytes int64  `json:"dropped_bytes"`
+	State        string `json:"state"`
+	EOF          bool   `json:"eof"`
+}
+
+// jobManager tracks background jobs and removes finished ones after a TTL.
+type jobManager struct {
+	mu     sync.Mutex
+	jobs   map[string]*job
+	nextID int
+	// starting counts the jobs being started, which take a slot of the limit until they are added.
+	starting int
+}
+
+// jobs is the process-wide job registry shared by all job tools.
+var jobs = &jobManager{jobs: make(map[string]*job)}
+
+// start launches cmd in the background through the platform shell.
+func (m *jobManager) start(cmd string, workDir string) (*job, error) {
+	m.mu.Lock()
+	running := m.starting
+	for _, j := range m.jobs {
+		if j.status().State == jobRunning {
+			running++
+		}
+	}
+	if config.MaxJobs > 0 && running >= config.MaxJobs {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many running jobs (limit %d)", config.MaxJobs)
+	}
+	m.starting++
+	m.mu.Unlock()
+	started := false
+	defer func() {
+		if !started {
+			m.mu.Lock()
+			m.starting--
+			m.mu.Unlock()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	command, err := newShellCommand(ctx, cmd, workDir)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	j := &job{
+		command: cmd,
+		workDir: workDir,
+		cmd:     command,
+		cancel:  cancel,
+		ttl:     config.JobTTL,
+		done:    make(chan struct{}),
+		output:  ringBuffer{limit: config.JobBufferBytes},
+		updated: make(chan struct{}),
+	}
+	command.Stdout = j
+	command.Stderr = j
+
+	j.stdin, err = command.StdinPipe()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	if err := command.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	j.startedAt = time.Now()
+
+	m.mu.Lock()
+	m.starting--
+	m.nextID++
+	j.id = "job-" + strconv.Itoa(m.nextID)
+	m.jobs[j.id] = j
+	m.mu.Unlock()
+	started = true
+
+	go m.wait(j)
+	return j, nil
+}
+
+// wait records the job's exit status and schedules its removal.
+func (m *jobManager) wait(j *job) {
+	command := j.cmd
+	command.Wait()
+	j.cancel()
+
+	j.mu.Lock()
+	j.finishedAt = time.Now()
+	j.exitCode = command.ProcessState.ExitCode()
+	j.signal = exitSignal(command.ProcessState)
+	j.notifyLocked()
+	j.mu.Unlock()
+	close(j.done)
+
+	if j.ttl > 0 {
+		time.AfterFunc(j.ttl, func() { m.remove(j.id) })
+	}
+}
+
+// get looks up a job by ID.
+func (m *jobManager) get(id string) (*job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return j, nil
+}
+
+// remove forgets a job.
+func (m *jobManager) remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.jobs, id)
+}
+
+// list returns the status of every known job, ordered by start time.
+func (m *jobManager) list() []jobStatus {
+	m.mu.Lock()
+	all := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		all = append(all, j)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(all, func(a, b int) bool { return all[a].startedAt.Before(all[b].startedAt) })
+
+	statuses := make([]jobStatus, 0, len(all))
+	for _, j := range all {
+		statuses = append(statuses, j.status())
+	}
+	return statuses
+}
+
+// readJobOutput returns up to maxBytes of output starting at offset. If no output is
+// available yet and the job is still running, it waits up to wait for more to arrive.
+func readJobOutput(ctx context.Context, id string, offset int64, maxBytes int, wait time.Duration) (*jobOutput, error) {
+	j, err := jobs.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	j.mu.Lock()
+	if offset >= j.output.total && j.finishedAt.IsZero() && wait > 0 {
+		updated := j.updated
+		j.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-updated:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+
+		j.mu.Lock()
+	}
+	data, next, dropped := j.output.read(offset, maxBytes)
+	finished := !j.finishedAt.IsZero()
+	eof := finished && next == j.output.total
+	j.mu.Unlock()
+
+	return &jobOutput{
+		ID:           id,
+		Output:       string(data),
+		Offset:       next - int64(len(data)),
+		NextOffset:   next,
+		DroppedBytes: dropped,
+		State:        j.status().State,
+		EOF:          eof,
+	}, nil
+}
+
+// inputTimeout bounds how long sendJobInput waits for a job to read its input.
+const inputTimeout = 10 * time.Second
+
+// sendJobInput writes input to the job's stdin and optionally closes it afterwards. It gives up
+// when ctx is done or the job does not read the input within inputTimeout, as a job that does
+// not read stdin would otherwise block the call once the pipe is full.
+func sendJobInput(ctx context.Context, id string, input string, closeStdin bool) error {
+	j, err := jobs.get(id)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-j.done:
+		return fmt.Errorf("job %s has already finished", id)
+	default:
+	}
+
+	if input != "" {
+		ctx, cancel := context.WithTimeout(ctx, inputTimeout)
+		defer cancel()
+		if err := writeInput(ctx, j.stdin, input); err != nil {
+			return fmt.Errorf("error writing to job %s, part of the input may have been written: %w", id, err)
+		}
+	}
+	if closeStdin {
+		return j.stdin.Close()
+	}
+	return nil
+}
+
+// writeInput writes input to w and returns ctx's error if ctx is done first. Pipes that support
+// deadlines are unblocked then; on others the write is left to finish in the background.
+func writeInput(ctx context.Context, w io.Writer, input string) error {
+	deadline, ok := w.(interface{ SetWriteDeadline(time.Time) error })
+	if ok && deadline.SetWriteDeadline(time.Time{}) != nil {
+		ok = false
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		_, err := io.WriteString(w, input)
+		written <- err
+	}()
+
+	select {
+	case err := <-written:
+		return err
+	case <-ctx.Done():
+		if ok {
+			deadline.SetWriteDeadline(time.Now())
+		}
+		return ctx.Err()
+	}
+}
+
+// killJob kills the job's whole process group and waits briefly for it to exit.
+func killJob(id string) (*jobStatus, error) {
+	j, err := jobs.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-j.done:
+	default:
+		j.mu.Lock()
+		j.killed = true
+		j.mu.Unlock()
+		j.cancel()
+
+		select {
+		case <-j.done:
+		case <-time.After(waitDelay + time.Second):
+		}
+	}
+
+	status := j.status()
+	return &status, nil
+}
@@ -0,0 +1,36 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetKillJob returns the tool and handler for stopping a background job.
+func GetKillJob() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("kill_job",
+		mcp.WithDescription("Kill a background job and every process it started"),
+		mcp.WithString("job_id",
+			mcp.Required(),
+			mcp.Description("ID of the job returned by start_job"),
+		),
+	), killJobHandler
+}
+
+// killJobHandler kills the job and returns its final status.
+func killJobHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["job_id"].(string)
+	if !ok {
+		return nil, errors.New("job_id is required")
+	}
+
+	status, err := killJob(id)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(status)
+}
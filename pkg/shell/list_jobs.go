@@ -0,0 +1,20 @@
+package shell
+
+import (
+	"context"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetListJobs returns the tool and handler for listing background jobs.
+func GetListJobs() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("list_jobs",
+		mcp.WithDescription("List running background jobs and finished jobs that have not been cleaned up yet"),
+	), listJobsHandler
+}
+
+// listJobsHandler returns the status of every known job.
+func listJobsHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return mcp.NewToolResultJSON(map[string]any{"jobs": jobs.list()})
+}
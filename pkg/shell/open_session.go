@@ -0,0 +1,40 @@
+package shell
+
+import (
+	"context"
+	"jarvis_mcp/pkg/sandbox"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetOpenSession returns the tool and handler for opening a persistent shell session.
+func GetOpenSession() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("open_session",
+		mcp.WithDescription("Open a persistent shell session. Pass the returned session_id to execute_command to keep the working directory, environment variables and activated virtualenvs between commands"),
+		mcp.WithString("working directory",
+			mcp.Description("Initial working directory for the session"),
+		),
+	), openSessionHandler
+}
+
+// openSessionHandler starts a new session shell and returns its ID.
+func openSessionHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	workDir := ""
+	if workDirVal, ok := request.GetArguments()["working directory"].(string); ok {
+		workDir = workDirVal
+	}
+
+	workDir, err := sandbox.WorkDir(ctx, workDir)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	s, err := sessions.open(workDir)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(s.info())
+}
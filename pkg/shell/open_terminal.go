@@ -0,0 +1,72 @@
+package shell
+
+import (
+	"context"
+	"fmt"
+	"jarvis_mcp/pkg/sandbox"
+	"jarvis_mcp/pkg/utils"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetOpenTerminal returns the tool and handler for starting a program in an interactive terminal.
+func GetOpenTerminal() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("open_terminal",
+		mcp.WithDescription("Start a program in a pseudo-terminal for interactive use, e.g. REPLs, ssh, pagers, editors or password prompts. Use write_terminal to type into it and read_terminal to see its output"),
+		mcp.WithString("command",
+			mcp.Description("Command to run in the terminal; defaults to an interactive shell"),
+		),
+		mcp.WithString("working directory",
+			mcp.Description("Working directory for the program"),
+		),
+		mcp.WithNumber("rows",
+			mcp.Description("Terminal height in lines (default 24)"),
+			mcp.Min(1),
+			mcp.Max(maxTerminalSize),
+		),
+		mcp.WithNumber("cols",
+			mcp.Description("Terminal width in columns (default 80)"),
+			mcp.Min(1),
+			mcp.Max(maxTerminalSize),
+		),
+	), openTerminalHandler
+}
+
+// openTerminalHandler starts the program and returns the new terminal's ID.
+func openTerminalHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	cmd := request.GetString("command", "")
+	workDir := ""
+	if workDirVal, ok := request.GetArguments()["working directory"].(string); ok {
+		workDir = workDirVal
+	}
+	rows := request.GetInt("rows", defaultTerminalRows)
+	cols := request.GetInt("cols", defaultTerminalCols)
+	if rows <= 0 || cols <= 0 || rows > maxTerminalSize || cols > maxTerminalSize {
+		return nil, fmt.Errorf("rows and cols must be between 1 and %d", maxTerminalSize)
+	}
+
+	workDir, err := sandbox.WorkDir(ctx, workDir)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	// Keystrokes typed into the terminal later cannot be checked, only the program it starts
+	var policyErr error
+	if strings.TrimSpace(cmd) == "" {
+		policyErr = checkProgram(ctx, []string{interactiveShell()}, workDir)
+	} else {
+		policyErr = checkCommand(ctx, cmd, workDir)
+	}
+	if policyErr != nil {
+		return utils.NewToolResultError(policyErr, ""), nil
+	}
+
+	t, err := terminals.open(cmd, workDir, rows, cols)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(t.info())
+}
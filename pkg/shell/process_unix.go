@@ -0,0 +1,50 @@
+//go:build !windows
+
+package shell
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in its own process group so that
+// the whole tree spawned by the shell can be signalled at once.
+func setProcessGroup(command *exec.Cmd) {
+	if command.SysProcAttr == nil {
+		command.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	command.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills every process in the command's process group,
+// not just the shell that was started directly.
+func killProcessGroup(command *exec.Cmd) error {
+	if command.Process == nil {
+		return nil
+	}
+	err := syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// exitSignal returns the name of the signal that terminated the process, if any.
+func exitSignal(state *os.ProcessState) string {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	return status.Signal().String()
+}
+
+// interruptProcessGroup sends SIGINT to the command's process group, stopping the
+// foreground command of a session shell that traps the signal itself.
+func interruptProcessGroup(command *exec.Cmd) error {
+	if command.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-command.Process.Pid, syscall.SIGINT)
+}
@@ -0,0 +1,34 @@
+//go:build windows
+
+package shell
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup is a no-op on Windows; the process tree is tracked by PID instead.
+func setProcessGroup(command *exec.Cmd) {}
+
+// killProcessGroup kills the command and all of its child processes.
+func killProcessGroup(command *exec.Cmd) error {
+	if command.Process == nil {
+		return nil
+	}
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(command.Process.Pid))
+	if err := kill.Run(); err != nil {
+		return command.Process.Kill()
+	}
+	return nil
+}
+
+// exitSignal always returns an empty string because Windows processes are not terminated by signals.
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
+
+// interruptProcessGroup is not supported on Windows; sessions are not available there.
+func interruptProcessGroup(command *exec.Cmd) error {
+	return ErrSessionsUnsupported
+}
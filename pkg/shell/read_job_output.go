@@ -0,0 +1,56 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultJobReadBytes is how much output read_job_output returns when max_bytes is not given.
+const defaultJobReadBytes = 64 * 1024
+
+// GetReadJobOutput returns the tool and handler for reading a background job's output.
+func GetReadJobOutput() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("read_job_output",
+		mcp.WithDescription("Read the combined stdout and stderr of a background job, starting at a byte offset. Pass the returned next_offset to the next call to stream new output"),
+		mcp.WithString("job_id",
+			mcp.Required(),
+			mcp.Description("ID of the job returned by start_job"),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("Byte offset to start reading from; use next_offset from the previous call"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("max_bytes",
+			mcp.Description("Maximum number of bytes to return"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("wait_seconds",
+			mcp.Description("If no new output is available yet, wait up to this many seconds for more"),
+			mcp.Min(0),
+		),
+	), readJobOutputHandler
+}
+
+// readJobOutputHandler returns a window of a job's output together with the cursor for the next read.
+func readJobOutputHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["job_id"].(string)
+	if !ok {
+		return nil, errors.New("job_id is required")
+	}
+
+	offset := int64(request.GetInt("offset", 0))
+	maxBytes := request.GetInt("max_bytes", defaultJobReadBytes)
+	wait := time.Duration(request.GetFloat("wait_seconds", 0) * float64(time.Second))
+
+	output, err := readJobOutput(ctx, id, offset, maxBytes, wait)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(output)
+}
@@ -0,0 +1,66 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// defaultTerminalReadBytes is how much output read_terminal returns when max_bytes is not given.
+const defaultTerminalReadBytes = 64 * 1024
+
+// GetReadTerminal returns the tool and handler for reading an interactive terminal's output.
+func GetReadTerminal() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("read_terminal",
+		mcp.WithDescription("Read the output of a terminal opened with open_terminal. Use format screen to see what a full-screen program currently displays, or text/raw to stream output from a byte offset"),
+		mcp.WithString("terminal_id",
+			mcp.Required(),
+			mcp.Description("ID of the terminal returned by open_terminal"),
+		),
+		mcp.WithString("format",
+			mcp.Description("text: output with escape sequences removed; raw: output as written, including escape sequences; screen: plain-text snapshot of the current screen"),
+			mcp.Enum(terminalFormatText, terminalFormatRaw, terminalFormatScreen),
+			mcp.DefaultString(terminalFormatText),
+		),
+		mcp.WithNumber("offset",
+			mcp.Description("Byte offset to start reading from; use next_offset from the previous call"),
+			mcp.Min(0),
+		),
+		mcp.WithNumber("max_bytes",
+			mcp.Description("Maximum number of bytes to return for the text and raw formats"),
+			mcp.Min(1),
+		),
+		mcp.WithNumber("wait_seconds",
+			mcp.Description("If nothing was written past offset yet, wait up to this many seconds for more output"),
+			mcp.Min(0),
+		),
+	), readTerminalHandler
+}
+
+// readTerminalHandler returns the terminal output together with the cursor for the next read.
+func readTerminalHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["terminal_id"].(string)
+	if !ok {
+		return nil, errors.New("terminal_id is required")
+	}
+
+	format := request.GetString("format", terminalFormatText)
+	if format != terminalFormatText && format != terminalFormatRaw && format != terminalFormatScreen {
+		return nil, errors.New("format must be one of text, raw or screen")
+	}
+
+	offset := int64(request.GetInt("offset", 0))
+	maxBytes := request.GetInt("max_bytes", defaultTerminalReadBytes)
+	wait := time.Duration(request.GetFloat("wait_seconds", 0) * float64(time.Second))
+
+	output, err := readTerminal(ctx, id, format, offset, maxBytes, wait)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(output)
+}
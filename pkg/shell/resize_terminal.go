@@ -0,0 +1,55 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetResizeTerminal returns the tool and handler for changing the size of an interactive terminal.
+func GetResizeTerminal() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("resize_terminal",
+		mcp.WithDescription("Change the size of a terminal opened with open_terminal; the program is notified of the new size"),
+		mcp.WithString("terminal_id",
+			mcp.Required(),
+			mcp.Description("ID of the terminal returned by open_terminal"),
+		),
+		mcp.WithNumber("rows",
+			mcp.Required(),
+			mcp.Description("New height in lines"),
+			mcp.Min(1),
+			mcp.Max(maxTerminalSize),
+		),
+		mcp.WithNumber("cols",
+			mcp.Required(),
+			mcp.Description("New width in columns"),
+			mcp.Min(1),
+			mcp.Max(maxTerminalSize),
+		),
+	), resizeTerminalHandler
+}
+
+// resizeTerminalHandler applies the new size and returns the terminal's state.
+func resizeTerminalHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["terminal_id"].(string)
+	if !ok {
+		return nil, errors.New("terminal_id is required")
+	}
+
+	rows := request.GetInt("rows", 0)
+	cols := request.GetInt("cols", 0)
+	if rows <= 0 || cols <= 0 || rows > maxTerminalSize || cols > maxTerminalSize {
+		return nil, fmt.Errorf("rows and cols must be between 1 and %d", maxTerminalSize)
+	}
+
+	info, err := resizeTerminal(id, rows, cols)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(info)
+}
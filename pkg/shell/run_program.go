@@ -0,0 +1,114 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"jarvis_mcp/pkg/sandbox"
+	"jarvis_mcp/pkg/utils"
+	"strings"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetRunProgram returns the tool and handler for running a program without a shell.
+func GetRunProgram() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("run_program",
+		mcp.WithDescription("Run a program directly with an argument list, without a shell. Arguments are passed as is, so no quoting is needed and pipes, redirects, globs and variables are not interpreted"),
+		mcp.WithString("program",
+			mcp.Required(),
+			mcp.Description("Program to run; looked up in PATH unless it contains a path separator"),
+		),
+		mcp.WithArray("args",
+			mcp.Description("Arguments passed to the program"),
+			mcp.WithStringItems(),
+		),
+		mcp.WithObject("env",
+			mcp.Description("Environment variables to set in addition to the server's environment"),
+			mcp.AdditionalProperties(map[string]any{"type": "string"}),
+		),
+		mcp.WithString("stdin",
+			mcp.Description("Text written to the program's standard input"),
+		),
+		mcp.WithString("working_directory",
+			mcp.Description("Working directory for the program"),
+		),
+		mcp.WithNumber("timeout_seconds",
+			mcp.Description("Maximum time in seconds the program may run before it is killed; defaults to the server setting"),
+			mcp.Min(0),
+		),
+		mcp.WithString("output_format",
+			mcp.Description("Result format: 'text' for a readable summary with merged output, 'json' for separate stdout, stderr, exit code and timing"),
+			mcp.Enum("text", "json"),
+			mcp.DefaultString("text"),
+		),
+	), runProgramHandler
+}
+
+// runProgramHandler runs the program and reports the result in the same forms as execute_command.
+func runProgramHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	program, ok := request.GetArguments()["program"].(string)
+	if !ok || strings.TrimSpace(program) == "" {
+		return nil, errors.New("program is required")
+	}
+
+	var args []string
+	if rawArgs, ok := request.GetArguments()["args"]; ok && rawArgs != nil {
+		list, ok := rawArgs.([]any)
+		if !ok {
+			return nil, errors.New("args must be an array of strings")
+		}
+		for _, arg := range list {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, errors.New("args must be an array of strings")
+			}
+			args = append(args, s)
+		}
+	}
+
+	env := map[string]string{}
+	if rawEnv, ok := request.GetArguments()["env"]; ok && rawEnv != nil {
+		vars, ok := rawEnv.(map[string]any)
+		if !ok {
+			return nil, errors.New("env must be an object of strings")
+		}
+		for name, value := range vars {
+			s, ok := value.(string)
+			if !ok || name == "" || strings.ContainsAny(name, "=\x00") {
+				return nil, fmt.Errorf("invalid environment variable %q", name)
+			}
+			env[name] = s
+		}
+	}
+
+	stdin := request.GetString("stdin", "")
+	workDir := request.GetString("working_directory", "")
+	timeout := resolveTimeout(time.Duration(request.GetFloat("timeout_seconds", 0) * float64(time.Second)))
+
+	workDir, err := sandbox.WorkDir(ctx, workDir)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if err := checkProgram(ctx, append([]string{program}, args...), workDir); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	result, err := runProgram(ctx, program, args, env, stdin, workDir, timeout, config.MaxOutputBytes)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if request.GetString("output_format", "text") == "json" {
+		return newCommandJSONResult(result)
+	}
+
+	text, err := formatCommandResult(displayCommand(program, args), timeout, result)
+	if err != nil {
+		return utils.NewToolResultError(err, text), nil
+	}
+	return mcp.NewToolResultText(text), nil
+}
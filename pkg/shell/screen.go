@@ -0,0 +1,433 @@
+package shell
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// ansiPattern matches the escape sequences removed by stripANSI: CSI sequences,
+// OSC strings, character set selection and other two-byte escapes.
+var ansiPattern = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[()*+][0-9A-Za-z]|\x1b[@-Z\\-_=>78]`)
+
+// stripANSI removes terminal escape sequences and carriage returns from s,
+// leaving the plain text a program printed.
+func stripANSI(s string) string {
+	s = ansiPattern.ReplaceAllString(s, "")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// Parser states of the screen emulator.
+const (
+	stateGround = iota
+	stateEscape
+	stateCSI
+	stateOSC
+	stateOSCEscape
+	stateCharset
+)
+
+// screen is a minimal VT100/xterm emulator that renders terminal output into a
+// grid of characters, so full-screen programs can be shown as a plain-text snapshot.
+// It understands cursor movement, erasing, scrolling regions and the alternate
+// screen; colours and other attributes are ignored.
+type screen struct {
+	rows, cols   int
+	cells        [][]rune
+	row, col     int
+	savedRow     int
+	savedCol     int
+	top, bottom  int // scrolling region, inclusive
+	wrapPending  bool
+	state        int
+	params       []byte
+	pending      []byte // incomplete UTF-8 sequence carried over between writes
+	altCells     [][]rune
+	altActive    bool
+	cursorHidden bool
+}
+
+// newScreen returns an empty screen of the given size.
+func newScreen(rows, cols int) *screen {
+	s := &screen{}
+	s.resize(rows, cols)
+	return s
+}
+
+// blankRow returns a row of spaces.
+func (s *screen) blankRow() []rune {
+	row := make([]rune, s.cols)
+	for i := range row {
+		row[i] = ' '
+	}
+	return row
+}
+
+// resize changes the screen size, keeping as much content as fits.
+func (s *screen) resize(rows, cols int) {
+	cells := make([][]rune, rows)
+	oldCols := s.cols
+	s.rows, s.cols = rows, cols
+	for r := range cells {
+		cells[r] = s.blankRow()
+		if r < len(s.cells) {
+			copy(cells[r], s.cells[r][:min(oldCols, cols)])
+		}
+	}
+	s.cells = cells
+	s.top, s.bottom = 0, rows-1
+	s.row = min(s.row, rows-1)
+	s.col = min(s.col, cols-1)
+	s.savedRow = min(s.savedRow, rows-1)
+	s.savedCol = min(s.savedCol, cols-1)
+	s.altCells = nil
+}
+
+// Write feeds terminal output into the emulator.
+func (s *screen) Write(p []byte) (int, error) {
+	data := append(s.pending, p...)
+	s.pending = nil
+
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size <= 1 && !utf8.FullRune(data) {
+			s.pending = append([]byte(nil), data...)
+			break
+		}
+		data = data[size:]
+		s.feed(r)
+	}
+	return len(p), nil
+}
+
+// feed processes a single rune according to the current parser state.
+func (s *screen) feed(r rune) {
+	switch s.state {
+	case stateEscape:
+		s.escape(r)
+		return
+	case stateCSI:
+		if r >= 0x40 && r <= 0x7e {
+			s.csi(r)
+			s.state = stateGround
+		} else {
+			s.params = append(s.params, byte(r))
+		}
+		return
+	case stateOSC:
+		if r == 0x07 {
+			s.state = stateGround
+		} else if r == 0x1b {
+			s.state = stateOSCEscape
+		}
+		return
+	case stateOSCEscape:
+		s.state = stateGround
+		return
+	case stateCharset:
+		s.state = stateGround
+		return
+	}
+
+	switch r {
+	case 0x1b:
+		s.state = stateEscape
+	case '\r':
+		s.col = 0
+		s.wrapPending = false
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\b':
+		if s.col > 0 {
+			s.col--
+		}
+		s.wrapPending = false
+	case '\t':
+		s.col = min((s.col/8+1)*8, s.cols-1)
+	default:
+		if r >= 0x20 {
+			s.print(r)
+		}
+	}
+}
+
+// print writes a printable rune at the cursor, wrapping at the right margin.
+func (s *screen) print(r rune) {
+	if s.wrapPending {
+		s.col = 0
+		s.lineFeed()
+	}
+	s.cells[s.row][s.col] = r
+	if s.col == s.cols-1 {
+		s.wrapPending = true
+	} else {
+		s.col++
+	}
+}
+
+// lineFeed moves the cursor down, scrolling the region when it is at the bottom.
+func (s *screen) lineFeed() {
+	s.wrapPending = false
+	if s.row == s.bottom {
+		s.scrollUp(1)
+	} else if s.row < s.rows-1 {
+		s.row++
+	}
+}
+
+// scrollUp scrolls the scrolling region up by n lines.
+func (s *screen) scrollUp(n int) {
+	n = min(n, s.bottom-s.top+1)
+	for i := 0; i < n; i++ {
+		copy(s.cells[s.top:s.bottom], s.cells[s.top+1:s.bottom+1])
+		s.cells[s.bottom] = s.blankRow()
+	}
+}
+
+// scrollDown scrolls the scrolling region down by n lines.
+func (s *screen) scrollDown(n int) {
+	n = min(n, s.bottom-s.top+1)
+	for i := 0; i < n; i++ {
+		copy(s.cells[s.top+1:s.bottom+1], s.cells[s.top:s.bottom])
+		s.cells[s.top] = s.blankRow()
+	}
+}
+
+// escape handles the byte following ESC.
+func (s *screen) escape(r rune) {
+	s.state = stateGround
+	switch r {
+	case '[':
+		s.state = stateCSI
+		s.params = s.params[:0]
+	case ']':
+		s.state = stateOSC
+	case '(', ')', '*', '+':
+		s.state = stateCharset
+	case '7':
+		s.savedRow, s.savedCol = s.row, s.col
+	case '8':
+		s.row, s.col = s.savedRow, s.savedCol
+	case 'D':
+		s.lineFeed()
+	case 'E':
+		s.col = 0
+		s.lineFeed()
+	case 'M':
+		if s.row == s.top {
+			s.scrollDown(1)
+		} else if s.row > 0 {
+			s.row--
+		}
+	case 'c':
+		s.resize(s.rows, s.cols)
+		s.row, s.col = 0, 0
+	}
+}
+
+// maxCSIParam bounds the numeric parameters of CSI sequences, which count cells or lines and
+// so never need to exceed the largest terminal.
+const maxCSIParam = 1 << 16
+
+// csiParams parses the numeric parameters of a CSI sequence, substituting def for missing and
+// negative values and capping the others at maxCSIParam.
+func (s *screen) csiParams(def int) (private bool, values []int) {
+	params := string(s.params)
+	if strings.HasPrefix(params, "?") {
+		private = true
+		params = params[1:]
+	}
+	for _, field := range strings.Split(params, ";") {
+		n, err := strconv.Atoi(field)
+		if errors.Is(err, strconv.ErrRange) && !strings.HasPrefix(field, "-") {
+			n, err = maxCSIParam, nil
+		}
+		if err != nil || n < 0 || (n == 0 && def > 0) {
+			n = def
+		}
+		values = append(values, min(n, maxCSIParam))
+	}
+	return private, values
+}
+
+// csi executes a complete CSI sequence ending in final.
+func (s *screen) csi(final rune) {
+	s.wrapPending = false
+	def := 1
+	if final == 'J' || final == 'K' || final == 'm' || final == 'r' || final == 'h' || final == 'l' {
+		def = 0
+	}
+	private, p := s.csiParams(def)
+	n := p[0]
+
+	switch final {
+	case 'A':
+		s.row = max(s.row-n, 0)
+	case 'B', 'e':
+		s.row = min(s.row+n, s.rows-1)
+	case 'C', 'a':
+		s.col = min(s.col+n, s.cols-1)
+	case 'D':
+		s.col = max(s.col-n, 0)
+	case 'E':
+		s.row, s.col = min(s.row+n, s.rows-1), 0
+	case 'F':
+		s.row, s.col = max(s.row-n, 0), 0
+	case 'G', '`':
+		s.col = clamp(n-1, 0, s.cols-1)
+	case 'd':
+		s.row = clamp(n-1, 0, s.rows-1)
+	case 'H', 'f':
+		col := 1
+		if len(p) > 1 {
+			col = p[1]
+		}
+		s.row, s.col = clamp(n-1, 0, s.rows-1), clamp(col-1, 0, s.cols-1)
+	case 'J':
+		s.eraseDisplay(n)
+	case 'K':
+		s.eraseLine(n)
+	case 'L':
+		if s.row >= s.top && s.row <= s.bottom {
+			top := s.top
+			s.top = s.row
+			s.scrollDown(n)
+			s.top = top
+		}
+	case 'M':
+		if s.row >= s.top && s.row <= s.bottom {
+			top := s.top
+			s.top = s.row
+			s.scrollUp(n)
+			s.top = top
+		}
+	case '@':
+		line := s.cells[s.row]
+		n = min(n, s.cols-s.col)
+		copy(line[s.col+n:], line[s.col:])
+		for i := s.col; i < s.col+n; i++ {
+			line[i] = ' '
+		}
+	case 'P':
+		line := s.cells[s.row]
+		n = min(n, s.cols-s.col)
+		copy(line[s.col:], line[s.col+n:])
+		for i := s.cols - n; i < s.cols; i++ {
+			line[i] = ' '
+		}
+	case 'X':
+		for i := s.col; i < min(s.col+n, s.cols); i++ {
+			s.cells[s.row][i] = ' '
+		}
+	case 'S':
+		s.scrollUp(n)
+	case 'T':
+		s.scrollDown(n)
+	case 'r':
+		top, bottom := 1, s.rows
+		if n > 0 {
+			top = n
+		}
+		if len(p) > 1 && p[1] > 0 {
+			bottom = p[1]
+		}
+		if top < bottom && bottom <= s.rows {
+			s.top, s.bottom = top-1, bottom-1
+			s.row, s.col = 0, 0
+		}
+	case 's':
+		s.savedRow, s.savedCol = s.row, s.col
+	case 'u':
+		s.row, s.col = s.savedRow, s.savedCol
+	case 'h', 'l':
+		if private {
+			s.setMode(p, final == 'h')
+		}
+	}
+}
+
+// setMode handles DEC private modes: the alternate screen and cursor visibility.
+func (s *screen) setMode(modes []int, enable bool) {
+	for _, mode := range modes {
+		switch mode {
+		case 25:
+			s.cursorHidden = !enable
+		case 47, 1047, 1049:
+			if enable && !s.altActive {
+				s.altCells = s.cells
+				s.cells = make([][]rune, s.rows)
+				for r := range s.cells {
+					s.cells[r] = s.blankRow()
+				}
+				s.altActive = true
+			} else if !enable && s.altActive {
+				if s.altCells != nil {
+					s.cells = s.altCells
+				}
+				s.altCells = nil
+				s.altActive = false
+			}
+		}
+	}
+}
+
+// eraseDisplay implements ED: 0 erases below the cursor, 1 above, 2 and 3 everything.
+func (s *screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.eraseLine(0)
+		for r := s.row + 1; r < s.rows; r++ {
+			s.cells[r] = s.blankRow()
+		}
+	case 1:
+		s.eraseLine(1)
+		for r := 0; r < s.row; r++ {
+			s.cells[r] = s.blankRow()
+		}
+	default:
+		for r := range s.cells {
+			s.cells[r] = s.blankRow()
+		}
+	}
+}
+
+// eraseLine implements EL: 0 erases right of the cursor, 1 left, 2 the whole line.
+func (s *screen) eraseLine(mode int) {
+	from, to := s.col, s.cols
+	switch mode {
+	case 1:
+		from, to = 0, s.col+1
+	case 2:
+		from = 0
+	}
+	for i := from; i < min(to, s.cols); i++ {
+		s.cells[s.row][i] = ' '
+	}
+}
+
+// snapshot renders the screen as text, one line per row with trailing spaces
+// and trailing empty rows removed.
+func (s *screen) snapshot() string {
+	lines := make([]string, s.rows)
+	last := -1
+	for r, row := range s.cells {
+		lines[r] = strings.TrimRight(string(row), " ")
+		if lines[r] != "" {
+			last = r
+		}
+	}
+	return strings.Join(lines[:last+1], "\n")
+}
+
+// clamp limits v to the range [lo, hi].
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
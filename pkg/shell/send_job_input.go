@@ -0,0 +1,44 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetSendJobInput returns the tool and handler for writing to a background job's stdin.
+func GetSendJobInput() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("send_job_input",
+		mcp.WithDescription("Write text to the standard input of a running background job"),
+		mcp.WithString("job_id",
+			mcp.Required(),
+			mcp.Description("ID of the job returned by start_job"),
+		),
+		mcp.WithString("input",
+			mcp.Description("Text to write; include a trailing newline to submit a line"),
+		),
+		mcp.WithBoolean("close_stdin",
+			mcp.Description("Close standard input after writing, signalling end of input"),
+		),
+	), sendJobInputHandler
+}
+
+// sendJobInputHandler writes the given input to the job's stdin.
+func sendJobInputHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["job_id"].(string)
+	if !ok {
+		return nil, errors.New("job_id is required")
+	}
+
+	input := request.GetString("input", "")
+	closeStdin := request.GetBool("close_stdin", false)
+
+	if err := sendJobInput(ctx, id, input, closeStdin); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultText("Input sent to job " + id), nil
+}
@@ -0,0 +1,362 @@
+package shell
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"jarvis_mcp/pkg/utils"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrSessionNotFound is returned when a session ID is unknown or the session was closed.
+	ErrSessionNotFound = utils.NewCodedError(utils.ErrCodeNotFound, errors.New("session not found"))
+	// ErrSessionEnded is returned when the session's shell exits while running a command.
+	ErrSessionEnded = errors.New("session shell exited")
+	// ErrSessionsUnsupported is returned on platforms without a POSIX shell.
+	ErrSessionsUnsupported = errors.New("persistent sessions are only supported on Unix-like systems")
+)
+
+// session is a long-lived shell process whose working directory and environment
+// persist between commands. Commands are framed with a unique sentinel that the
+// shell prints, together with the exit code, once the command has finished.
+type session struct {
+	id        string
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stdout    chan []byte
+	stderr    chan []byte
+	createdAt time.Time
+	mu        sync.Mutex // serializes commands
+	closed    atomic.Bool
+}
+
+// sessionInfo is the snapshot of a session returned to clients.
+type sessionInfo struct {
+	ID        string `json:"session_id"`
+	PID       int    `json:"pid"`
+	CreatedAt string `json:"created_at"`
+}
+
+// sessionManager tracks open shell sessions.
+type sessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	nextID   int
+	// opening counts the sessions being opened, which take a slot of the limit until they are added.
+	opening int
+}
+
+// sessions is the process-wide session registry.
+var sessions = &sessionManager{sessions: make(map[string]*session)}
+
+// open starts a new shell session, optionally in workDir.
+func (m *sessionManager) open(workDir string) (*session, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrSessionsUnsupported
+	}
+
+	m.mu.Lock()
+	if config.MaxSessions > 0 && len(m.sessions)+m.opening >= config.MaxSessions {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many open sessions (limit %d)", config.MaxSessions)
+	}
+	m.opening++
+	m.mu.Unlock()
+	opened := false
+	defer func() {
+		if !opened {
+			m.mu.Lock()
+			m.opening--
+			m.mu.Unlock()
+		}
+	}()
+
+	command, err := newShellCommand(context.Background(), "", workDir)
+	if err != nil {
+		return nil, err
+	}
+	// Read commands from stdin instead of -c
+	command.Args = []string{"sh", "-s"}
+
+	s := &session{
+		cmd:    command,
+		stdout: make(chan []byte, 64),
+		stderr: make(chan []byte, 64),
+	}
+
+	s.stdin, err = command.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := command.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := command.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := command.Start(); err != nil {
+		return nil, err
+	}
+	go pump(stdout, s.stdout)
+	go pump(stderr, s.stderr)
+
+	// Interrupts are meant for the running command, not the session shell
+	if _, err := io.WriteString(s.stdin, "trap ':' INT\n"); err != nil {
+		killProcessGroup(command)
+		return nil, err
+	}
+
+	s.createdAt = time.Now()
+
+	m.mu.Lock()
+	m.opening--
+	m.nextID++
+	s.id = "session-" + strconv.Itoa(m.nextID)
+	m.sessions[s.id] = s
+	m.mu.Unlock()
+	opened = true
+
+	return s, nil
+}
+
+// pump forwards everything read from r to ch and closes ch at EOF.
+func pump(r io.Reader, ch chan<- []byte) {
+	defer close(ch)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			ch <- append([]byte(nil), buf[:n]...)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// get looks up an open session by ID.
+func (m *sessionManager) get(id string) (*session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+	return s, nil
+}
+
+// close terminates the session's shell and everything it started.
+func (m *sessionManager) close(id string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, id)
+	}
+
+	s.terminate()
+	return nil
+}
+
+// list returns information about every open session, ordered by creation time.
+func (m *sessionManager) list() []sessionInfo {
+	m.mu.Lock()
+	all := make([]*session, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		all = append(all, s)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(all, func(a, b int) bool { return all[a].createdAt.Before(all[b].createdAt) })
+
+	infos := make([]sessionInfo, 0, len(all))
+	for _, s := range all {
+		infos = append(infos, s.info())
+	}
+	return infos
+}
+
+// info returns a snapshot of the session.
+func (s *session) info() sessionInfo {
+	return sessionInfo{
+		ID:        s.id,
+		PID:       s.cmd.Process.Pid,
+		CreatedAt: s.createdAt.Format(time.RFC3339),
+	}
+}
+
+// terminate kills the session's process group and reaps the shell.
+func (s *session) terminate() {
+	s.stdin.Close()
+	killProcessGroup(s.cmd)
+	go s.cmd.Wait()
+	s.closed.Store(true)
+}
+
+// shellQuote quotes s for safe use as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// newSentinel returns a marker that cannot plausibly appear in command output.
+func newSentinel() string {
+	token := make([]byte, 12)
+	rand.Read(token)
+	return "__JARVIS_" + hex.EncodeToString(token) + "__"
+}
+
+// run executes cmd inside the session and waits for its sentinel. The command is evaluated
+// by the session shell itself, so cd, export and source affect later commands. On timeout or
+// cancellation the command is interrupted; if it does not stop the whole session is closed.
+func (s *session) run(ctx context.Context, cmd string, workDir string, timeout time.Duration, maxOutput int) (*commandResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed.Load() {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, s.id)
+	}
+
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	if strings.TrimSpace(workDir) != "" {
+		cmd = "cd -- " + shellQuote(workDir) + " && " + cmd
+	}
+
+	// "command eval" keeps the shell alive on syntax errors in cmd; stdin is the script
+	// itself, so commands that read it must not get the lines after them
+	sentinel := newSentinel()
+	script := fmt.Sprintf("command eval %s </dev/null\n__jarvis_rc=$?\nprintf '%%s:%%d\\n' '%s' \"$__jarvis_rc\"\nprintf '%%s\\n' '%s' >&2\n",
+		shellQuote(cmd), sentinel, sentinel)
+
+	start := time.Now()
+	if _, err := io.WriteString(s.stdin, script); err != nil {
+		sessions.close(s.id)
+		return nil, fmt.Errorf("%w: %v", ErrSessionEnded, err)
+	}
+
+	// Output is capped while it is collected; the sentinels are found in the chunks as they arrive
+	stdout := &sentinelBuffer{marker: []byte(sentinel + ":"), output: cappedBuffer{limit: maxOutput}}
+	stderr := &sentinelBuffer{marker: []byte(sentinel + "\n"), output: cappedBuffer{limit: maxOutput}}
+	stdoutCh, stderrCh := s.stdout, s.stderr // set to nil once the sentinel was seen or the stream closed
+	exitCode := -1
+	done := ctx.Done() // set to nil once the command has been interrupted
+	var grace <-chan time.Time
+	ended := false // the shell exited; what is left of the other stream is still collected
+	var drain <-chan time.Time
+
+	for stdoutCh != nil || stderrCh != nil {
+		select {
+		case chunk, ok := <-stdoutCh:
+			if !ok {
+				stdoutCh, ended = nil, true
+				drain = time.After(waitDelay)
+				continue
+			}
+			stdout.write(chunk)
+			// The exit code follows the sentinel on the same line
+			if nl := bytes.IndexByte(stdout.rest, '\n'); stdout.found && nl >= 0 {
+				exitCode, _ = strconv.Atoi(string(stdout.rest[:nl]))
+				stdoutCh = nil
+			}
+		case chunk, ok := <-stderrCh:
+			if !ok {
+				stderrCh, ended = nil, true
+				drain = time.After(waitDelay)
+				continue
+			}
+			if stderr.write(chunk); stderr.found {
+				stderrCh = nil
+			}
+		case <-drain:
+			// A process the shell left behind holds the other stream open
+			stdoutCh, stderrCh = nil, nil
+		case <-done:
+			done = nil
+			interruptProcessGroup(s.cmd)
+			grace = time.After(waitDelay)
+		case <-grace:
+			// The command ignored the interrupt; give up on the session
+			sessions.close(s.id)
+			return s.result(ctx, stdout, stderr, -1, start),
+				fmt.Errorf("%w: command did not stop after interrupt", ErrSessionEnded)
+		}
+	}
+
+	if ended {
+		sessions.close(s.id)
+		return s.result(ctx, stdout, stderr, -1, start), ErrSessionEnded
+	}
+	return s.result(ctx, stdout, stderr, exitCode, start), nil
+}
+
+// sentinelBuffer collects one output stream of a session command up to the marker that
+// ends it. Only the last bytes, which may be the start of a marker split across chunks,
+// are held back, so every chunk is searched once.
+type sentinelBuffer struct {
+	marker []byte
+	output cappedBuffer
+	tail   []byte // output that may be the start of the marker
+	found  bool
+	rest   []byte // what followed the marker
+}
+
+// write adds a chunk of the stream.
+func (b *sentinelBuffer) write(chunk []byte) {
+	if b.found {
+		b.rest = append(b.rest, chunk...)
+		return
+	}
+	data := append(b.tail, chunk...)
+	if i := bytes.Index(data, b.marker); i >= 0 {
+		b.output.Write(data[:i])
+		b.rest = bytes.Clone(data[i+len(b.marker):])
+		b.tail, b.found = nil, true
+		return
+	}
+	keep := min(len(data), len(b.marker)-1)
+	b.output.Write(data[:len(data)-keep])
+	b.tail = bytes.Clone(data[len(data)-keep:])
+}
+
+// flush returns the collected output, including any held back bytes when the marker never came.
+func (b *sentinelBuffer) flush() *cappedBuffer {
+	b.output.Write(b.tail)
+	b.tail = nil
+	return &b.output
+}
+
+// result builds a commandResult from the output collected for one session command.
+func (s *session) result(ctx context.Context, stdout, stderr *sentinelBuffer, exitCode int, start time.Time) *commandResult {
+	stdoutCapped := stdout.flush()
+	stderrCapped := stderr.flush()
+
+	result := &commandResult{
+		Stdout:          stdoutCapped.String(),
+		Stderr:          stderrCapped.String(),
+		ExitCode:        exitCode,
+		DurationMs:      time.Since(start).Milliseconds(),
+		TimedOut:        errors.Is(ctx.Err(), context.DeadlineExceeded),
+		Cancelled:       errors.Is(ctx.Err(), context.Canceled),
+		StdoutTruncated: stdoutCapped.truncated,
+		StderrTruncated: stderrCapped.truncated,
+	}
+	result.setErrorCode()
+	return result
+}
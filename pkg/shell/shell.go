@@ -1,60 +1,280 @@
 package shell
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"jarvis_mcp/pkg/utils"
 	"os"
 	"os/exec"
 	"runtime"
 	"strings"
+	"time"
 )
 
-// ExecuteCommand executes OS commands specified by the user and returns the command output.
-// It takes the command string and an optional working directory, runs the command via the shell,
-// and captures both stdout and stderr output.
-func executeCommand(cmd string, workDir string) (string, error) {
+var (
+	// ErrTimedOut is returned when a command is killed because it exceeded its timeout.
+	ErrTimedOut = fmt.Errorf("command timed out: %w", context.DeadlineExceeded)
+	// ErrCancelled is returned when a command is killed because the request was cancelled.
+	ErrCancelled = fmt.Errorf("command cancelled: %w", context.Canceled)
+)
+
+// waitDelay bounds how long we wait for output pipes to close after the process
+// group has been killed, in case a detached grandchild still holds them open.
+const waitDelay = 2 * time.Second
+
+// commandResult is the structured outcome of a command run with separate output streams.
+type commandResult struct {
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	ExitCode        int    `json:"exit_code"`
+	Signal          string `json:"signal,omitempty"`
+	DurationMs      int64  `json:"duration_ms"`
+	ErrorCode       string `json:"error_code,omitempty"`
+	Error           string `json:"error,omitempty"`
+	TimedOut        bool   `json:"timed_out"`
+	Cancelled       bool   `json:"cancelled"`
+	StdoutTruncated bool   `json:"stdout_truncated"`
+	StderrTruncated bool   `json:"stderr_truncated"`
+}
 
-	var command *exec.Cmd
+// setErrorCode classifies a finished command: timeouts and cancellations take
+// precedence over a non-zero exit code.
+func (r *commandResult) setErrorCode() {
+	switch {
+	case r.TimedOut:
+		r.ErrorCode = string(utils.ErrCodeTimeout)
+	case r.Cancelled:
+		r.ErrorCode = string(utils.ErrCodeCancelled)
+	case r.ExitCode != 0:
+		r.ErrorCode = string(utils.ErrCodeCommandFailed)
+	}
+}
+
+// cappedBuffer is an io.Writer that keeps at most limit bytes and records
+// whether anything was dropped. A limit of zero or less means unlimited.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
 
+// Write stores as much of p as fits and always reports success so the
+// command is never blocked or failed by a full buffer.
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 {
+		remaining := b.limit - b.buf.Len()
+		if remaining < len(p) {
+			b.truncated = true
+			if remaining > 0 {
+				b.buf.Write(p[:remaining])
+			}
+			return len(p), nil
+		}
+	}
+	return b.buf.Write(p)
+}
+
+// String returns the captured output.
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
+
+// newShellCommand prepares a command that runs cmd through the platform shell in workDir.
+// The command runs in its own process group, which is killed as a whole when ctx is done.
+func newShellCommand(ctx context.Context, cmd string, workDir string) (*exec.Cmd, error) {
 	// Select the appropriate shell based on operating system
 	if runtime.GOOS == "windows" {
-		command = exec.Command("cmd", "/C", cmd)
-	} else {
-		command = exec.Command("sh", "-c", cmd)
+		return newCommand(ctx, "cmd", []string{"/C", cmd}, workDir)
 	}
+	return newCommand(ctx, "sh", []string{"-c", cmd}, workDir)
+}
+
+// newCommand prepares a command that runs program with args in workDir, without a shell.
+// The program is looked up in PATH unless it contains a path separator. It runs in its
+// own process group, which is killed as a whole when ctx is done.
+func newCommand(ctx context.Context, program string, args []string, workDir string) (*exec.Cmd, error) {
+	command := exec.CommandContext(ctx, program, args...)
+	if errors.Is(command.Err, exec.ErrNotFound) {
+		return nil, utils.NewCodedError(utils.ErrCodeNotFound, command.Err)
+	}
+
+	// Kill the whole process group on cancellation, not just the direct child
+	setProcessGroup(command)
+	command.Cancel = func() error { return killProcessGroup(command) }
+	command.WaitDelay = waitDelay
 
 	// Copy the current environment
 	command.Env = os.Environ() // Explicitly copy the current environment
 
 	// Set working directory if provided
 	if strings.TrimSpace(workDir) != "" {
-		dirInfo, err := os.Stat(workDir)
-		if err != nil {
-			if os.IsNotExist(err) {
-				return "", fmt.Errorf("Path '%s' does not exist\n", workDir)
-			}
-			return "", fmt.Errorf("Error checking path: %v\n", err)
+		if err := checkWorkDir(workDir); err != nil {
+			return nil, err
 		}
+		command.Dir = workDir
+	}
 
-		if !dirInfo.IsDir() {
-			return "", fmt.Errorf("path '%s' exists but is not a directory", workDir)
+	return command, nil
+}
+
+// checkWorkDir verifies that workDir exists and is a directory.
+func checkWorkDir(workDir string) error {
+	dirInfo, err := os.Stat(workDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("Path '%s' does not exist: %w", workDir, err)
 		}
+		return fmt.Errorf("Error checking path: %w", err)
+	}
 
-		command.Dir = workDir
+	if !dirInfo.IsDir() {
+		return utils.NewCodedError(utils.ErrCodeNotADirectory,
+			fmt.Errorf("path '%s' exists but is not a directory", workDir))
 	}
+	return nil
+}
 
-	// Execute command and capture output
-	output, err := command.CombinedOutput()
-	outputStr := string(output)
+// withTimeout derives a context that expires after timeout; a timeout of zero means no limit.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// ExecuteCommand executes OS commands specified by the user and returns the command output.
+// It takes the command string and an optional working directory, runs the command via the shell,
+// and captures both stdout and stderr output. The command is killed together with every process
+// it spawned when the timeout expires or ctx is cancelled; a timeout of zero means no limit.
+func executeCommand(ctx context.Context, cmd string, workDir string, timeout time.Duration) (string, error) {
+
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	command, err := newShellCommand(ctx, cmd, workDir)
+	if err != nil {
+		return "", err
+	}
+
+	// Execute command and capture output, keeping whatever was produced before a kill
+	var output bytes.Buffer
+	command.Stdout = &output
+	command.Stderr = &output
+	err = command.Run()
+	outputStr := output.String()
+
+	// Report timeouts and cancellations with the partial output
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		resultText := fmt.Sprintf("Command timed out after %s: %s\n\nPartial output:\n%s",
+			timeout, cmd, outputStr)
+		return resultText, fmt.Errorf("%w after %s", ErrTimedOut, timeout)
+	case errors.Is(ctx.Err(), context.Canceled):
+		resultText := fmt.Sprintf("Command cancelled: %s\n\nPartial output:\n%s",
+			cmd, outputStr)
+		return resultText, ErrCancelled
+	}
 
 	// Format the response
 	if err != nil {
 		// Return both the error and any output
 		resultText := fmt.Sprintf("Command failed: %s\n\nOutput:\n%s\n\nError: %v",
 			cmd, outputStr, err)
-		return resultText, err
+		return resultText, utils.NewCodedError(utils.ErrCodeCommandFailed, err)
 	}
 
 	resultText := fmt.Sprintf("Command executed successfully: %s\n\nOutput:\n%s",
 		cmd, outputStr)
 	return resultText, nil
 }
+
+// runCommand executes cmd like executeCommand but keeps stdout and stderr apart and
+// reports the outcome as a commandResult. A non-zero exit, timeout or cancellation is
+// described by the result rather than returned as an error; errors are reserved for
+// commands that could not be set up or started. Each stream is capped at maxOutput bytes.
+func runCommand(ctx context.Context, cmd string, workDir string, timeout time.Duration, maxOutput int) (*commandResult, error) {
+
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	command, err := newShellCommand(ctx, cmd, workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return collectResult(ctx, command, maxOutput)
+}
+
+// collectResult runs a prepared command and reports its outcome as a commandResult.
+// ctx must be the context the command was created with.
+func collectResult(ctx context.Context, command *exec.Cmd, maxOutput int) (*commandResult, error) {
+	stdout := &cappedBuffer{limit: maxOutput}
+	stderr := &cappedBuffer{limit: maxOutput}
+	command.Stdout = stdout
+	command.Stderr = stderr
+
+	start := time.Now()
+	err := command.Run()
+	duration := time.Since(start)
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) && ctx.Err() == nil {
+		return nil, err
+	}
+
+	result := &commandResult{
+		Stdout:          stdout.String(),
+		Stderr:          stderr.String(),
+		ExitCode:        -1,
+		DurationMs:      duration.Milliseconds(),
+		TimedOut:        errors.Is(ctx.Err(), context.DeadlineExceeded),
+		Cancelled:       errors.Is(ctx.Err(), context.Canceled),
+		StdoutTruncated: stdout.truncated,
+		StderrTruncated: stderr.truncated,
+	}
+	if command.ProcessState != nil {
+		result.ExitCode = command.ProcessState.ExitCode()
+		result.Signal = exitSignal(command.ProcessState)
+	}
+
+	result.setErrorCode()
+
+	return result, nil
+}
+
+// runProgram executes program with args directly, without a shell, so no argument is ever
+// subject to shell expansion or quoting. env entries are added to the inherited environment
+// and stdin, if not empty, is fed to the program. The outcome is reported like runCommand.
+func runProgram(ctx context.Context, program string, args []string, env map[string]string, stdin string, workDir string, timeout time.Duration, maxOutput int) (*commandResult, error) {
+
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	command, err := newCommand(ctx, program, args, workDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range env {
+		command.Env = append(command.Env, name+"="+value)
+	}
+	if stdin != "" {
+		command.Stdin = strings.NewReader(stdin)
+	}
+
+	return collectResult(ctx, command, maxOutput)
+}
+
+// displayCommand renders program and args as a shell-like command line for messages.
+func displayCommand(program string, args []string) string {
+	words := make([]string, 0, len(args)+1)
+	for _, word := range append([]string{program}, args...) {
+		if word == "" || strings.ContainsAny(word, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+			word = shellQuote(word)
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " ")
+}
@@ -1,6 +1,7 @@
 package shell
 
 import (
+	"context"
 	"strings"
 	"testing"
 )
@@ -58,7 +59,7 @@ func TestBasicCommands(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := executeCommand(tt.cmd, tt.workDir)
+			result, err := executeCommand(context.Background(), tt.cmd, tt.workDir, 0)
 			
 			// Check error cases
 			if tt.wantErr {
@@ -86,7 +87,7 @@ func TestBasicCommands(t *testing.T) {
 // TestWithInvalidWorkingDirectory tests handling of invalid working directory
 func TestWithInvalidWorkingDirectory(t *testing.T) {
 	// Test empty working directory
-	_, err := executeCommand("echo 'test'", "")
+	_, err := executeCommand(context.Background(), "echo 'test'", "", 0)
 	if err != nil {
 		t.Errorf("executeCommand() with empty working directory should not error, got = %v", err)
 	}
@@ -122,7 +123,7 @@ func TestWithInvalidWorkingDirectory(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// For working directory errors, we only check the error message
-			_, err := executeCommand("echo 'test'", tt.workDir)
+			_, err := executeCommand(context.Background(), "echo 'test'", tt.workDir, 0)
 			
 			if !tt.wantErr && err != nil {
 				t.Errorf("Expected no error but got: %v", err)
@@ -145,7 +146,7 @@ func TestWithLargeOutput(t *testing.T) {
 		cmd = "find /usr -type f -name '*.go' 2>/dev/null || echo 'No Go files found'"
 	}
 	
-	result, err := executeCommand(cmd, "")
+	result, err := executeCommand(context.Background(), cmd, "", 0)
 	if err != nil {
 		t.Errorf("executeCommand() with large output should not error, got = %v", err)
 	}
@@ -165,7 +166,7 @@ func TestWithNonAsciiOutput(t *testing.T) {
 		cmd = "echo '" + nonAscii + "'"
 	}
 	
-	result, err := executeCommand(cmd, "")
+	result, err := executeCommand(context.Background(), cmd, "", 0)
 	if err != nil {
 		t.Errorf("executeCommand() with non-ASCII output should not error, got = %v", err)
 	}
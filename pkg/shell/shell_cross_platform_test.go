@@ -1,6 +1,7 @@
 package shell
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -84,7 +85,7 @@ func TestPathNormalization(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := executeCommand(tt.cmd, tt.workDir)
+			_, err := executeCommand(context.Background(), tt.cmd, tt.workDir, 0)
 			if tt.wantErr && err == nil {
 				t.Errorf("Expected error for workDir=%s, got none", tt.workDir)
 			} else if !tt.wantErr && err != nil {
@@ -129,7 +130,7 @@ func TestRelativePathHandling(t *testing.T) {
 	}
 	
 	readCmd := pc.ReadFile + " " + relativePath
-	result, err := executeCommand(readCmd, subDirPath)
+	result, err := executeCommand(context.Background(), readCmd, subDirPath, 0)
 	if err != nil {
 		t.Errorf("Failed to read file with relative path: %v", err)
 	} else if !strings.Contains(result, "Parent file content") {
@@ -150,7 +151,7 @@ func TestCrossPlatformPaths(t *testing.T) {
 	pc := GetPlatformCommands()
 	
 	// Test listing directory with platform-specific list command
-	result, err := executeCommand(pc.ListDir, helper.TempDir)
+	result, err := executeCommand(context.Background(), pc.ListDir, helper.TempDir, 0)
 	if err != nil {
 		t.Errorf("Failed to list directory: %v", err)
 		return
@@ -167,7 +168,7 @@ func TestCrossPlatformPaths(t *testing.T) {
 		trailingPath += string(os.PathSeparator)
 	}
 	
-	_, err = executeCommand(pc.ListDir, trailingPath)
+	_, err = executeCommand(context.Background(), pc.ListDir, trailingPath, 0)
 	if err != nil {
 		t.Errorf("Failed to use path with trailing separator: %v", err)
 	}
@@ -176,7 +177,7 @@ func TestCrossPlatformPaths(t *testing.T) {
 	nonNormalizedPath := filepath.Join(helper.TempDir, ".", "test1.txt")
 	readCmd := pc.ReadFile + " " + nonNormalizedPath
 	
-	result, err = executeCommand(readCmd, "")
+	result, err = executeCommand(context.Background(), readCmd, "", 0)
 	if err != nil {
 		t.Errorf("Failed to use non-normalized path: %v", err)
 	} else if !strings.Contains(result, "Test file 1 content") {
@@ -205,7 +206,7 @@ func TestEnvironmentVariables(t *testing.T) {
 	// the duration of the command, while on Windows, it persists across commands
 	// we expect the variable to be set in the current command only
 	cmd := setCmd + " && " + getCmd
-	result, err := executeCommand(cmd, "")
+	result, err := executeCommand(context.Background(), cmd, "", 0)
 	if err != nil {
 		t.Errorf("Failed to use environment variables: %v", err)
 	}
@@ -1,8 +1,15 @@
 package shell
 
 import (
+	"context"
+	"jarvis_mcp/pkg/confirm"
+	"jarvis_mcp/pkg/policy"
+	"jarvis_mcp/pkg/sandbox"
+	"os"
 	"strings"
 	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
 )
 
 // TestCommandErrorHandling tests error handling for command execution
@@ -41,7 +48,7 @@ func TestCommandErrorHandling(t *testing.T) {
 		}
 		
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := executeCommand(tt.cmd, helper.TempDir)
+			result, err := executeCommand(context.Background(), tt.cmd, helper.TempDir, 0)
 			
 			// Command execution errors should be propagated
 			if err == nil {
@@ -99,7 +106,7 @@ func TestCommandPipes(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := executeCommand(tt.cmd, helper.TempDir)
+			result, err := executeCommand(context.Background(), tt.cmd, helper.TempDir, 0)
 			
 			if tt.wantErr && err == nil {
 				t.Errorf("executeCommand() should return error for %s", tt.name)
@@ -145,7 +152,7 @@ func TestSpecialCommandsEscaping(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := executeCommand(tt.cmd, "")
+			_, err := executeCommand(context.Background(), tt.cmd, "", 0)
 			if tt.wantErr && err == nil {
 				t.Errorf("Expected error for cmd=%s, got none", tt.cmd)
 			} else if !tt.wantErr && err != nil {
@@ -154,3 +161,218 @@ func TestSpecialCommandsEscaping(t *testing.T) {
 		})
 	}
 }
+
+// TestExecuteCommandHandlerErrors tests that failures are reported as tool errors with codes
+func TestExecuteCommandHandlerErrors(t *testing.T) {
+	helper := NewTestHelper(t, "jarvis-handler-error-test")
+	defer helper.Cleanup()
+
+	testFile := helper.CreateTestFile("file.txt", "Not a directory")
+
+	tests := []struct {
+		name     string
+		args     map[string]any
+		wantCode string
+		wantText string
+	}{
+		{
+			name:     "failing command keeps output",
+			args:     map[string]any{"command": "echo visible output && nonexistentcommand"},
+			wantCode: "command_failed",
+			wantText: "visible output",
+		},
+		{
+			name:     "missing working directory",
+			args:     map[string]any{"command": "echo test", "working directory": "/path/that/does/not/exist"},
+			wantCode: "not_found",
+		},
+		{
+			name:     "file as working directory",
+			args:     map[string]any{"command": "echo test", "working directory": testFile},
+			wantCode: "not_a_directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := executeCommandHandler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("executeCommandHandler() returned protocol error: %v", err)
+			}
+			if !result.IsError {
+				t.Errorf("executeCommandHandler() result should be flagged as error")
+			}
+
+			text := result.Content[0].(mcp.TextContent).Text
+			if !strings.Contains(text, "["+tt.wantCode+"]") {
+				t.Errorf("result doesn't contain error code %q: %q", tt.wantCode, text)
+			}
+			if tt.wantText != "" && !strings.Contains(text, tt.wantText) {
+				t.Errorf("result doesn't contain %q: %q", tt.wantText, text)
+			}
+		})
+	}
+}
+
+// TestRunProgramHandlerErrors tests argument validation and error codes of run_program
+func TestRunProgramHandlerErrors(t *testing.T) {
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{"program": "jarvis-no-such-program"}
+	result, err := runProgramHandler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("runProgramHandler() returned protocol error: %v", err)
+	}
+	if text := result.Content[0].(mcp.TextContent).Text; !result.IsError || !strings.Contains(text, "[not_found]") {
+		t.Errorf("runProgramHandler() = %q, want not_found tool error", text)
+	}
+
+	for _, args := range []map[string]any{
+		{"args": []any{"x"}},
+		{"program": "echo", "args": "not an array"},
+		{"program": "echo", "args": []any{1}},
+		{"program": "echo", "env": map[string]any{"A=B": "x"}},
+	} {
+		request.Params.Arguments = args
+		if _, err := runProgramHandler(context.Background(), request); err == nil {
+			t.Errorf("runProgramHandler(%v) expected protocol error", args)
+		}
+	}
+}
+
+// TestCommandPolicy tests that the command policy is consulted before anything runs
+func TestCommandPolicy(t *testing.T) {
+	commandPolicy, err := policy.Decode([]byte(`{"rules": [
+		{"name": "no-rm", "action": "deny", "programs": ["rm"]},
+		{"name": "confirm-push", "action": "require-confirmation", "programs": ["git"], "args": ["push"]}
+	]}`))
+	if err != nil {
+		t.Fatalf("policy.Decode() error = %v", err)
+	}
+	saved := config
+	config.Policy = commandPolicy
+	defer func() { config = saved }()
+	// Without an MCP session the user cannot be asked, so confirmation falls back to denial
+	confirm.Configure(confirm.Config{Enabled: true, Fallback: confirm.FallbackDeny})
+	defer confirm.Configure(confirm.DefaultConfig())
+
+	tests := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]any
+		denied  bool
+	}{
+		{"allowed command", executeCommandHandler, map[string]any{"command": "echo allowed"}, false},
+		{"denied segment", executeCommandHandler, map[string]any{"command": "echo a && rm -rf nothing"}, true},
+		{"confirmation required", executeCommandHandler, map[string]any{"command": "git push", "output_format": "json"}, true},
+		{"built-in confirmation", executeCommandHandler, map[string]any{"command": "echo a; dd if=/dev/zero of=nothing count=0"}, true},
+		{"denied job", startJobHandler, map[string]any{"command": "rm -rf nothing"}, true},
+		{"denied program", runProgramHandler, map[string]any{"program": "rm", "args": []any{"nothing"}}, true},
+		{"literal argument", runProgramHandler, map[string]any{"program": "echo", "args": []any{"; rm -rf nothing"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := tt.handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("handler returned protocol error: %v", err)
+			}
+			text := result.Content[0].(mcp.TextContent).Text
+			if denied := strings.Contains(text, "[policy_denied]"); denied != tt.denied {
+				t.Errorf("denied = %v, want %v: %q", denied, tt.denied, text)
+			}
+		})
+	}
+}
+
+// TestSandboxWorkDir tests that commands only start inside the allowed roots
+func TestSandboxWorkDir(t *testing.T) {
+	root := t.TempDir()
+	if err := sandbox.Configure(sandbox.Config{Roots: []string{root}}); err != nil {
+		t.Fatalf("sandbox.Configure() error = %v", err)
+	}
+	defer sandbox.Configure(sandbox.DefaultConfig())
+	outside := t.TempDir()
+
+	tests := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]any
+		denied  bool
+	}{
+		{"inside root", executeCommandHandler, map[string]any{"command": "echo ok", "working directory": root}, false},
+		{"default directory", executeCommandHandler, map[string]any{"command": "echo ok"}, false},
+		{"outside root", executeCommandHandler, map[string]any{"command": "echo ok", "working directory": outside}, true},
+		{"job outside root", startJobHandler, map[string]any{"command": "echo ok", "working directory": outside}, true},
+		{"program outside root", runProgramHandler, map[string]any{"program": "echo", "working_directory": outside}, true},
+		{"session outside root", openSessionHandler, map[string]any{"working directory": outside}, true},
+		{"terminal outside root", openTerminalHandler, map[string]any{"working directory": outside}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := tt.handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("handler returned protocol error: %v", err)
+			}
+			text := result.Content[0].(mcp.TextContent).Text
+			if denied := strings.Contains(text, "[policy_denied]"); denied != tt.denied {
+				t.Errorf("denied = %v, want %v: %q", denied, tt.denied, text)
+			}
+		})
+	}
+}
+
+// TestReadOnlyCommands tests the read-only allowlist together with a configured policy
+func TestReadOnlyCommands(t *testing.T) {
+	commandPolicy, err := policy.Decode([]byte(`{"rules": [{"name": "no-cat", "action": "deny", "programs": ["cat"]}]}`))
+	if err != nil {
+		t.Fatalf("policy.Decode() error = %v", err)
+	}
+	saved := config
+	config.ReadOnly = true
+	config.Policy = commandPolicy
+	defer func() { config = saved }()
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		handler func(context.Context, mcp.CallToolRequest) (*mcp.CallToolResult, error)
+		args    map[string]any
+		denied  bool
+	}{
+		{"read-only command", executeCommandHandler, map[string]any{"command": "ls -la", "working directory": dir}, false},
+		{"mutating command", executeCommandHandler, map[string]any{"command": "touch x", "working directory": dir}, true},
+		{"redirection", executeCommandHandler, map[string]any{"command": "echo x > x", "working directory": dir}, true},
+		{"denied by policy", executeCommandHandler, map[string]any{"command": "cat /dev/null", "working directory": dir}, true},
+		{"program", runProgramHandler, map[string]any{"program": "rm", "args": []any{"x"}, "working_directory": dir}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := mcp.CallToolRequest{}
+			request.Params.Arguments = tt.args
+
+			result, err := tt.handler(context.Background(), request)
+			if err != nil {
+				t.Fatalf("handler returned protocol error: %v", err)
+			}
+			text := result.Content[0].(mcp.TextContent).Text
+			if denied := strings.Contains(text, "[policy_denied]"); denied != tt.denied {
+				t.Errorf("denied = %v, want %v: %q", denied, tt.denied, text)
+			}
+		})
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("read-only commands created %d files", len(entries))
+	}
+}
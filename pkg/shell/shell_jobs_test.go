@@ -0,0 +1,192 @@
+//go:build !windows
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForJob waits until the job has finished or the timeout expires
+func waitForJob(t *testing.T, id string, timeout time.Duration) jobStatus {
+	t.Helper()
+	j, err := jobs.get(id)
+	if err != nil {
+		t.Fatalf("jobs.get(%s) error = %v", id, err)
+	}
+	select {
+	case <-j.done:
+	case <-time.After(timeout):
+		t.Fatalf("job %s did not finish within %s", id, timeout)
+	}
+	return j.status()
+}
+
+// TestJobLifecycle tests starting a job, streaming its output and capturing the exit status
+func TestJobLifecycle(t *testing.T) {
+	j, err := jobs.start("echo first; sleep 0.3; echo second; exit 4", "")
+	if err != nil {
+		t.Fatalf("jobs.start() error = %v", err)
+	}
+
+	// Wait for the first chunk of output
+	output, err := readJobOutput(context.Background(), j.id, 0, 0, 2*time.Second)
+	if err != nil {
+		t.Fatalf("readJobOutput() error = %v", err)
+	}
+	if output.Output != "first\n" {
+		t.Errorf("first read = %q, want %q", output.Output, "first\n")
+	}
+
+	status := waitForJob(t, j.id, 5*time.Second)
+	if status.State != jobExited || status.ExitCode == nil || *status.ExitCode != 4 {
+		t.Errorf("unexpected final status: %+v", status)
+	}
+
+	// Resume from the cursor returned by the first read
+	output, err = readJobOutput(context.Background(), j.id, output.NextOffset, 0, 0)
+	if err != nil {
+		t.Fatalf("readJobOutput() error = %v", err)
+	}
+	if output.Output != "second\n" || !output.EOF {
+		t.Errorf("second read = %q (eof=%v), want %q at eof", output.Output, output.EOF, "second\n")
+	}
+}
+
+// TestJobInputAndKill tests writing to a job's stdin and killing it
+func TestJobInputAndKill(t *testing.T) {
+	j, err := jobs.start("while read line; do echo \"got $line\"; done; sleep 30", "")
+	if err != nil {
+		t.Fatalf("jobs.start() error = %v", err)
+	}
+
+	if err := sendJobInput(context.Background(), j.id, "hello\n", true); err != nil {
+		t.Fatalf("sendJobInput() error = %v", err)
+	}
+
+	output, err := readJobOutput(context.Background(), j.id, 0, 0, 2*time.Second)
+	if err != nil {
+		t.Fatalf("readJobOutput() error = %v", err)
+	}
+	if !strings.Contains(output.Output, "got hello") {
+		t.Errorf("output = %q, want it to contain %q", output.Output, "got hello")
+	}
+
+	status, err := killJob(j.id)
+	if err != nil {
+		t.Fatalf("killJob() error = %v", err)
+	}
+	if status.State != jobKilled {
+		t.Errorf("state after kill = %s, want %s", status.State, jobKilled)
+	}
+
+	if err := sendJobInput(context.Background(), j.id, "late\n", false); err == nil {
+		t.Errorf("sendJobInput() to a finished job should fail")
+	}
+}
+
+// TestJobInputTimeout tests that writing to a job that does not read its input gives up
+func TestJobInputTimeout(t *testing.T) {
+	j, err := jobs.start("sleep 30", "")
+	if err != nil {
+		t.Fatalf("jobs.start() error = %v", err)
+	}
+	defer killJob(j.id)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = sendJobInput(ctx, j.id, strings.Repeat("x", 1<<20), false)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("sendJobInput() error = %v, want DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("sendJobInput() returned after %s", elapsed)
+	}
+
+	// Stdin can still be closed after the write gave up
+	if err := sendJobInput(context.Background(), j.id, "", true); err != nil {
+		t.Errorf("sendJobInput() closing stdin error = %v", err)
+	}
+}
+
+// TestJobRingBuffer tests that old output is dropped and reported via the cursor
+func TestJobRingBuffer(t *testing.T) {
+	buf := ringBuffer{limit: 4}
+	buf.write([]byte("0123"))
+	buf.write([]byte("4567"))
+
+	data, next, dropped := buf.read(2, 0)
+	if string(data) != "4567" || next != 8 || dropped != 2 {
+		t.Errorf("read(2) = %q, next %d, dropped %d; want %q, 8, 2", data, next, dropped, "4567")
+	}
+
+	data, next, _ = buf.read(5, 2)
+	if string(data) != "56" || next != 7 {
+		t.Errorf("read(5, 2) = %q, next %d; want %q, 7", data, next, "56")
+	}
+
+	// Writes that do not fill the buffer wrap around its end
+	buf.write([]byte("89"))
+	buf.write([]byte("abc"))
+	data, next, dropped = buf.read(0, 0)
+	if string(data) != "9abc" || next != 13 || dropped != 9 {
+		t.Errorf("read(0) = %q, next %d, dropped %d; want %q, 13, 9", data, next, dropped, "9abc")
+	}
+	data, next, _ = buf.read(10, 2)
+	if string(data) != "ab" || next != 12 {
+		t.Errorf("read(10, 2) = %q, next %d; want %q, 12", data, next, "ab")
+	}
+}
+
+// TestJobCleanup tests that finished jobs are removed after the TTL
+func TestJobCleanup(t *testing.T) {
+	saved := config
+	defer Configure(saved)
+	config.JobTTL = 100 * time.Millisecond
+
+	j, err := jobs.start("true", "")
+	if err != nil {
+		t.Fatalf("jobs.start() error = %v", err)
+	}
+	waitForJob(t, j.id, 5*time.Second)
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err := jobs.get(j.id); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("jobs.get() after TTL error = %v, want ErrJobNotFound", err)
+	}
+}
+
+// TestJobLimit tests that concurrent starts cannot exceed MaxJobs
+func TestJobLimit(t *testing.T) {
+	saved := config
+	defer Configure(saved)
+	config.MaxJobs = 2
+
+	m := &jobManager{jobs: make(map[string]*job)}
+	var mu sync.Mutex
+	var started []*job
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() {
+			if j, err := m.start("sleep 5", ""); err == nil {
+				mu.Lock()
+				started = append(started, j)
+				mu.Unlock()
+			}
+		})
+	}
+	wg.Wait()
+
+	for _, j := range started {
+		j.cancel()
+		<-j.done
+	}
+	if len(started) != 2 {
+		t.Errorf("started %d jobs, want 2", len(started))
+	}
+}
@@ -0,0 +1,199 @@
+//go:build !windows
+
+package shell
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// TestSessionPersistsState tests that cwd and environment survive between commands
+func TestSessionPersistsState(t *testing.T) {
+	helper := NewTestHelper(t, "jarvis-session-test")
+	defer helper.Cleanup()
+	helper.CreateTestFile("marker.txt", "session marker")
+
+	s, err := sessions.open("")
+	if err != nil {
+		t.Fatalf("sessions.open() error = %v", err)
+	}
+	defer sessions.close(s.id)
+
+	steps := []struct {
+		cmd        string
+		wantStdout string
+		wantExit   int
+	}{
+		{cmd: "cd " + shellQuote(helper.TempDir)},
+		{cmd: "export JARVIS_SESSION_VAR=persisted"},
+		{cmd: "cat marker.txt", wantStdout: "session marker"},
+		{cmd: "cat"},
+		{cmd: "read line; echo \"read:$?\"", wantStdout: "read:1\n"},
+		{cmd: "echo $JARVIS_SESSION_VAR", wantStdout: "persisted\n"},
+		{cmd: "printf 'no newline'", wantStdout: "no newline"},
+		{cmd: "echo 'unterminated", wantExit: 2},
+		{cmd: "false", wantExit: 1},
+		{cmd: "basename \"$PWD\"", wantStdout: filepath.Base(helper.TempDir) + "\n"},
+	}
+
+	for _, step := range steps {
+		result, err := s.run(context.Background(), step.cmd, "", time.Minute, 0)
+		if err != nil {
+			t.Fatalf("run(%q) error = %v", step.cmd, err)
+		}
+		if result.Stdout != step.wantStdout {
+			t.Errorf("run(%q) stdout = %q, want %q", step.cmd, result.Stdout, step.wantStdout)
+		}
+		if result.ExitCode != step.wantExit {
+			t.Errorf("run(%q) exit code = %d, want %d", step.cmd, result.ExitCode, step.wantExit)
+		}
+	}
+}
+
+// TestSessionSeparatesStreams tests that stderr is framed separately from stdout
+func TestSessionSeparatesStreams(t *testing.T) {
+	s, err := sessions.open("")
+	if err != nil {
+		t.Fatalf("sessions.open() error = %v", err)
+	}
+	defer sessions.close(s.id)
+
+	result, err := s.run(context.Background(), "echo out; echo err 1>&2", "", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if result.Stdout != "out\n" || result.Stderr != "err\n" {
+		t.Errorf("stdout = %q, stderr = %q; want %q and %q", result.Stdout, result.Stderr, "out\n", "err\n")
+	}
+}
+
+// TestSessionTimeoutKeepsSession tests that a timed out command is interrupted without losing the session
+func TestSessionTimeoutKeepsSession(t *testing.T) {
+	s, err := sessions.open("")
+	if err != nil {
+		t.Fatalf("sessions.open() error = %v", err)
+	}
+	defer sessions.close(s.id)
+
+	s.run(context.Background(), "export KEPT=yes", "", time.Minute, 0)
+
+	result, err := s.run(context.Background(), "echo started; sleep 30", "", 300*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if !result.TimedOut || !strings.Contains(result.Stdout, "started") {
+		t.Errorf("expected timed out result with partial output, got %+v", result)
+	}
+
+	result, err = s.run(context.Background(), "echo $KEPT", "", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("run() after timeout error = %v", err)
+	}
+	if result.Stdout != "yes\n" {
+		t.Errorf("session state lost after timeout, stdout = %q", result.Stdout)
+	}
+}
+
+// TestSessionExit tests that exiting the shell closes the session
+func TestSessionExit(t *testing.T) {
+	s, err := sessions.open("")
+	if err != nil {
+		t.Fatalf("sessions.open() error = %v", err)
+	}
+
+	if _, err := s.run(context.Background(), "exit 0", "", time.Minute, 0); !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("run(exit) error = %v, want ErrSessionEnded", err)
+	}
+	if _, err := sessions.get(s.id); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("sessions.get() after exit error = %v, want ErrSessionNotFound", err)
+	}
+}
+
+// TestSessionExitJSON tests that a session command ending the shell still gets a JSON result
+func TestSessionExitJSON(t *testing.T) {
+	s, err := sessions.open("")
+	if err != nil {
+		t.Fatalf("sessions.open() error = %v", err)
+	}
+
+	request := mcp.CallToolRequest{}
+	request.Params.Arguments = map[string]any{"command": "echo bye; exit 3", "session_id": s.id, "output_format": "json"}
+	result, err := executeCommandHandler(context.Background(), request)
+	if err != nil {
+		t.Fatalf("executeCommandHandler() returned protocol error: %v", err)
+	}
+
+	var got commandResult
+	if err := json.Unmarshal([]byte(result.Content[0].(mcp.TextContent).Text), &got); err != nil {
+		t.Fatalf("result is not JSON: %v", err)
+	}
+	if !result.IsError || got.Stdout != "bye\n" || !strings.Contains(got.Error, ErrSessionEnded.Error()) {
+		t.Errorf("result = %+v, IsError = %v; want the output and the session error", got, result.IsError)
+	}
+}
+
+// TestSessionLargeOutput tests that output is capped while it is collected and the sentinel is still found
+func TestSessionLargeOutput(t *testing.T) {
+	s, err := sessions.open("")
+	if err != nil {
+		t.Fatalf("sessions.open() error = %v", err)
+	}
+	defer sessions.close(s.id)
+
+	result, err := s.run(context.Background(), "head -c 5000000 /dev/zero | tr '\\0' a; echo; false", "", time.Minute, 1000)
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if len(result.Stdout) != 1000 || !result.StdoutTruncated || result.ExitCode != 1 {
+		t.Errorf("stdout length = %d, truncated = %v, exit code = %d; want 1000, true, 1",
+			len(result.Stdout), result.StdoutTruncated, result.ExitCode)
+	}
+}
+
+// TestSentinelBuffer tests that a marker split across chunks is found and held back bytes are kept
+func TestSentinelBuffer(t *testing.T) {
+	stream := "output__END__:7\n"
+	for split := range len(stream) + 1 {
+		b := &sentinelBuffer{marker: []byte("__END__:")}
+		b.write([]byte(stream[:split]))
+		b.write([]byte(stream[split:]))
+		if got := b.flush().String(); !b.found || got != "output" || string(b.rest) != "7\n" {
+			t.Errorf("split at %d: found = %v, output = %q, rest = %q", split, b.found, got, b.rest)
+		}
+	}
+
+	b := &sentinelBuffer{marker: []byte("__END__:")}
+	b.write([]byte("partial __END"))
+	if got := b.flush().String(); b.found || got != "partial __END" {
+		t.Errorf("without marker: found = %v, output = %q", b.found, got)
+	}
+}
+
+// TestSessionLimit tests that concurrent opens cannot exceed MaxSessions
+func TestSessionLimit(t *testing.T) {
+	saved := config
+	defer Configure(saved)
+	config.MaxSessions = 2
+
+	m := &sessionManager{sessions: make(map[string]*session)}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() { m.open("") })
+	}
+	wg.Wait()
+
+	if len(m.sessions) != 2 {
+		t.Errorf("opened %d sessions, want 2", len(m.sessions))
+	}
+	for id := range m.sessions {
+		m.close(id)
+	}
+}
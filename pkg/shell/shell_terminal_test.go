@@ -0,0 +1,277 @@
+//go:build !windows
+
+package shell
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForTerminal reads the terminal until its text output contains want
+func waitForTerminal(t *testing.T, id string, format string, want string) string {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	var text strings.Builder
+	var offset int64
+	for time.Now().Before(deadline) {
+		output, err := readTerminal(context.Background(), id, format, offset, 0, 200*time.Millisecond)
+		if err != nil {
+			t.Fatalf("readTerminal() error = %v", err)
+		}
+		if format == terminalFormatScreen {
+			text.Reset()
+		} else {
+			offset = output.NextOffset
+		}
+		text.WriteString(output.Output)
+		if strings.Contains(text.String(), want) {
+			return text.String()
+		}
+		if output.EOF {
+			break
+		}
+	}
+	t.Fatalf("terminal output does not contain %q, got %q", want, text.String())
+	return ""
+}
+
+// TestTerminalInteractive tests that programs see a tty and receive typed input
+func TestTerminalInteractive(t *testing.T) {
+	term, err := terminals.open("test -t 0 && echo IS_TTY; read line; echo \"got:$line\"", "", 0, 0)
+	if err != nil {
+		t.Fatalf("terminals.open() error = %v", err)
+	}
+	defer terminals.close(term.id)
+
+	waitForTerminal(t, term.id, terminalFormatText, "IS_TTY")
+
+	if err := writeTerminal(term.id, "hello\r"); err != nil {
+		t.Fatalf("writeTerminal() error = %v", err)
+	}
+	waitForTerminal(t, term.id, terminalFormatText, "got:hello")
+
+	select {
+	case <-term.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("program did not exit")
+	}
+	if info := term.info(); info.State != jobExited || *info.ExitCode != 0 {
+		t.Errorf("info = %+v, want exited with code 0", info)
+	}
+}
+
+// TestTerminalCtrlC tests that ctrl-c interrupts the foreground program
+func TestTerminalCtrlC(t *testing.T) {
+	term, err := terminals.open("", "", 0, 0)
+	if err != nil {
+		t.Fatalf("terminals.open() error = %v", err)
+	}
+	defer terminals.close(term.id)
+
+	ctrlC, err := keySequence("ctrl-c")
+	if err != nil {
+		t.Fatalf("keySequence() error = %v", err)
+	}
+	writeTerminal(term.id, "sleep 30\r")
+	time.Sleep(300 * time.Millisecond)
+	writeTerminal(term.id, ctrlC+"echo after-$((40+2))\r")
+	waitForTerminal(t, term.id, terminalFormatText, "after-42")
+}
+
+// TestTerminalResize tests that the program sees the new terminal size
+func TestTerminalResize(t *testing.T) {
+	term, err := terminals.open("", "", 30, 100)
+	if err != nil {
+		t.Fatalf("terminals.open() error = %v", err)
+	}
+	defer terminals.close(term.id)
+
+	writeTerminal(term.id, "stty size\r")
+	waitForTerminal(t, term.id, terminalFormatText, "30 100")
+
+	info, err := resizeTerminal(term.id, 40, 120)
+	if err != nil {
+		t.Fatalf("resizeTerminal() error = %v", err)
+	}
+	if info.Rows != 40 || info.Cols != 120 {
+		t.Errorf("resizeTerminal() = %dx%d, want 40x120", info.Rows, info.Cols)
+	}
+	writeTerminal(term.id, "stty size\r")
+	waitForTerminal(t, term.id, terminalFormatText, "40 120")
+}
+
+// TestTerminalScreen tests the screen snapshot of a program that redraws the screen
+func TestTerminalScreen(t *testing.T) {
+	term, err := terminals.open(`printf '\033[2J\033[Hfirst\033[3;5Hsecond\033[1;1Hxx'; sleep 5`, "", 0, 0)
+	if err != nil {
+		t.Fatalf("terminals.open() error = %v", err)
+	}
+	defer terminals.close(term.id)
+
+	screen := waitForTerminal(t, term.id, terminalFormatScreen, "second")
+	if want := "xxrst\n\n    second"; screen != want {
+		t.Errorf("screen = %q, want %q", screen, want)
+	}
+
+	if _, err := terminals.close(term.id); err != nil {
+		t.Fatalf("terminals.close() error = %v", err)
+	}
+	if _, err := readTerminal(context.Background(), term.id, terminalFormatText, 0, 0, 0); err == nil {
+		t.Error("readTerminal() after close succeeded")
+	}
+}
+
+// TestTerminalLimit tests that concurrent opens cannot exceed MaxTerminals
+func TestTerminalLimit(t *testing.T) {
+	saved := config
+	defer Configure(saved)
+	config.MaxTerminals = 2
+
+	m := &terminalManager{terminals: make(map[string]*terminal)}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Go(func() { m.open("sleep 5", "", 0, 0) })
+	}
+	wg.Wait()
+
+	if len(m.terminals) != 2 {
+		t.Errorf("opened %d terminals, want 2", len(m.terminals))
+	}
+	for id := range m.terminals {
+		m.close(id)
+	}
+}
+
+// TestTerminalCleanup tests that terminals whose program exited are removed after the TTL
+func TestTerminalCleanup(t *testing.T) {
+	saved := config
+	defer Configure(saved)
+	config.JobTTL = 100 * time.Millisecond
+
+	term, err := terminals.open("true", "", 0, 0)
+	if err != nil {
+		t.Fatalf("terminals.open() error = %v", err)
+	}
+	select {
+	case <-term.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("program did not exit")
+	}
+
+	time.Sleep(300 * time.Millisecond)
+	if _, err := terminals.get(term.id); !errors.Is(err, ErrTerminalNotFound) {
+		t.Errorf("terminals.get() after TTL error = %v, want ErrTerminalNotFound", err)
+	}
+}
+
+// TestScreenEmulator tests cursor movement, erasing, wrapping and scrolling
+func TestScreenEmulator(t *testing.T) {
+	tests := []struct {
+		name  string
+		input []string
+		rows  int
+		cols  int
+		want  string
+	}{
+		{name: "plain lines", input: []string{"one\r\ntwo\r\n"}, rows: 3, cols: 10, want: "one\ntwo"},
+		{name: "scroll", input: []string{"1\r\n2\r\n3\r\n4"}, rows: 3, cols: 10, want: "2\n3\n4"},
+		{name: "wrap", input: []string{"abcdefg"}, rows: 3, cols: 4, want: "abcd\nefg"},
+		{name: "carriage return overwrite", input: []string{"hello\rJ"}, rows: 1, cols: 10, want: "Jello"},
+		{name: "erase line", input: []string{"hello\x1b[3D\x1b[K"}, rows: 1, cols: 10, want: "he"},
+		{name: "split sequence", input: []string{"abc\x1b[", "1;2Hz"}, rows: 1, cols: 10, want: "azc"},
+		{name: "split utf8", input: []string{"\xc3", "\xa9t\xc3\xa9"}, rows: 1, cols: 10, want: "été"},
+		{name: "alternate screen", input: []string{"main\x1b[?1049h\x1b[Halt\x1b[?1049l"}, rows: 2, cols: 10, want: "main"},
+		{name: "colours ignored", input: []string{"\x1b[1;31mred\x1b[0m"}, rows: 1, cols: 10, want: "red"},
+		{name: "osc title ignored", input: []string{"\x1b]0;title\x07ok"}, rows: 1, cols: 10, want: "ok"},
+		{name: "delete chars", input: []string{"abcdef\x1b[1;2H\x1b[2P"}, rows: 1, cols: 10, want: "adef"},
+		{name: "huge scroll count", input: []string{"a\r\nb\x1b[999999999S\x1b[999999999Tc"}, rows: 2, cols: 5, want: "\n c"},
+		{name: "scroll region", input: []string{"a\r\nb\r\nc\x1b[1;2r\x1b[2;1H\nx"}, rows: 3, cols: 5, want: "b\nx\nc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScreen(tt.rows, tt.cols)
+			for _, chunk := range tt.input {
+				s.Write([]byte(chunk))
+			}
+			if got := s.snapshot(); got != tt.want {
+				t.Errorf("snapshot() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestScreenResizeSavedCursor tests that a cursor saved before the screen shrank is restored inside it
+func TestScreenResizeSavedCursor(t *testing.T) {
+	for _, restore := range []string{"\x1b8", "\x1b[u"} {
+		s := newScreen(40, 100)
+		s.Write([]byte("\x1b[35;90H\x1b7\x1b[s"))
+		s.resize(24, 80)
+		s.Write([]byte(restore + "x"))
+		if s.row != 23 || s.col != 79 {
+			t.Errorf("cursor after %q = %d,%d, want 23,79", restore, s.row, s.col)
+		}
+		if got := s.snapshot(); !strings.HasSuffix(got, "x") {
+			t.Errorf("snapshot() after %q = %q, want x at the end", restore, got)
+		}
+	}
+}
+
+// TestScreenHostileParams tests that negative and huge parameters of any CSI sequence keep the cursor on the screen
+func TestScreenHostileParams(t *testing.T) {
+	params := []string{"-5", "-5;-5", "0;-1", "99999999999999999999", "9223372036854775807;9223372036854775807", "?-5", "65535;65535"}
+	for final := rune(0x40); final <= 0x7e; final++ {
+		for _, param := range params {
+			s := newScreen(5, 10)
+			s.Write([]byte("ab\r\ncd\x1b[3;4H"))
+			sequence := "\x1b[" + param + string(final)
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%q panicked: %v", sequence, r)
+					}
+				}()
+				s.Write([]byte(sequence + "x\x1b8y\x1b[uz"))
+				s.snapshot()
+			}()
+			if s.row < 0 || s.row >= s.rows || s.col < 0 || s.col >= s.cols {
+				t.Errorf("%q left the cursor at %d,%d", sequence, s.row, s.col)
+			}
+		}
+	}
+}
+
+// TestStripANSI tests removal of escape sequences from terminal output
+func TestStripANSI(t *testing.T) {
+	input := "\x1b[?2004h\x1b]0;title\x07\x1b[1;32muser\x1b[0m$ ls\r\nfile\x1b(B\r\n"
+	if got, want := stripANSI(input), "user$ ls\nfile\n"; got != want {
+		t.Errorf("stripANSI() = %q, want %q", got, want)
+	}
+}
+
+// TestKeySequence tests translation of named keys
+func TestKeySequence(t *testing.T) {
+	tests := map[string]string{
+		"enter":  "\r",
+		"Up":     "\x1b[A",
+		"ctrl-c": "\x03",
+		"ctrl-d": "\x04",
+		"ctrl-[": "\x1b",
+		"alt-f":  "\x1bf",
+		"alt-up": "\x1b\x1b[A",
+		"f5":     "\x1b[15~",
+	}
+	for key, want := range tests {
+		got, err := keySequence(key)
+		if err != nil || got != want {
+			t.Errorf("keySequence(%q) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	if _, err := keySequence("hyper-x"); err == nil {
+		t.Error("keySequence(\"hyper-x\") succeeded, want error")
+	}
+}
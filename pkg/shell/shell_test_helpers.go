@@ -1,6 +1,7 @@
 package shell
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -51,7 +52,7 @@ func (h *TestHelper) CreateTestFile(name, content string) string {
 
 // AssertCommandSuccess checks that a command succeeds and contains expected output
 func (h *TestHelper) AssertCommandSuccess(cmd, workDir, expectedOutput string) {
-	result, err := executeCommand(cmd, workDir)
+	result, err := executeCommand(context.Background(), cmd, workDir, 0)
 	if err != nil {
 		h.T.Errorf("executeCommand(%s) unexpected error: %v", cmd, err)
 		return
@@ -64,7 +65,7 @@ func (h *TestHelper) AssertCommandSuccess(cmd, workDir, expectedOutput string) {
 
 // AssertCommandError checks that a command fails with expected error message
 func (h *TestHelper) AssertCommandError(cmd, workDir, expectedErrMsg string) {
-	_, err := executeCommand(cmd, workDir)
+	_, err := executeCommand(context.Background(), cmd, workDir, 0)
 	if err == nil {
 		h.T.Errorf("executeCommand(%s) expected error but got none", cmd)
 		return
@@ -4,9 +4,12 @@
 package shell
 
 import (
+	"context"
+	"errors"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 // TestUnixSpecificCommands tests Unix-specific command execution
@@ -79,7 +82,7 @@ func TestUnixSpecificCommands(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := executeCommand(tt.cmd, tt.workDir)
+			result, err := executeCommand(context.Background(), tt.cmd, tt.workDir, 0)
 			
 			// Check error cases
 			if tt.wantErr {
@@ -160,7 +163,7 @@ func TestUnixPathHandling(t *testing.T) {
 				return
 			}
 			
-			_, err := executeCommand(tt.cmd, tt.workDir)
+			_, err := executeCommand(context.Background(), tt.cmd, tt.workDir, 0)
 			if tt.wantErr && err == nil {
 				t.Errorf("Expected error for %s, got none", tt.name)
 			} else if !tt.wantErr && err != nil {
@@ -210,7 +213,7 @@ func TestUnixSpecificOperators(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := executeCommand(tt.cmd, helper.TempDir)
+			result, err := executeCommand(context.Background(), tt.cmd, helper.TempDir, 0)
 			
 			if tt.wantErr && err == nil {
 				t.Errorf("Expected error for command %s but got none", tt.cmd)
@@ -225,3 +228,230 @@ func TestUnixSpecificOperators(t *testing.T) {
 		})
 	}
 }
+
+// TestCommandTimeout tests that long-running commands are killed with partial output
+func TestCommandTimeout(t *testing.T) {
+	start := time.Now()
+	result, err := executeCommand(context.Background(), "echo 'before sleep'; sleep 30", "", 500*time.Millisecond)
+
+	if !errors.Is(err, ErrTimedOut) {
+		t.Fatalf("Expected ErrTimedOut, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Command was not killed promptly, took %s", elapsed)
+	}
+	if !strings.Contains(result, "timed out") {
+		t.Errorf("Result doesn't contain timeout marker: %q", result)
+	}
+	if !strings.Contains(result, "before sleep") {
+		t.Errorf("Result doesn't contain partial output: %q", result)
+	}
+}
+
+// TestCommandCancellation tests that cancelling the context kills the whole process group
+func TestCommandCancellation(t *testing.T) {
+	helper := NewTestHelper(t, "jarvis-unix-cancel")
+	defer helper.Cleanup()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+
+	// The grandchild would create the marker file if it survived the shell
+	cmd := "(sleep 1 && touch survived.txt) & wait"
+	result, err := executeCommand(ctx, cmd, helper.TempDir, 0)
+
+	if !errors.Is(err, ErrCancelled) {
+		t.Fatalf("Expected ErrCancelled, got: %v", err)
+	}
+	if !strings.Contains(result, "cancelled") {
+		t.Errorf("Result doesn't contain cancellation marker: %q", result)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(helper.TempDir + "/survived.txt"); err == nil {
+		t.Errorf("Child process survived cancellation")
+	}
+}
+
+// TestResolveTimeout tests default and maximum timeout handling
+func TestResolveTimeout(t *testing.T) {
+	saved := config
+	defer Configure(saved)
+
+	Configure(Config{DefaultTimeout: 30 * time.Second, MaxTimeout: time.Minute})
+
+	tests := []struct {
+		name      string
+		requested time.Duration
+		want      time.Duration
+	}{
+		{name: "default when unset", requested: 0, want: 30 * time.Second},
+		{name: "requested within limit", requested: 5 * time.Second, want: 5 * time.Second},
+		{name: "capped at maximum", requested: time.Hour, want: time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveTimeout(tt.requested); got != tt.want {
+				t.Errorf("resolveTimeout(%s) = %s, want %s", tt.requested, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRunCommandResult tests structured results with separate streams and exit codes
+func TestRunCommandResult(t *testing.T) {
+	tests := []struct {
+		name         string
+		cmd          string
+		timeout      time.Duration
+		wantStdout   string
+		wantStderr   string
+		wantExitCode int
+		wantSignal   string
+		wantTimedOut bool
+	}{
+		{
+			name:       "successful command",
+			cmd:        "echo out; echo err 1>&2",
+			wantStdout: "out\n",
+			wantStderr: "err\n",
+		},
+		{
+			name:         "non-zero exit code",
+			cmd:          "echo failing 1>&2; exit 3",
+			wantStderr:   "failing\n",
+			wantExitCode: 3,
+		},
+		{
+			name:         "killed by signal",
+			cmd:          "kill -TERM $$",
+			wantExitCode: -1,
+			wantSignal:   "terminated",
+		},
+		{
+			name:         "timed out",
+			cmd:          "echo partial; sleep 30",
+			timeout:      300 * time.Millisecond,
+			wantStdout:   "partial\n",
+			wantExitCode: -1,
+			wantSignal:   "killed",
+			wantTimedOut: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := runCommand(context.Background(), tt.cmd, "", tt.timeout, 0)
+			if err != nil {
+				t.Fatalf("runCommand() unexpected error = %v", err)
+			}
+
+			if result.Stdout != tt.wantStdout {
+				t.Errorf("Stdout = %q, want %q", result.Stdout, tt.wantStdout)
+			}
+			if result.Stderr != tt.wantStderr {
+				t.Errorf("Stderr = %q, want %q", result.Stderr, tt.wantStderr)
+			}
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+			if result.Signal != tt.wantSignal {
+				t.Errorf("Signal = %q, want %q", result.Signal, tt.wantSignal)
+			}
+			if result.TimedOut != tt.wantTimedOut {
+				t.Errorf("TimedOut = %v, want %v", result.TimedOut, tt.wantTimedOut)
+			}
+		})
+	}
+}
+
+// TestRunCommandTruncation tests that each output stream is capped independently
+func TestRunCommandTruncation(t *testing.T) {
+	result, err := runCommand(context.Background(), "printf '0123456789'; printf 'ab' 1>&2", "", 0, 4)
+	if err != nil {
+		t.Fatalf("runCommand() unexpected error = %v", err)
+	}
+
+	if result.Stdout != "0123" || !result.StdoutTruncated {
+		t.Errorf("Stdout = %q (truncated=%v), want %q truncated", result.Stdout, result.StdoutTruncated, "0123")
+	}
+	if result.Stderr != "ab" || result.StderrTruncated {
+		t.Errorf("Stderr = %q (truncated=%v), want %q not truncated", result.Stderr, result.StderrTruncated, "ab")
+	}
+}
+
+// TestRunProgram tests direct execution without shell interpretation of arguments
+func TestRunProgram(t *testing.T) {
+	helper := NewTestHelper(t, "jarvis-run-program-test")
+	defer helper.Cleanup()
+
+	tests := []struct {
+		name         string
+		program      string
+		args         []string
+		env          map[string]string
+		stdin        string
+		workDir      string
+		wantStdout   string
+		wantExitCode int
+		wantErr      bool
+	}{
+		{
+			name:       "metacharacters are passed literally",
+			program:    "printf",
+			args:       []string{"%s|", "$HOME", "a b", "; rm -rf /", "`id`", "*"},
+			wantStdout: "$HOME|a b|; rm -rf /|`id`|*|",
+		},
+		{
+			name:       "environment",
+			program:    "sh",
+			args:       []string{"-c", "echo $JARVIS_RUN_VAR"},
+			env:        map[string]string{"JARVIS_RUN_VAR": "from env"},
+			wantStdout: "from env\n",
+		},
+		{
+			name:       "stdin",
+			program:    "cat",
+			stdin:      "piped input",
+			wantStdout: "piped input",
+		},
+		{
+			name:       "working directory",
+			program:    "pwd",
+			workDir:    helper.TempDir,
+			wantStdout: helper.TempDir + "\n",
+		},
+		{
+			name:         "exit code",
+			program:      "false",
+			wantExitCode: 1,
+		},
+		{
+			name:    "program not found",
+			program: "jarvis-no-such-program",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := runProgram(context.Background(), tt.program, tt.args, tt.env, tt.stdin, tt.workDir, 0, 0)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("runProgram() expected error, got %+v", result)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runProgram() unexpected error = %v", err)
+			}
+			if result.Stdout != tt.wantStdout {
+				t.Errorf("Stdout = %q, want %q", result.Stdout, tt.wantStdout)
+			}
+			if result.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", result.ExitCode, tt.wantExitCode)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@
 package shell
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -80,7 +81,7 @@ func TestWindowsSpecificCommands(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := executeCommand(tt.cmd, tt.workDir)
+			result, err := executeCommand(context.Background(), tt.cmd, tt.workDir, 0)
 			
 			// Check error cases
 			if tt.wantErr {
@@ -132,7 +133,7 @@ func TestWindowsPathHandling(t *testing.T) {
 	
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			_, err := executeCommand(tc.cmd, tc.workDir)
+			_, err := executeCommand(context.Background(), tc.cmd, tc.workDir, 0)
 			if tc.wantErr && err == nil {
 				t.Errorf("Expected error for workDir=%s, got none", tc.workDir)
 			} else if !tc.wantErr && err != nil {
@@ -191,7 +192,7 @@ func TestWindowsCommandCharacters(t *testing.T) {
 	
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := executeCommand(tt.cmd, helper.TempDir)
+			result, err := executeCommand(context.Background(), tt.cmd, helper.TempDir, 0)
 			
 			if tt.wantErr && err == nil {
 				t.Errorf("Expected error for command %s but got none", tt.cmd)
@@ -0,0 +1,54 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/sandbox"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetStartJob returns the tool and handler for starting background commands.
+func GetStartJob() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("start_job",
+		mcp.WithDescription("Start a long-running OS command in the background and return a job ID for polling its status and output"),
+		mcp.WithString("command",
+			mcp.Required(),
+			mcp.Description("Full OS command to execute"),
+		),
+		mcp.WithString("working directory",
+			mcp.Description("Working directory for the command"),
+		),
+	), startJobHandler
+}
+
+// startJobHandler starts the command as a background job and returns its initial status.
+func startJobHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	cmd, ok := request.GetArguments()["command"].(string)
+	if !ok {
+		return nil, errors.New("command must be a string")
+	}
+
+	workDir := ""
+	if workDirVal, ok := request.GetArguments()["working directory"].(string); ok {
+		workDir = workDirVal
+	}
+
+	workDir, err := sandbox.WorkDir(ctx, workDir)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	if err := checkCommand(ctx, cmd, workDir); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	j, err := jobs.start(cmd, workDir)
+	if err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultJSON(j.status())
+}
@@ -0,0 +1,456 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"jarvis_mcp/pkg/utils"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+var (
+	// ErrTerminalNotFound is returned when a terminal ID is unknown or the terminal was closed.
+	ErrTerminalNotFound = utils.NewCodedError(utils.ErrCodeNotFound, errors.New("terminal not found"))
+	// ErrTerminalsUnsupported is returned on platforms without pseudo-terminal support.
+	ErrTerminalsUnsupported = errors.New("interactive terminals are only supported on Unix-like systems")
+)
+
+// Default size of a new terminal.
+const (
+	defaultTerminalRows = 24
+	defaultTerminalCols = 80
+)
+
+// maxTerminalSize bounds the rows and cols of a terminal, so its screen stays small.
+const maxTerminalSize = 1000
+
+// Output formats accepted by readTerminal.
+const (
+	terminalFormatRaw    = "raw"
+	terminalFormatText   = "text"
+	terminalFormatScreen = "screen"
+)
+
+// terminal is a program running in a pseudo-terminal, for interactive tools such as
+// REPLs, pagers, editors and password prompts that refuse to work with plain pipes.
+// Output is kept both as a raw byte stream and as an emulated screen.
+type terminal struct {
+	id         string
+	command    string
+	cmd        *exec.Cmd
+	pty        *os.File
+	startedAt  time.Time
+	ttl        time.Duration // how long the terminal is kept after its program exits
+	done       chan struct{}
+	mu         sync.Mutex
+	output     ringBuffer
+	screen     *screen
+	updated    chan struct{} // closed and replaced whenever output is written or the program exits
+	finishedAt time.Time
+	exitCode   int
+	signal     string
+}
+
+// terminalInfo is the snapshot of a terminal returned to clients.
+type terminalInfo struct {
+	ID        string `json:"terminal_id"`
+	Command   string `json:"command"`
+	PID       int    `json:"pid"`
+	Rows      int    `json:"rows"`
+	Cols      int    `json:"cols"`
+	State     string `json:"state"`
+	ExitCode  *int   `json:"exit_code,omitempty"`
+	Signal    string `json:"signal,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// terminalOutput is what readTerminal returns. For the screen format Output holds the
+// current screen contents and the cursor position is reported; otherwise it holds the
+// output between Offset and NextOffset.
+type terminalOutput struct {
+	ID           string `json:"terminal_id"`
+	Format       string `json:"format"`
+	Output       string `json:"output"`
+	Offset       int64  `json:"offset"`
+	NextOffset   int64  `json:"next_offset"`
+	DroppedBytes int64  `json:"dropped_bytes"`
+	Rows         int    `json:"rows"`
+	Cols         int    `json:"cols"`
+	CursorRow    *int   `json:"cursor_row,omitempty"`
+	CursorCol    *int   `json:"cursor_col,omitempty"`
+	State        string `json:"state"`
+	ExitCode     *int   `json:"exit_code,omitempty"`
+	EOF          bool   `json:"eof"`
+}
+
+// Write implements io.Writer for everything the program prints to the terminal.
+func (t *terminal) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.output.write(p)
+	t.screen.Write(p)
+	close(t.updated)
+	t.updated = make(chan struct{})
+	return len(p), nil
+}
+
+// infoLocked returns a snapshot of the terminal. t.mu must be held.
+func (t *terminal) infoLocked() terminalInfo {
+	info := terminalInfo{
+		ID:        t.id,
+		Command:   t.command,
+		PID:       t.cmd.Process.Pid,
+		Rows:      t.screen.rows,
+		Cols:      t.screen.cols,
+		State:     jobRunning,
+		CreatedAt: t.startedAt.Format(time.RFC3339),
+	}
+	if !t.finishedAt.IsZero() {
+		exitCode := t.exitCode
+		info.State = jobExited
+		info.ExitCode = &exitCode
+		info.Signal = t.signal
+	}
+	return info
+}
+
+// info returns a snapshot of the terminal.
+func (t *terminal) info() terminalInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.infoLocked()
+}
+
+// terminalManager tracks open terminals and closes the ones whose program exited after a TTL.
+type terminalManager struct {
+	mu        sync.Mutex
+	terminals map[string]*terminal
+	nextID    int
+	// opening counts the terminals being opened, which take a slot of the limit until they are added.
+	opening int
+}
+
+// terminals is the process-wide terminal registry.
+var terminals = &terminalManager{terminals: make(map[string]*terminal)}
+
+// open starts cmd through the shell in a new pseudo-terminal of the given size. An empty
+// cmd starts the user's interactive shell.
+func (m *terminalManager) open(cmd string, workDir string, rows, cols int) (*terminal, error) {
+	if runtime.GOOS == "windows" {
+		return nil, ErrTerminalsUnsupported
+	}
+
+	m.mu.Lock()
+	if config.MaxTerminals > 0 && len(m.terminals)+m.opening >= config.MaxTerminals {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("too many open terminals (limit %d)", config.MaxTerminals)
+	}
+	m.opening++
+	m.mu.Unlock()
+	opened := false
+	defer func() {
+		if !opened {
+			m.mu.Lock()
+			m.opening--
+			m.mu.Unlock()
+		}
+	}()
+
+	if rows <= 0 {
+		rows = defaultTerminalRows
+	}
+	if cols <= 0 {
+		cols = defaultTerminalCols
+	}
+
+	var command *exec.Cmd
+	if strings.TrimSpace(cmd) == "" {
+		command = exec.Command(interactiveShell())
+	} else {
+		command = exec.Command("sh", "-c", cmd)
+	}
+
+	// The pty package puts the program in a new session, which also makes it the
+	// leader of its own process group, so no setProcessGroup here
+	command.Env = append(os.Environ(), "TERM=xterm")
+	if strings.TrimSpace(workDir) != "" {
+		if err := checkWorkDir(workDir); err != nil {
+			return nil, err
+		}
+		command.Dir = workDir
+	}
+
+	f, err := pty.StartWithSize(command, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+	if err != nil {
+		if errors.Is(err, pty.ErrUnsupported) {
+			return nil, ErrTerminalsUnsupported
+		}
+		return nil, err
+	}
+
+	t := &terminal{
+		command:   cmd,
+		cmd:       command,
+		pty:       f,
+		startedAt: time.Now(),
+		ttl:       config.JobTTL,
+		done:      make(chan struct{}),
+		output:    ringBuffer{limit: config.JobBufferBytes},
+		screen:    newScreen(rows, cols),
+		updated:   make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.opening--
+	m.nextID++
+	t.id = "terminal-" + strconv.Itoa(m.nextID)
+	m.terminals[t.id] = t
+	m.mu.Unlock()
+	opened = true
+
+	copied := make(chan struct{})
+	go func() {
+		// Reading fails with EIO once the program and its children have exited
+		io.Copy(t, f)
+		close(copied)
+	}()
+	go m.wait(t, copied)
+
+	return t, nil
+}
+
+// wait records the program's exit status once it has exited and its output was drained,
+// and schedules the terminal to be closed.
+func (m *terminalManager) wait(t *terminal, copied <-chan struct{}) {
+	t.cmd.Wait()
+
+	// A background child may keep the terminal open; don't wait for it forever
+	select {
+	case <-copied:
+	case <-time.After(waitDelay):
+	}
+
+	t.mu.Lock()
+	t.finishedAt = time.Now()
+	t.exitCode = t.cmd.ProcessState.ExitCode()
+	t.signal = exitSignal(t.cmd.ProcessState)
+	close(t.updated)
+	t.updated = make(chan struct{})
+	t.mu.Unlock()
+	close(t.done)
+
+	if t.ttl > 0 {
+		time.AfterFunc(t.ttl, func() { m.close(t.id) })
+	}
+}
+
+// interactiveShell returns the shell started by terminals opened without a command.
+func interactiveShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	return "sh"
+}
+
+// get looks up an open terminal by ID.
+func (m *terminalManager) get(id string) (*terminal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.terminals[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTerminalNotFound, id)
+	}
+	return t, nil
+}
+
+// close kills everything running in the terminal and releases it.
+func (m *terminalManager) close(id string) (*terminalInfo, error) {
+	m.mu.Lock()
+	t, ok := m.terminals[id]
+	delete(m.terminals, id)
+	m.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTerminalNotFound, id)
+	}
+
+	killProcessGroup(t.cmd)
+	t.pty.Close()
+	select {
+	case <-t.done:
+	case <-time.After(waitDelay + time.Second):
+	}
+
+	info := t.info()
+	return &info, nil
+}
+
+// readTerminal returns the terminal's output in the requested format. Raw and text
+// output start at offset and are limited to maxBytes; text has escape sequences removed.
+// The screen format renders what a user would currently see. If nothing was written
+// past offset and the program is still running, it waits up to wait for more output.
+func readTerminal(ctx context.Context, id string, format string, offset int64, maxBytes int, wait time.Duration) (*terminalOutput, error) {
+	t, err := terminals.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	if offset >= t.output.total && t.finishedAt.IsZero() && wait > 0 {
+		updated := t.updated
+		t.mu.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-updated:
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+
+		t.mu.Lock()
+	}
+	defer t.mu.Unlock()
+
+	info := t.infoLocked()
+	result := &terminalOutput{
+		ID:       id,
+		Format:   format,
+		Rows:     info.Rows,
+		Cols:     info.Cols,
+		State:    info.State,
+		ExitCode: info.ExitCode,
+	}
+
+	if format == terminalFormatScreen {
+		row, col := t.screen.row, t.screen.col
+		result.Output = t.screen.snapshot()
+		result.Offset = t.output.total
+		result.NextOffset = t.output.total
+		result.CursorRow = &row
+		result.CursorCol = &col
+		result.EOF = !t.finishedAt.IsZero()
+		return result, nil
+	}
+
+	data, next, dropped := t.output.read(offset, maxBytes)
+	result.Output = string(data)
+	if format == terminalFormatText {
+		result.Output = stripANSI(result.Output)
+	}
+	result.Offset = next - int64(len(data))
+	result.NextOffset = next
+	result.DroppedBytes = dropped
+	result.EOF = !t.finishedAt.IsZero() && next == t.output.total
+	return result, nil
+}
+
+// writeTerminal sends input to the terminal as if it was typed on a keyboard.
+func writeTerminal(id string, input string) error {
+	t, err := terminals.get(id)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-t.done:
+		return fmt.Errorf("terminal %s has already exited", id)
+	default:
+	}
+
+	if _, err := io.WriteString(t.pty, input); err != nil {
+		return fmt.Errorf("error writing to terminal %s: %w", id, err)
+	}
+	return nil
+}
+
+// resizeTerminal changes the terminal size; the program is notified with SIGWINCH.
+func resizeTerminal(id string, rows, cols int) (*terminalInfo, error) {
+	t, err := terminals.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := pty.Setsize(t.pty, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)}); err != nil {
+		return nil, fmt.Errorf("error resizing terminal %s: %w", id, err)
+	}
+	t.screen.resize(rows, cols)
+
+	info := t.infoLocked()
+	return &info, nil
+}
+
+// namedKeys maps key names accepted by write_terminal to the bytes an xterm sends.
+var namedKeys = map[string]string{
+	"enter":     "\r",
+	"return":    "\r",
+	"tab":       "\t",
+	"backspace": "\x7f",
+	"escape":    "\x1b",
+	"esc":       "\x1b",
+	"space":     " ",
+	"up":        "\x1b[A",
+	"down":      "\x1b[B",
+	"right":     "\x1b[C",
+	"left":      "\x1b[D",
+	"home":      "\x1b[H",
+	"end":       "\x1b[F",
+	"insert":    "\x1b[2~",
+	"delete":    "\x1b[3~",
+	"pageup":    "\x1b[5~",
+	"pagedown":  "\x1b[6~",
+	"f1":        "\x1bOP",
+	"f2":        "\x1bOQ",
+	"f3":        "\x1bOR",
+	"f4":        "\x1bOS",
+	"f5":        "\x1b[15~",
+	"f6":        "\x1b[17~",
+	"f7":        "\x1b[18~",
+	"f8":        "\x1b[19~",
+	"f9":        "\x1b[20~",
+	"f10":       "\x1b[21~",
+	"f11":       "\x1b[23~",
+	"f12":       "\x1b[24~",
+}
+
+// keySequence translates a key name such as "enter", "up", "ctrl-c" or "alt-f"
+// into the bytes to write to the terminal.
+func keySequence(key string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(key))
+	if seq, ok := namedKeys[name]; ok {
+		return seq, nil
+	}
+
+	if rest, ok := strings.CutPrefix(name, "ctrl-"); ok && len(rest) == 1 {
+		c := rest[0]
+		switch {
+		case c >= 'a' && c <= 'z':
+			return string(rune(c - 'a' + 1)), nil
+		case c >= '@' && c <= '_':
+			return string(rune(c - '@')), nil
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(name, "alt-"); ok && rest != "" {
+		seq, err := keySequence(rest)
+		if err != nil && len(rest) == 1 {
+			seq, err = rest, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		return "\x1b" + seq, nil
+	}
+
+	return "", fmt.Errorf("unknown key %q", key)
+}
@@ -0,0 +1,54 @@
+package shell
+
+import (
+	"context"
+	"errors"
+	"jarvis_mcp/pkg/utils"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// GetWriteTerminal returns the tool and handler for typing into an interactive terminal.
+func GetWriteTerminal() (tool mcp.Tool, handler server.ToolHandlerFunc) {
+	return mcp.NewTool("write_terminal",
+		mcp.WithDescription("Type text and keys into a terminal opened with open_terminal. The text is sent first, followed by the named keys in order"),
+		mcp.WithString("terminal_id",
+			mcp.Required(),
+			mcp.Description("ID of the terminal returned by open_terminal"),
+		),
+		mcp.WithString("input",
+			mcp.Description("Text to type, sent as is; control characters such as \\u0003 are allowed"),
+		),
+		mcp.WithArray("keys",
+			mcp.Description("Named keys to press after the text: enter, tab, backspace, escape, space, up, down, left, right, home, end, insert, delete, pageup, pagedown, f1-f12, ctrl-<key> (e.g. ctrl-c, ctrl-d) and alt-<key>"),
+			mcp.WithStringItems(),
+		),
+	), writeTerminalHandler
+}
+
+// writeTerminalHandler translates the keys and writes everything to the terminal.
+func writeTerminalHandler(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	id, ok := request.GetArguments()["terminal_id"].(string)
+	if !ok {
+		return nil, errors.New("terminal_id is required")
+	}
+
+	input := request.GetString("input", "")
+	for _, key := range request.GetStringSlice("keys", nil) {
+		seq, err := keySequence(key)
+		if err != nil {
+			return nil, err
+		}
+		input += seq
+	}
+	if input == "" {
+		return nil, errors.New("input or keys is required")
+	}
+
+	if err := writeTerminal(id, input); err != nil {
+		return utils.NewToolResultError(err, ""), nil
+	}
+
+	return mcp.NewToolResultText("Input sent to terminal " + id), nil
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"syscall"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ErrorCode is a machine-readable classification of a failed tool operation.
+type ErrorCode string
+
+const (
+	ErrCodeNotFound         ErrorCode = "not_found"
+	ErrCodePermissionDenied ErrorCode = "permission_denied"
+	ErrCodeNotADirectory    ErrorCode = "not_a_directory"
+	ErrCodeIsADirectory     ErrorCode = "is_a_directory"
+	ErrCodeAlreadyExists    ErrorCode = "already_exists"
+	ErrCodeTooLarge         ErrorCode = "too_large"
+	ErrCodeUnsupported      ErrorCode = "unsupported"
+	ErrCodeConflict         ErrorCode = "conflict"
+	ErrCodeTimeout          ErrorCode = "timeout"
+	ErrCodeCancelled        ErrorCode = "cancelled"
+	ErrCodePolicyDenied     ErrorCode = "policy_denied"
+	ErrCodeCommandFailed    ErrorCode = "command_failed"
+	ErrCodeInternal         ErrorCode = "internal_error"
+)
+
+var (
+	// ErrNotADirectory is returned when a directory was expected but the path is something else.
+	ErrNotADirectory = errors.New("not a directory")
+	// ErrIsADirectory is returned when a file was expected but the path is a directory.
+	ErrIsADirectory = errors.New("is a directory")
+	// ErrPolicyDenied is returned when an operation is refused by the server's configuration.
+	ErrPolicyDenied = errors.New("denied by policy")
+	// ErrTooLarge is returned when a result would exceed a configured size limit.
+	ErrTooLarge = errors.New("too large")
+	// ErrConflict is returned when a change expects content that the file does not have.
+	ErrConflict = errors.New("conflict")
+)
+
+// CodedError attaches an explicit ErrorCode to an error.
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+// NewCodedError wraps err with the given code.
+func NewCodedError(code ErrorCode, err error) *CodedError {
+	return &CodedError{Code: code, Err: err}
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError maps an error to the ErrorCode reported to clients.
+func ClassifyError(err error) ErrorCode {
+	var coded *CodedError
+	switch {
+	case errors.As(err, &coded):
+		return coded.Code
+	case errors.Is(err, ErrPolicyDenied):
+		return ErrCodePolicyDenied
+	case errors.Is(err, fs.ErrNotExist):
+		return ErrCodeNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return ErrCodePermissionDenied
+	case errors.Is(err, fs.ErrExist):
+		return ErrCodeAlreadyExists
+	case errors.Is(err, ErrNotADirectory), errors.Is(err, syscall.ENOTDIR):
+		return ErrCodeNotADirectory
+	case errors.Is(err, ErrIsADirectory), errors.Is(err, syscall.EISDIR):
+		return ErrCodeIsADirectory
+	case errors.Is(err, ErrTooLarge):
+		return ErrCodeTooLarge
+	case errors.Is(err, errors.ErrUnsupported):
+		return ErrCodeUnsupported
+	case errors.Is(err, ErrConflict):
+		return ErrCodeConflict
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrCodeTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrCodeCancelled
+	default:
+		return ErrCodeInternal
+	}
+}
+
+// toolError is the structured content attached to failed tool results.
+type toolError struct {
+	ErrorCode ErrorCode `json:"error_code"`
+	Error     string    `json:"error"`
+	Output    string    `json:"output,omitempty"`
+}
+
+// NewToolResultError reports an operational failure as a tool result with IsError set,
+// so the model can see what went wrong. The text names the error code, followed by any
+// output the operation produced; the same fields are provided as structured content.
+func NewToolResultError(err error, output string) *mcp.CallToolResult {
+	code := ClassifyError(err)
+
+	text := fmt.Sprintf("Error [%s]: %v", code, err)
+	if output != "" {
+		text = output + "\n\n" + text
+	}
+
+	result := mcp.NewToolResultStructured(toolError{
+		ErrorCode: code,
+		Error:     err.Error(),
+		Output:    output,
+	}, text)
+	result.IsError = true
+	return result
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestClassifyError(t *testing.T) {
+	tmpDir := t.TempDir()
+	filePath := filepath.Join(tmpDir, "file.txt")
+	if err := os.WriteFile(filePath, []byte("content"), 0644); err != nil {
+		t.Fatalf("failed to create test file: %v", err)
+	}
+
+	_, notFoundErr := os.Stat(filepath.Join(tmpDir, "missing"))
+	_, notDirErr := os.ReadDir(filePath)
+	existsErr := os.Mkdir(tmpDir, 0755)
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{name: "missing file", err: notFoundErr, want: ErrCodeNotFound},
+		{name: "file used as directory", err: notDirErr, want: ErrCodeNotADirectory},
+		{name: "existing directory", err: existsErr, want: ErrCodeAlreadyExists},
+		{name: "permission error", err: fmt.Errorf("wrapped: %w", os.ErrPermission), want: ErrCodePermissionDenied},
+		{name: "policy denied", err: fmt.Errorf("path outside roots: %w", ErrPolicyDenied), want: ErrCodePolicyDenied},
+		{name: "too large", err: fmt.Errorf("%w: 10 MB file", ErrTooLarge), want: ErrCodeTooLarge},
+		{name: "unsupported", err: fmt.Errorf("binary file: %w", errors.ErrUnsupported), want: ErrCodeUnsupported},
+		{name: "conflict", err: fmt.Errorf("%w: old text not found", ErrConflict), want: ErrCodeConflict},
+		{name: "timeout", err: fmt.Errorf("command: %w", context.DeadlineExceeded), want: ErrCodeTimeout},
+		{name: "explicit code", err: NewCodedError(ErrCodeCommandFailed, errors.New("exit status 1")), want: ErrCodeCommandFailed},
+		{name: "unknown error", err: errors.New("boom"), want: ErrCodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ClassifyError(tt.err); got != tt.want {
+				t.Errorf("ClassifyError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewToolResultError(t *testing.T) {
+	result := NewToolResultError(fmt.Errorf("open x: %w", os.ErrNotExist), "partial output")
+
+	if !result.IsError {
+		t.Errorf("expected IsError to be set")
+	}
+
+	text, ok := mcp.AsTextContent(result.Content[0])
+	if !ok {
+		t.Fatalf("expected text content, got %T", result.Content[0])
+	}
+	if !strings.Contains(text.Text, "[not_found]") || !strings.Contains(text.Text, "partial output") {
+		t.Errorf("unexpected error text: %q", text.Text)
+	}
+
+	structured, ok := result.StructuredContent.(toolError)
+	if !ok {
+		t.Fatalf("expected toolError structured content, got %T", result.StructuredContent)
+	}
+	if structured.ErrorCode != ErrCodeNotFound || structured.Output != "partial output" {
+		t.Errorf("unexpected structured content: %+v", structured)
+	}
+}